@@ -16,7 +16,7 @@ func main() {
 
 	repository := document.NewPostgresRepository(configuration.GetDatabaseConf())
 
-	service, err := document.NewDocumentService(repository)
+	service, err := document.NewDocumentService(repository, configuration.GetDocumentConf())
 	if err != nil {
 		log.Fatal("failed to start the service:", err)
 	}
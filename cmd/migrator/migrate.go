@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"log"
 
 	document "github.com/emaforlin/ce-document-service/internal/document"
@@ -24,8 +25,33 @@ func (m *DatabaseMigrator) RunMigrations() error {
 
 	// Add all models that need to be migrated here
 	models := []interface{}{
+		&document.Workspace{},
+		&document.WorkspaceMember{},
+		&document.Folder{},
+		&document.FolderPermission{},
+		&document.Tag{},
 		&document.Document{},
 		&document.DocumentPermission{},
+		&document.DocumentRevision{},
+		&document.DocumentStep{},
+		&document.DocumentUpdate{},
+		&document.DocumentLock{},
+		&document.OwnershipTransfer{},
+	}
+
+	// pg_trgm backs the fuzzy title matching of /documents/suggest
+	if err := m.repo.GetDB().Exec("CREATE EXTENSION IF NOT EXISTS pg_trgm").Error; err != nil {
+		log.Printf("Migration failed: %v", err)
+		return err
+	}
+
+	// Locks of documents deleted before they referenced documents would
+	// block their foreign key
+	if m.repo.GetDB().Migrator().HasTable(&document.DocumentLock{}) {
+		if err := m.repo.GetDB().Exec("DELETE FROM document_locks WHERE document_id NOT IN (SELECT id FROM documents)").Error; err != nil {
+			log.Printf("Migration failed: %v", err)
+			return err
+		}
 	}
 
 	if err := m.repo.GetDB().AutoMigrate(models...); err != nil {
@@ -33,6 +59,21 @@ func (m *DatabaseMigrator) RunMigrations() error {
 		return err
 	}
 
+	if err := m.repo.GetDB().Exec("CREATE INDEX IF NOT EXISTS idx_documents_title_trgm ON documents USING gin (title gin_trgm_ops)").Error; err != nil {
+		log.Printf("Migration failed: %v", err)
+		return err
+	}
+
+	// Documents written before the search index existed are not indexed yet
+	indexed, err := m.repo.RebuildSearchIndex(context.Background(), 100)
+	if err != nil {
+		log.Printf("Migration failed: %v", err)
+		return err
+	}
+	if indexed > 0 {
+		log.Printf("Indexed %d documents for search", indexed)
+	}
+
 	log.Println("Database migrations completed successfully!")
 	return nil
 }
@@ -0,0 +1,178 @@
+package internal
+
+import (
+	"archive/zip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// archivePageSize is the number of documents loaded at a time while an
+// archive is written, which bounds its memory use
+const archivePageSize = 50
+
+// ArchiveManifest is the manifest.json written at the end of a document
+// archive
+type ArchiveManifest struct {
+	UserID     string                 `json:"user_id"`
+	Format     string                 `json:"format"`
+	ExportedAt time.Time              `json:"exported_at"`
+	Documents  []ArchiveManifestEntry `json:"documents"`
+}
+
+type ArchiveManifestEntry struct {
+	ID          string      `json:"id"`
+	Title       string      `json:"title"`
+	OwnerID     string      `json:"owner_id"`
+	Role        Role        `json:"role"`
+	ContentMode ContentMode `json:"content_mode"`
+	Version     int64       `json:"version"`
+	CreatedAt   time.Time   `json:"created_at"`
+	UpdatedAt   time.Time   `json:"updated_at"`
+	// File is the path of the document in the archive, empty when it could
+	// not be exported
+	File  string `json:"file,omitempty"`
+	Error string `json:"error,omitempty"`
+}
+
+// ExportDocumentArchive writes a ZIP archive of every document data.UserID
+// can access to w, each rendered in data.Format, followed by a manifest.json
+// describing them. Documents are loaded a page at a time so that the archive
+// streams. Documents in the yjs content mode are stored as their Yjs state.
+// Nothing is written to w when the format is not supported.
+func (s *DocumentService) ExportDocumentArchive(ctx context.Context, data ExportArchiveDTO, w io.Writer) error {
+	format, ok := exportFormats[data.Format]
+	if !ok {
+		return fmt.Errorf("%w: %q", ErrUnsupportedFormat, data.Format)
+	}
+
+	archive := zip.NewWriter(w)
+	manifest := ArchiveManifest{
+		UserID:     data.UserID,
+		Format:     data.Format,
+		ExportedAt: time.Now().UTC(),
+		Documents:  []ArchiveManifestEntry{},
+	}
+	// names holds the lowercased file names in use, so that the archive
+	// extracts on case insensitive file systems
+	names := make(map[string]bool)
+
+	afterID := ""
+	for {
+		documents, err := s.repo.GetUserDocumentsPage(ctx, data.UserID, afterID, archivePageSize)
+		if err != nil {
+			return err
+		}
+		if len(documents) == 0 {
+			break
+		}
+		ids := make([]string, len(documents))
+		for i, document := range documents {
+			ids[i] = document.ID
+		}
+		roles, err := s.repo.GetUserRoles(ctx, data.UserID, ids)
+		if err != nil {
+			return err
+		}
+
+		for i := range documents {
+			document := &documents[i]
+			entry := ArchiveManifestEntry{
+				ID:          document.ID,
+				Title:       document.Title,
+				OwnerID:     document.OwnerID,
+				Role:        roles[document.ID],
+				ContentMode: document.ContentMode,
+				Version:     document.Version,
+				CreatedAt:   document.CreatedAt,
+				UpdatedAt:   document.UpdatedAt,
+			}
+			if document.OwnerID == data.UserID {
+				entry.Role = RoleOwner
+			}
+
+			out, extension, compressed, err := s.renderArchived(ctx, document, format, data)
+			if err != nil {
+				if ctx.Err() != nil {
+					return ctx.Err()
+				}
+				log.Printf("document %s: failed to export to archive: %v", document.ID, err)
+				entry.Error = "failed to export document"
+			} else {
+				entry.File = uniqueArchiveName(names, exportFilename(document.Title, extension))
+				if err := writeArchiveFile(archive, entry.File, document.UpdatedAt, compressed, out); err != nil {
+					return err
+				}
+			}
+			manifest.Documents = append(manifest.Documents, entry)
+		}
+		afterID = documents[len(documents)-1].ID
+	}
+
+	manifestData, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode archive manifest: %w", err)
+	}
+	if err := writeArchiveFile(archive, "manifest.json", manifest.ExportedAt, false, manifestData); err != nil {
+		return err
+	}
+	return archive.Close()
+}
+
+// renderArchived renders one document of an archive and returns it with its
+// file extension and whether it is already compressed
+func (s *DocumentService) renderArchived(ctx context.Context, document *Document, format exportFormat, data ExportArchiveDTO) ([]byte, string, bool, error) {
+	if document.ContentMode == ContentModeYjs {
+		update, err := s.GetDocumentUpdate(ctx, document.ID, nil)
+		return update, ".yjs", false, err
+	}
+	content, err := decodeContent(document.Content)
+	if err != nil {
+		return nil, "", false, err
+	}
+	out, err := format.render(s, document, content, ExportDocumentDTO{
+		Document: document,
+		Format:   data.Format,
+		PageSize: data.PageSize,
+		Margins:  data.Margins,
+	})
+	return out, format.extension, format.compressed, err
+}
+
+// uniqueArchiveName places a document file in the documents directory of the
+// archive, numbering it when another document has the same name
+func uniqueArchiveName(names map[string]bool, filename string) string {
+	extension := path.Ext(filename)
+	base := strings.TrimSuffix(filename, extension)
+	name := path.Join("documents", filename)
+	for n := 2; names[strings.ToLower(name)]; n++ {
+		name = path.Join("documents", base+" ("+strconv.Itoa(n)+")"+extension)
+	}
+	names[strings.ToLower(name)] = true
+	return name
+}
+
+func writeArchiveFile(archive *zip.Writer, name string, modified time.Time, compressed bool, data []byte) error {
+	header := &zip.FileHeader{
+		Name:     name,
+		Method:   zip.Deflate,
+		Modified: modified,
+	}
+	if compressed {
+		header.Method = zip.Store
+	}
+	file, err := archive.CreateHeader(header)
+	if err != nil {
+		return fmt.Errorf("failed to write archive: %w", err)
+	}
+	if _, err := file.Write(data); err != nil {
+		return fmt.Errorf("failed to write archive: %w", err)
+	}
+	return nil
+}
@@ -1,12 +1,59 @@
 package internal
 
-import "time"
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/emaforlin/ce-document-service/pkg/pdf"
+	"github.com/emaforlin/ce-document-service/pkg/prosemirror"
+)
 
 type UpdateDocumentDTO struct {
 	DocumentID string
+	UserID     string
+	Version    int64
 	Title      string `json:"title"`
 }
 
+type UpdateDocumentContentDTO struct {
+	DocumentID string
+	UserID     string
+	Version    int64
+	// Content is the raw ProseMirror JSON tree as sent by the client
+	Content []byte
+}
+
+type ApplyStepsDTO struct {
+	Document *Document
+	UserID   string
+	// Version is the document version the steps were made on
+	Version  int64             `json:"version" binding:"required"`
+	Steps    []json.RawMessage `json:"steps" binding:"required"`
+	ClientID StepClientID      `json:"clientID" binding:"required"`
+}
+
+// StepClientID identifies the editor instance that produced steps.
+// prosemirror-collab uses random numbers by default, strings are accepted too.
+type StepClientID string
+
+func (id *StepClientID) UnmarshalJSON(data []byte) error {
+	var value any
+	if err := json.Unmarshal(data, &value); err != nil {
+		return err
+	}
+	switch v := value.(type) {
+	case string:
+		*id = StepClientID(v)
+	case float64:
+		*id = StepClientID(strconv.FormatFloat(v, 'f', -1, 64))
+	default:
+		return fmt.Errorf("clientID must be a string or a number")
+	}
+	return nil
+}
+
 type RemoveCollaboratorDTO struct {
 	DocumentID string
 	UserID     string `json:"user_id"`
@@ -20,8 +67,26 @@ type AddCollaboratorDTO struct {
 }
 
 type CreateDocumentDTO struct {
-	Title   string `json:"title" binding:"required"`
-	OwnerID string
+	Title       string      `json:"title" binding:"required_without=TemplateID"`
+	ContentMode ContentMode `json:"content_mode" binding:"omitempty,oneof=prosemirror yjs"`
+	FolderID    *string     `json:"folder_id" binding:"omitempty,uuid"`
+	// WorkspaceID adds the document to a workspace the user is a member of
+	WorkspaceID *string `json:"workspace_id" binding:"omitempty,uuid"`
+	// TemplateID copies the content, and unless given the title, of a
+	// template, filling its {{variable}} placeholders from Variables
+	TemplateID *string           `json:"template_id" binding:"omitempty,uuid"`
+	Variables  map[string]string `json:"variables" binding:"omitempty,max=100"`
+	OwnerID    string
+	// Content is the initial content of prosemirror documents, already
+	// validated against the schema
+	Content *prosemirror.Node `json:"-"`
+}
+
+type PushUpdateDTO struct {
+	Document *Document
+	UserID   string
+	// Update is a Yjs update in the v1 encoding
+	Update []byte
 }
 
 type GetOneDocumentDTO struct {
@@ -30,20 +95,417 @@ type GetOneDocumentDTO struct {
 }
 
 type DocumentResponse struct {
-	ID        string    `json:"id"`
-	OwnerID   string    `json:"owner_id"`
+	ID          string             `json:"id"`
+	OwnerID     string             `json:"owner_id"`
+	Title       string             `json:"title"`
+	Version     int64              `json:"version"`
+	ContentMode ContentMode        `json:"content_mode"`
+	FolderID    *string            `json:"folder_id"`
+	Tags        []string           `json:"tags"`
+	Template    TemplateVisibility `json:"template,omitempty"`
+	// SourceDocumentID is the document this one was duplicated from
+	SourceDocumentID *string   `json:"source_document_id"`
+	WorkspaceID      *string   `json:"workspace_id"`
+	CreatedAt        time.Time `json:"created_at"`
+	UpdatedAt        time.Time `json:"updated_at"`
+}
+
+type DocumentDetailResponse struct {
+	ID          string             `json:"id"`
+	OwnerID     string             `json:"owner_id"`
+	Title       string             `json:"title"`
+	Content     interface{}        `json:"content"`
+	Version     int64              `json:"version"`
+	ContentMode ContentMode        `json:"content_mode"`
+	FolderID    *string            `json:"folder_id"`
+	Tags        []string           `json:"tags"`
+	Template    TemplateVisibility `json:"template,omitempty"`
+	// SourceDocumentID is the document this one was duplicated from
+	SourceDocumentID *string   `json:"source_document_id"`
+	WorkspaceID      *string   `json:"workspace_id"`
+	CreatedAt        time.Time `json:"created_at"`
+	UpdatedAt        time.Time `json:"updated_at"`
+}
+
+type RestoreRevisionDTO struct {
+	DocumentID string
+	UserID     string
+	Version    int64
+	Revision   int64
+}
+
+type RevisionResponse struct {
+	Version   int64     `json:"version"`
 	Title     string    `json:"title"`
+	AuthorID  string    `json:"author_id"`
 	CreatedAt time.Time `json:"created_at"`
-	UpdatedAt time.Time `json:"updated_at"`
 }
 
-type DocumentDetailResponse struct {
-	ID        string      `json:"id"`
-	OwnerID   string      `json:"owner_id"`
+type RevisionDetailResponse struct {
+	Version   int64       `json:"version"`
 	Title     string      `json:"title"`
 	Content   interface{} `json:"content"`
+	AuthorID  string      `json:"author_id"`
 	CreatedAt time.Time   `json:"created_at"`
-	UpdatedAt time.Time   `json:"updated_at"`
+}
+
+type DiffDocumentDTO struct {
+	Document *Document
+	// From and To are document versions, zero meaning the current one
+	From int64
+	To   int64
+}
+
+type DocumentDiffResponse struct {
+	From    int64                `json:"from"`
+	To      int64                `json:"to"`
+	Changes []prosemirror.Change `json:"changes"`
+}
+
+type LockDocumentDTO struct {
+	Document *Document
+	UserID   string
+	// TTL is the lease in seconds, the configured default when zero
+	TTL int64 `json:"ttl" binding:"omitempty,min=1"`
+	// From and To restrict the lock to a node range when both are set
+	From *int `json:"from" binding:"omitempty,min=0"`
+	To   *int `json:"to" binding:"omitempty,min=0"`
+}
+
+type RenewLockDTO struct {
+	DocumentID string
+	LockID     string
+	UserID     string
+	TTL        int64 `json:"ttl" binding:"omitempty,min=1"`
+}
+
+type UnlockDocumentDTO struct {
+	DocumentID string
+	// LockID selects a single lock, every lock of the holder when empty
+	LockID string
+	// HolderID restricts the release to locks held by that user, empty for a
+	// forced release by the document owner
+	HolderID string
+}
+
+type LockResponse struct {
+	ID        string    `json:"id"`
+	HolderID  string    `json:"holder_id"`
+	From      *int      `json:"from,omitempty"`
+	To        *int      `json:"to,omitempty"`
+	ExpiresAt time.Time `json:"expires_at"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type ExportDocumentDTO struct {
+	Document *Document
+	Format   string
+	// Fragment renders HTML without the surrounding page
+	Fragment bool
+	// PageSize and Margins lay out PDF pages
+	PageSize pdf.Size
+	Margins  pdf.Margins
+}
+
+type ListDocumentsDTO struct {
+	UserID      string
+	Role        string   `form:"role" binding:"omitempty,oneof=owned shared editor viewer"`
+	TitlePrefix string   `form:"title_prefix" binding:"omitempty,max=255"`
+	FolderID    string   `form:"folder_id" binding:"omitempty,uuid"`
+	Tags        []string `form:"tag"`
+	// Templates keeps the templates the user can create documents from
+	Templates bool `form:"templates"`
+	// WorkspaceID keeps the documents of a workspace, it is set from the path
+	// of the workspace documents listing
+	WorkspaceID string `form:"-"`
+	// TagMatch tells whether documents need all the tags or any of them
+	TagMatch      string    `form:"tag_match" binding:"omitempty,oneof=all any"`
+	UpdatedAfter  time.Time `form:"updated_after"`
+	UpdatedBefore time.Time `form:"updated_before"`
+	Sort          string    `form:"sort" binding:"omitempty,oneof=updated_at created_at title"`
+	Order         string    `form:"order" binding:"omitempty,oneof=asc desc"`
+	Limit         int       `form:"limit" binding:"omitempty,min=1,max=100"`
+	// Cursor is the next_cursor of the previous page
+	Cursor string `form:"cursor"`
+}
+
+type DocumentListItemResponse struct {
+	DocumentResponse
+	Role Role `json:"role"`
+}
+
+type DocumentListResponse struct {
+	Documents []DocumentListItemResponse `json:"documents"`
+	// NextCursor is null on the last page
+	NextCursor *string `json:"next_cursor"`
+}
+
+type SearchDocumentsDTO struct {
+	UserID string
+	Query  string `form:"q" binding:"required"`
+	Limit  int    `form:"limit" binding:"omitempty,min=1,max=100"`
+	Offset int    `form:"offset" binding:"omitempty,min=0"`
+}
+
+type DocumentSearchResultResponse struct {
+	ID          string      `json:"id"`
+	OwnerID     string      `json:"owner_id"`
+	Title       string      `json:"title"`
+	Role        Role        `json:"role"`
+	Version     int64       `json:"version"`
+	ContentMode ContentMode `json:"content_mode"`
+	CreatedAt   time.Time   `json:"created_at"`
+	UpdatedAt   time.Time   `json:"updated_at"`
+	Rank        float64     `json:"rank"`
+	// TitleHighlight and Snippet are HTML, with matches in mark elements
+	TitleHighlight string `json:"title_highlight"`
+	Snippet        string `json:"snippet"`
+}
+
+type SearchDocumentsResponse struct {
+	Query   string                         `json:"query"`
+	Results []DocumentSearchResultResponse `json:"results"`
+}
+
+type SuggestDocumentsDTO struct {
+	UserID string
+	Query  string `form:"q" binding:"required,max=255"`
+	Limit  int    `form:"limit" binding:"omitempty,min=1,max=20"`
+}
+
+type DocumentSuggestionResponse struct {
+	ID    string  `json:"id"`
+	Title string  `json:"title"`
+	Role  Role    `json:"role"`
+	Score float64 `json:"score"`
+}
+
+type SuggestDocumentsResponse struct {
+	Query       string                       `json:"query"`
+	Suggestions []DocumentSuggestionResponse `json:"suggestions"`
+}
+
+type CreateFolderDTO struct {
+	OwnerID string
+	Name    string `json:"name" binding:"required,max=255"`
+	// ParentID is the folder to create the folder in, null for the top level
+	ParentID *string `json:"parent_id" binding:"omitempty,uuid"`
+}
+
+type RenameFolderDTO struct {
+	FolderID string
+	Name     string `json:"name" binding:"required,max=255"`
+}
+
+type MoveFolderDTO struct {
+	Folder *Folder
+	UserID string
+	// ParentID is the new parent, null moves the folder to the top level
+	ParentID *string `json:"parent_id" binding:"omitempty,uuid"`
+}
+
+type MoveDocumentDTO struct {
+	Document *Document
+	UserID   string
+	// FolderID is the new folder, null moves the document out of any folder
+	FolderID *string `json:"folder_id" binding:"omitempty,uuid"`
+}
+
+type AddFolderCollaboratorDTO struct {
+	FolderID string
+	UserID   string `json:"user_id" binding:"required"`
+	// Role is granted on every document and subfolder, ownership stays with
+	// the owners of the folder and of each document
+	Role Role `json:"role" binding:"required,oneof=editor viewer"`
+}
+
+type RemoveFolderCollaboratorDTO struct {
+	FolderID string
+	UserID   string `json:"user_id" binding:"required"`
+}
+
+type FolderResponse struct {
+	ID        string    `json:"id"`
+	ParentID  *string   `json:"parent_id"`
+	OwnerID   string    `json:"owner_id"`
+	Name      string    `json:"name"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+type FolderListItemResponse struct {
+	FolderResponse
+	Role Role `json:"role"`
+}
+
+type FolderDetailResponse struct {
+	FolderResponse
+	Role Role `json:"role"`
+	// Folders are the subfolders, the documents are listed through
+	// GET /documents?folder_id=
+	Folders []FolderResponse `json:"folders"`
+}
+
+type TagDocumentDTO struct {
+	DocumentID string
+	Tags       []string `json:"tags" binding:"required,min=1"`
+}
+
+type UntagDocumentDTO struct {
+	DocumentID string
+	Tag        string
+}
+
+type DocumentTagsResponse struct {
+	Tags []string `json:"tags"`
+}
+
+type TagCountResponse struct {
+	Name  string `json:"name"`
+	Count int64  `json:"count"`
+}
+
+type TrashedDocumentResponse struct {
+	DocumentResponse
+	DeletedAt time.Time `json:"deleted_at"`
+	// PurgeAt is null when trashed documents are kept until removed by hand
+	PurgeAt *time.Time `json:"purge_at"`
+}
+
+type DuplicateDocumentDTO struct {
+	Document *Document
+	UserID   string
+	// Role is the role of the user on the document
+	Role Role
+	// Title defaults to the title of the document
+	Title             string `json:"title" binding:"omitempty,max=255"`
+	CopyCollaborators bool   `json:"copy_collaborators"`
+	CopyHistory       bool   `json:"copy_history"`
+}
+
+type CreateWorkspaceDTO struct {
+	UserID string
+	Name   string `json:"name" binding:"required,max=255"`
+}
+
+type SetWorkspaceMemberDTO struct {
+	WorkspaceID string
+	UserID      string        `json:"user_id" binding:"required,uuid"`
+	Role        WorkspaceRole `json:"role" binding:"required,oneof=admin member guest"`
+}
+
+type RemoveWorkspaceMemberDTO struct {
+	WorkspaceID string
+	UserID      string
+}
+
+type MoveDocumentWorkspaceDTO struct {
+	Document *Document
+	UserID   string
+	// WorkspaceID is the new workspace, null takes the document out of any
+	// workspace
+	WorkspaceID *string `json:"workspace_id" binding:"omitempty,uuid"`
+}
+
+type WorkspaceResponse struct {
+	ID        string    `json:"id"`
+	Name      string    `json:"name"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+type WorkspaceListItemResponse struct {
+	WorkspaceResponse
+	Role WorkspaceRole `json:"role"`
+}
+
+type WorkspaceMemberResponse struct {
+	UserID    string        `json:"user_id"`
+	Role      WorkspaceRole `json:"role"`
+	CreatedAt time.Time     `json:"created_at"`
+}
+
+type TransferOwnershipDTO struct {
+	Document *Document
+	UserID   string
+	// ToUserID is the user asked to become the owner
+	ToUserID     string `json:"user_id" binding:"required,uuid"`
+	KeepAsEditor bool   `json:"keep_as_editor"`
+}
+
+type TransferResponse struct {
+	ID            string    `json:"id"`
+	DocumentID    string    `json:"document_id"`
+	DocumentTitle string    `json:"document_title,omitempty"`
+	FromUserID    string    `json:"from_user_id"`
+	ToUserID      string    `json:"to_user_id"`
+	KeepAsEditor  bool      `json:"keep_as_editor"`
+	CreatedAt     time.Time `json:"created_at"`
+}
+
+type SetTemplateDTO struct {
+	DocumentID string
+	Visibility TemplateVisibility `json:"visibility" binding:"required,oneof=personal shared"`
+}
+
+type ExportArchiveDTO struct {
+	UserID string
+	Format string
+	// PageSize and Margins lay out PDF pages
+	PageSize pdf.Size
+	Margins  pdf.Margins
+}
+
+type ImportDocumentDTO struct {
+	OwnerID string
+	// Title overrides the title found in the file
+	Title  string
+	Format string
+	Data   []byte
+}
+
+type ImportContentDTO struct {
+	Document *Document
+	UserID   string
+	Version  int64
+	Format   string
+	Mode     ImportMode
+	Data     []byte
+}
+
+type ImportDocumentResponse struct {
+	DocumentResponse
+	Warnings prosemirror.Warnings `json:"warnings"`
+}
+
+type ImportContentResponse struct {
+	Message  string               `json:"message"`
+	Version  int64                `json:"version"`
+	Warnings prosemirror.Warnings `json:"warnings"`
+}
+
+type UpdatePresenceDTO struct {
+	// SessionID identifies the editor instance, chosen by the client
+	SessionID string             `json:"session_id" binding:"required,max=64"`
+	Color     string             `json:"color" binding:"omitempty,hexcolor"`
+	Selection *PresenceSelection `json:"selection"`
+}
+
+type DocumentPresenceResponse struct {
+	// TTL is how many seconds a session stays present without a heartbeat
+	TTL      int64      `json:"ttl"`
+	Sessions []Presence `json:"sessions"`
+}
+
+type PresenceEventResponse struct {
+	Type     PresenceEventType `json:"type"`
+	Presence Presence          `json:"presence"`
+}
+
+type DocumentStepsResponse struct {
+	Version   int64             `json:"version"`
+	Steps     []json.RawMessage `json:"steps"`
+	ClientIDs []string          `json:"client_ids"`
 }
 
 type CollaboratorResponse struct {
@@ -55,11 +517,18 @@ type CollaboratorResponse struct {
 
 func ToDocumentResponse(doc *Document) DocumentResponse {
 	return DocumentResponse{
-		ID:        doc.ID,
-		OwnerID:   doc.OwnerID,
-		Title:     doc.Title,
-		CreatedAt: doc.CreatedAt,
-		UpdatedAt: doc.UpdatedAt,
+		ID:               doc.ID,
+		OwnerID:          doc.OwnerID,
+		Title:            doc.Title,
+		Version:          doc.Version,
+		ContentMode:      doc.ContentMode,
+		FolderID:         doc.FolderID,
+		Tags:             ToTagNames(doc.Tags),
+		Template:         doc.Template,
+		SourceDocumentID: doc.SourceDocumentID,
+		WorkspaceID:      doc.WorkspaceID,
+		CreatedAt:        doc.CreatedAt,
+		UpdatedAt:        doc.UpdatedAt,
 	}
 }
 
@@ -73,15 +542,63 @@ func ToDocumentDetailResponse(doc *Document) DocumentDetailResponse {
 	}
 
 	return DocumentDetailResponse{
-		ID:        doc.ID,
-		OwnerID:   doc.OwnerID,
-		Title:     doc.Title,
+		ID:               doc.ID,
+		OwnerID:          doc.OwnerID,
+		Title:            doc.Title,
+		Content:          content,
+		Version:          doc.Version,
+		ContentMode:      doc.ContentMode,
+		FolderID:         doc.FolderID,
+		Tags:             ToTagNames(doc.Tags),
+		Template:         doc.Template,
+		SourceDocumentID: doc.SourceDocumentID,
+		WorkspaceID:      doc.WorkspaceID,
+		CreatedAt:        doc.CreatedAt,
+		UpdatedAt:        doc.UpdatedAt,
+	}
+}
+
+func ToRevisionResponse(rev *DocumentRevision) RevisionResponse {
+	return RevisionResponse{
+		Version:   rev.Version,
+		Title:     rev.Title,
+		AuthorID:  rev.AuthorID,
+		CreatedAt: rev.CreatedAt,
+	}
+}
+
+func ToRevisionDetailResponse(rev *DocumentRevision) RevisionDetailResponse {
+	var content interface{}
+	if rev.Content != nil {
+		if err := rev.Content.AssignTo(&content); err != nil {
+			content = nil
+		}
+	}
+
+	return RevisionDetailResponse{
+		Version:   rev.Version,
+		Title:     rev.Title,
 		Content:   content,
-		CreatedAt: doc.CreatedAt,
-		UpdatedAt: doc.UpdatedAt,
+		AuthorID:  rev.AuthorID,
+		CreatedAt: rev.CreatedAt,
 	}
 }
 
+// ToDocumentStepsResponse lists steps in the shape prosemirror-collab's
+// receiveTransaction expects. version is the version after the last step.
+func ToDocumentStepsResponse(version int64, steps []DocumentStep) DocumentStepsResponse {
+	response := DocumentStepsResponse{
+		Version:   version,
+		Steps:     make([]json.RawMessage, len(steps)),
+		ClientIDs: make([]string, len(steps)),
+	}
+	for i, step := range steps {
+		response.Steps[i] = step.Step.Bytes
+		response.ClientIDs[i] = step.ClientID
+	}
+	return response
+}
+
 func ToCollaboratorResponse(perm *DocumentPermission) CollaboratorResponse {
 	return CollaboratorResponse{
 		UserID: perm.UserID,
@@ -89,6 +606,17 @@ func ToCollaboratorResponse(perm *DocumentPermission) CollaboratorResponse {
 	}
 }
 
+func ToLockResponse(lock *DocumentLock) LockResponse {
+	return LockResponse{
+		ID:        lock.ID,
+		HolderID:  lock.HolderID,
+		From:      lock.From,
+		To:        lock.To,
+		ExpiresAt: lock.ExpiresAt,
+		CreatedAt: lock.CreatedAt,
+	}
+}
+
 // Generic function to convert a slice of models to a slice of responses
 func ToResponseList[T any, R any](items []T, converter func(*T) R) []R {
 	responses := make([]R, len(items))
@@ -103,6 +631,204 @@ func ToDocumentResponseList(docs []Document) []DocumentResponse {
 	return ToResponseList(docs, ToDocumentResponse)
 }
 
+func ToRevisionResponseList(revisions []DocumentRevision) []RevisionResponse {
+	return ToResponseList(revisions, ToRevisionResponse)
+}
+
 func ToCollaboratorResponseList(perms []DocumentPermission) []CollaboratorResponse {
 	return ToResponseList(perms, ToCollaboratorResponse)
 }
+
+func ToLockResponseList(locks []DocumentLock) []LockResponse {
+	return ToResponseList(locks, ToLockResponse)
+}
+
+func ToSearchResultResponse(result *DocumentSearchResult) DocumentSearchResultResponse {
+	return DocumentSearchResultResponse{
+		ID:             result.ID,
+		OwnerID:        result.OwnerID,
+		Title:          result.Title,
+		Role:           result.Role,
+		Version:        result.Version,
+		ContentMode:    result.ContentMode,
+		CreatedAt:      result.CreatedAt,
+		UpdatedAt:      result.UpdatedAt,
+		Rank:           result.Rank,
+		TitleHighlight: highlightHTML(result.TitleHighlight),
+		Snippet:        highlightHTML(result.Snippet),
+	}
+}
+
+func ToSearchResultResponseList(results []DocumentSearchResult) []DocumentSearchResultResponse {
+	return ToResponseList(results, ToSearchResultResponse)
+}
+
+func ToListItemResponse(item *DocumentListItem) DocumentListItemResponse {
+	return DocumentListItemResponse{
+		DocumentResponse: DocumentResponse{
+			ID:               item.ID,
+			OwnerID:          item.OwnerID,
+			Title:            item.Title,
+			Version:          item.Version,
+			ContentMode:      item.ContentMode,
+			FolderID:         item.FolderID,
+			Tags:             ToTagNames(item.Tags),
+			Template:         item.Template,
+			SourceDocumentID: item.SourceDocumentID,
+			WorkspaceID:      item.WorkspaceID,
+			CreatedAt:        item.CreatedAt,
+			UpdatedAt:        item.UpdatedAt,
+		},
+		Role: item.Role,
+	}
+}
+
+func ToListItemResponseList(items []DocumentListItem) []DocumentListItemResponse {
+	return ToResponseList(items, ToListItemResponse)
+}
+
+func ToSuggestionResponse(suggestion *DocumentSuggestion) DocumentSuggestionResponse {
+	return DocumentSuggestionResponse{
+		ID:    suggestion.ID,
+		Title: suggestion.Title,
+		Role:  suggestion.Role,
+		Score: suggestion.Score,
+	}
+}
+
+func ToSuggestionResponseList(suggestions []DocumentSuggestion) []DocumentSuggestionResponse {
+	return ToResponseList(suggestions, ToSuggestionResponse)
+}
+
+func ToFolderResponse(folder *Folder) FolderResponse {
+	return FolderResponse{
+		ID:        folder.ID,
+		ParentID:  folder.ParentID,
+		OwnerID:   folder.OwnerID,
+		Name:      folder.Name,
+		CreatedAt: folder.CreatedAt,
+		UpdatedAt: folder.UpdatedAt,
+	}
+}
+
+func ToFolderResponseList(folders []Folder) []FolderResponse {
+	return ToResponseList(folders, ToFolderResponse)
+}
+
+func ToFolderListItemResponse(item *FolderListItem) FolderListItemResponse {
+	return FolderListItemResponse{
+		FolderResponse: FolderResponse{
+			ID:        item.ID,
+			ParentID:  item.ParentID,
+			OwnerID:   item.OwnerID,
+			Name:      item.Name,
+			CreatedAt: item.CreatedAt,
+			UpdatedAt: item.UpdatedAt,
+		},
+		Role: item.Role,
+	}
+}
+
+func ToFolderListItemResponseList(items []FolderListItem) []FolderListItemResponse {
+	return ToResponseList(items, ToFolderListItemResponse)
+}
+
+func ToFolderCollaboratorResponse(perm *FolderPermission) CollaboratorResponse {
+	return CollaboratorResponse{
+		UserID: perm.UserID,
+		Role:   perm.Role,
+	}
+}
+
+func ToFolderCollaboratorResponseList(perms []FolderPermission) []CollaboratorResponse {
+	return ToResponseList(perms, ToFolderCollaboratorResponse)
+}
+
+// ToTagNames returns the names of tags, never nil so that documents without
+// tags render an empty list
+func ToTagNames(tags []Tag) []string {
+	names := make([]string, len(tags))
+	for i, tag := range tags {
+		names[i] = tag.Name
+	}
+	return names
+}
+
+func ToTagCountResponse(tag *TagCount) TagCountResponse {
+	return TagCountResponse{
+		Name:  tag.Name,
+		Count: tag.Count,
+	}
+}
+
+func ToTagCountResponseList(tags []TagCount) []TagCountResponse {
+	return ToResponseList(tags, ToTagCountResponse)
+}
+
+func ToTrashedDocumentResponse(document *TrashedDocument) TrashedDocumentResponse {
+	return TrashedDocumentResponse{
+		DocumentResponse: ToDocumentResponse(&document.Document),
+		DeletedAt:        document.DeletedAt.Time,
+		PurgeAt:          document.PurgeAt,
+	}
+}
+
+func ToTrashedDocumentResponseList(documents []TrashedDocument) []TrashedDocumentResponse {
+	return ToResponseList(documents, ToTrashedDocumentResponse)
+}
+
+func ToTransferResponse(transfer *OwnershipTransfer) TransferResponse {
+	response := TransferResponse{
+		ID:           transfer.ID,
+		DocumentID:   transfer.DocumentID,
+		FromUserID:   transfer.FromUserID,
+		ToUserID:     transfer.ToUserID,
+		KeepAsEditor: transfer.KeepAsEditor,
+		CreatedAt:    transfer.CreatedAt,
+	}
+	if transfer.Document != nil {
+		response.DocumentTitle = transfer.Document.Title
+	}
+	return response
+}
+
+func ToTransferResponseList(transfers []OwnershipTransfer) []TransferResponse {
+	return ToResponseList(transfers, ToTransferResponse)
+}
+
+func ToWorkspaceResponse(workspace *Workspace) WorkspaceResponse {
+	return WorkspaceResponse{
+		ID:        workspace.ID,
+		Name:      workspace.Name,
+		CreatedAt: workspace.CreatedAt,
+		UpdatedAt: workspace.UpdatedAt,
+	}
+}
+
+func ToWorkspaceListItemResponse(item *WorkspaceListItem) WorkspaceListItemResponse {
+	return WorkspaceListItemResponse{
+		WorkspaceResponse: WorkspaceResponse{
+			ID:        item.ID,
+			Name:      item.Name,
+			CreatedAt: item.CreatedAt,
+			UpdatedAt: item.UpdatedAt,
+		},
+		Role: item.Role,
+	}
+}
+
+func ToWorkspaceListItemResponseList(items []WorkspaceListItem) []WorkspaceListItemResponse {
+	return ToResponseList(items, ToWorkspaceListItemResponse)
+}
+
+func ToWorkspaceMemberResponse(member *WorkspaceMember) WorkspaceMemberResponse {
+	return WorkspaceMemberResponse{
+		UserID:    member.UserID,
+		Role:      member.Role,
+		CreatedAt: member.CreatedAt,
+	}
+}
+
+func ToWorkspaceMemberResponseList(members []WorkspaceMember) []WorkspaceMemberResponse {
+	return ToResponseList(members, ToWorkspaceMemberResponse)
+}
@@ -0,0 +1,59 @@
+package internal
+
+import (
+	"context"
+	"fmt"
+)
+
+// DuplicateDocument creates a copy of data.Document owned by data.UserID. The
+// copy stays in the folder and workspace of the original when the user can
+// add documents there, and gets the collaborators and revision history of
+// the original on request.
+// Only the owner, who can see the collaborators, can copy them.
+func (s *DocumentService) DuplicateDocument(ctx context.Context, data DuplicateDocumentDTO) (*Document, error) {
+	source := data.Document
+	if data.CopyCollaborators && data.Role != RoleOwner {
+		return nil, fmt.Errorf("failed to duplicate document: %w", ErrCollaboratorsHidden)
+	}
+
+	document := Document{
+		OwnerID:          data.UserID,
+		Title:            source.Title,
+		Content:          source.Content,
+		Version:          1,
+		ContentMode:      source.ContentMode,
+		SourceDocumentID: &source.ID,
+	}
+	if data.Title != "" {
+		document.Title = data.Title
+	}
+	// Copied revisions go up to the version of the original
+	if data.CopyHistory {
+		document.Version = source.Version
+	}
+	if source.FolderID != nil && s.checkFolderRole(ctx, data.UserID, *source.FolderID, RoleEditor) == nil {
+		document.FolderID = source.FolderID
+	}
+	if source.WorkspaceID != nil && s.checkWorkspaceRole(ctx, data.UserID, *source.WorkspaceID, WorkspaceRoleMember) == nil {
+		document.WorkspaceID = source.WorkspaceID
+	}
+
+	permissions := []DocumentPermission{{UserID: data.UserID, Role: RoleOwner}}
+	if data.CopyCollaborators {
+		for _, permission := range s.repo.GetDocumentPermissions(ctx, source.ID) {
+			if permission.UserID == data.UserID || permission.Role == RoleOwner {
+				continue
+			}
+			permissions = append(permissions, permission)
+		}
+	}
+
+	doc, err := s.repo.DuplicateDocument(ctx, document, permissions, data.CopyHistory)
+	if err != nil {
+		return nil, fmt.Errorf("failed to duplicate document: %w", err)
+	}
+	if data.CopyHistory {
+		s.pruneRevisions(ctx, doc.ID)
+	}
+	return doc, nil
+}
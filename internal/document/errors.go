@@ -0,0 +1,105 @@
+package internal
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+var (
+	// ErrVersionConflict is returned when a write is based on a stale document version
+	ErrVersionConflict = errors.New("document version conflict")
+	// ErrRevisionNotFound is returned when a document revision does not exist
+	ErrRevisionNotFound = errors.New("revision not found")
+	// ErrInvalidUpdate is returned when a Yjs update cannot be decoded
+	ErrInvalidUpdate = errors.New("invalid update")
+	// ErrDocumentLocked is returned when a write hits a lock held by another user
+	ErrDocumentLocked = errors.New("document is locked")
+	// ErrLockNotFound is returned when a lock does not exist, expired or is
+	// held by someone else
+	ErrLockNotFound = errors.New("lock not found")
+	// ErrInvalidLock is returned for lock requests with a TTL above the
+	// maximum or a node range that is empty or outside the document
+	ErrInvalidLock = errors.New("invalid lock")
+	// ErrUnsupportedFormat is returned for unknown export and import formats
+	ErrUnsupportedFormat = errors.New("unsupported format")
+	// ErrInvalidImport is returned when an uploaded file cannot be read or
+	// converted into valid content
+	ErrInvalidImport = errors.New("invalid import")
+	// ErrInvalidExportOptions is returned when export options, like PDF page
+	// margins, cannot be honored
+	ErrInvalidExportOptions = errors.New("invalid export options")
+	// ErrInvalidSteps is returned when submitted steps cannot be parsed or
+	// applied to the document
+	ErrInvalidSteps = errors.New("invalid steps")
+	// ErrInvalidSearch is returned for empty search queries
+	ErrInvalidSearch = errors.New("invalid search")
+	// ErrInvalidListing is returned for listing requests with an unknown sort
+	// or a cursor that is malformed or made for another sort
+	ErrInvalidListing = errors.New("invalid listing")
+	// ErrFolderNotFound is returned when a folder does not exist or the user
+	// has no access to it
+	ErrFolderNotFound = errors.New("folder not found")
+	// ErrInvalidFolder is returned for blank folder names and for moves that
+	// would put a folder inside itself
+	ErrInvalidFolder = errors.New("invalid folder")
+	// ErrNotInTrash is returned when a document is not in the trash of the user
+	ErrNotInTrash = errors.New("document not found in trash")
+	// ErrTemplateNotFound is returned when a document does not exist, is not a
+	// template or is a template the user cannot use
+	ErrTemplateNotFound = errors.New("template not found")
+	// ErrCollaboratorsHidden is returned when someone other than the owner
+	// asks to copy the collaborators of a document
+	ErrCollaboratorsHidden = errors.New("only the owner can copy collaborators")
+	// ErrTransferNotFound is returned when an ownership transfer does not
+	// exist, is not addressed to the user or its document changed owner
+	ErrTransferNotFound = errors.New("transfer not found")
+	// ErrInvalidTransfer is returned for transfers to the current owner and
+	// transfers requested by someone other than the owner
+	ErrInvalidTransfer = errors.New("invalid transfer")
+	// ErrWorkspaceNotFound is returned when a workspace does not exist or the
+	// user is not a member of it
+	ErrWorkspaceNotFound = errors.New("workspace not found")
+	// ErrWorkspaceForbidden is returned when the workspace role of the user
+	// does not allow the operation
+	ErrWorkspaceForbidden = errors.New("workspace role does not allow this")
+	// ErrInvalidWorkspace is returned for blank workspace names and for
+	// changes that would leave a workspace without admins
+	ErrInvalidWorkspace = errors.New("invalid workspace")
+	// ErrInvalidTag is returned for blank or overlong tag names
+	ErrInvalidTag = errors.New("invalid tag")
+)
+
+// ErrStepsUnavailable is returned when the steps leading from a version to the
+// current one are not all recorded, because the document was changed through
+// a full content write or old steps were pruned. Clients have to reload.
+var ErrStepsUnavailable = errors.New("steps since the given version are not available")
+
+// StaleStepsError is returned when steps are submitted on top of an outdated
+// version. It carries the steps the client missed so it can rebase its own.
+type StaleStepsError struct {
+	Version int64
+	Steps   []DocumentStep
+}
+
+func (e *StaleStepsError) Error() string {
+	return fmt.Sprintf("document is at version %d, %d steps behind", e.Version, len(e.Steps))
+}
+
+func (e *StaleStepsError) Unwrap() error {
+	return ErrVersionConflict
+}
+
+// LockedError is returned when a lock held by another user blocks a write or
+// the acquisition of a lock
+type LockedError struct {
+	Lock DocumentLock
+}
+
+func (e *LockedError) Error() string {
+	return fmt.Sprintf("document is locked by %s until %s", e.Lock.HolderID, e.Lock.ExpiresAt.Format(time.RFC3339))
+}
+
+func (e *LockedError) Unwrap() error {
+	return ErrDocumentLocked
+}
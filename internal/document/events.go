@@ -0,0 +1,48 @@
+package internal
+
+import "sync"
+
+type DocumentEventType string
+
+const (
+	// EventContentUpdated is published after the document content changed
+	EventContentUpdated DocumentEventType = "content_updated"
+	// EventMetadataUpdated is published after a write that left the content untouched
+	EventMetadataUpdated DocumentEventType = "metadata_updated"
+	EventDocumentDeleted DocumentEventType = "document_deleted"
+	// EventCollaboratorRemoved carries the user that lost access in UserID
+	EventCollaboratorRemoved DocumentEventType = "collaborator_removed"
+	// EventStepsApplied carries the applied ProseMirror steps in Steps
+	EventStepsApplied DocumentEventType = "steps_applied"
+)
+
+// DocumentEvent notifies in-process subscribers about document changes.
+// Version is the document version after the change, when it has one.
+type DocumentEvent struct {
+	Type       DocumentEventType
+	DocumentID string
+	UserID     string
+	Version    int64
+	Steps      []DocumentStep
+}
+
+// eventBus dispatches document events to subscribers synchronously, listeners
+// must not block
+type eventBus struct {
+	mu        sync.RWMutex
+	listeners []func(DocumentEvent)
+}
+
+func (b *eventBus) subscribe(listener func(DocumentEvent)) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.listeners = append(b.listeners, listener)
+}
+
+func (b *eventBus) publish(event DocumentEvent) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	for _, listener := range b.listeners {
+		listener(event)
+	}
+}
@@ -0,0 +1,226 @@
+package internal
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html/template"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/emaforlin/ce-document-service/pkg/docx"
+	"github.com/emaforlin/ce-document-service/pkg/pdf"
+	"github.com/emaforlin/ce-document-service/pkg/prosemirror"
+)
+
+// exportFormat renders the content of a document into a downloadable file
+type exportFormat struct {
+	contentType string
+	extension   string
+	// compressed formats are stored in archives without compressing them again
+	compressed bool
+	render     func(s *DocumentService, document *Document, content *prosemirror.Node, data ExportDocumentDTO) ([]byte, error)
+}
+
+// exportFormats maps the values of the format query parameter to renderers
+var exportFormats = map[string]exportFormat{
+	"json": {
+		contentType: "application/json",
+		extension:   ".json",
+		render: func(_ *DocumentService, _ *Document, content *prosemirror.Node, _ ExportDocumentDTO) ([]byte, error) {
+			return json.MarshalIndent(content, "", "  ")
+		},
+	},
+	"markdown": {
+		contentType: "text/markdown; charset=utf-8",
+		extension:   ".md",
+		render: func(s *DocumentService, _ *Document, content *prosemirror.Node, _ ExportDocumentDTO) ([]byte, error) {
+			return []byte(s.markdown.Serialize(content)), nil
+		},
+	},
+	"html": {
+		contentType: "text/html; charset=utf-8",
+		extension:   ".html",
+		render: func(s *DocumentService, document *Document, content *prosemirror.Node, data ExportDocumentDTO) ([]byte, error) {
+			body := prosemirror.RenderHTML(s.schema, content)
+			if data.Fragment {
+				return []byte(body), nil
+			}
+			return renderHTMLPage(document, body)
+		},
+	},
+	"pdf": {
+		contentType: "application/pdf",
+		extension:   ".pdf",
+		render: func(s *DocumentService, document *Document, content *prosemirror.Node, data ExportDocumentDTO) ([]byte, error) {
+			out, err := pdf.Render(s.schema, content, pdf.Options{
+				Size:    data.PageSize,
+				Margins: data.Margins,
+				Title:   document.Title,
+				Created: document.CreatedAt,
+				Updated: document.UpdatedAt,
+			})
+			if errors.Is(err, pdf.ErrInvalidMargins) {
+				return nil, fmt.Errorf("%w: %v", ErrInvalidExportOptions, err)
+			}
+			return out, err
+		},
+	},
+	"docx": {
+		contentType: "application/vnd.openxmlformats-officedocument.wordprocessingml.document",
+		extension:   ".docx",
+		compressed:  true,
+		render: func(s *DocumentService, document *Document, content *prosemirror.Node, _ ExportDocumentDTO) ([]byte, error) {
+			return docx.Render(s.schema, content, docx.Options{
+				Title:   document.Title,
+				Created: document.CreatedAt,
+				Updated: document.UpdatedAt,
+			})
+		},
+	},
+}
+
+// ExportedDocument is a rendered document ready to be downloaded
+type ExportedDocument struct {
+	Filename    string
+	ContentType string
+	Data        []byte
+}
+
+// ExportDocument renders the current content of a document in data.Format
+func (s *DocumentService) ExportDocument(ctx context.Context, data ExportDocumentDTO) (*ExportedDocument, error) {
+	format, ok := exportFormats[data.Format]
+	if !ok {
+		return nil, fmt.Errorf("%w: %q", ErrUnsupportedFormat, data.Format)
+	}
+	content, err := decodeContent(data.Document.Content)
+	if err != nil {
+		return nil, err
+	}
+	out, err := format.render(s, data.Document, content, data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to export document: %w", err)
+	}
+	return &ExportedDocument{
+		Filename:    exportFilename(data.Document.Title, format.extension),
+		ContentType: format.contentType,
+		Data:        out,
+	}, nil
+}
+
+// exportFilename turns a document title into a file name that is safe on
+// every platform
+func exportFilename(title, extension string) string {
+	name := strings.Map(func(r rune) rune {
+		switch {
+		case unicode.IsLetter(r), unicode.IsDigit(r), r == '-', r == '_', r == '.':
+			return r
+		case unicode.IsSpace(r):
+			return ' '
+		}
+		return -1
+	}, title)
+	name = strings.Trim(strings.Join(strings.Fields(name), " "), ". ")
+	if runes := []rune(name); len(runes) > 100 {
+		name = strings.TrimSpace(string(runes[:100]))
+	}
+	if name == "" {
+		name = "document"
+	}
+	return name + extension
+}
+
+// htmlPageTemplate wraps exported content in a standalone page. The content is
+// rendered by prosemirror.HTMLRenderer, which escapes text and drops unsafe
+// URLs; the CSP keeps scripts out even if something slipped through.
+var htmlPageTemplate = template.Must(template.New("page").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<meta http-equiv="Content-Security-Policy" content="default-src 'none'; img-src http: https: data:; style-src 'unsafe-inline'">
+<title>{{.Title}}</title>
+<style>{{.CSS}}</style>
+</head>
+<body>
+<article class="document">
+<header class="document-header">
+<h1 class="document-title">{{.Title}}</h1>
+<p class="document-meta">Last updated <time datetime="{{.UpdatedAt.Format "2006-01-02T15:04:05Z07:00"}}">{{.UpdatedAt.Format "January 2, 2006 15:04 MST"}}</time></p>
+</header>
+<div class="document-content">
+{{.Content}}
+</div>
+</article>
+</body>
+</html>
+`))
+
+// htmlPageCSS styles exported pages for reading on screen and for printing
+const htmlPageCSS = `
+:root { color-scheme: light; }
+body { margin: 0; background: #fff; color: #1f2328; font: 16px/1.6 Georgia, "Times New Roman", serif; }
+.document { max-width: 46rem; margin: 0 auto; padding: 2.5rem 1.5rem; }
+.document-header { border-bottom: 1px solid #d0d7de; margin-bottom: 2rem; }
+.document-title { margin: 0 0 .25rem; font-size: 2.25rem; line-height: 1.2; }
+.document-meta { margin: 0 0 1rem; color: #59636e; font-size: .875rem; }
+h1, h2, h3, h4, h5, h6 { line-height: 1.25; margin: 1.75em 0 .5em; }
+p, ul, ol, blockquote, pre, table { margin: 0 0 1em; }
+a { color: #0969da; }
+blockquote { margin-left: 0; padding-left: 1em; border-left: .25em solid #d0d7de; color: #59636e; }
+code, pre { font-family: "SFMono-Regular", Consolas, "Liberation Mono", monospace; font-size: .875em; }
+code { padding: .1em .3em; border-radius: 4px; background: #f6f8fa; }
+pre { padding: 1em; overflow-x: auto; border-radius: 6px; background: #f6f8fa; }
+pre code { padding: 0; background: none; }
+img { max-width: 100%; height: auto; }
+hr { border: 0; border-top: 1px solid #d0d7de; margin: 2em 0; }
+table { border-collapse: collapse; width: 100%; }
+th, td { border: 1px solid #d0d7de; padding: .4em .75em; text-align: left; vertical-align: top; }
+th { background: #f6f8fa; }
+th > p:last-child, td > p:last-child, li > p:last-child { margin-bottom: 0; }
+ul[data-type="taskList"] { list-style: none; padding-left: 0; }
+li[data-type="taskItem"] { display: flex; gap: .5em; }
+li[data-type="taskItem"] > label { flex: none; }
+mark { background: #fff8c5; }
+@media print {
+  @page { margin: 2cm; }
+  body { font-size: 11pt; }
+  .document { max-width: none; padding: 0; }
+  a { color: inherit; }
+  a[href^="http"]::after { content: " (" attr(href) ")"; font-size: .8em; color: #59636e; }
+  pre, blockquote, table, img, figure { page-break-inside: avoid; }
+  h1, h2, h3, h4, h5, h6 { page-break-after: avoid; }
+  pre { white-space: pre-wrap; }
+}
+`
+
+// renderHTMLPage builds a standalone page around rendered content, with the
+// title and last update taken from the document details
+func renderHTMLPage(document *Document, content string) ([]byte, error) {
+	details := ToDocumentDetailResponse(document)
+	title := details.Title
+	if strings.TrimSpace(title) == "" {
+		title = "Untitled document"
+	}
+
+	var out bytes.Buffer
+	err := htmlPageTemplate.Execute(&out, struct {
+		Title     string
+		UpdatedAt time.Time
+		CSS       template.CSS
+		Content   template.HTML
+	}{
+		Title:     title,
+		UpdatedAt: details.UpdatedAt.UTC(),
+		CSS:       template.CSS(htmlPageCSS),
+		// The renderer output is escaped and sanitized already
+		Content: template.HTML(content),
+	})
+	if err != nil {
+		return nil, err
+	}
+	return out.Bytes(), nil
+}
@@ -0,0 +1,229 @@
+package internal
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// FolderListItem is a folder of a listing with the role of the user
+type FolderListItem struct {
+	ID        string
+	ParentID  *string
+	OwnerID   string
+	Name      string
+	Role      Role
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
+
+// CreateFolder creates a folder owned by data.OwnerID, at the top level or
+// inside a folder they can edit
+func (s *DocumentService) CreateFolder(ctx context.Context, data CreateFolderDTO) (*Folder, error) {
+	name, err := folderName(data.Name)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create folder: %w", err)
+	}
+	if data.ParentID != nil {
+		if err := s.checkFolderRole(ctx, data.OwnerID, *data.ParentID, RoleEditor); err != nil {
+			return nil, fmt.Errorf("failed to create folder: %w", err)
+		}
+	}
+	folder, err := s.repo.CreateFolder(ctx, Folder{
+		ParentID: data.ParentID,
+		OwnerID:  data.OwnerID,
+		Name:     name,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create folder: %w", err)
+	}
+	return folder, nil
+}
+
+// GetUserFolders returns the folders userID can access at the top of their
+// tree, the ones whose parent they cannot access
+func (s *DocumentService) GetUserFolders(ctx context.Context, userID string) ([]FolderListItem, error) {
+	folders, err := s.repo.GetUserRootFolders(ctx, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch user folders: %w", err)
+	}
+	return folders, nil
+}
+
+// GetFolderWithPermission returns the folder and the role userID has on it,
+// inherited from its ancestors or not
+func (s *DocumentService) GetFolderWithPermission(ctx context.Context, userID, folderID string) (*Folder, string) {
+	return s.repo.GetFolderWithPermission(ctx, userID, folderID)
+}
+
+// GetSubfolders returns the folders directly inside folderID
+func (s *DocumentService) GetSubfolders(ctx context.Context, folderID string) ([]Folder, error) {
+	folders, err := s.repo.GetChildFolders(ctx, folderID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch subfolders: %w", err)
+	}
+	return folders, nil
+}
+
+func (s *DocumentService) RenameFolder(ctx context.Context, data RenameFolderDTO) error {
+	name, err := folderName(data.Name)
+	if err != nil {
+		return fmt.Errorf("failed to rename folder: %w", err)
+	}
+	if err := s.repo.RenameFolder(ctx, data.FolderID, name); err != nil {
+		return fmt.Errorf("failed to rename folder: %w", err)
+	}
+	return nil
+}
+
+// MoveFolder moves a folder inside another one the user can edit, or to the
+// top level when data.ParentID is nil
+func (s *DocumentService) MoveFolder(ctx context.Context, data MoveFolderDTO) error {
+	if data.ParentID != nil {
+		if err := s.checkFolderRole(ctx, data.UserID, *data.ParentID, RoleEditor); err != nil {
+			return fmt.Errorf("failed to move folder: %w", err)
+		}
+	}
+	revoked, err := s.inheritedAccess(ctx, data.Folder.ID, data.Folder.ParentID)
+	if err != nil {
+		return fmt.Errorf("failed to move folder: %w", err)
+	}
+	if err := s.repo.MoveFolder(ctx, data.Folder.ID, data.ParentID); err != nil {
+		return fmt.Errorf("failed to move folder: %w", err)
+	}
+	revoked.publish(&s.events)
+	return nil
+}
+
+// DeleteFolder deletes a folder, moving what it contains to its parent
+func (s *DocumentService) DeleteFolder(ctx context.Context, folder *Folder) error {
+	revoked, err := s.inheritedAccess(ctx, folder.ID, &folder.ID)
+	if err != nil {
+		return fmt.Errorf("failed to delete folder: %w", err)
+	}
+	if err := s.repo.DeleteFolder(ctx, folder.ID); err != nil {
+		return fmt.Errorf("failed to delete folder: %w", err)
+	}
+	revoked.publish(&s.events)
+	return nil
+}
+
+// MoveDocument moves a document inside a folder the user can edit, or out of
+// any folder when data.FolderID is nil
+func (s *DocumentService) MoveDocument(ctx context.Context, data MoveDocumentDTO) error {
+	if data.FolderID != nil {
+		if err := s.checkFolderRole(ctx, data.UserID, *data.FolderID, RoleEditor); err != nil {
+			return fmt.Errorf("failed to move document: %w", err)
+		}
+	}
+	revoked := folderAccess{documentIDs: []string{data.Document.ID}}
+	if data.Document.FolderID != nil {
+		grantees, err := s.repo.GetFolderGrantees(ctx, *data.Document.FolderID)
+		if err != nil {
+			return fmt.Errorf("failed to move document: %w", err)
+		}
+		revoked.userIDs = grantees
+	}
+	if err := s.repo.MoveDocument(ctx, data.Document.ID, data.FolderID); err != nil {
+		return fmt.Errorf("failed to move document: %w", err)
+	}
+	revoked.publish(&s.events)
+	return nil
+}
+
+func (s *DocumentService) GetFolderCollaborators(ctx context.Context, folderID string) ([]FolderPermission, error) {
+	permissions, err := s.repo.GetFolderPermissions(ctx, folderID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch folder collaborators: %w", err)
+	}
+	return permissions, nil
+}
+
+// AddFolderCollaborator shares a folder, and with it every document and
+// subfolder inside, with a user
+func (s *DocumentService) AddFolderCollaborator(ctx context.Context, data AddFolderCollaboratorDTO) error {
+	if err := s.repo.SetFolderPermission(ctx, FolderPermission{
+		FolderID: data.FolderID,
+		UserID:   data.UserID,
+		Role:     data.Role,
+	}); err != nil {
+		return fmt.Errorf("failed to add folder collaborator: %w", err)
+	}
+	// The role may have been lowered, so open sessions are checked again
+	documentIDs, err := s.repo.GetFolderTreeDocumentIDs(ctx, data.FolderID)
+	if err != nil {
+		return fmt.Errorf("failed to add folder collaborator: %w", err)
+	}
+	folderAccess{documentIDs: documentIDs, userIDs: []string{data.UserID}}.publish(&s.events)
+	return nil
+}
+
+func (s *DocumentService) RemoveFolderCollaborator(ctx context.Context, data RemoveFolderCollaboratorDTO) error {
+	documentIDs, err := s.repo.GetFolderTreeDocumentIDs(ctx, data.FolderID)
+	if err != nil {
+		return fmt.Errorf("failed to remove folder collaborator: %w", err)
+	}
+	if err := s.repo.RemoveFolderPermission(ctx, data.UserID, data.FolderID); err != nil {
+		return fmt.Errorf("failed to remove folder collaborator: %w", err)
+	}
+	folderAccess{documentIDs: documentIDs, userIDs: []string{data.UserID}}.publish(&s.events)
+	return nil
+}
+
+// checkFolderRole fails with ErrFolderNotFound unless userID has at least
+// role on the folder
+func (s *DocumentService) checkFolderRole(ctx context.Context, userID, folderID string, role Role) error {
+	folder, permission := s.repo.GetFolderWithPermission(ctx, userID, folderID)
+	if folder == nil || !validatePermission(permission, string(role)) {
+		return ErrFolderNotFound
+	}
+	return nil
+}
+
+// inheritedAccess returns the documents inside folderID and the users that
+// may reach them through folder grants on parentID and its ancestors, which
+// is access a move or deletion of folderID can take away
+func (s *DocumentService) inheritedAccess(ctx context.Context, folderID string, parentID *string) (folderAccess, error) {
+	if parentID == nil {
+		return folderAccess{}, nil
+	}
+	documentIDs, err := s.repo.GetFolderTreeDocumentIDs(ctx, folderID)
+	if err != nil {
+		return folderAccess{}, err
+	}
+	userIDs, err := s.repo.GetFolderGrantees(ctx, *parentID)
+	if err != nil {
+		return folderAccess{}, err
+	}
+	return folderAccess{documentIDs: documentIDs, userIDs: userIDs}, nil
+}
+
+// folderAccess pairs documents with users that may have lost their inherited
+// access to them
+type folderAccess struct {
+	documentIDs []string
+	userIDs     []string
+}
+
+// publish tells the subscribers to check again the access of the users to
+// the documents
+func (a folderAccess) publish(events *eventBus) {
+	for _, documentID := range a.documentIDs {
+		for _, userID := range a.userIDs {
+			events.publish(DocumentEvent{
+				Type:       EventCollaboratorRemoved,
+				DocumentID: documentID,
+				UserID:     userID,
+			})
+		}
+	}
+}
+
+func folderName(name string) (string, error) {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return "", fmt.Errorf("%w: the name cannot be blank", ErrInvalidFolder)
+	}
+	return name, nil
+}
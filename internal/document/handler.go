@@ -1,19 +1,38 @@
 package internal
 
 import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"mime"
 	"net/http"
+	"net/url"
+	"path/filepath"
+	"strconv"
 	"strings"
+	"time"
 
+	"github.com/emaforlin/ce-document-service/pkg/pdf"
+	"github.com/emaforlin/ce-document-service/pkg/prosemirror"
 	"github.com/gin-gonic/gin"
+	"golang.org/x/net/websocket"
 )
 
 type HTTPHandler struct {
 	documentService *DocumentService
+	hub             *CollaborationHub
+	presence        *PresenceTracker
+	allowedOrigins  []string
 }
 
-func NewHTTPHandler(service *DocumentService) *HTTPHandler {
+func NewHTTPHandler(service *DocumentService, hub *CollaborationHub, presence *PresenceTracker, allowedOrigins []string) *HTTPHandler {
 	return &HTTPHandler{
 		documentService: service,
+		hub:             hub,
+		presence:        presence,
+		allowedOrigins:  allowedOrigins,
 	}
 }
 
@@ -21,8 +40,10 @@ func (h *HTTPHandler) deleteDocument(c *gin.Context) {
 	resCode := http.StatusOK
 	documentID := c.GetString("documentID")
 
-	if err := h.documentService.DeleteDocument(c.Request.Context(), documentID); err != nil {
-		if strings.Contains(err.Error(), "not found") {
+	if err := h.documentService.DeleteDocument(c.Request.Context(), documentID, c.GetInt64("expectedVersion")); err != nil {
+		if errors.Is(err, ErrVersionConflict) {
+			resCode = http.StatusPreconditionFailed
+		} else if strings.Contains(err.Error(), "not found") {
 			resCode = http.StatusNotFound
 		} else {
 			resCode = http.StatusBadRequest
@@ -33,63 +54,1763 @@ func (h *HTTPHandler) deleteDocument(c *gin.Context) {
 		return
 	}
 	c.JSON(resCode, gin.H{
-		"message": "document deleted",
+		"message": "document moved to trash",
+	})
+}
+
+// getTrash lists the trashed documents the caller has owner access to
+func (h *HTTPHandler) getTrash(c *gin.Context) {
+	trash, err := h.documentService.GetTrash(c.Request.Context(), c.GetString("userID"))
+	if err != nil {
+		c.Error(err)
+		c.JSON(http.StatusInternalServerError, httpResponseMessage{
+			Message: "failed to fetch trash",
+		})
+		return
+	}
+	c.JSON(http.StatusOK, ToTrashedDocumentResponseList(trash))
+}
+
+// restoreDocument takes a document the caller has owner access to out of the trash
+func (h *HTTPHandler) restoreDocument(c *gin.Context) {
+	if err := h.documentService.RestoreDocument(c.Request.Context(), c.GetString("userID"), c.Param("id")); err != nil {
+		replyTrashError(c, err, "failed to restore document")
+		return
+	}
+	c.JSON(http.StatusOK, httpResponseMessage{
+		Message: "document restored",
+	})
+}
+
+// purgeDocument permanently deletes a trashed document the caller has owner
+// access to
+func (h *HTTPHandler) purgeDocument(c *gin.Context) {
+	if err := h.documentService.PurgeDocument(c.Request.Context(), c.GetString("userID"), c.Param("id")); err != nil {
+		replyTrashError(c, err, "failed to delete document")
+		return
+	}
+	c.JSON(http.StatusOK, httpResponseMessage{
+		Message: "document deleted",
+	})
+}
+
+func replyTrashError(c *gin.Context, err error, message string) {
+	if errors.Is(err, ErrNotInTrash) {
+		c.JSON(http.StatusNotFound, httpResponseMessage{
+			Message: "document not found in trash",
+		})
+		return
+	}
+	c.Error(err)
+	c.JSON(http.StatusInternalServerError, httpResponseMessage{
+		Message: message,
 	})
 }
 
 func (h *HTTPHandler) updateDocument(c *gin.Context) {
 	documentID := c.GetString("documentID")
 
-	var body UpdateDocumentDTO
-	if err := c.Bind(&body); err != nil {
+	var body UpdateDocumentDTO
+	if err := c.Bind(&body); err != nil {
+		c.JSON(http.StatusBadRequest, httpResponseMessage{
+			Message: "failed to marshal body",
+		})
+		return
+	}
+	body.DocumentID = documentID
+	body.UserID = c.GetString("userID")
+	body.Version = c.GetInt64("expectedVersion")
+
+	version, err := h.documentService.UpdateDocumentMetadata(c.Request.Context(), body)
+	if err != nil {
+		if errors.Is(err, ErrVersionConflict) {
+			c.JSON(http.StatusPreconditionFailed, httpResponseMessage{
+				Message: "document has been modified, reload and retry",
+			})
+			return
+		}
+		c.JSON(http.StatusBadRequest, httpResponseMessage{
+			Message: "failed to update document",
+		})
+		return
+	}
+
+	c.Header("ETag", formatETag(version))
+	c.JSON(http.StatusOK, gin.H{
+		"message": "document metadata updated",
+	})
+}
+
+func (h *HTTPHandler) updateDocumentContent(c *gin.Context) {
+	documentID := c.GetString("documentID")
+
+	content, err := c.GetRawData()
+	if err != nil || len(content) == 0 {
+		c.JSON(http.StatusBadRequest, httpResponseMessage{
+			Message: "bad request: missing document content",
+		})
+		return
+	}
+
+	version, err := h.documentService.UpdateDocumentContent(c.Request.Context(), UpdateDocumentContentDTO{
+		DocumentID: documentID,
+		UserID:     c.GetString("userID"),
+		Version:    c.GetInt64("expectedVersion"),
+		Content:    content,
+	})
+	if err != nil {
+		var validationErr *prosemirror.ValidationError
+		if errors.As(err, &validationErr) {
+			c.JSON(http.StatusUnprocessableEntity, contentValidationResponse{
+				Message: "invalid document content: " + validationErr.Message,
+				Path:    validationErr.Path,
+			})
+			return
+		}
+		if errors.Is(err, ErrVersionConflict) {
+			c.JSON(http.StatusPreconditionFailed, httpResponseMessage{
+				Message: "document has been modified, reload and retry",
+			})
+			return
+		}
+		c.JSON(http.StatusBadRequest, httpResponseMessage{
+			Message: "failed to update document content",
+		})
+		return
+	}
+
+	c.Header("ETag", formatETag(version))
+	c.JSON(http.StatusOK, httpResponseMessage{
+		Message: "document content updated",
+	})
+}
+
+// applyDocumentSteps is the authority endpoint of prosemirror-collab. Steps
+// made on an outdated version are answered with the steps the client missed.
+func (h *HTTPHandler) applyDocumentSteps(c *gin.Context) {
+	doc, ok := documentFromContext(c)
+	if !ok {
+		return
+	}
+
+	var body ApplyStepsDTO
+	if err := c.ShouldBindJSON(&body); err != nil {
+		c.JSON(http.StatusBadRequest, httpResponseMessage{
+			Message: "bad request: " + err.Error(),
+		})
+		return
+	}
+	body.Document = doc
+	body.UserID = c.GetString("userID")
+
+	version, err := h.documentService.ApplyDocumentSteps(c.Request.Context(), body)
+	if err != nil {
+		var staleErr *StaleStepsError
+		var validationErr *prosemirror.ValidationError
+		var lockedErr *LockedError
+		switch {
+		case errors.As(err, &lockedErr):
+			c.JSON(http.StatusLocked, lockedResponse{
+				Message: "steps touch a range locked by another user",
+				Lock:    ToLockResponse(&lockedErr.Lock),
+			})
+		case errors.As(err, &staleErr):
+			c.JSON(http.StatusConflict, stepsConflictResponse{
+				Message:               "document has been modified, apply the missed steps and retry",
+				DocumentStepsResponse: ToDocumentStepsResponse(staleErr.Version, staleErr.Steps),
+			})
+		case errors.Is(err, ErrStepsUnavailable):
+			c.JSON(http.StatusConflict, httpResponseMessage{
+				Message: "document has been modified, reload and retry",
+			})
+		case errors.Is(err, ErrInvalidSteps):
+			c.JSON(http.StatusUnprocessableEntity, httpResponseMessage{
+				Message: err.Error(),
+			})
+		case errors.As(err, &validationErr):
+			c.JSON(http.StatusUnprocessableEntity, contentValidationResponse{
+				Message: "steps produce invalid content: " + validationErr.Message,
+				Path:    validationErr.Path,
+			})
+		default:
+			c.JSON(http.StatusBadRequest, httpResponseMessage{
+				Message: "failed to apply steps",
+			})
+		}
+		return
+	}
+
+	c.Header("ETag", formatETag(version))
+	c.JSON(http.StatusOK, stepsAppliedResponse{
+		Message: "steps applied",
+		Version: version,
+	})
+}
+
+// getDocumentSteps lets collaborators catch up with the steps applied after
+// the version given in the since query parameter
+func (h *HTTPHandler) getDocumentSteps(c *gin.Context) {
+	doc, ok := documentFromContext(c)
+	if !ok {
+		return
+	}
+
+	since, err := strconv.ParseInt(c.Query("since"), 10, 64)
+	if err != nil || since < 1 {
+		c.JSON(http.StatusBadRequest, httpResponseMessage{
+			Message: "bad request: invalid since version",
+		})
+		return
+	}
+
+	steps, err := h.documentService.GetDocumentSteps(c.Request.Context(), doc, since)
+	if err != nil {
+		if errors.Is(err, ErrStepsUnavailable) {
+			c.JSON(http.StatusGone, httpResponseMessage{
+				Message: "steps are no longer available, reload the document",
+			})
+			return
+		}
+		c.JSON(http.StatusBadRequest, httpResponseMessage{
+			Message: "failed to fetch steps",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, ToDocumentStepsResponse(doc.Version, steps))
+}
+
+// yjsMaxUpdateSize bounds the body of POST /documents/:id/updates
+const yjsMaxUpdateSize = 4 << 20
+
+func (h *HTTPHandler) pushDocumentUpdate(c *gin.Context) {
+	doc, ok := documentFromContext(c)
+	if !ok {
+		return
+	}
+
+	update, err := io.ReadAll(io.LimitReader(c.Request.Body, yjsMaxUpdateSize+1))
+	if err != nil || len(update) == 0 || len(update) > yjsMaxUpdateSize {
+		c.JSON(http.StatusBadRequest, httpResponseMessage{
+			Message: "bad request: invalid update body",
+		})
+		return
+	}
+
+	if err := h.documentService.PushDocumentUpdate(c.Request.Context(), PushUpdateDTO{
+		Document: doc,
+		UserID:   c.GetString("userID"),
+		Update:   update,
+	}); err != nil {
+		if errors.Is(err, ErrInvalidUpdate) {
+			c.JSON(http.StatusUnprocessableEntity, httpResponseMessage{
+				Message: err.Error(),
+			})
+			return
+		}
+		c.JSON(http.StatusBadRequest, httpResponseMessage{
+			Message: "failed to store update",
+		})
+		return
+	}
+
+	c.JSON(http.StatusAccepted, httpResponseMessage{
+		Message: "update stored",
+	})
+}
+
+// getDocumentUpdates returns the Yjs state of the document as one binary
+// update. With ?sv= (a base64 state vector) only the missing part is sent.
+func (h *HTTPHandler) getDocumentUpdates(c *gin.Context) {
+	doc, ok := documentFromContext(c)
+	if !ok {
+		return
+	}
+
+	var stateVector []byte
+	if sv := c.Query("sv"); sv != "" {
+		var err error
+		if stateVector, err = decodeBase64Query(sv); err != nil {
+			c.JSON(http.StatusBadRequest, httpResponseMessage{
+				Message: "bad request: invalid state vector",
+			})
+			return
+		}
+	}
+
+	update, err := h.documentService.GetDocumentUpdate(c.Request.Context(), doc.ID, stateVector)
+	if err != nil {
+		if errors.Is(err, ErrInvalidUpdate) {
+			c.JSON(http.StatusBadRequest, httpResponseMessage{
+				Message: "bad request: invalid state vector",
+			})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, httpResponseMessage{
+			Message: "failed to fetch updates",
+		})
+		return
+	}
+
+	c.Data(http.StatusOK, "application/octet-stream", update)
+}
+
+func (h *HTTPHandler) getDocumentStateVector(c *gin.Context) {
+	doc, ok := documentFromContext(c)
+	if !ok {
+		return
+	}
+
+	sv, err := h.documentService.GetDocumentStateVector(c.Request.Context(), doc.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, httpResponseMessage{
+			Message: "failed to compute state vector",
+		})
+		return
+	}
+
+	c.Data(http.StatusOK, "application/octet-stream", sv)
+}
+
+// decodeBase64Query accepts both the standard and the URL-safe base64
+// alphabets, with or without padding
+func decodeBase64Query(value string) ([]byte, error) {
+	// An unescaped '+' arrives as a space
+	value = strings.ReplaceAll(strings.TrimRight(value, "="), " ", "+")
+	if strings.ContainsAny(value, "-_") {
+		return base64.RawURLEncoding.DecodeString(value)
+	}
+	return base64.RawStdEncoding.DecodeString(value)
+}
+
+func (h *HTTPHandler) getDocumentRevisions(c *gin.Context) {
+	documentID := c.GetString("documentID")
+
+	revisions, err := h.documentService.GetDocumentRevisions(c.Request.Context(), documentID)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, httpResponseMessage{
+			Message: "failed to fetch revisions",
+		})
+		return
+	}
+
+	response := ToRevisionResponseList(revisions)
+	c.JSON(http.StatusOK, response)
+}
+
+func (h *HTTPHandler) getDocumentRevision(c *gin.Context) {
+	documentID := c.GetString("documentID")
+
+	revision, err := strconv.ParseInt(c.Param("rev"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, httpResponseMessage{
+			Message: "bad request: invalid revision number",
+		})
+		return
+	}
+
+	rev, err := h.documentService.GetDocumentRevision(c.Request.Context(), documentID, revision)
+	if err != nil {
+		c.JSON(http.StatusNotFound, httpResponseMessage{
+			Message: err.Error(),
+		})
+		return
+	}
+
+	response := ToRevisionDetailResponse(rev)
+	c.JSON(http.StatusOK, response)
+}
+
+func (h *HTTPHandler) restoreDocumentRevision(c *gin.Context) {
+	documentID := c.GetString("documentID")
+
+	revision, err := strconv.ParseInt(c.Param("rev"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, httpResponseMessage{
+			Message: "bad request: invalid revision number",
+		})
+		return
+	}
+
+	version, err := h.documentService.RestoreDocumentRevision(c.Request.Context(), RestoreRevisionDTO{
+		DocumentID: documentID,
+		UserID:     c.GetString("userID"),
+		Version:    c.GetInt64("expectedVersion"),
+		Revision:   revision,
+	})
+	if err != nil {
+		switch {
+		case errors.Is(err, ErrRevisionNotFound):
+			c.JSON(http.StatusNotFound, httpResponseMessage{
+				Message: err.Error(),
+			})
+		case errors.Is(err, ErrVersionConflict):
+			c.JSON(http.StatusPreconditionFailed, httpResponseMessage{
+				Message: "document has been modified, reload and retry",
+			})
+		default:
+			c.JSON(http.StatusBadRequest, httpResponseMessage{
+				Message: "failed to restore revision",
+			})
+		}
+		return
+	}
+
+	c.Header("ETag", formatETag(version))
+	c.JSON(http.StatusOK, httpResponseMessage{
+		Message: "revision restored",
+	})
+}
+
+func (h *HTTPHandler) diffDocument(c *gin.Context) {
+	doc, ok := documentFromContext(c)
+	if !ok {
+		return
+	}
+
+	from, err := parseVersionQuery(c.Query("from"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, httpResponseMessage{
+			Message: "bad request: invalid from version",
+		})
+		return
+	}
+	to, err := parseVersionQuery(c.Query("to"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, httpResponseMessage{
+			Message: "bad request: invalid to version",
+		})
+		return
+	}
+	if from == 0 {
+		c.JSON(http.StatusBadRequest, httpResponseMessage{
+			Message: "bad request: from version is required",
+		})
+		return
+	}
+
+	diff, err := h.documentService.DiffDocument(c.Request.Context(), DiffDocumentDTO{
+		Document: doc,
+		From:     from,
+		To:       to,
+	})
+	if err != nil {
+		if errors.Is(err, ErrRevisionNotFound) {
+			c.JSON(http.StatusNotFound, httpResponseMessage{
+				Message: err.Error(),
+			})
+			return
+		}
+		c.JSON(http.StatusBadRequest, httpResponseMessage{
+			Message: "failed to diff document",
+		})
+		return
+	}
+
+	if to == 0 {
+		to = doc.Version
+	}
+
+	switch c.DefaultQuery("format", "json") {
+	case "json":
+		changes := diff.Changes()
+		if changes == nil {
+			changes = []prosemirror.Change{}
+		}
+		c.JSON(http.StatusOK, DocumentDiffResponse{
+			From:    from,
+			To:      to,
+			Changes: changes,
+		})
+	case "html":
+		c.Data(http.StatusOK, "text/html; charset=utf-8", []byte(diff.HTML(h.documentService.Schema())))
+	default:
+		c.JSON(http.StatusBadRequest, httpResponseMessage{
+			Message: "bad request: format must be json or html",
+		})
+	}
+}
+
+// exportDocument downloads the document content as a file in the format
+// requested by the format query parameter
+func (h *HTTPHandler) exportDocument(c *gin.Context) {
+	doc, ok := documentFromContext(c)
+	if !ok {
+		return
+	}
+
+	var fragment bool
+	switch mode := c.DefaultQuery("mode", "standalone"); mode {
+	case "standalone":
+	case "fragment":
+		fragment = true
+	default:
+		c.JSON(http.StatusBadRequest, httpResponseMessage{
+			Message: "bad request: mode must be standalone or fragment",
+		})
+		return
+	}
+
+	data := ExportDocumentDTO{
+		Document: doc,
+		Format:   c.Query("format"),
+		Fragment: fragment,
+	}
+	if data.Format == "pdf" {
+		var err error
+		if data.PageSize, data.Margins, err = parsePageLayout(c); err != nil {
+			c.JSON(http.StatusBadRequest, httpResponseMessage{
+				Message: "bad request: " + err.Error(),
+			})
+			return
+		}
+	}
+
+	exported, err := h.documentService.ExportDocument(c.Request.Context(), data)
+	if err != nil {
+		if errors.Is(err, ErrUnsupportedFormat) || errors.Is(err, ErrInvalidExportOptions) {
+			c.JSON(http.StatusBadRequest, httpResponseMessage{
+				Message: "bad request: " + err.Error(),
+			})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, httpResponseMessage{
+			Message: "failed to export document",
+		})
+		return
+	}
+
+	c.Header("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": exported.Filename}))
+	c.Header("X-Content-Type-Options", "nosniff")
+	c.Data(http.StatusOK, exported.ContentType, exported.Data)
+}
+
+// exportDocumentArchive streams a ZIP archive of every document the caller
+// can access, rendered in the format query parameter, with a manifest.json
+// listing them
+func (h *HTTPHandler) exportDocumentArchive(c *gin.Context) {
+	data := ExportArchiveDTO{
+		UserID: c.GetString("userID"),
+		Format: c.DefaultQuery("format", "json"),
+	}
+	if data.Format == "pdf" {
+		var err error
+		if data.PageSize, data.Margins, err = parsePageLayout(c); err != nil {
+			c.JSON(http.StatusBadRequest, httpResponseMessage{
+				Message: "bad request: " + err.Error(),
+			})
+			return
+		}
+	}
+
+	filename := "documents-" + time.Now().UTC().Format("2006-01-02") + ".zip"
+	c.Header("Content-Type", "application/zip")
+	c.Header("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": filename}))
+	c.Header("X-Content-Type-Options", "nosniff")
+	err := h.documentService.ExportDocumentArchive(c.Request.Context(), data, c.Writer)
+	if err == nil {
+		return
+	}
+	if c.Writer.Written() {
+		// The status is already sent, the client is left with a truncated
+		// archive
+		c.Error(err)
+		return
+	}
+	c.Writer.Header().Del("Content-Type")
+	c.Writer.Header().Del("Content-Disposition")
+	if errors.Is(err, ErrUnsupportedFormat) {
+		c.JSON(http.StatusBadRequest, httpResponseMessage{
+			Message: "bad request: " + err.Error(),
+		})
+		return
+	}
+	c.Error(err)
+	c.JSON(http.StatusInternalServerError, httpResponseMessage{
+		Message: "failed to export documents",
+	})
+}
+
+// importMaxFileSize bounds the files uploaded to the import endpoints
+const importMaxFileSize = 20 << 20
+
+// importFormatNames maps the format query parameter and file extensions to
+// import formats
+var importFormatNames = map[string]string{
+	"markdown": "markdown",
+	"md":       "markdown",
+	"html":     "html",
+	"htm":      "html",
+	"docx":     "docx",
+}
+
+// importUpload is a file sent to the import endpoints
+type importUpload struct {
+	format string
+	title  string
+	data   []byte
+}
+
+// readImportUpload reads the file uploaded in the "file" form field, or the
+// raw request body when it is not a multipart form. The format query parameter
+// wins over the file extension. It replies to the client and returns false on
+// failure.
+func readImportUpload(c *gin.Context) (*importUpload, bool) {
+	// Leave room for the rest of the multipart body
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, importMaxFileSize+1<<20)
+	upload := &importUpload{format: c.Query("format")}
+
+	var source io.Reader = c.Request.Body
+	if c.ContentType() == "multipart/form-data" {
+		header, err := c.FormFile("file")
+		if err != nil {
+			replyUploadError(c, err)
+			return nil, false
+		}
+		if header.Size > importMaxFileSize {
+			c.JSON(http.StatusRequestEntityTooLarge, httpResponseMessage{
+				Message: "file too large",
+			})
+			return nil, false
+		}
+		file, err := header.Open()
+		if err != nil {
+			c.JSON(http.StatusBadRequest, httpResponseMessage{
+				Message: "bad request: failed to read the uploaded file",
+			})
+			return nil, false
+		}
+		defer file.Close()
+		source = file
+		upload.title = c.PostForm("title")
+		if upload.format == "" {
+			upload.format = strings.TrimPrefix(filepath.Ext(header.Filename), ".")
+		}
+	} else {
+		upload.title = c.Query("title")
+	}
+
+	data, err := io.ReadAll(io.LimitReader(source, importMaxFileSize+1))
+	if err != nil {
+		replyUploadError(c, err)
+		return nil, false
+	}
+	if len(data) > importMaxFileSize {
+		c.JSON(http.StatusRequestEntityTooLarge, httpResponseMessage{
+			Message: "file too large",
+		})
+		return nil, false
+	}
+	if len(data) == 0 {
+		c.JSON(http.StatusBadRequest, httpResponseMessage{
+			Message: "bad request: a file is required in the file form field or the request body",
+		})
+		return nil, false
+	}
+	upload.data = data
+
+	format := strings.ToLower(upload.format)
+	if name, ok := importFormatNames[format]; ok {
+		format = name
+	}
+	upload.format = format
+	return upload, true
+}
+
+func replyUploadError(c *gin.Context, err error) {
+	var maxBytesErr *http.MaxBytesError
+	if errors.As(err, &maxBytesErr) {
+		c.JSON(http.StatusRequestEntityTooLarge, httpResponseMessage{
+			Message: "file too large",
+		})
+		return
+	}
+	c.JSON(http.StatusBadRequest, httpResponseMessage{
+		Message: "bad request: a file is required in the file form field or the request body",
+	})
+}
+
+// replyImportError maps import failures to responses
+func replyImportError(c *gin.Context, err error) {
+	var validationErr *prosemirror.ValidationError
+	switch {
+	case errors.Is(err, ErrUnsupportedFormat):
+		c.JSON(http.StatusUnsupportedMediaType, httpResponseMessage{
+			Message: "unsupported format, use markdown, html or docx",
+		})
+	case errors.Is(err, ErrInvalidImport), errors.As(err, &validationErr):
+		c.JSON(http.StatusUnprocessableEntity, httpResponseMessage{
+			Message: "failed to import document: " + err.Error(),
+		})
+	case errors.Is(err, ErrVersionConflict):
+		c.JSON(http.StatusPreconditionFailed, httpResponseMessage{
+			Message: "document has been modified, reload and retry",
+		})
+	default:
+		c.Error(err)
+		c.JSON(http.StatusInternalServerError, httpResponseMessage{
+			Message: "failed to import document",
+		})
+	}
+}
+
+// importDocument creates a document owned by the caller from an uploaded
+// Markdown, HTML or DOCX file. The optional title overrides the title found
+// in the file.
+func (h *HTTPHandler) importDocument(c *gin.Context) {
+	upload, ok := readImportUpload(c)
+	if !ok {
+		return
+	}
+
+	document, warnings, err := h.documentService.ImportDocument(c.Request.Context(), ImportDocumentDTO{
+		OwnerID: c.GetString("userID"),
+		Title:   upload.title,
+		Format:  upload.format,
+		Data:    upload.data,
+	})
+	if err != nil {
+		replyImportError(c, err)
+		return
+	}
+
+	if warnings == nil {
+		warnings = prosemirror.Warnings{}
+	}
+	c.JSON(http.StatusCreated, ImportDocumentResponse{
+		DocumentResponse: ToDocumentResponse(document),
+		Warnings:         warnings,
+	})
+}
+
+// importDocumentContent replaces the content of the document with an
+// uploaded file, or appends the file to it when mode is append
+func (h *HTTPHandler) importDocumentContent(c *gin.Context) {
+	doc, ok := documentFromContext(c)
+	if !ok {
+		return
+	}
+
+	mode := ImportMode(c.DefaultQuery("mode", string(ImportModeAppend)))
+	if mode != ImportModeAppend && mode != ImportModeReplace {
+		c.JSON(http.StatusBadRequest, httpResponseMessage{
+			Message: "bad request: mode must be replace or append",
+		})
+		return
+	}
+	upload, ok := readImportUpload(c)
+	if !ok {
+		return
+	}
+
+	version, warnings, err := h.documentService.ImportDocumentContent(c.Request.Context(), ImportContentDTO{
+		Document: doc,
+		UserID:   c.GetString("userID"),
+		Version:  c.GetInt64("expectedVersion"),
+		Format:   upload.format,
+		Mode:     mode,
+		Data:     upload.data,
+	})
+	if err != nil {
+		replyImportError(c, err)
+		return
+	}
+
+	if warnings == nil {
+		warnings = prosemirror.Warnings{}
+	}
+	c.Header("ETag", formatETag(version))
+	c.JSON(http.StatusOK, ImportContentResponse{
+		Message:  "document content imported",
+		Version:  version,
+		Warnings: warnings,
+	})
+}
+
+// pageSizes are the values accepted by the page_size query parameter
+var pageSizes = map[string]pdf.Size{
+	"a3":     pdf.A3,
+	"a4":     pdf.A4,
+	"a5":     pdf.A5,
+	"letter": pdf.Letter,
+	"legal":  pdf.Legal,
+}
+
+const defaultPageMargin = "20mm"
+
+// parsePageLayout reads the page_size, orientation, margin and margin_top,
+// margin_right, margin_bottom and margin_left query parameters. Margins on
+// one side take precedence over margin.
+func parsePageLayout(c *gin.Context) (pdf.Size, pdf.Margins, error) {
+	size, ok := pageSizes[strings.ToLower(c.DefaultQuery("page_size", "a4"))]
+	if !ok {
+		return pdf.Size{}, pdf.Margins{}, errors.New("page_size must be one of a3, a4, a5, letter or legal")
+	}
+	switch c.DefaultQuery("orientation", "portrait") {
+	case "portrait":
+	case "landscape":
+		size.Width, size.Height = size.Height, size.Width
+	default:
+		return pdf.Size{}, pdf.Margins{}, errors.New("orientation must be portrait or landscape")
+	}
+
+	margin, err := parseLength(c.DefaultQuery("margin", defaultPageMargin))
+	if err != nil {
+		return pdf.Size{}, pdf.Margins{}, fmt.Errorf("margin: %w", err)
+	}
+	margins := pdf.Margins{Top: margin, Right: margin, Bottom: margin, Left: margin}
+	for param, side := range map[string]*float64{
+		"margin_top":    &margins.Top,
+		"margin_right":  &margins.Right,
+		"margin_bottom": &margins.Bottom,
+		"margin_left":   &margins.Left,
+	} {
+		if value, exists := c.GetQuery(param); exists {
+			if *side, err = parseLength(value); err != nil {
+				return pdf.Size{}, pdf.Margins{}, fmt.Errorf("%s: %w", param, err)
+			}
+		}
+	}
+	return size, margins, nil
+}
+
+// lengthUnits converts the units accepted by parseLength to points
+var lengthUnits = map[string]float64{
+	"mm": 72 / 25.4,
+	"cm": 72 / 2.54,
+	"in": 72,
+	"pt": 1,
+}
+
+// parseLength parses a length like "20mm", "1.5cm", "1in" or "36pt" into
+// points. Numbers without a unit are millimeters.
+func parseLength(value string) (float64, error) {
+	value = strings.ToLower(strings.TrimSpace(value))
+	unit := "mm"
+	if len(value) > 2 {
+		if _, ok := lengthUnits[value[len(value)-2:]]; ok {
+			unit = value[len(value)-2:]
+			value = value[:len(value)-2]
+		}
+	}
+	length, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+	if err != nil || length < 0 || math.IsInf(length, 0) || math.IsNaN(length) {
+		return 0, errors.New("invalid length, use a number followed by mm, cm, in or pt")
+	}
+	return length * lengthUnits[unit], nil
+}
+
+// parseVersionQuery parses a version query parameter, "" and "current" map to zero
+func parseVersionQuery(value string) (int64, error) {
+	if value == "" || value == "current" {
+		return 0, nil
+	}
+	version, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || version < 1 {
+		return 0, errors.New("invalid version")
+	}
+	return version, nil
+}
+
+// collaborate upgrades the request to a websocket joined to the document room.
+// Viewers receive updates, editors can also send them.
+func (h *HTTPHandler) collaborate(c *gin.Context) {
+	doc, ok := documentFromContext(c)
+	if !ok {
+		return
+	}
+	userID := c.GetString("userID")
+	permission := c.GetString("userPermission")
+
+	server := websocket.Server{
+		Handshake: func(_ *websocket.Config, r *http.Request) error {
+			return h.checkOrigin(r)
+		},
+		Handler: func(conn *websocket.Conn) {
+			h.hub.ServeClient(conn, doc, userID, permission)
+		},
+	}
+	server.ServeHTTP(c.Writer, c.Request)
+}
+
+// checkOrigin rejects websocket upgrades from browser pages outside the
+// allowed origins, CORS does not apply to them. Requests without an Origin
+// come from other clients and are let through, when no origins are
+// configured only the host of the service itself is allowed.
+func (h *HTTPHandler) checkOrigin(r *http.Request) error {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return nil
+	}
+	if len(h.allowedOrigins) == 0 {
+		if parsed, err := url.Parse(origin); err == nil && parsed.Host == r.Host {
+			return nil
+		}
+		return fmt.Errorf("origin %s not allowed", origin)
+	}
+	for _, allowed := range h.allowedOrigins {
+		if allowed == "*" || strings.EqualFold(allowed, origin) {
+			return nil
+		}
+	}
+	return fmt.Errorf("origin %s not allowed", origin)
+}
+
+func (h *HTTPHandler) getDocumentLocks(c *gin.Context) {
+	documentID := c.GetString("documentID")
+
+	locks, err := h.documentService.GetDocumentLocks(c.Request.Context(), documentID)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, httpResponseMessage{
+			Message: "failed to fetch locks",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, ToLockResponseList(locks))
+}
+
+func (h *HTTPHandler) lockDocument(c *gin.Context) {
+	doc, ok := documentFromContext(c)
+	if !ok {
+		return
+	}
+
+	var body LockDocumentDTO
+	// The body is optional, an empty one locks the whole document
+	if c.Request.ContentLength != 0 {
+		if err := c.ShouldBindJSON(&body); err != nil {
+			c.JSON(http.StatusBadRequest, httpResponseMessage{
+				Message: "bad request: " + err.Error(),
+			})
+			return
+		}
+	}
+	body.Document = doc
+	body.UserID = c.GetString("userID")
+
+	lock, err := h.documentService.LockDocument(c.Request.Context(), body)
+	if err != nil {
+		var lockedErr *LockedError
+		switch {
+		case errors.As(err, &lockedErr):
+			c.JSON(http.StatusLocked, lockedResponse{
+				Message: "document is locked by another user",
+				Lock:    ToLockResponse(&lockedErr.Lock),
+			})
+		case errors.Is(err, ErrInvalidLock):
+			c.JSON(http.StatusBadRequest, httpResponseMessage{
+				Message: err.Error(),
+			})
+		default:
+			c.JSON(http.StatusBadRequest, httpResponseMessage{
+				Message: "failed to lock document",
+			})
+		}
+		return
+	}
+
+	c.JSON(http.StatusCreated, ToLockResponse(lock))
+}
+
+func (h *HTTPHandler) renewDocumentLock(c *gin.Context) {
+	var body RenewLockDTO
+	if c.Request.ContentLength != 0 {
+		if err := c.ShouldBindJSON(&body); err != nil {
+			c.JSON(http.StatusBadRequest, httpResponseMessage{
+				Message: "bad request: " + err.Error(),
+			})
+			return
+		}
+	}
+	body.DocumentID = c.GetString("documentID")
+	body.LockID = c.Param("lock")
+	body.UserID = c.GetString("userID")
+
+	lock, err := h.documentService.RenewDocumentLock(c.Request.Context(), body)
+	if err != nil {
+		switch {
+		case errors.Is(err, ErrLockNotFound):
+			c.JSON(http.StatusNotFound, httpResponseMessage{
+				Message: "lock not found or expired",
+			})
+		case errors.Is(err, ErrInvalidLock):
+			c.JSON(http.StatusBadRequest, httpResponseMessage{
+				Message: err.Error(),
+			})
+		default:
+			c.JSON(http.StatusBadRequest, httpResponseMessage{
+				Message: "failed to renew lock",
+			})
+		}
+		return
+	}
+
+	c.JSON(http.StatusOK, ToLockResponse(lock))
+}
+
+// unlockDocument releases the locks of the user, or with ?force=true every
+// lock on the document, which only the owner may do
+func (h *HTTPHandler) unlockDocument(c *gin.Context) {
+	data := UnlockDocumentDTO{
+		DocumentID: c.GetString("documentID"),
+		HolderID:   c.GetString("userID"),
+	}
+	if force, _ := strconv.ParseBool(c.Query("force")); force {
+		if !validatePermission(c.GetString("userPermission"), string(RoleOwner)) {
+			c.JSON(http.StatusForbidden, httpResponseMessage{
+				Message: "only the owner can force the release of locks",
+			})
+			return
+		}
+		data.HolderID = ""
+	}
+	h.releaseLocks(c, data)
+}
+
+// releaseDocumentLock releases one lock, held by the user unless the user
+// owns the document
+func (h *HTTPHandler) releaseDocumentLock(c *gin.Context) {
+	data := UnlockDocumentDTO{
+		DocumentID: c.GetString("documentID"),
+		LockID:     c.Param("lock"),
+		HolderID:   c.GetString("userID"),
+	}
+	if validatePermission(c.GetString("userPermission"), string(RoleOwner)) {
+		data.HolderID = ""
+	}
+	h.releaseLocks(c, data)
+}
+
+func (h *HTTPHandler) releaseLocks(c *gin.Context, data UnlockDocumentDTO) {
+	if err := h.documentService.UnlockDocument(c.Request.Context(), data); err != nil {
+		if errors.Is(err, ErrLockNotFound) {
+			c.JSON(http.StatusNotFound, httpResponseMessage{
+				Message: "lock not found or expired",
+			})
+			return
+		}
+		c.JSON(http.StatusBadRequest, httpResponseMessage{
+			Message: "failed to release lock",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, httpResponseMessage{
+		Message: "document unlocked",
+	})
+}
+
+func (h *HTTPHandler) getDocumentPresence(c *gin.Context) {
+	documentID := c.GetString("documentID")
+
+	c.JSON(http.StatusOK, DocumentPresenceResponse{
+		TTL:      int64(h.presence.TTL().Seconds()),
+		Sessions: h.presence.List(documentID),
+	})
+}
+
+// updateDocumentPresence joins the document or refreshes the session lease
+func (h *HTTPHandler) updateDocumentPresence(c *gin.Context) {
+	documentID := c.GetString("documentID")
+	userID := c.GetString("userID")
+
+	var body UpdatePresenceDTO
+	if err := c.ShouldBindJSON(&body); err != nil {
+		c.JSON(http.StatusBadRequest, httpResponseMessage{
+			Message: "bad request: " + err.Error(),
+		})
+		return
+	}
+
+	presence := h.presence.Heartbeat(documentID, userID, body.SessionID, body.Color, body.Selection)
+	c.JSON(http.StatusOK, presence)
+}
+
+func (h *HTTPHandler) leaveDocumentPresence(c *gin.Context) {
+	documentID := c.GetString("documentID")
+	userID := c.GetString("userID")
+
+	if !h.presence.Leave(documentID, userID, c.Param("session")) {
+		c.JSON(http.StatusNotFound, httpResponseMessage{
+			Message: "session not found",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, httpResponseMessage{
+		Message: "left the document",
+	})
+}
+
+// streamDocumentPresence pushes presence changes as server-sent events. The
+// first event is a "snapshot" with the current sessions.
+func (h *HTTPHandler) streamDocumentPresence(c *gin.Context) {
+	documentID := c.GetString("documentID")
+	userID := c.GetString("userID")
+
+	sub := h.presence.Subscribe(documentID, userID)
+	defer h.presence.Unsubscribe(documentID, sub)
+
+	keepAlive := time.NewTicker(h.presence.TTL() / 2)
+	defer keepAlive.Stop()
+
+	c.Header("Cache-Control", "no-cache")
+	c.Header("X-Accel-Buffering", "no")
+	c.SSEvent("snapshot", DocumentPresenceResponse{
+		TTL:      int64(h.presence.TTL().Seconds()),
+		Sessions: h.presence.List(documentID),
+	})
+	c.Writer.Flush()
+
+	c.Stream(func(w io.Writer) bool {
+		select {
+		case event := <-sub.Events:
+			c.SSEvent("presence", PresenceEventResponse{Type: event.Type, Presence: event.Presence})
+			return true
+		case <-keepAlive.C:
+			c.SSEvent("ping", "")
+			return true
+		case <-sub.Closed():
+			c.SSEvent("closed", httpResponseMessage{Message: "presence stream closed"})
+			return false
+		case <-c.Request.Context().Done():
+			return false
+		}
+	})
+}
+
+func (h *HTTPHandler) getDocumentCollaborators(c *gin.Context) {
+	documentID := c.GetString("documentID")
+
+	collaborators, err := h.documentService.getDocumentCollaborators(c.Request.Context(), documentID)
+	if err != nil {
+		c.JSON(http.StatusNotFound, httpResponseMessage{
+			Message: err.Error(),
+		})
+		return
+	}
+
+	// Convert to DTO response
+	response := ToCollaboratorResponseList(collaborators)
+	c.JSON(http.StatusOK, response)
+}
+
+func (h *HTTPHandler) removeDocumentCollaborator(c *gin.Context) {
+	var body RemoveCollaboratorDTO
+
+	if err := c.Bind(&body); err != nil {
+		c.JSON(http.StatusBadRequest, httpResponseMessage{
+			Message: "bad request: " + err.Error(),
+		})
+		return
+	}
+
+	body.DocumentID = c.GetString("documentID")
+
+	if err := h.documentService.RemoveDocumentCollaborator(c.Request.Context(), body); err != nil {
+		c.JSON(http.StatusBadRequest, httpResponseMessage{
+			Message: "bad request: couldn't remove collaborator",
+		})
+		return
+	}
+	c.JSON(http.StatusOK, httpResponseMessage{
+		Message: "collaborator removed",
+	})
+}
+
+func (h *HTTPHandler) addDocumentCollaborator(c *gin.Context) {
+	// Get values from middleware context
+	ownerID := c.GetString("userID")
+	documentID := c.GetString("documentID")
+
+	var body AddCollaboratorDTO
+	if err := c.Bind(&body); err != nil {
+		c.JSON(http.StatusBadRequest, httpResponseMessage{
+			Message: "bad request: " + err.Error(),
+		})
+		return
+	}
+
+	body.OwnerID = ownerID
+	body.DocumentID = documentID
+
+	if err := h.documentService.AddCollaboratorToDocument(c.Request.Context(), body); err != nil {
+		c.JSON(http.StatusBadRequest, httpResponseMessage{
+			Message: "bad request: " + err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusCreated, httpResponseMessage{
+		Message: "document permission created",
+	})
+}
+
+func (h *HTTPHandler) createDocument(c *gin.Context) {
+	// Get userID from middleware context
+	ownerID := c.GetString("userID")
+
+	var body CreateDocumentDTO
+	if err := c.Bind(&body); err != nil {
+		c.JSON(http.StatusBadRequest, httpResponseMessage{
+			Message: "bad request: " + err.Error(),
+		})
+		return
+	}
+
+	body.OwnerID = ownerID
+	document, err := h.documentService.CreateNewDocument(c.Request.Context(), body)
+	if errors.Is(err, ErrFolderNotFound) {
+		c.JSON(http.StatusNotFound, httpResponseMessage{
+			Message: "folder not found or access denied",
+		})
+		return
+	}
+	if errors.Is(err, ErrTemplateNotFound) {
+		c.JSON(http.StatusNotFound, httpResponseMessage{
+			Message: "template not found or access denied",
+		})
+		return
+	}
+	if errors.Is(err, ErrWorkspaceNotFound) || errors.Is(err, ErrWorkspaceForbidden) {
+		replyWorkspaceError(c, err, "failed to create document")
+		return
+	}
+	if err != nil {
+		// Log the error for debugging purposes
+		c.Error(err)
+		c.JSON(http.StatusBadRequest, httpResponseMessage{
+			Message: "failed to create document: " + err.Error(),
+		})
+		return
+	}
+
+	response := ToDocumentResponse(document)
+
+	c.JSON(http.StatusCreated, response)
+}
+
+// getDocuments lists a page of the documents the caller can access, filtered
+// and sorted by the query parameters
+func (h *HTTPHandler) getDocuments(c *gin.Context) {
+	var query ListDocumentsDTO
+	if err := c.ShouldBindQuery(&query); err != nil {
+		c.JSON(http.StatusBadRequest, httpResponseMessage{
+			Message: "bad request: " + err.Error(),
+		})
+		return
+	}
+	query.UserID = c.GetString("userID")
+	h.listDocuments(c, query)
+}
+
+// listDocuments replies with a page of the documents selected by query
+func (h *HTTPHandler) listDocuments(c *gin.Context, query ListDocumentsDTO) {
+	documents, next, err := h.documentService.ListDocuments(c.Request.Context(), query)
+	if err != nil {
+		if errors.Is(err, ErrInvalidListing) {
+			c.JSON(http.StatusBadRequest, httpResponseMessage{
+				Message: "bad request: " + err.Error(),
+			})
+			return
+		}
+		c.JSON(http.StatusBadRequest, httpResponseMessage{
+			Message: "failed to fetch documents",
+		})
+		return
+	}
+
+	response := DocumentListResponse{
+		Documents: ToListItemResponseList(documents),
+	}
+	if next != "" {
+		response.NextCursor = &next
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+// searchDocuments runs a full-text search over the documents the caller can
+// access
+func (h *HTTPHandler) searchDocuments(c *gin.Context) {
+	var query SearchDocumentsDTO
+	if err := c.ShouldBindQuery(&query); err != nil {
+		c.JSON(http.StatusBadRequest, httpResponseMessage{
+			Message: "bad request: " + err.Error(),
+		})
+		return
+	}
+	query.UserID = c.GetString("userID")
+
+	results, err := h.documentService.SearchDocuments(c.Request.Context(), query)
+	if err != nil {
+		if errors.Is(err, ErrInvalidSearch) {
+			c.JSON(http.StatusBadRequest, httpResponseMessage{
+				Message: "bad request: " + err.Error(),
+			})
+			return
+		}
+		c.Error(err)
+		c.JSON(http.StatusInternalServerError, httpResponseMessage{
+			Message: "failed to search documents",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, SearchDocumentsResponse{
+		Query:   query.Query,
+		Results: ToSearchResultResponseList(results),
+	})
+}
+
+// suggestDocuments returns documents with titles resembling the q query
+// parameter, for type-ahead
+func (h *HTTPHandler) suggestDocuments(c *gin.Context) {
+	var query SuggestDocumentsDTO
+	if err := c.ShouldBindQuery(&query); err != nil {
+		c.JSON(http.StatusBadRequest, httpResponseMessage{
+			Message: "bad request: " + err.Error(),
+		})
+		return
+	}
+	query.UserID = c.GetString("userID")
+
+	suggestions, err := h.documentService.SuggestDocuments(c.Request.Context(), query)
+	if err != nil {
+		if errors.Is(err, ErrInvalidSearch) {
+			c.JSON(http.StatusBadRequest, httpResponseMessage{
+				Message: "bad request: " + err.Error(),
+			})
+			return
+		}
+		c.Error(err)
+		c.JSON(http.StatusInternalServerError, httpResponseMessage{
+			Message: "failed to suggest documents",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, SuggestDocumentsResponse{
+		Query:       query.Query,
+		Suggestions: ToSuggestionResponseList(suggestions),
+	})
+}
+
+// tagDocument adds tags to the document and replies with all of its tags
+func (h *HTTPHandler) tagDocument(c *gin.Context) {
+	var body TagDocumentDTO
+	if err := c.ShouldBindJSON(&body); err != nil {
+		c.JSON(http.StatusBadRequest, httpResponseMessage{
+			Message: "bad request: " + err.Error(),
+		})
+		return
+	}
+	body.DocumentID = c.GetString("documentID")
+
+	tags, err := h.documentService.TagDocument(c.Request.Context(), body)
+	if err != nil {
+		replyTagError(c, err, "failed to tag document")
+		return
+	}
+	c.JSON(http.StatusOK, DocumentTagsResponse{Tags: ToTagNames(tags)})
+}
+
+// untagDocument removes the tag in the path from the document and replies
+// with the tags left
+func (h *HTTPHandler) untagDocument(c *gin.Context) {
+	tags, err := h.documentService.UntagDocument(c.Request.Context(), UntagDocumentDTO{
+		DocumentID: c.GetString("documentID"),
+		Tag:        c.Param("tag"),
+	})
+	if err != nil {
+		replyTagError(c, err, "failed to untag document")
+		return
+	}
+	c.JSON(http.StatusOK, DocumentTagsResponse{Tags: ToTagNames(tags)})
+}
+
+// getTags lists the tags on the documents the caller can access with the
+// number of documents carrying each
+func (h *HTTPHandler) getTags(c *gin.Context) {
+	tags, err := h.documentService.GetUserTags(c.Request.Context(), c.GetString("userID"))
+	if err != nil {
+		c.Error(err)
+		c.JSON(http.StatusInternalServerError, httpResponseMessage{
+			Message: "failed to fetch tags",
+		})
+		return
+	}
+	c.JSON(http.StatusOK, ToTagCountResponseList(tags))
+}
+
+func replyTagError(c *gin.Context, err error, message string) {
+	if errors.Is(err, ErrInvalidTag) {
+		c.JSON(http.StatusBadRequest, httpResponseMessage{
+			Message: "bad request: " + err.Error(),
+		})
+		return
+	}
+	c.Error(err)
+	c.JSON(http.StatusInternalServerError, httpResponseMessage{
+		Message: message,
+	})
+}
+
+// moveDocument moves the document into another folder or out of any folder
+func (h *HTTPHandler) moveDocument(c *gin.Context) {
+	document, ok := documentFromContext(c)
+	if !ok {
+		return
+	}
+
+	var body MoveDocumentDTO
+	if err := c.ShouldBindJSON(&body); err != nil {
+		c.JSON(http.StatusBadRequest, httpResponseMessage{
+			Message: "bad request: " + err.Error(),
+		})
+		return
+	}
+	body.Document = document
+	body.UserID = c.GetString("userID")
+
+	if err := h.documentService.MoveDocument(c.Request.Context(), body); err != nil {
+		replyFolderError(c, err, "failed to move document")
+		return
+	}
+	c.JSON(http.StatusOK, httpResponseMessage{
+		Message: "document moved",
+	})
+}
+
+// duplicateDocument creates a copy of the document owned by the caller
+func (h *HTTPHandler) duplicateDocument(c *gin.Context) {
+	document, ok := documentFromContext(c)
+	if !ok {
+		return
+	}
+
+	var body DuplicateDocumentDTO
+	// The body is optional, an empty one copies the title and content only
+	if c.Request.ContentLength != 0 {
+		if err := c.ShouldBindJSON(&body); err != nil {
+			c.JSON(http.StatusBadRequest, httpResponseMessage{
+				Message: "bad request: " + err.Error(),
+			})
+			return
+		}
+	}
+	body.Document = document
+	body.UserID = c.GetString("userID")
+	body.Role = Role(c.GetString("userPermission"))
+
+	duplicate, err := h.documentService.DuplicateDocument(c.Request.Context(), body)
+	if errors.Is(err, ErrCollaboratorsHidden) {
+		c.JSON(http.StatusForbidden, httpResponseMessage{
+			Message: ErrCollaboratorsHidden.Error(),
+		})
+		return
+	}
+	if err != nil {
+		c.Error(err)
+		c.JSON(http.StatusInternalServerError, httpResponseMessage{
+			Message: "failed to duplicate document",
+		})
+		return
+	}
+	c.JSON(http.StatusCreated, ToDocumentResponse(duplicate))
+}
+
+// transferDocument offers the document to another user, who has to accept
+// the transfer before anything changes
+func (h *HTTPHandler) transferDocument(c *gin.Context) {
+	document, ok := documentFromContext(c)
+	if !ok {
+		return
+	}
+
+	var body TransferOwnershipDTO
+	if err := c.ShouldBindJSON(&body); err != nil {
+		c.JSON(http.StatusBadRequest, httpResponseMessage{
+			Message: "bad request: " + err.Error(),
+		})
+		return
+	}
+	body.Document = document
+	body.UserID = c.GetString("userID")
+
+	transfer, err := h.documentService.TransferOwnership(c.Request.Context(), body)
+	if err != nil {
+		replyTransferError(c, err, "failed to transfer document")
+		return
+	}
+	c.JSON(http.StatusAccepted, ToTransferResponse(transfer))
+}
+
+func (h *HTTPHandler) getDocumentTransfer(c *gin.Context) {
+	transfer, err := h.documentService.GetOwnershipTransfer(c.Request.Context(), c.GetString("documentID"))
+	if err != nil {
+		replyTransferError(c, err, "failed to fetch transfer")
+		return
+	}
+	c.JSON(http.StatusOK, ToTransferResponse(transfer))
+}
+
+func (h *HTTPHandler) cancelDocumentTransfer(c *gin.Context) {
+	if err := h.documentService.CancelOwnershipTransfer(c.Request.Context(), c.GetString("documentID")); err != nil {
+		replyTransferError(c, err, "failed to cancel transfer")
+		return
+	}
+	c.JSON(http.StatusOK, httpResponseMessage{
+		Message: "transfer cancelled",
+	})
+}
+
+// getTransfers lists the transfers waiting for the caller to answer
+func (h *HTTPHandler) getTransfers(c *gin.Context) {
+	transfers, err := h.documentService.GetIncomingTransfers(c.Request.Context(), c.GetString("userID"))
+	if err != nil {
+		replyTransferError(c, err, "failed to fetch transfers")
+		return
+	}
+	c.JSON(http.StatusOK, ToTransferResponseList(transfers))
+}
+
+func (h *HTTPHandler) acceptTransfer(c *gin.Context) {
+	transfer, err := h.documentService.AcceptOwnershipTransfer(c.Request.Context(), c.GetString("userID"), c.Param("id"))
+	if err != nil {
+		replyTransferError(c, err, "failed to accept transfer")
+		return
+	}
+	c.JSON(http.StatusOK, httpResponseMessage{
+		Message: "you are now the owner of document " + transfer.DocumentID,
+	})
+}
+
+func (h *HTTPHandler) declineTransfer(c *gin.Context) {
+	if err := h.documentService.DeclineOwnershipTransfer(c.Request.Context(), c.GetString("userID"), c.Param("id")); err != nil {
+		replyTransferError(c, err, "failed to decline transfer")
+		return
+	}
+	c.JSON(http.StatusOK, httpResponseMessage{
+		Message: "transfer declined",
+	})
+}
+
+func replyTransferError(c *gin.Context, err error, message string) {
+	switch {
+	case errors.Is(err, ErrTransferNotFound):
+		c.JSON(http.StatusNotFound, httpResponseMessage{
+			Message: "transfer not found",
+		})
+	case errors.Is(err, ErrInvalidTransfer):
+		c.JSON(http.StatusBadRequest, httpResponseMessage{
+			Message: "bad request: " + err.Error(),
+		})
+	default:
+		c.Error(err)
+		c.JSON(http.StatusInternalServerError, httpResponseMessage{
+			Message: message,
+		})
+	}
+}
+
+// setTemplate makes the document a personal or shared template
+func (h *HTTPHandler) setTemplate(c *gin.Context) {
+	var body SetTemplateDTO
+	if err := c.ShouldBindJSON(&body); err != nil {
 		c.JSON(http.StatusBadRequest, httpResponseMessage{
-			Message: "failed to marshal body",
+			Message: "bad request: " + err.Error(),
 		})
 		return
 	}
-	body.DocumentID = documentID
+	body.DocumentID = c.GetString("documentID")
 
-	if err := h.documentService.UpdateDocumentMetadata(c.Request.Context(), body); err != nil {
-		c.JSON(http.StatusBadRequest, httpResponseMessage{
-			Message: "failed to update document",
+	if err := h.documentService.SetTemplate(c.Request.Context(), body); err != nil {
+		c.Error(err)
+		c.JSON(http.StatusInternalServerError, httpResponseMessage{
+			Message: "failed to set template",
 		})
 		return
 	}
+	c.JSON(http.StatusOK, httpResponseMessage{
+		Message: "document is now a " + string(body.Visibility) + " template",
+	})
+}
 
-	c.JSON(http.StatusOK, gin.H{
-		"message": "document metadata updated",
+// unsetTemplate turns a template back into a plain document
+func (h *HTTPHandler) unsetTemplate(c *gin.Context) {
+	if err := h.documentService.UnsetTemplate(c.Request.Context(), c.GetString("documentID")); err != nil {
+		c.Error(err)
+		c.JSON(http.StatusInternalServerError, httpResponseMessage{
+			Message: "failed to unset template",
+		})
+		return
+	}
+	c.JSON(http.StatusOK, httpResponseMessage{
+		Message: "document is no longer a template",
 	})
 }
 
-func (h *HTTPHandler) getDocumentCollaborators(c *gin.Context) {
-	documentID := c.GetString("documentID")
+// getFolders lists the folders at the top of the caller's tree
+func (h *HTTPHandler) getFolders(c *gin.Context) {
+	folders, err := h.documentService.GetUserFolders(c.Request.Context(), c.GetString("userID"))
+	if err != nil {
+		c.Error(err)
+		c.JSON(http.StatusInternalServerError, httpResponseMessage{
+			Message: "failed to fetch folders",
+		})
+		return
+	}
+	c.JSON(http.StatusOK, ToFolderListItemResponseList(folders))
+}
 
-	collaborators, err := h.documentService.getDocumentCollaborators(c.Request.Context(), documentID)
+func (h *HTTPHandler) createFolder(c *gin.Context) {
+	var body CreateFolderDTO
+	if err := c.ShouldBindJSON(&body); err != nil {
+		c.JSON(http.StatusBadRequest, httpResponseMessage{
+			Message: "bad request: " + err.Error(),
+		})
+		return
+	}
+	body.OwnerID = c.GetString("userID")
+
+	folder, err := h.documentService.CreateFolder(c.Request.Context(), body)
 	if err != nil {
-		c.JSON(http.StatusNotFound, httpResponseMessage{
-			Message: err.Error(),
+		replyFolderError(c, err, "failed to create folder")
+		return
+	}
+	c.JSON(http.StatusCreated, ToFolderResponse(folder))
+}
+
+// getFolder returns the folder, the caller's role on it and its subfolders
+func (h *HTTPHandler) getFolder(c *gin.Context) {
+	folder, ok := folderFromContext(c)
+	if !ok {
+		return
+	}
+
+	folders, err := h.documentService.GetSubfolders(c.Request.Context(), folder.ID)
+	if err != nil {
+		c.Error(err)
+		c.JSON(http.StatusInternalServerError, httpResponseMessage{
+			Message: "failed to fetch folder",
 		})
 		return
 	}
+	c.JSON(http.StatusOK, FolderDetailResponse{
+		FolderResponse: ToFolderResponse(folder),
+		Role:           Role(c.GetString("folderPermission")),
+		Folders:        ToFolderResponseList(folders),
+	})
+}
 
-	// Convert to DTO response
-	response := ToCollaboratorResponseList(collaborators)
-	c.JSON(http.StatusOK, response)
+func (h *HTTPHandler) renameFolder(c *gin.Context) {
+	var body RenameFolderDTO
+	if err := c.ShouldBindJSON(&body); err != nil {
+		c.JSON(http.StatusBadRequest, httpResponseMessage{
+			Message: "bad request: " + err.Error(),
+		})
+		return
+	}
+	body.FolderID = c.GetString("folderID")
+
+	if err := h.documentService.RenameFolder(c.Request.Context(), body); err != nil {
+		replyFolderError(c, err, "failed to rename folder")
+		return
+	}
+	c.JSON(http.StatusOK, httpResponseMessage{
+		Message: "folder renamed",
+	})
 }
 
-func (h *HTTPHandler) removeDocumentCollaborator(c *gin.Context) {
-	var body RemoveCollaboratorDTO
+// moveFolder moves the folder into another folder or to the top level
+func (h *HTTPHandler) moveFolder(c *gin.Context) {
+	folder, ok := folderFromContext(c)
+	if !ok {
+		return
+	}
 
-	if err := c.Bind(&body); err != nil {
+	var body MoveFolderDTO
+	if err := c.ShouldBindJSON(&body); err != nil {
 		c.JSON(http.StatusBadRequest, httpResponseMessage{
 			Message: "bad request: " + err.Error(),
 		})
 		return
 	}
+	body.Folder = folder
+	body.UserID = c.GetString("userID")
 
-	body.DocumentID = c.GetString("documentID")
+	if err := h.documentService.MoveFolder(c.Request.Context(), body); err != nil {
+		replyFolderError(c, err, "failed to move folder")
+		return
+	}
+	c.JSON(http.StatusOK, httpResponseMessage{
+		Message: "folder moved",
+	})
+}
 
-	if err := h.documentService.RemoveDocumentCollaborator(c.Request.Context(), body); err != nil {
+// deleteFolder deletes the folder, what it contains moves to its parent
+func (h *HTTPHandler) deleteFolder(c *gin.Context) {
+	folder, ok := folderFromContext(c)
+	if !ok {
+		return
+	}
+
+	if err := h.documentService.DeleteFolder(c.Request.Context(), folder); err != nil {
+		replyFolderError(c, err, "failed to delete folder")
+		return
+	}
+	c.JSON(http.StatusOK, httpResponseMessage{
+		Message: "folder deleted",
+	})
+}
+
+func (h *HTTPHandler) getFolderCollaborators(c *gin.Context) {
+	collaborators, err := h.documentService.GetFolderCollaborators(c.Request.Context(), c.GetString("folderID"))
+	if err != nil {
+		c.Error(err)
+		c.JSON(http.StatusInternalServerError, httpResponseMessage{
+			Message: "failed to fetch folder collaborators",
+		})
+		return
+	}
+	c.JSON(http.StatusOK, ToFolderCollaboratorResponseList(collaborators))
+}
+
+// addFolderCollaborator shares the folder and everything inside it, sharing
+// it again with a user replaces their role
+func (h *HTTPHandler) addFolderCollaborator(c *gin.Context) {
+	var body AddFolderCollaboratorDTO
+	if err := c.ShouldBindJSON(&body); err != nil {
+		c.JSON(http.StatusBadRequest, httpResponseMessage{
+			Message: "bad request: " + err.Error(),
+		})
+		return
+	}
+	body.FolderID = c.GetString("folderID")
+
+	if err := h.documentService.AddFolderCollaborator(c.Request.Context(), body); err != nil {
+		c.JSON(http.StatusBadRequest, httpResponseMessage{
+			Message: "bad request: " + err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusCreated, httpResponseMessage{
+		Message: "folder permission created",
+	})
+}
+
+func (h *HTTPHandler) removeFolderCollaborator(c *gin.Context) {
+	var body RemoveFolderCollaboratorDTO
+	if err := c.ShouldBindJSON(&body); err != nil {
+		c.JSON(http.StatusBadRequest, httpResponseMessage{
+			Message: "bad request: " + err.Error(),
+		})
+		return
+	}
+	body.FolderID = c.GetString("folderID")
+
+	if err := h.documentService.RemoveFolderCollaborator(c.Request.Context(), body); err != nil {
 		c.JSON(http.StatusBadRequest, httpResponseMessage{
 			Message: "bad request: couldn't remove collaborator",
 		})
@@ -100,102 +1821,273 @@ func (h *HTTPHandler) removeDocumentCollaborator(c *gin.Context) {
 	})
 }
 
-func (h *HTTPHandler) addDocumentCollaborator(c *gin.Context) {
-	// Get values from middleware context
-	ownerID := c.GetString("userID")
-	documentID := c.GetString("documentID")
-
-	var body AddCollaboratorDTO
-	if err := c.Bind(&body); err != nil {
+// createWorkspace creates a workspace with the caller as its admin
+func (h *HTTPHandler) createWorkspace(c *gin.Context) {
+	var body CreateWorkspaceDTO
+	if err := c.ShouldBindJSON(&body); err != nil {
 		c.JSON(http.StatusBadRequest, httpResponseMessage{
 			Message: "bad request: " + err.Error(),
 		})
 		return
 	}
+	body.UserID = c.GetString("userID")
 
-	body.OwnerID = ownerID
-	body.DocumentID = documentID
+	workspace, err := h.documentService.CreateWorkspace(c.Request.Context(), body)
+	if err != nil {
+		replyWorkspaceError(c, err, "failed to create workspace")
+		return
+	}
+	c.JSON(http.StatusCreated, ToWorkspaceResponse(workspace))
+}
 
-	if err := h.documentService.AddCollaboratorToDocument(c.Request.Context(), body); err != nil {
+// getWorkspaces lists the workspaces the caller is a member of
+func (h *HTTPHandler) getWorkspaces(c *gin.Context) {
+	workspaces, err := h.documentService.GetUserWorkspaces(c.Request.Context(), c.GetString("userID"))
+	if err != nil {
+		replyWorkspaceError(c, err, "failed to fetch workspaces")
+		return
+	}
+	c.JSON(http.StatusOK, ToWorkspaceListItemResponseList(workspaces))
+}
+
+func (h *HTTPHandler) getWorkspace(c *gin.Context) {
+	workspace, ok := workspaceFromContext(c)
+	if !ok {
+		return
+	}
+	c.JSON(http.StatusOK, WorkspaceListItemResponse{
+		WorkspaceResponse: ToWorkspaceResponse(workspace),
+		Role:              WorkspaceRole(c.GetString("workspaceRole")),
+	})
+}
+
+func (h *HTTPHandler) getWorkspaceMembers(c *gin.Context) {
+	members, err := h.documentService.GetWorkspaceMembers(c.Request.Context(), c.GetString("workspaceID"))
+	if err != nil {
+		replyWorkspaceError(c, err, "failed to fetch workspace members")
+		return
+	}
+	c.JSON(http.StatusOK, ToWorkspaceMemberResponseList(members))
+}
+
+// setWorkspaceMember adds a member to the workspace or changes their role
+func (h *HTTPHandler) setWorkspaceMember(c *gin.Context) {
+	var body SetWorkspaceMemberDTO
+	if err := c.ShouldBindJSON(&body); err != nil {
 		c.JSON(http.StatusBadRequest, httpResponseMessage{
 			Message: "bad request: " + err.Error(),
 		})
 		return
 	}
+	body.WorkspaceID = c.GetString("workspaceID")
 
-	c.JSON(http.StatusCreated, httpResponseMessage{
-		Message: "document permission created",
+	if err := h.documentService.SetWorkspaceMember(c.Request.Context(), body); err != nil {
+		replyWorkspaceError(c, err, "failed to set workspace member")
+		return
+	}
+	c.JSON(http.StatusOK, httpResponseMessage{
+		Message: "workspace member saved",
 	})
 }
 
-func (h *HTTPHandler) createDocument(c *gin.Context) {
-	// Get userID from middleware context
-	ownerID := c.GetString("userID")
+func (h *HTTPHandler) removeWorkspaceMember(c *gin.Context) {
+	if err := h.documentService.RemoveWorkspaceMember(c.Request.Context(), RemoveWorkspaceMemberDTO{
+		WorkspaceID: c.GetString("workspaceID"),
+		UserID:      c.Param("user"),
+	}); err != nil {
+		replyWorkspaceError(c, err, "failed to remove workspace member")
+		return
+	}
+	c.JSON(http.StatusOK, httpResponseMessage{
+		Message: "workspace member removed",
+	})
+}
 
-	var body CreateDocumentDTO
-	if err := c.Bind(&body); err != nil {
+// getWorkspaceDocuments lists the documents of the workspace the caller can
+// access, all of them for admins, with the filters of GET /documents
+func (h *HTTPHandler) getWorkspaceDocuments(c *gin.Context) {
+	var query ListDocumentsDTO
+	if err := c.ShouldBindQuery(&query); err != nil {
 		c.JSON(http.StatusBadRequest, httpResponseMessage{
 			Message: "bad request: " + err.Error(),
 		})
 		return
 	}
+	query.UserID = c.GetString("userID")
+	query.WorkspaceID = c.GetString("workspaceID")
+	h.listDocuments(c, query)
+}
 
-	body.OwnerID = ownerID
-	document, err := h.documentService.CreateNewDocument(c.Request.Context(), body)
-	if err != nil {
-		// Log the error for debugging purposes
-		c.Error(err)
+// moveDocumentToWorkspace moves the document into one of the caller's
+// workspaces, or out of its workspace
+func (h *HTTPHandler) moveDocumentToWorkspace(c *gin.Context) {
+	document, ok := documentFromContext(c)
+	if !ok {
+		return
+	}
+
+	var body MoveDocumentWorkspaceDTO
+	if err := c.ShouldBindJSON(&body); err != nil {
 		c.JSON(http.StatusBadRequest, httpResponseMessage{
-			Message: "failed to create document: " + err.Error(),
+			Message: "bad request: " + err.Error(),
 		})
 		return
 	}
+	body.Document = document
+	body.UserID = c.GetString("userID")
 
-	response := ToDocumentResponse(document)
+	if err := h.documentService.MoveDocumentToWorkspace(c.Request.Context(), body); err != nil {
+		replyWorkspaceError(c, err, "failed to move document to workspace")
+		return
+	}
+	c.JSON(http.StatusOK, httpResponseMessage{
+		Message: "document moved",
+	})
+}
 
-	c.JSON(http.StatusCreated, response)
+func replyWorkspaceError(c *gin.Context, err error, message string) {
+	switch {
+	case errors.Is(err, ErrWorkspaceNotFound):
+		c.JSON(http.StatusNotFound, httpResponseMessage{
+			Message: "workspace not found or access denied",
+		})
+	case errors.Is(err, ErrWorkspaceForbidden):
+		c.JSON(http.StatusForbidden, httpResponseMessage{
+			Message: err.Error(),
+		})
+	case errors.Is(err, ErrInvalidWorkspace):
+		c.JSON(http.StatusBadRequest, httpResponseMessage{
+			Message: "bad request: " + err.Error(),
+		})
+	default:
+		c.Error(err)
+		c.JSON(http.StatusInternalServerError, httpResponseMessage{
+			Message: message,
+		})
+	}
 }
 
-func (h *HTTPHandler) getDocuments(c *gin.Context) {
-	// Get userID from middleware context
-	userID := c.GetString("userID")
+// replyFolderError maps folder failures to responses
+func replyFolderError(c *gin.Context, err error, message string) {
+	switch {
+	case errors.Is(err, ErrFolderNotFound):
+		c.JSON(http.StatusNotFound, httpResponseMessage{
+			Message: "folder not found or access denied",
+		})
+	case errors.Is(err, ErrInvalidFolder):
+		c.JSON(http.StatusBadRequest, httpResponseMessage{
+			Message: "bad request: " + err.Error(),
+		})
+	default:
+		c.Error(err)
+		c.JSON(http.StatusInternalServerError, httpResponseMessage{
+			Message: message,
+		})
+	}
+}
 
-	documents, err := h.documentService.GetUserDocuments(c.Request.Context(), userID, false)
+func (h *HTTPHandler) getOneDocument(c *gin.Context) {
+	doc, ok := documentFromContext(c)
+	if !ok {
+		return
+	}
+
+	tags, err := h.documentService.GetDocumentTags(c.Request.Context(), doc.ID)
 	if err != nil {
-		c.JSON(http.StatusBadRequest, httpResponseMessage{
-			Message: "failed to fetch documents",
+		c.Error(err)
+		c.JSON(http.StatusInternalServerError, httpResponseMessage{
+			Message: "failed to fetch document",
 		})
 		return
 	}
+	doc.Tags = tags
 
-	// Convert to DTO response
-	response := ToDocumentResponseList(documents)
+	response := ToDocumentDetailResponse(doc)
+	c.Header("ETag", formatETag(doc.Version))
 	c.JSON(http.StatusOK, response)
 }
 
-func (h *HTTPHandler) getOneDocument(c *gin.Context) {
+// documentFromContext returns the document loaded by the access middleware,
+// writing an error response when it is missing
+func documentFromContext(c *gin.Context) (*Document, bool) {
 	// Get document from middleware context (already validated)
 	document, exists := c.Get("document")
 	if !exists {
 		c.JSON(http.StatusInternalServerError, httpResponseMessage{
 			Message: "document not found in context",
 		})
-		return
+		return nil, false
 	}
 
-	// Type assert and convert to DTO response
+	// Type assert to the document model
 	doc, ok := document.(*Document)
 	if !ok {
 		c.JSON(http.StatusInternalServerError, httpResponseMessage{
 			Message: "invalid document type in context",
 		})
-		return
+		return nil, false
 	}
+	return doc, true
+}
 
-	response := ToDocumentDetailResponse(doc)
-	c.JSON(http.StatusOK, response)
+func folderFromContext(c *gin.Context) (*Folder, bool) {
+	value, exists := c.Get("folder")
+	if !exists {
+		c.JSON(http.StatusInternalServerError, httpResponseMessage{
+			Message: "folder not found in context",
+		})
+		return nil, false
+	}
+
+	folder, ok := value.(*Folder)
+	if !ok {
+		c.JSON(http.StatusInternalServerError, httpResponseMessage{
+			Message: "invalid folder type in context",
+		})
+		return nil, false
+	}
+	return folder, true
+}
+
+func workspaceFromContext(c *gin.Context) (*Workspace, bool) {
+	value, exists := c.Get("workspace")
+	if !exists {
+		c.JSON(http.StatusInternalServerError, httpResponseMessage{
+			Message: "workspace not found in context",
+		})
+		return nil, false
+	}
+
+	workspace, ok := value.(*Workspace)
+	if !ok {
+		c.JSON(http.StatusInternalServerError, httpResponseMessage{
+			Message: "invalid workspace type in context",
+		})
+		return nil, false
+	}
+	return workspace, true
 }
 
 type httpResponseMessage struct {
 	Message string `json:"message"`
 }
+
+type contentValidationResponse struct {
+	Message string `json:"message"`
+	Path    string `json:"path"`
+}
+
+type stepsAppliedResponse struct {
+	Message string `json:"message"`
+	Version int64  `json:"version"`
+}
+
+type lockedResponse struct {
+	Message string       `json:"message"`
+	Lock    LockResponse `json:"lock"`
+}
+
+type stepsConflictResponse struct {
+	Message string `json:"message"`
+	DocumentStepsResponse
+}
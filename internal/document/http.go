@@ -13,9 +13,12 @@ import (
 )
 
 type APIHTTPServer struct {
-	router  *gin.Engine
-	server  *http.Server
-	handler *HTTPHandler
+	router   *gin.Engine
+	server   *http.Server
+	handler  *HTTPHandler
+	hub      *CollaborationHub
+	presence *PresenceTracker
+	purger   *TrashPurger
 }
 
 func (s *APIHTTPServer) Start(cfg config.ServerConfig) error {
@@ -23,6 +26,9 @@ func (s *APIHTTPServer) Start(cfg config.ServerConfig) error {
 		Addr:    cfg.Host + ":" + cfg.Port,
 		Handler: s.router.Handler(),
 	}
+	// Presence streams only end once their subscriptions close, Shutdown
+	// would wait for them until it times out otherwise
+	s.server.RegisterOnShutdown(s.presence.Stop)
 
 	go func() {
 		if err := s.server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
@@ -36,13 +42,19 @@ func (s *APIHTTPServer) Stop() error {
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 
-	if err := s.server.Shutdown(ctx); err != nil {
+	err := s.server.Shutdown(ctx)
+	if err != nil {
 		log.Println("Server shutdown:", err)
-		return err
 	}
 
+	// Websocket connections are hijacked and not tracked by Shutdown, the
+	// hub has to flush their edits even when Shutdown timed out
+	s.hub.Stop()
+	s.presence.Stop()
+	s.purger.Stop()
+
 	log.Println("Server exiting")
-	return nil
+	return err
 }
 
 func NewAPIServer(documentService *DocumentService) (*APIHTTPServer, error) {
@@ -50,10 +62,17 @@ func NewAPIServer(documentService *DocumentService) (*APIHTTPServer, error) {
 		return nil, fmt.Errorf("documents service cannot be nil")
 	}
 
+	hub := NewCollaborationHub(documentService, documentService.config.CollabFlushInterval)
+	presence := NewPresenceTracker(documentService, documentService.config.PresenceTTL)
+	purger := NewTrashPurger(documentService, documentService.config.TrashRetention, documentService.config.TrashPurgeInterval)
+
 	server := &APIHTTPServer{
-		router:  gin.Default(),
-		server:  &http.Server{},
-		handler: NewHTTPHandler(documentService),
+		router:   gin.Default(),
+		server:   &http.Server{},
+		handler:  NewHTTPHandler(documentService, hub, presence, documentService.config.CollabAllowedOrigins),
+		hub:      hub,
+		presence: presence,
+		purger:   purger,
 	}
 	server.setupRoutes()
 	return server, nil
@@ -64,7 +83,8 @@ func (s *APIHTTPServer) setupRoutes() {
 	s.router.Use(gin.Recovery())
 	config := cors.DefaultConfig()
 	config.AllowAllOrigins = true
-	config.AllowHeaders = []string{"Origin", "Content-Length", "Content-Type", "Authorization", "X-User-Id"}
+	config.AllowHeaders = []string{"Origin", "Content-Length", "Content-Type", "Authorization", "X-User-Id", "If-Match"}
+	config.ExposeHeaders = []string{"ETag", "Content-Disposition"}
 	s.router.Use(cors.New(config))
 
 	// ProtectedRoutes require the X-User-Id header
@@ -73,21 +93,95 @@ func (s *APIHTTPServer) setupRoutes() {
 	{
 		protectedRoutes.GET("/documents", s.handler.getDocuments)
 		protectedRoutes.POST("/documents", s.handler.createDocument)
+		protectedRoutes.GET("/documents/search", s.handler.searchDocuments)
+		protectedRoutes.GET("/documents/suggest", s.handler.suggestDocuments)
+		protectedRoutes.GET("/documents/export.zip", s.handler.exportDocumentArchive)
+		protectedRoutes.POST("/documents/import", s.handler.importDocument)
+		protectedRoutes.GET("/folders", s.handler.getFolders)
+		protectedRoutes.POST("/folders", s.handler.createFolder)
+		protectedRoutes.GET("/tags", s.handler.getTags)
+		protectedRoutes.GET("/trash", s.handler.getTrash)
+		protectedRoutes.DELETE("/trash/:id", s.handler.purgeDocument)
+		protectedRoutes.GET("/transfers", s.handler.getTransfers)
+		protectedRoutes.GET("/workspaces", s.handler.getWorkspaces)
+		protectedRoutes.POST("/workspaces", s.handler.createWorkspace)
+		protectedRoutes.POST("/transfers/:id/accept", s.handler.acceptTransfer)
+		protectedRoutes.POST("/transfers/:id/decline", s.handler.declineTransfer)
 	}
 
 	// Document routes with specific permission requirements
 	documentRoutes := protectedRoutes.Group("/documents/:id")
+	prosemirrorOnly := RequireContentMode(ContentModeProseMirror)
+	yjsOnly := RequireContentMode(ContentModeYjs)
 	{
 		// Routes that require viewer access (read-only)
 		documentRoutes.GET("", RequireViewerAccess(s.handler.documentService), s.handler.getOneDocument)
+		documentRoutes.GET("/revisions", RequireViewerAccess(s.handler.documentService), s.handler.getDocumentRevisions)
+		documentRoutes.GET("/revisions/:rev", RequireViewerAccess(s.handler.documentService), s.handler.getDocumentRevision)
+		documentRoutes.GET("/diff", RequireViewerAccess(s.handler.documentService), prosemirrorOnly, s.handler.diffDocument)
+		documentRoutes.GET("/export", RequireViewerAccess(s.handler.documentService), prosemirrorOnly, s.handler.exportDocument)
+		documentRoutes.GET("/ws", RequireViewerAccess(s.handler.documentService), prosemirrorOnly, s.handler.collaborate)
+		documentRoutes.GET("/lock", RequireViewerAccess(s.handler.documentService), s.handler.getDocumentLocks)
+		documentRoutes.GET("/presence", RequireViewerAccess(s.handler.documentService), s.handler.getDocumentPresence)
+		documentRoutes.GET("/presence/stream", RequireViewerAccess(s.handler.documentService), s.handler.streamDocumentPresence)
+		documentRoutes.PUT("/presence", RequireViewerAccess(s.handler.documentService), s.handler.updateDocumentPresence)
+		documentRoutes.DELETE("/presence/:session", RequireViewerAccess(s.handler.documentService), s.handler.leaveDocumentPresence)
+		documentRoutes.GET("/steps", RequireViewerAccess(s.handler.documentService), prosemirrorOnly, s.handler.getDocumentSteps)
+		documentRoutes.GET("/updates", RequireViewerAccess(s.handler.documentService), yjsOnly, s.handler.getDocumentUpdates)
+		documentRoutes.GET("/state-vector", RequireViewerAccess(s.handler.documentService), yjsOnly, s.handler.getDocumentStateVector)
+		documentRoutes.POST("/duplicate", RequireViewerAccess(s.handler.documentService), s.handler.duplicateDocument)
 
 		// Routes that require editor access (can modify content)
-		documentRoutes.PATCH("", RequireEditorAccess(s.handler.documentService), s.handler.updateDocument)
+		documentRoutes.PATCH("", RequireEditorAccess(s.handler.documentService), RequireUnlocked(s.handler.documentService, false), RequireIfMatch(), s.handler.updateDocument)
+		documentRoutes.PUT("/content", RequireEditorAccess(s.handler.documentService), prosemirrorOnly, RequireUnlocked(s.handler.documentService, true), RequireIfMatch(), s.handler.updateDocumentContent)
+		documentRoutes.POST("/steps", RequireEditorAccess(s.handler.documentService), prosemirrorOnly, RequireUnlocked(s.handler.documentService, false), s.handler.applyDocumentSteps)
+		documentRoutes.POST("/updates", RequireEditorAccess(s.handler.documentService), yjsOnly, RequireUnlocked(s.handler.documentService, true), s.handler.pushDocumentUpdate)
+		documentRoutes.POST("/import", RequireEditorAccess(s.handler.documentService), prosemirrorOnly, RequireUnlocked(s.handler.documentService, true), RequireIfMatch(), s.handler.importDocumentContent)
+		documentRoutes.POST("/revisions/:rev/restore", RequireEditorAccess(s.handler.documentService), prosemirrorOnly, RequireUnlocked(s.handler.documentService, true), RequireIfMatch(), s.handler.restoreDocumentRevision)
+		documentRoutes.POST("/lock", RequireEditorAccess(s.handler.documentService), s.handler.lockDocument)
+		documentRoutes.POST("/lock/:lock/renew", RequireEditorAccess(s.handler.documentService), s.handler.renewDocumentLock)
+		documentRoutes.DELETE("/lock", RequireEditorAccess(s.handler.documentService), s.handler.unlockDocument)
+		documentRoutes.DELETE("/lock/:lock", RequireEditorAccess(s.handler.documentService), s.handler.releaseDocumentLock)
+		documentRoutes.POST("/tags", RequireEditorAccess(s.handler.documentService), s.handler.tagDocument)
+		documentRoutes.DELETE("/tags/:tag", RequireEditorAccess(s.handler.documentService), s.handler.untagDocument)
 
 		// Routes that require owner access (can manage permissions)
-		documentRoutes.DELETE("", RequireOwnerAccess(s.handler.documentService), s.handler.deleteDocument)
+		documentRoutes.DELETE("", RequireOwnerAccess(s.handler.documentService), RequireUnlocked(s.handler.documentService, false), RequireIfMatch(), s.handler.deleteDocument)
 		documentRoutes.POST("/collaborators", RequireOwnerAccess(s.handler.documentService), s.handler.addDocumentCollaborator)
 		documentRoutes.DELETE("/collaborators", RequireOwnerAccess(s.handler.documentService), s.handler.removeDocumentCollaborator)
 		documentRoutes.GET("/collaborators", RequireOwnerAccess(s.handler.documentService), s.handler.getDocumentCollaborators)
+		documentRoutes.POST("/move", RequireOwnerAccess(s.handler.documentService), s.handler.moveDocument)
+		documentRoutes.PUT("/workspace", RequireOwnerAccess(s.handler.documentService), s.handler.moveDocumentToWorkspace)
+		documentRoutes.PUT("/template", RequireOwnerAccess(s.handler.documentService), prosemirrorOnly, s.handler.setTemplate)
+		documentRoutes.DELETE("/template", RequireOwnerAccess(s.handler.documentService), s.handler.unsetTemplate)
+		documentRoutes.POST("/transfer", RequireOwnerAccess(s.handler.documentService), s.handler.transferDocument)
+		documentRoutes.GET("/transfer", RequireOwnerAccess(s.handler.documentService), s.handler.getDocumentTransfer)
+		documentRoutes.DELETE("/transfer", RequireOwnerAccess(s.handler.documentService), s.handler.cancelDocumentTransfer)
+
+		// The access middlewares do not see trashed documents, the trash
+		// resolves owner access itself
+		documentRoutes.POST("/restore", s.handler.restoreDocument)
+	}
+
+	// Folder routes, roles on a folder apply to everything inside it
+	folderRoutes := protectedRoutes.Group("/folders/:id")
+	{
+		folderRoutes.GET("", FolderAccessMiddleware(s.handler.documentService, "viewer"), s.handler.getFolder)
+		folderRoutes.PATCH("", FolderAccessMiddleware(s.handler.documentService, "editor"), s.handler.renameFolder)
+		folderRoutes.POST("/move", FolderAccessMiddleware(s.handler.documentService, "owner"), s.handler.moveFolder)
+		folderRoutes.DELETE("", FolderAccessMiddleware(s.handler.documentService, "owner"), s.handler.deleteFolder)
+		folderRoutes.GET("/collaborators", FolderAccessMiddleware(s.handler.documentService, "owner"), s.handler.getFolderCollaborators)
+		folderRoutes.POST("/collaborators", FolderAccessMiddleware(s.handler.documentService, "owner"), s.handler.addFolderCollaborator)
+		folderRoutes.DELETE("/collaborators", FolderAccessMiddleware(s.handler.documentService, "owner"), s.handler.removeFolderCollaborator)
+	}
+
+	// Workspace routes, admins manage the members and reach every document
+	workspaceRoutes := protectedRoutes.Group("/workspaces/:id")
+	{
+		workspaceRoutes.GET("", WorkspaceAccessMiddleware(s.handler.documentService, WorkspaceRoleGuest), s.handler.getWorkspace)
+		workspaceRoutes.GET("/documents", WorkspaceAccessMiddleware(s.handler.documentService, WorkspaceRoleGuest), s.handler.getWorkspaceDocuments)
+		workspaceRoutes.GET("/members", WorkspaceAccessMiddleware(s.handler.documentService, WorkspaceRoleGuest), s.handler.getWorkspaceMembers)
+		workspaceRoutes.POST("/members", WorkspaceAccessMiddleware(s.handler.documentService, WorkspaceRoleAdmin), s.handler.setWorkspaceMember)
+		workspaceRoutes.DELETE("/members/:user", WorkspaceAccessMiddleware(s.handler.documentService, WorkspaceRoleAdmin), s.handler.removeWorkspaceMember)
 	}
 }
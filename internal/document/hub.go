@@ -0,0 +1,563 @@
+package internal
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/emaforlin/ce-document-service/pkg/prosemirror"
+	"golang.org/x/net/websocket"
+)
+
+const (
+	hubClientBuffer   = 64
+	hubMaxMessageSize = 4 << 20
+	hubWriteTimeout   = 10 * time.Second
+)
+
+// hubMessage is the envelope of every message exchanged over the websocket
+type hubMessage struct {
+	Type    string          `json:"type"`
+	Version int64           `json:"version,omitempty"`
+	UserID  string          `json:"user_id,omitempty"`
+	Content json.RawMessage `json:"content,omitempty"`
+	Message string          `json:"message,omitempty"`
+	Path    string          `json:"path,omitempty"`
+	// Steps and ClientIDs are set on "steps" messages, see DocumentStepsResponse
+	Steps     []json.RawMessage `json:"steps,omitempty"`
+	ClientIDs []string          `json:"client_ids,omitempty"`
+}
+
+// CollaborationHub relays edits between the websocket clients connected to a
+// document. Each document gets a room holding the merged state, which is
+// persisted periodically and when the last client leaves.
+type CollaborationHub struct {
+	service  *DocumentService
+	interval time.Duration
+
+	mu     sync.Mutex
+	rooms  map[string]*hubRoom
+	closed bool
+
+	events chan DocumentEvent
+	ctx    context.Context
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+type hubRoom struct {
+	documentID string
+
+	// flushMu serializes writes of the room state and the handling of
+	// version changes coming from the service
+	flushMu sync.Mutex
+
+	mu      sync.Mutex
+	clients map[*hubClient]struct{}
+	content *prosemirror.Node
+	version int64
+	// dirty is set when content has edits that are not persisted yet,
+	// editors holds the users who made them
+	dirty      bool
+	editors    map[string]struct{}
+	lastAuthor string
+}
+
+type hubClient struct {
+	conn   *websocket.Conn
+	userID string
+	role   string
+	send   chan hubMessage
+	once   sync.Once
+	closed chan struct{}
+}
+
+// NewCollaborationHub creates a hub and starts its background loop
+func NewCollaborationHub(service *DocumentService, flushInterval time.Duration) *CollaborationHub {
+	if flushInterval <= 0 {
+		flushInterval = 5 * time.Second
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	hub := &CollaborationHub{
+		service:  service,
+		interval: flushInterval,
+		rooms:    make(map[string]*hubRoom),
+		events:   make(chan DocumentEvent, 1024),
+		ctx:      ctx,
+		cancel:   cancel,
+		done:     make(chan struct{}),
+	}
+	service.Subscribe(hub.onDocumentEvent)
+	go hub.run()
+	return hub
+}
+
+// ServeClient handles a websocket connection for a user that already passed
+// the document access middleware. It blocks until the connection is closed.
+func (h *CollaborationHub) ServeClient(conn *websocket.Conn, document *Document, userID, role string) {
+	conn.MaxPayloadBytes = hubMaxMessageSize
+
+	client := &hubClient{
+		conn:   conn,
+		userID: userID,
+		role:   role,
+		send:   make(chan hubMessage, hubClientBuffer),
+		closed: make(chan struct{}),
+	}
+
+	room, err := h.join(document, client)
+	if err != nil {
+		websocket.JSON.Send(conn, hubMessage{Type: "error", Message: err.Error()})
+		conn.Close()
+		return
+	}
+	defer h.leave(room, client)
+
+	go client.writeLoop()
+
+	for {
+		var msg hubMessage
+		if err := websocket.JSON.Receive(conn, &msg); err != nil {
+			return
+		}
+		h.handleMessage(room, client, msg)
+	}
+}
+
+func (h *CollaborationHub) join(document *Document, client *hubClient) (*hubRoom, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.closed {
+		return nil, errors.New("server is shutting down")
+	}
+
+	room, exists := h.rooms[document.ID]
+	if !exists {
+		content, err := decodeContent(document.Content)
+		if err != nil {
+			return nil, err
+		}
+		room = &hubRoom{
+			documentID: document.ID,
+			clients:    make(map[*hubClient]struct{}),
+			content:    content,
+			version:    document.Version,
+		}
+		h.rooms[document.ID] = room
+	}
+
+	room.mu.Lock()
+	defer room.mu.Unlock()
+	room.clients[client] = struct{}{}
+	client.enqueue(room.snapshotMessage("init"))
+	return room, nil
+}
+
+func (h *CollaborationHub) leave(room *hubRoom, client *hubClient) {
+	client.close()
+
+	room.mu.Lock()
+	delete(room.clients, client)
+	empty := len(room.clients) == 0
+	room.mu.Unlock()
+
+	// The last client to leave persists pending edits and drops the room
+	if empty {
+		h.flushRoom(room)
+	}
+}
+
+func (h *CollaborationHub) handleMessage(room *hubRoom, client *hubClient, msg hubMessage) {
+	switch msg.Type {
+	case "ping":
+		client.enqueue(hubMessage{Type: "pong"})
+	case "update":
+		room.mu.Lock()
+		role := client.role
+		room.mu.Unlock()
+		if !validatePermission(role, string(RoleEditor)) {
+			client.enqueue(hubMessage{Type: "error", Message: "viewers cannot edit the document"})
+			return
+		}
+		// Updates replace the whole content, any lock held by someone else
+		// blocks them
+		if err := h.service.CheckDocumentLock(h.ctx, room.documentID, client.userID, true); err != nil {
+			client.enqueue(hubMessage{Type: "error", Message: err.Error()})
+			return
+		}
+		doc, err := h.service.Schema().NodeFromJSON(msg.Content)
+		if err != nil {
+			reply := hubMessage{Type: "error", Message: err.Error()}
+			var validationErr *prosemirror.ValidationError
+			if errors.As(err, &validationErr) {
+				reply.Message = "invalid document content: " + validationErr.Message
+				reply.Path = validationErr.Path
+			}
+			client.enqueue(reply)
+			return
+		}
+
+		content, _ := json.Marshal(doc)
+
+		room.mu.Lock()
+		room.content = doc
+		room.dirty = true
+		room.addEditor(client.userID)
+		room.lastAuthor = client.userID
+		room.broadcast(hubMessage{Type: "update", UserID: client.userID, Content: content}, client)
+		room.mu.Unlock()
+	default:
+		client.enqueue(hubMessage{Type: "error", Message: "unknown message type " + msg.Type})
+	}
+}
+
+// onDocumentEvent is the service listener, events are handled by the loop.
+// It may be called from the loop itself while flushing, so it never blocks.
+func (h *CollaborationHub) onDocumentEvent(event DocumentEvent) {
+	if h.ctx.Err() != nil {
+		return
+	}
+	select {
+	case h.events <- event:
+	default:
+		go func() {
+			select {
+			case h.events <- event:
+			case <-h.ctx.Done():
+			}
+		}()
+	}
+}
+
+func (h *CollaborationHub) run() {
+	defer close(h.done)
+
+	ticker := time.NewTicker(h.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case event := <-h.events:
+			h.handleEvent(event)
+		case <-ticker.C:
+			h.flushAll()
+		case <-h.ctx.Done():
+			return
+		}
+	}
+}
+
+func (h *CollaborationHub) handleEvent(event DocumentEvent) {
+	h.mu.Lock()
+	room, exists := h.rooms[event.DocumentID]
+	h.mu.Unlock()
+	if !exists {
+		return
+	}
+
+	switch event.Type {
+	case EventDocumentDeleted:
+		h.mu.Lock()
+		delete(h.rooms, event.DocumentID)
+		h.mu.Unlock()
+		room.closeAll(hubMessage{Type: "deleted", Message: "the document was deleted"})
+	case EventCollaboratorRemoved:
+		h.evict(room, event.UserID)
+	case EventMetadataUpdated:
+		room.flushMu.Lock()
+		defer room.flushMu.Unlock()
+		room.mu.Lock()
+		switch {
+		case event.Version <= room.version:
+			room.mu.Unlock()
+		case event.Version == room.version+1:
+			// Only the version moved, the room state is still current
+			room.version = event.Version
+			room.mu.Unlock()
+		default:
+			room.mu.Unlock()
+			h.reload(room)
+		}
+	case EventStepsApplied:
+		room.flushMu.Lock()
+		defer room.flushMu.Unlock()
+		h.applySteps(room, event)
+	case EventContentUpdated:
+		room.flushMu.Lock()
+		defer room.flushMu.Unlock()
+		room.mu.Lock()
+		current := event.Version <= room.version
+		room.mu.Unlock()
+		if !current {
+			h.reload(room)
+		}
+	}
+}
+
+// evict disconnects the sessions of a user whose access was revoked. Users
+// that still reach the document some other way keep their sessions with the
+// updated role.
+func (h *CollaborationHub) evict(room *hubRoom, userID string) {
+	document, permission := h.service.GetDocumentWithPermission(h.ctx, userID, room.documentID)
+
+	room.mu.Lock()
+	defer room.mu.Unlock()
+	for client := range room.clients {
+		if client.userID != userID {
+			continue
+		}
+		if document != nil && validatePermission(permission, string(RoleViewer)) {
+			client.role = permission
+			continue
+		}
+		client.enqueue(hubMessage{Type: "evicted", Message: "access to the document was revoked"})
+		client.close()
+		delete(room.clients, client)
+	}
+}
+
+// applySteps forwards steps applied through the steps endpoint to the room
+// clients. Rooms that are not exactly at the version the steps were made on,
+// or that have unsaved edits, are reloaded instead. Callers hold room.flushMu.
+func (h *CollaborationHub) applySteps(room *hubRoom, event DocumentEvent) {
+	room.mu.Lock()
+	if event.Version <= room.version {
+		room.mu.Unlock()
+		return
+	}
+	if room.dirty || room.version != event.Version-int64(len(event.Steps)) {
+		room.mu.Unlock()
+		h.reload(room)
+		return
+	}
+	content, err := h.service.applyStoredSteps(room.content, event.Steps)
+	if err != nil {
+		room.mu.Unlock()
+		log.Printf("collaboration hub: document %s: %v", room.documentID, err)
+		h.reload(room)
+		return
+	}
+	defer room.mu.Unlock()
+
+	steps := ToDocumentStepsResponse(event.Version, event.Steps)
+	room.content = content
+	room.version = event.Version
+	room.broadcast(hubMessage{
+		Type:      "steps",
+		Version:   event.Version,
+		UserID:    event.UserID,
+		Steps:     steps.Steps,
+		ClientIDs: steps.ClientIDs,
+	}, nil)
+}
+
+// reload replaces the room state with the stored document and pushes it to
+// every client. Edits that were not persisted yet cannot be rebased since
+// clients send whole documents, their authors are sent a "rejected" message
+// with the dropped content before the reset so they can resend it. Callers
+// hold room.flushMu.
+func (h *CollaborationHub) reload(room *hubRoom) {
+	document := h.service.FindDocument(h.ctx, room.documentID)
+	if document == nil {
+		return
+	}
+	content, err := decodeContent(document.Content)
+	if err != nil {
+		log.Printf("collaboration hub: document %s: %v", room.documentID, err)
+		return
+	}
+
+	room.mu.Lock()
+	defer room.mu.Unlock()
+	if len(room.editors) > 0 {
+		rejected := room.snapshotMessage("rejected")
+		rejected.Message = "the document was changed elsewhere and your unsaved edits were dropped, resend them on top of the reset content"
+		for client := range room.clients {
+			if _, ok := room.editors[client.userID]; ok {
+				client.enqueue(rejected)
+			}
+		}
+	}
+	room.content = content
+	room.version = document.Version
+	room.dirty = false
+	room.editors = nil
+	room.broadcast(room.snapshotMessage("reset"), nil)
+}
+
+func (h *CollaborationHub) flushAll() {
+	h.mu.Lock()
+	rooms := make([]*hubRoom, 0, len(h.rooms))
+	for _, room := range h.rooms {
+		rooms = append(rooms, room)
+	}
+	h.mu.Unlock()
+
+	for _, room := range rooms {
+		h.flushRoom(room)
+	}
+}
+
+// flushRoom persists the room state if it has unsaved edits and drops the
+// room once it has no clients left
+func (h *CollaborationHub) flushRoom(room *hubRoom) {
+	room.flushMu.Lock()
+	defer room.flushMu.Unlock()
+
+	room.mu.Lock()
+	dirty, editors := room.dirty, room.editors
+	content, version, author := room.content, room.version, room.lastAuthor
+	room.dirty = false
+	room.editors = nil
+	room.mu.Unlock()
+
+	if dirty {
+		// The hub context is cancelled on shutdown, the final flush must
+		// still reach the database
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		newVersion, err := h.service.storeContent(ctx, room.documentID, author, version, content)
+		cancel()
+
+		switch {
+		case err == nil:
+			room.mu.Lock()
+			room.version = newVersion
+			room.broadcast(hubMessage{Type: "saved", Version: newVersion}, nil)
+			room.mu.Unlock()
+		case errors.Is(err, ErrVersionConflict):
+			// Edits made while storing are dropped along with the snapshot
+			room.mu.Lock()
+			for userID := range editors {
+				room.addEditor(userID)
+			}
+			room.mu.Unlock()
+			h.reload(room)
+		default:
+			log.Printf("collaboration hub: document %s: %v", room.documentID, err)
+			room.mu.Lock()
+			room.dirty = true
+			for userID := range editors {
+				room.addEditor(userID)
+			}
+			room.mu.Unlock()
+		}
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	room.mu.Lock()
+	defer room.mu.Unlock()
+	if len(room.clients) == 0 && !room.dirty && h.rooms[room.documentID] == room {
+		delete(h.rooms, room.documentID)
+	}
+}
+
+// Stop disconnects every client and persists pending edits
+func (h *CollaborationHub) Stop() {
+	h.mu.Lock()
+	if h.closed {
+		h.mu.Unlock()
+		return
+	}
+	h.closed = true
+	h.mu.Unlock()
+
+	h.cancel()
+	<-h.done
+
+	h.mu.Lock()
+	rooms := make([]*hubRoom, 0, len(h.rooms))
+	for _, room := range h.rooms {
+		rooms = append(rooms, room)
+	}
+	h.mu.Unlock()
+
+	for _, room := range rooms {
+		room.closeAll(hubMessage{Type: "closing", Message: "server is shutting down"})
+		h.flushRoom(room)
+	}
+}
+
+// broadcast queues a message for every client but except. Callers hold room.mu.
+func (r *hubRoom) broadcast(msg hubMessage, except *hubClient) {
+	for client := range r.clients {
+		if client != except {
+			client.enqueue(msg)
+		}
+	}
+}
+
+// addEditor records a user whose edits are not persisted yet. Callers hold room.mu.
+func (r *hubRoom) addEditor(userID string) {
+	if r.editors == nil {
+		r.editors = make(map[string]struct{})
+	}
+	r.editors[userID] = struct{}{}
+}
+
+// snapshotMessage builds a message carrying the full room state. Callers hold room.mu.
+func (r *hubRoom) snapshotMessage(msgType string) hubMessage {
+	content, _ := json.Marshal(r.content)
+	return hubMessage{Type: msgType, Version: r.version, Content: content}
+}
+
+func (r *hubRoom) closeAll(msg hubMessage) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for client := range r.clients {
+		client.enqueue(msg)
+		client.close()
+		delete(r.clients, client)
+	}
+}
+
+// enqueue queues a message without blocking, clients too slow to keep up
+// are disconnected
+func (c *hubClient) enqueue(msg hubMessage) {
+	select {
+	case <-c.closed:
+	case c.send <- msg:
+	default:
+		c.close()
+	}
+}
+
+// close stops the write loop, which flushes queued messages and then closes
+// the connection, unblocking the read loop
+func (c *hubClient) close() {
+	c.once.Do(func() {
+		close(c.closed)
+	})
+}
+
+func (c *hubClient) writeLoop() {
+	defer c.conn.Close()
+	for {
+		select {
+		case msg := <-c.send:
+			if err := c.write(msg); err != nil {
+				return
+			}
+		case <-c.closed:
+			for {
+				select {
+				case msg := <-c.send:
+					if err := c.write(msg); err != nil {
+						return
+					}
+				default:
+					return
+				}
+			}
+		}
+	}
+}
+
+func (c *hubClient) write(msg hubMessage) error {
+	c.conn.SetWriteDeadline(time.Now().Add(hubWriteTimeout))
+	return websocket.JSON.Send(c.conn, msg)
+}
@@ -0,0 +1,201 @@
+package internal
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/emaforlin/ce-document-service/pkg/docx"
+	"github.com/emaforlin/ce-document-service/pkg/prosemirror"
+	"github.com/emaforlin/ce-document-service/pkg/prosemirror/markdown"
+)
+
+// ImportMode decides what happens to the content of a document content is
+// imported into
+type ImportMode string
+
+const (
+	// ImportModeReplace discards the current content
+	ImportModeReplace ImportMode = "replace"
+	// ImportModeAppend adds the imported blocks after the current content
+	ImportModeAppend ImportMode = "append"
+)
+
+// importedContent is the result of converting an uploaded file
+type importedContent struct {
+	content *prosemirror.Node
+	// title is the title the file carries, if any
+	title    string
+	warnings prosemirror.Warnings
+}
+
+// importFormat converts an uploaded file into document content
+type importFormat struct {
+	parse func(s *DocumentService, data []byte) (*importedContent, error)
+}
+
+// importFormats maps format names to parsers
+var importFormats = map[string]importFormat{
+	"docx": {
+		parse: func(_ *DocumentService, data []byte) (*importedContent, error) {
+			content, title, err := docx.Parse(data)
+			if errors.Is(err, docx.ErrInvalidPackage) {
+				return nil, fmt.Errorf("%w: %v", ErrInvalidImport, err)
+			}
+			if err != nil {
+				return nil, err
+			}
+			return &importedContent{content: content, title: title}, nil
+		},
+	},
+	"markdown": {
+		parse: func(s *DocumentService, data []byte) (*importedContent, error) {
+			content, warnings := markdown.NewParser(s.schema).Parse(string(data))
+			return &importedContent{content: content, title: headingTitle(content), warnings: warnings}, nil
+		},
+	},
+	"html": {
+		parse: func(s *DocumentService, data []byte) (*importedContent, error) {
+			parser := &prosemirror.HTMLParser{Schema: s.schema}
+			content, title, err := parser.Parse(bytes.NewReader(data))
+			if err != nil {
+				return nil, fmt.Errorf("%w: %v", ErrInvalidImport, err)
+			}
+			if title == "" {
+				title = headingTitle(content)
+			}
+			return &importedContent{content: content, title: title, warnings: parser.Warnings}, nil
+		},
+	},
+}
+
+// headingTitle returns the text of a level 1 heading opening the document,
+// which Markdown and HTML files use in place of a title
+func headingTitle(doc *prosemirror.Node) string {
+	if len(doc.Content) == 0 {
+		return ""
+	}
+	first := doc.Content[0]
+	if first.Type != "heading" || attrLevel(first) != 1 {
+		return ""
+	}
+	var title strings.Builder
+	for _, child := range first.Content {
+		title.WriteString(child.Text)
+	}
+	return strings.Join(strings.Fields(title.String()), " ")
+}
+
+func attrLevel(node *prosemirror.Node) int {
+	switch level := node.Attr("level").(type) {
+	case float64:
+		return int(level)
+	case int:
+		return level
+	}
+	return 0
+}
+
+// importFile converts and validates an uploaded file
+func (s *DocumentService) importFile(format string, data []byte) (*importedContent, error) {
+	parser, ok := importFormats[format]
+	if !ok {
+		return nil, fmt.Errorf("%w: %q", ErrUnsupportedFormat, format)
+	}
+	imported, err := parser.parse(s, data)
+	if err != nil {
+		return nil, err
+	}
+	if imported.content, err = s.checkImported(imported.content); err != nil {
+		return nil, err
+	}
+	return imported, nil
+}
+
+// ImportDocument converts an uploaded file and creates a new document owned
+// by data.OwnerID with the result. The title falls back to the one stored in
+// the file. Constructs the schema cannot hold are dropped or simplified and
+// reported in the returned warnings.
+func (s *DocumentService) ImportDocument(ctx context.Context, data ImportDocumentDTO) (*Document, prosemirror.Warnings, error) {
+	imported, err := s.importFile(data.Format, data.Data)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	title := strings.TrimSpace(data.Title)
+	if title == "" {
+		title = imported.title
+	}
+	if title == "" {
+		title = "Untitled document"
+	}
+	document, err := s.CreateNewDocument(ctx, CreateDocumentDTO{
+		Title:   title,
+		OwnerID: data.OwnerID,
+		Content: imported.content,
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	return document, imported.warnings, nil
+}
+
+// ImportDocumentContent converts an uploaded file into the content of an
+// existing document, replacing or appending to what it holds, and returns the
+// new version with the conversion warnings
+func (s *DocumentService) ImportDocumentContent(ctx context.Context, data ImportContentDTO) (int64, prosemirror.Warnings, error) {
+	imported, err := s.importFile(data.Format, data.Data)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	doc := imported.content
+	if data.Mode == ImportModeAppend {
+		current, err := decodeContent(data.Document.Content)
+		if err != nil {
+			return 0, nil, err
+		}
+		if !isEmptyContent(current) {
+			content := make([]*prosemirror.Node, 0, len(current.Content)+len(doc.Content))
+			content = append(append(content, current.Content...), doc.Content...)
+			if doc, err = s.checkImported(&prosemirror.Node{Type: current.Type, Attrs: current.Attrs, Content: content}); err != nil {
+				return 0, nil, err
+			}
+		}
+	}
+
+	version, err := s.storeContent(ctx, data.Document.ID, data.UserID, data.Version, doc)
+	if err != nil {
+		return 0, nil, err
+	}
+	return version, imported.warnings, nil
+}
+
+// isEmptyContent reports whether a document holds nothing but an empty
+// paragraph, the content of a new document
+func isEmptyContent(doc *prosemirror.Node) bool {
+	switch len(doc.Content) {
+	case 0:
+		return true
+	case 1:
+		return doc.Content[0].Type == "paragraph" && len(doc.Content[0].Content) == 0
+	}
+	return false
+}
+
+// checkImported validates converted content against the schema, filling in
+// attribute defaults the converter left out
+func (s *DocumentService) checkImported(content *prosemirror.Node) (*prosemirror.Node, error) {
+	raw, err := json.Marshal(content)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode imported content: %w", err)
+	}
+	doc, err := s.schema.NodeFromJSON(raw)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidImport, err)
+	}
+	return doc, nil
+}
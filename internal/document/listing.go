@@ -0,0 +1,213 @@
+package internal
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	defaultListLimit = 50
+	maxListLimit     = 100
+)
+
+// DocumentListFilter selects, orders and pages the documents returned by
+// ListUserDocuments
+type DocumentListFilter struct {
+	UserID string
+	// Role is one of owned, shared, editor or viewer, empty for every
+	// document the user can access
+	Role        string
+	TitlePrefix string
+	// FolderID keeps the documents directly inside a folder only
+	FolderID string
+	// Templates keeps the templates the user can create documents from
+	Templates bool
+	// WorkspaceID keeps the documents of a workspace only
+	WorkspaceID string
+	// Tags keeps the documents with all of the tags when MatchAllTags is set,
+	// with any of them otherwise
+	Tags         []string
+	MatchAllTags bool
+	// UpdatedAfter and UpdatedBefore are exclusive bounds, ignored when zero
+	UpdatedAfter  time.Time
+	UpdatedBefore time.Time
+	// Sort is one of the keys of listSortColumns
+	Sort       string
+	Descending bool
+	// AfterValue and AfterID continue the listing after the row holding them,
+	// AfterValue is the value of the sort column
+	AfterValue any
+	AfterID    string
+	Limit      int
+}
+
+// DocumentListItem is a document of a listing with the role of the user
+type DocumentListItem struct {
+	ID          string
+	OwnerID     string
+	Title       string
+	Role        Role
+	ContentMode ContentMode
+	Version     int64
+	FolderID    *string
+	Template    TemplateVisibility
+	// SourceDocumentID is the document this one was duplicated from
+	SourceDocumentID *string
+	WorkspaceID      *string
+	Tags             []Tag `gorm:"-"`
+	CreatedAt        time.Time
+	UpdatedAt        time.Time
+}
+
+// listSortColumns maps the values of the sort query parameter to columns
+var listSortColumns = map[string]string{
+	"updated_at": "documents.updated_at",
+	"created_at": "documents.created_at",
+	"title":      "documents.title",
+}
+
+// listCursor is the position a listing continues from. It is handed to
+// clients base64 encoded and is only valid with the sort it was made for.
+type listCursor struct {
+	Sort       string `json:"s"`
+	Descending bool   `json:"d"`
+	Value      string `json:"v"`
+	ID         string `json:"id"`
+}
+
+// ListDocuments returns a page of the documents data.UserID can access and
+// the cursor of the next page, empty on the last one
+func (s *DocumentService) ListDocuments(ctx context.Context, data ListDocumentsDTO) ([]DocumentListItem, string, error) {
+	filter := DocumentListFilter{
+		UserID:        data.UserID,
+		Role:          data.Role,
+		TitlePrefix:   data.TitlePrefix,
+		FolderID:      data.FolderID,
+		Templates:     data.Templates,
+		WorkspaceID:   data.WorkspaceID,
+		MatchAllTags:  data.TagMatch != "any",
+		UpdatedAfter:  data.UpdatedAfter,
+		UpdatedBefore: data.UpdatedBefore,
+		Sort:          data.Sort,
+		Descending:    data.Order != "asc",
+		Limit:         data.Limit,
+	}
+	if filter.Sort == "" {
+		filter.Sort = "updated_at"
+	}
+	if _, ok := listSortColumns[filter.Sort]; !ok {
+		return nil, "", fmt.Errorf("%w: unknown sort %q", ErrInvalidListing, filter.Sort)
+	}
+	if filter.Limit <= 0 {
+		filter.Limit = defaultListLimit
+	}
+	filter.Limit = min(filter.Limit, maxListLimit)
+	if len(data.Tags) > 0 {
+		tags, err := tagNames(data.Tags)
+		if err != nil {
+			return nil, "", fmt.Errorf("%w: %w", ErrInvalidListing, err)
+		}
+		filter.Tags = tags
+	}
+
+	if data.Cursor != "" {
+		cursor, err := decodeListCursor(data.Cursor)
+		if err != nil || cursor.Sort != filter.Sort || cursor.Descending != filter.Descending {
+			return nil, "", fmt.Errorf("%w: invalid cursor", ErrInvalidListing)
+		}
+		filter.AfterID = cursor.ID
+		if filter.AfterValue, err = cursorValue(filter.Sort, cursor.Value); err != nil {
+			return nil, "", fmt.Errorf("%w: invalid cursor", ErrInvalidListing)
+		}
+	}
+
+	// One more row than asked tells whether there is a next page
+	limit := filter.Limit
+	filter.Limit++
+	items, err := s.repo.ListUserDocuments(ctx, filter)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to list documents: %w", err)
+	}
+	if len(items) <= limit {
+		return items, "", s.loadListTags(ctx, items)
+	}
+	items = items[:limit]
+	if err := s.loadListTags(ctx, items); err != nil {
+		return nil, "", err
+	}
+	last := items[limit-1]
+	next, err := encodeListCursor(listCursor{
+		Sort:       filter.Sort,
+		Descending: filter.Descending,
+		Value:      sortValue(filter.Sort, last),
+		ID:         last.ID,
+	})
+	if err != nil {
+		return nil, "", err
+	}
+	return items, next, nil
+}
+
+// loadListTags fills in the tags of listed documents
+func (s *DocumentService) loadListTags(ctx context.Context, items []DocumentListItem) error {
+	ids := make([]string, len(items))
+	for i, item := range items {
+		ids[i] = item.ID
+	}
+	tags, err := s.repo.GetDocumentTags(ctx, ids)
+	if err != nil {
+		return fmt.Errorf("failed to list documents: %w", err)
+	}
+	for i := range items {
+		items[i].Tags = tags[items[i].ID]
+	}
+	return nil
+}
+
+// sortValue returns the value of the sort column of an item as stored in
+// cursors
+func sortValue(sort string, item DocumentListItem) string {
+	switch sort {
+	case "created_at":
+		return item.CreatedAt.Format(time.RFC3339Nano)
+	case "title":
+		return item.Title
+	}
+	return item.UpdatedAt.Format(time.RFC3339Nano)
+}
+
+// cursorValue parses the sort column value of a cursor
+func cursorValue(sort, value string) (any, error) {
+	if sort == "title" {
+		return value, nil
+	}
+	return time.Parse(time.RFC3339Nano, value)
+}
+
+func encodeListCursor(cursor listCursor) (string, error) {
+	raw, err := json.Marshal(cursor)
+	if err != nil {
+		return "", fmt.Errorf("failed to encode cursor: %w", err)
+	}
+	return base64.RawURLEncoding.EncodeToString(raw), nil
+}
+
+func decodeListCursor(encoded string) (listCursor, error) {
+	var cursor listCursor
+	raw, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return cursor, err
+	}
+	if err := json.Unmarshal(raw, &cursor); err != nil {
+		return cursor, err
+	}
+	if err := uuid.Validate(cursor.ID); err != nil {
+		return cursor, err
+	}
+	return cursor, nil
+}
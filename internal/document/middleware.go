@@ -1,7 +1,11 @@
 package internal
 
 import (
+	"errors"
+	"fmt"
 	"net/http"
+	"strconv"
+	"strings"
 
 	"github.com/gin-gonic/gin"
 )
@@ -26,6 +30,9 @@ func UserHeaderMiddleware() gin.HandlerFunc {
 
 // DocumentAccessMiddleware - unified middleware to validate access on-demand
 // requiredPermission can be: "owner", "editor", "viewer"
+// The effective role comes from GetDocumentWithPermission: the highest of the
+// direct permission and the folder grants, and owner for the admins of the
+// workspace of the document (listings apply the same rule in documentAccessSQL).
 func DocumentAccessMiddleware(service *DocumentService, requiredPermission string) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		userID, exists := c.Get("userID")
@@ -80,14 +87,8 @@ func DocumentAccessMiddleware(service *DocumentService, requiredPermission strin
 // validatePermission verify if the user has the required permission level
 func validatePermission(userPermission, required string) bool {
 	// owner > editor > viewer
-	permissionLevels := map[string]int{
-		"owner":  3,
-		"editor": 2,
-		"viewer": 1,
-	}
-
-	userLevel, userExists := permissionLevels[userPermission]
-	requiredLevel, requiredExists := permissionLevels[required]
+	userLevel, userExists := roleLevels[Role(userPermission)]
+	requiredLevel, requiredExists := roleLevels[Role(required)]
 
 	if !userExists || !requiredExists {
 		return false
@@ -108,3 +109,166 @@ func RequireEditorAccess(service *DocumentService) gin.HandlerFunc {
 func RequireViewerAccess(service *DocumentService) gin.HandlerFunc {
 	return DocumentAccessMiddleware(service, "viewer")
 }
+
+// FolderAccessMiddleware validates the role of the user on the folder in the
+// id path parameter, inherited roles included
+func FolderAccessMiddleware(service *DocumentService, requiredPermission string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		folderID := c.Param("id")
+		if folderID == "" {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"message": "folder ID is required",
+			})
+			c.Abort()
+			return
+		}
+
+		folder, permission := service.GetFolderWithPermission(c.Request.Context(), c.GetString("userID"), folderID)
+		if folder == nil || !validatePermission(permission, requiredPermission) {
+			c.JSON(http.StatusNotFound, gin.H{
+				"message": "folder not found or access denied",
+			})
+			c.Abort()
+			return
+		}
+
+		c.Set("folder", folder)
+		c.Set("folderID", folderID)
+		c.Set("folderPermission", permission)
+		c.Next()
+	}
+}
+
+// WorkspaceAccessMiddleware validates the role of the user in the workspace
+// in the id path parameter
+func WorkspaceAccessMiddleware(service *DocumentService, requiredRole WorkspaceRole) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		workspaceID := c.Param("id")
+		if workspaceID == "" {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"message": "workspace ID is required",
+			})
+			c.Abort()
+			return
+		}
+
+		workspace, role := service.GetWorkspaceWithRole(c.Request.Context(), c.GetString("userID"), workspaceID)
+		if workspace == nil {
+			c.JSON(http.StatusNotFound, gin.H{
+				"message": "workspace not found or access denied",
+			})
+			c.Abort()
+			return
+		}
+		if workspaceRoleLevels[role] < workspaceRoleLevels[requiredRole] {
+			c.JSON(http.StatusForbidden, gin.H{
+				"message": "the " + string(requiredRole) + " workspace role is required",
+			})
+			c.Abort()
+			return
+		}
+
+		c.Set("workspace", workspace)
+		c.Set("workspaceID", workspaceID)
+		c.Set("workspaceRole", string(role))
+		c.Next()
+	}
+}
+
+// RequireContentMode rejects requests for documents stored in another content
+// mode. It must run after one of the access middlewares.
+func RequireContentMode(mode ContentMode) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		document, exists := c.Get("document")
+		if doc, ok := document.(*Document); exists && ok && doc.ContentMode != mode {
+			c.JSON(http.StatusConflict, gin.H{
+				"message": fmt.Sprintf("not supported for documents in the %s content mode", doc.ContentMode),
+			})
+			c.Abort()
+			return
+		}
+		c.Next()
+	}
+}
+
+// RequireUnlocked refuses writes with 423 Locked while another user holds a
+// lock on the document. Node range locks only count when includeRanges is set.
+func RequireUnlocked(service *DocumentService, includeRanges bool) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		err := service.CheckDocumentLock(c.Request.Context(), c.GetString("documentID"), c.GetString("userID"), includeRanges)
+		if err == nil {
+			c.Next()
+			return
+		}
+
+		var lockedErr *LockedError
+		if errors.As(err, &lockedErr) {
+			c.JSON(http.StatusLocked, gin.H{
+				"message": "document is locked by another user",
+				"lock":    ToLockResponse(&lockedErr.Lock),
+			})
+		} else {
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"message": "failed to check document locks",
+			})
+		}
+		c.Abort()
+	}
+}
+
+// RequireIfMatch makes the If-Match header mandatory and stores the version it
+// carries as "expectedVersion". When the access middleware already loaded the
+// document, stale versions are rejected before reaching the handler.
+func RequireIfMatch() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		header := c.GetHeader("If-Match")
+		if header == "" {
+			c.JSON(http.StatusPreconditionRequired, gin.H{
+				"message": "missing If-Match header",
+			})
+			c.Abort()
+			return
+		}
+
+		version, err := parseETag(header)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"message": err.Error(),
+			})
+			c.Abort()
+			return
+		}
+
+		if document, exists := c.Get("document"); exists {
+			if doc, ok := document.(*Document); ok && doc.Version != version {
+				c.Header("ETag", formatETag(doc.Version))
+				c.JSON(http.StatusPreconditionFailed, gin.H{
+					"message": "document has been modified, reload and retry",
+				})
+				c.Abort()
+				return
+			}
+		}
+
+		c.Set("expectedVersion", version)
+		c.Next()
+	}
+}
+
+// formatETag renders a document version as a strong entity tag
+func formatETag(version int64) string {
+	return fmt.Sprintf("\"%d\"", version)
+}
+
+// parseETag extracts the document version from an If-Match header value
+func parseETag(value string) (int64, error) {
+	tag := strings.TrimSpace(value)
+	tag = strings.TrimPrefix(tag, "W/")
+	tag = strings.Trim(tag, "\"")
+
+	version, err := strconv.ParseInt(tag, 10, 64)
+	if err != nil || version < 1 {
+		return 0, fmt.Errorf("invalid If-Match header: %q", value)
+	}
+	return version, nil
+}
@@ -4,6 +4,7 @@ import (
 	"time"
 
 	"github.com/jackc/pgtype"
+	"gorm.io/gorm"
 )
 
 type Role string
@@ -14,14 +15,97 @@ const (
 	RoleViewer Role = "viewer"
 )
 
+// roleLevels orders roles, each including the rights of the lower ones
+var roleLevels = map[Role]int{
+	RoleOwner:  3,
+	RoleEditor: 2,
+	RoleViewer: 1,
+}
+
+// maxRole returns the higher of two roles, unknown and empty roles rank
+// below every other
+func maxRole(a, b Role) Role {
+	if roleLevels[b] > roleLevels[a] {
+		return b
+	}
+	return a
+}
+
+// WorkspaceRole is the role of a member in a workspace
+type WorkspaceRole string
+
+const (
+	// WorkspaceRoleAdmin members manage the members and have owner access to
+	// every document of the workspace
+	WorkspaceRoleAdmin WorkspaceRole = "admin"
+	// WorkspaceRoleMember members can add documents to the workspace
+	WorkspaceRoleMember WorkspaceRole = "member"
+	// WorkspaceRoleGuest members only see the workspace and what is shared with
+	// them
+	WorkspaceRoleGuest WorkspaceRole = "guest"
+)
+
+// workspaceRoleLevels orders workspace roles like roleLevels
+var workspaceRoleLevels = map[WorkspaceRole]int{
+	WorkspaceRoleAdmin:  3,
+	WorkspaceRoleMember: 2,
+	WorkspaceRoleGuest:  1,
+}
+
+// ContentMode tells how the content of a document is stored
+type ContentMode string
+
+const (
+	// ContentModeProseMirror documents keep a ProseMirror JSON tree in Content
+	ContentModeProseMirror ContentMode = "prosemirror"
+	// ContentModeYjs documents keep a log of Yjs updates, compacted into a
+	// snapshot stored in Content
+	ContentModeYjs ContentMode = "yjs"
+)
+
+// TemplateVisibility tells who can create documents from a template, it is
+// empty on documents that are not templates
+type TemplateVisibility string
+
+const (
+	// TemplatePersonal templates can only be used by their owner
+	TemplatePersonal TemplateVisibility = "personal"
+	// TemplateShared templates can be used by everyone with access to them
+	TemplateShared TemplateVisibility = "shared"
+)
+
 type Document struct {
 	ID            string               `gorm:"primarykey;type:uuid;default:uuid_generate_v4()"`
 	OwnerID       string               `gorm:"type:uuid"`
 	Title         string               `gorm:"size:255"`
 	Content       *pgtype.JSONB        `gorm:"type:jsonb"`
+	Version       int64                `gorm:"not null;default:1"`
+	ContentMode   ContentMode          `gorm:"type:varchar(16);not null;default:prosemirror"`
+	Template      TemplateVisibility   `gorm:"type:varchar(16);not null;default:''"`
+	FolderID      *string              `gorm:"type:uuid;index"`
+	Folder        *Folder              `gorm:"constraint:OnDelete:SET NULL" json:"-"`
+	Tags          []Tag                `gorm:"many2many:document_tags;constraint:OnDelete:CASCADE" json:"-"`
 	Collaborators []DocumentPermission `gorm:"constraint:OnDelete:CASCADE" json:"-"`
+	Revisions     []DocumentRevision   `gorm:"constraint:OnDelete:CASCADE" json:"-"`
+	Steps         []DocumentStep       `gorm:"constraint:OnDelete:CASCADE" json:"-"`
+	Updates       []DocumentUpdate     `gorm:"constraint:OnDelete:CASCADE" json:"-"`
+	Locks         []DocumentLock       `gorm:"constraint:OnDelete:CASCADE" json:"-"`
 	CreatedAt     time.Time
 	UpdatedAt     time.Time
+	// DeletedAt is set while the document is in the trash
+	DeletedAt gorm.DeletedAt `gorm:"index"`
+	// SourceDocumentID is the document this one was duplicated from, it is
+	// cleared when that document is purged
+	SourceDocumentID *string   `gorm:"type:uuid;index"`
+	SourceDocument   *Document `gorm:"constraint:OnDelete:SET NULL" json:"-"`
+	// WorkspaceID is the workspace the document belongs to, if any
+	WorkspaceID *string    `gorm:"type:uuid;index"`
+	Workspace   *Workspace `gorm:"constraint:OnDelete:SET NULL" json:"-"`
+	// SearchText is the plain text of Content and SearchVector the full-text
+	// index over it and the title. The repository keeps both up to date and
+	// never loads them.
+	SearchText   string `gorm:"type:text;->:false;<-" json:"-"`
+	SearchVector string `gorm:"type:tsvector;index:idx_documents_search,type:gin;->:false;<-:update" json:"-"`
 }
 
 type DocumentPermission struct {
@@ -30,3 +114,140 @@ type DocumentPermission struct {
 	UserID     string `gorm:"type:uuid;not null;uniqueIndex:idx_document_user_permission"`
 	Role       Role   `gorm:"type:varchar(10);not null"`
 }
+
+// Folder groups documents and other folders. The owner of a folder and the
+// users it is shared with through FolderPermission have the same role on
+// everything inside it, subfolders included.
+type Folder struct {
+	ID            string             `gorm:"primarykey;type:uuid;default:uuid_generate_v4()"`
+	ParentID      *string            `gorm:"type:uuid;index"`
+	Parent        *Folder            `gorm:"constraint:OnDelete:SET NULL" json:"-"`
+	OwnerID       string             `gorm:"type:uuid;not null;index"`
+	Name          string             `gorm:"size:255;not null"`
+	Collaborators []FolderPermission `gorm:"constraint:OnDelete:CASCADE" json:"-"`
+	CreatedAt     time.Time
+	UpdatedAt     time.Time
+}
+
+type FolderPermission struct {
+	ID       string `gorm:"primarykey;type:uuid;default:uuid_generate_v4()"`
+	FolderID string `gorm:"type:uuid;not null;uniqueIndex:idx_folder_user_permission"`
+	UserID   string `gorm:"type:uuid;not null;uniqueIndex:idx_folder_user_permission"`
+	Role     Role   `gorm:"type:varchar(10);not null"`
+}
+
+// Workspace groups the documents of a team. Documents are still shared one
+// by one, except with admins who reach all of them.
+type Workspace struct {
+	ID        string            `gorm:"primarykey;type:uuid;default:uuid_generate_v4()"`
+	Name      string            `gorm:"size:255;not null"`
+	Members   []WorkspaceMember `gorm:"constraint:OnDelete:CASCADE" json:"-"`
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
+
+type WorkspaceMember struct {
+	ID          string        `gorm:"primarykey;type:uuid;default:uuid_generate_v4()"`
+	WorkspaceID string        `gorm:"type:uuid;not null;uniqueIndex:idx_workspace_user_member"`
+	UserID      string        `gorm:"type:uuid;not null;uniqueIndex:idx_workspace_user_member;index"`
+	Role        WorkspaceRole `gorm:"type:varchar(10);not null"`
+	CreatedAt   time.Time
+}
+
+// Tag is a label users put on documents through the document_tags join
+// table. Names are shared by everyone and stored normalized, see tagName.
+type Tag struct {
+	ID        string `gorm:"primarykey;type:uuid;default:uuid_generate_v4()"`
+	Name      string `gorm:"size:64;not null;uniqueIndex"`
+	CreatedAt time.Time
+}
+
+// OwnershipTransfer is an offer by the owner of a document to hand it over
+// to another user, it takes effect once the recipient accepts it. A document
+// has at most one pending transfer.
+type OwnershipTransfer struct {
+	ID         string    `gorm:"primarykey;type:uuid;default:uuid_generate_v4()"`
+	DocumentID string    `gorm:"type:uuid;not null;uniqueIndex"`
+	Document   *Document `gorm:"constraint:OnDelete:CASCADE" json:"-"`
+	FromUserID string    `gorm:"type:uuid;not null"`
+	ToUserID   string    `gorm:"type:uuid;not null;index"`
+	// KeepAsEditor leaves the previous owner with editor access
+	KeepAsEditor bool `gorm:"not null;default:false"`
+	CreatedAt    time.Time
+}
+
+// DocumentLock gives a user exclusive write access to a document, or to the
+// node range [From, To) of it when both are set, until ExpiresAt
+type DocumentLock struct {
+	ID         string    `gorm:"primarykey;type:uuid;default:uuid_generate_v4()"`
+	DocumentID string    `gorm:"type:uuid;not null;index"`
+	HolderID   string    `gorm:"type:uuid;not null"`
+	From       *int      `gorm:"column:range_from"`
+	To         *int      `gorm:"column:range_to"`
+	ExpiresAt  time.Time `gorm:"not null;index"`
+	CreatedAt  time.Time
+	UpdatedAt  time.Time
+}
+
+// IsRange reports whether the lock covers a node range only
+func (l *DocumentLock) IsRange() bool {
+	return l.From != nil && l.To != nil
+}
+
+// Covers reports whether the lock blocks changes to [from, to)
+func (l *DocumentLock) Covers(from, to int) bool {
+	if !l.IsRange() {
+		return true
+	}
+	// Insertions at a single position are blocked strictly inside the range
+	if from == to {
+		return *l.From < from && from < *l.To
+	}
+	return *l.From < to && from < *l.To
+}
+
+// ConflictsWith reports whether two locks cannot be held at the same time
+func (l *DocumentLock) ConflictsWith(other *DocumentLock) bool {
+	if l.HolderID == other.HolderID {
+		return false
+	}
+	if !other.IsRange() {
+		return true
+	}
+	return l.Covers(*other.From, *other.To)
+}
+
+// DocumentRevision is a snapshot of a document taken every time it changes.
+// Version is the document version the snapshot was taken at.
+type DocumentRevision struct {
+	ID         string        `gorm:"primarykey;type:uuid;default:uuid_generate_v4()"`
+	DocumentID string        `gorm:"type:uuid;not null;uniqueIndex:idx_document_revision_version"`
+	Version    int64         `gorm:"not null;uniqueIndex:idx_document_revision_version"`
+	Title      string        `gorm:"size:255"`
+	Content    *pgtype.JSONB `gorm:"type:jsonb"`
+	AuthorID   string        `gorm:"type:uuid"`
+	CreatedAt  time.Time
+}
+
+// DocumentStep is a ProseMirror step accepted through the steps endpoint,
+// kept so that collaborators behind the current version can catch up.
+// Version is the document version the step produced.
+type DocumentStep struct {
+	ID         string        `gorm:"primarykey;type:uuid;default:uuid_generate_v4()"`
+	DocumentID string        `gorm:"type:uuid;not null;uniqueIndex:idx_document_step_version"`
+	Version    int64         `gorm:"not null;uniqueIndex:idx_document_step_version"`
+	Step       *pgtype.JSONB `gorm:"type:jsonb;not null"`
+	ClientID   string        `gorm:"size:255"`
+	AuthorID   string        `gorm:"type:uuid"`
+	CreatedAt  time.Time
+}
+
+// DocumentUpdate is a binary Yjs update (v1 encoding) pushed to a document in
+// the yjs content mode. Compaction merges updates into Document.Content.
+type DocumentUpdate struct {
+	ID         string `gorm:"primarykey;type:uuid;default:uuid_generate_v4()"`
+	DocumentID string `gorm:"type:uuid;not null;index"`
+	Update     []byte `gorm:"type:bytea;not null"`
+	AuthorID   string `gorm:"type:uuid"`
+	CreatedAt  time.Time
+}
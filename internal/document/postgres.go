@@ -2,12 +2,17 @@ package internal
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log"
+	"strings"
+	"time"
 
 	"github.com/emaforlin/ce-document-service/pkg/config"
+	"github.com/jackc/pgtype"
 	"gorm.io/driver/postgres"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 	"gorm.io/gorm/logger"
 )
 
@@ -15,53 +20,177 @@ type PostgresDocumentRepositoryImpl struct {
 	db *gorm.DB
 }
 
-// DeleteDocument implements DocumentRepository
-func (r *PostgresDocumentRepositoryImpl) DeleteDocument(ctx context.Context, documentID string) error {
-	rows, err := gorm.G[Document](r.db).Where("id = ?", documentID).Delete(ctx)
+// DeleteDocument implements DocumentRepository. The document is moved to the
+// trash, see PurgeDocument for permanent removal.
+func (r *PostgresDocumentRepositoryImpl) DeleteDocument(ctx context.Context, documentID string, version int64) error {
+	rows, err := gorm.G[Document](r.db).Where("id = ? AND version = ?", documentID, version).Delete(ctx)
 	if err != nil {
 		return fmt.Errorf("error deleting: %w", err)
 	}
 	if rows < 1 {
+		if r.documentExists(ctx, documentID) {
+			return fmt.Errorf("error deleting: %w", ErrVersionConflict)
+		}
 		return fmt.Errorf("error deleting: document not found")
 	}
 	return nil
 }
 
-// GetDocumentWithPermission implements DocumentRepository
+// GetDocumentWithPermission implements DocumentRepository. The role is the
+// highest of the direct permission and the role inherited from the folders
+// containing the document.
 func (r *PostgresDocumentRepositoryImpl) GetDocumentWithPermission(ctx context.Context, userID, documentID string) (*Document, string) {
-	var document Document
-
-	// First check if user is owner
-	err := r.db.WithContext(ctx).
-		Where("id = ? AND owner_id = ?", documentID, userID).
-		First(&document).Error
+	document, err := gorm.G[Document](r.db).Where("id = ?", documentID).First(ctx)
+	if err != nil {
+		return nil, ""
+	}
+	if document.OwnerID == userID {
+		return &document, string(RoleOwner)
+	}
 
+	var role Role
+	permission, err := gorm.G[DocumentPermission](r.db).
+		Where("document_id = ? AND user_id = ?", documentID, userID).
+		First(ctx)
 	if err == nil {
-		return &document, "owner"
+		role = permission.Role
+	} else if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ""
 	}
 
-	// If not owner, check permissions table
-	var docPermission DocumentPermission
-	err = r.db.WithContext(ctx).
-		Joins("JOIN documents ON documents.id = document_permissions.document_id").
-		Where("document_permissions.document_id = ? AND document_permissions.user_id = ?", documentID, userID).
-		Select("document_permissions.role, documents.*").
-		First(&docPermission).Error
+	if document.FolderID != nil {
+		inherited, err := r.folderRole(ctx, userID, *document.FolderID)
+		if err != nil {
+			return nil, ""
+		}
+		role = maxRole(role, inherited)
+	}
+	// Workspace admins have owner access to every document of the workspace
+	if document.WorkspaceID != nil {
+		workspaceRole, err := r.workspaceRole(ctx, userID, *document.WorkspaceID)
+		if err != nil {
+			return nil, ""
+		}
+		if workspaceRole == WorkspaceRoleAdmin {
+			role = RoleOwner
+		}
+	}
 
-	if err != nil {
+	if role == "" {
 		return nil, ""
 	}
+	return &document, string(role)
+}
 
-	// Get the document
-	err = r.db.WithContext(ctx).
-		Where("id = ?", documentID).
-		First(&document).Error
+var (
+	// roleLevelSQL and levelRoleSQL convert between roles and roleLevels
+	roleLevelSQL = "CASE %s WHEN 'owner' THEN 3 WHEN 'editor' THEN 2 WHEN 'viewer' THEN 1 END"
+	levelRoleSQL = "CASE %s WHEN 3 THEN 'owner' WHEN 2 THEN 'editor' WHEN 1 THEN 'viewer' END"
+
+	// grantedFoldersSQL is a CTE of the folders @user can access with the
+	// level of each role they have there. Roles granted on a folder, owning it
+	// counting as an owner grant, flow down to its subfolders. UNION ends the
+	// recursion should the tree ever loop.
+	grantedFoldersSQL = `granted_folders AS (
+		SELECT folders.id, CASE WHEN folders.owner_id = @user THEN 3 ELSE ` + fmt.Sprintf(roleLevelSQL, "folder_permissions.role") + ` END AS level
+		FROM folders
+		LEFT JOIN folder_permissions ON folder_permissions.folder_id = folders.id AND folder_permissions.user_id = @user
+		WHERE folders.owner_id = @user OR folder_permissions.user_id = @user
+		UNION
+		SELECT folders.id, granted_folders.level
+		FROM folders JOIN granted_folders ON folders.parent_id = granted_folders.id
+	)`
+
+	// documentGrantsSQL selects the document_id of every document @user can
+	// access and the highest role they have on it, owned, shared directly,
+	// through a folder or as admin of its workspace. It is completed by a
+	// WHERE clause on documents and groupGrantsSQL.
+	documentGrantsSQL = `WITH RECURSIVE ` + grantedFoldersSQL + `, grants AS (
+		SELECT documents.id AS document_id, 3 AS level FROM documents WHERE documents.owner_id = @user
+		UNION ALL
+		SELECT document_permissions.document_id, ` + fmt.Sprintf(roleLevelSQL, "document_permissions.role") + `
+		FROM document_permissions WHERE document_permissions.user_id = @user
+		UNION ALL
+		SELECT documents.id, granted_folders.level
+		FROM documents JOIN granted_folders ON documents.folder_id = granted_folders.id
+		UNION ALL
+		SELECT documents.id, 3
+		FROM documents JOIN workspace_members ON workspace_members.workspace_id = documents.workspace_id
+		WHERE workspace_members.user_id = @user AND workspace_members.role = 'admin'
+	)
+	SELECT grants.document_id, ` + fmt.Sprintf(levelRoleSQL, "max(level)") + ` AS role
+	FROM grants JOIN documents ON documents.id = grants.document_id`
+	groupGrantsSQL = `
+	GROUP BY grants.document_id HAVING max(level) IS NOT NULL`
+
+	// documentAccessSQL is documentGrantsSQL without trashed documents
+	documentAccessSQL = documentGrantsSQL + `
+	WHERE documents.deleted_at IS NULL` + groupGrantsSQL
+
+	// trashAccessSQL is documentGrantsSQL limited to trashed documents
+	trashAccessSQL = documentGrantsSQL + `
+	WHERE documents.deleted_at IS NOT NULL` + groupGrantsSQL
+
+	// folderAccessSQL selects the folder_id of every folder @user can access
+	// and the highest role they have on it
+	folderAccessSQL = `WITH RECURSIVE ` + grantedFoldersSQL + `
+	SELECT id AS folder_id, ` + fmt.Sprintf(levelRoleSQL, "max(level)") + ` AS role
+	FROM granted_folders GROUP BY id HAVING max(level) IS NOT NULL`
+
+	// folderAncestorsSQL is a CTE of the folder @folder and its ancestors
+	folderAncestorsSQL = `ancestors AS (
+		SELECT id, parent_id, owner_id FROM folders WHERE id = @folder
+		UNION
+		SELECT folders.id, folders.parent_id, folders.owner_id
+		FROM folders JOIN ancestors ON folders.id = ancestors.parent_id
+	)`
+
+	// folderTreeSQL is a CTE of the folder @folder and its descendants
+	folderTreeSQL = `tree AS (
+		SELECT id FROM folders WHERE id = @folder
+		UNION
+		SELECT folders.id FROM folders JOIN tree ON folders.parent_id = tree.id
+	)`
+)
 
+// documentAccess is a subquery of the documents userID can access, see
+// documentAccessSQL
+func (r *PostgresDocumentRepositoryImpl) documentAccess(userID string) *gorm.DB {
+	return r.db.Raw(documentAccessSQL, map[string]interface{}{"user": userID})
+}
+
+// ownedTrash is a subquery of the trashed documents userID has owner access
+// to, the same access needed to delete them
+func (r *PostgresDocumentRepositoryImpl) ownedTrash(userID string) *gorm.DB {
+	return r.db.Raw(`SELECT document_id FROM (`+trashAccessSQL+`) AS access WHERE role = @role`,
+		map[string]interface{}{"user": userID, "role": RoleOwner})
+}
+
+// folderAccess is a subquery of the folders userID can access, see
+// folderAccessSQL
+func (r *PostgresDocumentRepositoryImpl) folderAccess(userID string) *gorm.DB {
+	return r.db.Raw(folderAccessSQL, map[string]interface{}{"user": userID})
+}
+
+// folderRole returns the highest role userID has on the folder or any of its
+// ancestors, empty when they have none
+func (r *PostgresDocumentRepositoryImpl) folderRole(ctx context.Context, userID, folderID string) (Role, error) {
+	var level int
+	err := r.db.WithContext(ctx).Raw(`WITH RECURSIVE `+folderAncestorsSQL+`
+		SELECT coalesce(max(CASE WHEN ancestors.owner_id = @user THEN 3 ELSE `+fmt.Sprintf(roleLevelSQL, "folder_permissions.role")+` END), 0)
+		FROM ancestors
+		LEFT JOIN folder_permissions ON folder_permissions.folder_id = ancestors.id AND folder_permissions.user_id = @user`,
+		map[string]interface{}{"user": userID, "folder": folderID},
+	).Scan(&level).Error
 	if err != nil {
-		return nil, ""
+		return "", fmt.Errorf("failed to resolve folder role: %w", err)
 	}
-
-	return &document, string(docPermission.Role)
+	for role, roleLevel := range roleLevels {
+		if roleLevel == level {
+			return role, nil
+		}
+	}
+	return "", nil
 }
 
 // GetDocumentPermissions implements DocumentRepository
@@ -89,39 +218,163 @@ func (r *PostgresDocumentRepositoryImpl) CreateDocumentPermission(ctx context.Co
 	return nil
 }
 
-// UpdateDocument implements DocumentRepository
-func (r *PostgresDocumentRepositoryImpl) UpdateDocument(ctx context.Context, document Document) error {
-	updated, err := gorm.G[Document](r.db).Where("id = ?", document.ID).Updates(ctx, document)
-	if updated < 1 {
-		return fmt.Errorf("document update failed: no document matched")
+// UpdateDocument implements DocumentRepository. The update only applies when
+// document.Version still matches the stored version, which is then incremented.
+// Empty titles and nil content are left untouched.
+func (r *PostgresDocumentRepositoryImpl) UpdateDocument(ctx context.Context, document Document, authorID string) error {
+	updates := map[string]interface{}{
+		"version": gorm.Expr("version + 1"),
+	}
+	if document.Title != "" {
+		updates["title"] = document.Title
+	}
+	if document.Content != nil {
+		updates["content"] = document.Content
 	}
+	indexUpdates(updates, document.Title, document.Content)
 
-	if err != nil {
-		return fmt.Errorf("document update failed: %w", err)
+	return r.compareAndSwap(ctx, document.ID, document.Version, updates, authorID)
+}
+
+// UpdateDocumentContent implements DocumentRepository
+func (r *PostgresDocumentRepositoryImpl) UpdateDocumentContent(ctx context.Context, documentID string, version int64, content *pgtype.JSONB, authorID string) error {
+	updates := map[string]interface{}{
+		"content": content,
+		"version": gorm.Expr("version + 1"),
 	}
+	indexUpdates(updates, "", content)
+	return r.compareAndSwap(ctx, documentID, version, updates, authorID)
+}
+
+// compareAndSwap applies updates to the document only if it is still at
+// version, and records the revision it produced in the same transaction
+func (r *PostgresDocumentRepositoryImpl) compareAndSwap(ctx context.Context, documentID string, version int64, updates map[string]interface{}, authorID string) error {
+	return r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		result := tx.Model(&Document{}).
+			Where("id = ? AND version = ?", documentID, version).
+			Updates(updates)
+
+		if result.Error != nil {
+			return fmt.Errorf("document update failed: %w", result.Error)
+		}
+		if result.RowsAffected < 1 {
+			if r.documentExists(ctx, documentID) {
+				return fmt.Errorf("document update failed: %w", ErrVersionConflict)
+			}
+			return fmt.Errorf("document update failed: no document matched")
+		}
+		return snapshotRevision(tx, documentID, authorID)
+	})
+}
 
+// snapshotRevision records the document row as a revision within tx. Called
+// right after the row is inserted or updated, it is still locked by tx so the
+// revision holds exactly what was written.
+func snapshotRevision(tx *gorm.DB, documentID, authorID string) error {
+	err := tx.Exec(
+		`INSERT INTO document_revisions (document_id, version, title, content, author_id, created_at)
+		SELECT id, version, title, content, ?, NOW() FROM documents WHERE id = ?`,
+		authorID, documentID,
+	).Error
+	if err != nil {
+		return fmt.Errorf("failed to create document revision: %w", err)
+	}
 	return nil
 }
 
-// CreateDocument implements DocumentRepository.
+func (r *PostgresDocumentRepositoryImpl) documentExists(ctx context.Context, documentID string) bool {
+	count, err := gorm.G[Document](r.db).Where("id = ?", documentID).Count(ctx, "id")
+	return err == nil && count > 0
+}
+
+// CreateDocument implements DocumentRepository. The owner permission and the
+// first revision are written in the same transaction as the document.
 func (r *PostgresDocumentRepositoryImpl) CreateDocument(ctx context.Context, document Document) (*Document, error) {
-	if err := gorm.G[Document](r.db).Create(ctx, &document); err != nil {
+	err := r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := createDocument(ctx, tx, &document); err != nil {
+			return err
+		}
+		if err := gorm.G[DocumentPermission](tx).Create(ctx, &DocumentPermission{
+			DocumentID: document.ID,
+			UserID:     document.OwnerID,
+			Role:       RoleOwner,
+		}); err != nil {
+			return err
+		}
+		return snapshotRevision(tx, document.ID, document.OwnerID)
+	})
+	if err != nil {
 		return nil, fmt.Errorf("failed to create document: %w", err)
 	}
 	return &document, nil
 }
 
+// DuplicateDocument implements DocumentRepository. The pending Yjs updates of
+// the source are always copied since they hold part of its content. The copy
+// gets its own revision of the current version, owned by its owner, in the
+// same transaction.
+func (r *PostgresDocumentRepositoryImpl) DuplicateDocument(ctx context.Context, document Document, permissions []DocumentPermission, copyRevisions bool) (*Document, error) {
+	sourceID := *document.SourceDocumentID
+	err := r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := createDocument(ctx, tx, &document); err != nil {
+			return err
+		}
+		for i := range permissions {
+			permissions[i].ID = ""
+			permissions[i].DocumentID = document.ID
+		}
+		if len(permissions) > 0 {
+			if err := gorm.G[DocumentPermission](tx).CreateInBatches(ctx, &permissions, len(permissions)); err != nil {
+				return err
+			}
+		}
+		if err := tx.Exec(
+			`INSERT INTO document_updates (document_id, "update", author_id, created_at)
+			SELECT ?, "update", author_id, created_at FROM document_updates WHERE document_id = ?`,
+			document.ID, sourceID,
+		).Error; err != nil {
+			return err
+		}
+		if copyRevisions {
+			if err := tx.Exec(
+				`INSERT INTO document_revisions (document_id, version, title, content, author_id, created_at)
+				SELECT ?, version, title, content, author_id, created_at FROM document_revisions
+				WHERE document_id = ? AND version < ?`,
+				document.ID, sourceID, document.Version,
+			).Error; err != nil {
+				return err
+			}
+		}
+		return snapshotRevision(tx, document.ID, document.OwnerID)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to duplicate document: %w", err)
+	}
+	return &document, nil
+}
+
+// createDocument inserts document and indexes it for search within tx
+func createDocument(ctx context.Context, tx *gorm.DB, document *Document) error {
+	document.SearchText = contentText(document.Content)
+	if err := gorm.G[Document](tx).Create(ctx, document); err != nil {
+		return err
+	}
+	document.SearchText = ""
+	return tx.Model(&Document{}).
+		Where("id = ?", document.ID).
+		UpdateColumn("search_vector", gorm.Expr(searchVectorSQL("title", "search_text"))).Error
+}
+
 // FindDocument implements DocumentRepository.
 func (r *PostgresDocumentRepositoryImpl) FindDocument(ctx context.Context, userID string, documentID string) *Document {
 	var document Document
 
-	// Query to find document if user is owner OR has permission
+	// Query to find document if the user can access it
 	err := r.db.WithContext(ctx).
-		Select("DISTINCT documents.*").
+		Select("documents.*").
 		Table("documents").
-		Joins("LEFT JOIN document_permissions ON documents.id = document_permissions.document_id").
-		Where("documents.id = ? AND (documents.owner_id = ? OR document_permissions.user_id = ?)",
-			documentID, userID, userID).
+		Joins("JOIN (?) AS access ON access.document_id = documents.id", r.documentAccess(userID)).
+		Where("documents.id = ?", documentID).
 		First(&document).Error
 
 	if err != nil {
@@ -130,6 +383,15 @@ func (r *PostgresDocumentRepositoryImpl) FindDocument(ctx context.Context, userI
 	return &document
 }
 
+// FindDocumentByID implements DocumentRepository.
+func (r *PostgresDocumentRepositoryImpl) FindDocumentByID(ctx context.Context, documentID string) *Document {
+	document, err := gorm.G[Document](r.db).Where("id = ?", documentID).First(ctx)
+	if err != nil {
+		return nil
+	}
+	return &document
+}
+
 // GetAllDocuments implements DocumentRepository.
 func (r *PostgresDocumentRepositoryImpl) GetUserDocuments(ctx context.Context, userID string, userIsOwner bool) ([]Document, error) {
 	if userIsOwner {
@@ -141,7 +403,7 @@ func (r *PostgresDocumentRepositoryImpl) GetUserDocuments(ctx context.Context, u
 	}
 
 	documents, err := gorm.G[Document](r.db).
-		Raw("SELECT DISTINCT documents.* FROM documents LEFT JOIN document_permissions ON documents.id = document_permissions.document_id WHERE documents.owner_id = ? OR document_permissions.user_id = ?", userID, userID).
+		Where("id IN (SELECT document_id FROM (?) AS access)", r.documentAccess(userID)).
 		Find(ctx)
 
 	if err != nil {
@@ -150,6 +412,1136 @@ func (r *PostgresDocumentRepositoryImpl) GetUserDocuments(ctx context.Context, u
 	return documents, nil
 }
 
+// GetUserDocumentsPage implements DocumentRepository
+func (r *PostgresDocumentRepositoryImpl) GetUserDocumentsPage(ctx context.Context, userID, afterID string, limit int) ([]Document, error) {
+	query := gorm.G[Document](r.db).Where("id IN (SELECT document_id FROM (?) AS access)", r.documentAccess(userID))
+	if afterID != "" {
+		query = query.Where("id > ?", afterID)
+	}
+	documents, err := query.Order("id ASC").Limit(limit).Find(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to find documents: %w", err)
+	}
+	return documents, nil
+}
+
+// GetUserRoles implements DocumentRepository. Documents the user cannot
+// access are left out of the map.
+func (r *PostgresDocumentRepositoryImpl) GetUserRoles(ctx context.Context, userID string, documentIDs []string) (map[string]Role, error) {
+	roles := make(map[string]Role, len(documentIDs))
+	if len(documentIDs) == 0 {
+		return roles, nil
+	}
+	var permissions []DocumentPermission
+	err := r.db.WithContext(ctx).
+		Table("(?) AS access", r.documentAccess(userID)).
+		Select("document_id, role").
+		Where("document_id IN ?", documentIDs).
+		Scan(&permissions).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to find document permissions: %w", err)
+	}
+	for _, permission := range permissions {
+		roles[permission.DocumentID] = permission.Role
+	}
+	return roles, nil
+}
+
+// likeEscaper escapes the wildcards of LIKE patterns
+var likeEscaper = strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`)
+
+// ListUserDocuments implements DocumentRepository. Documents are filtered
+// like in GetUserDocuments and paged with a keyset on the sort column and the
+// ID, which breaks ties.
+func (r *PostgresDocumentRepositoryImpl) ListUserDocuments(ctx context.Context, filter DocumentListFilter) ([]DocumentListItem, error) {
+	column, ok := listSortColumns[filter.Sort]
+	if !ok {
+		return nil, fmt.Errorf("failed to list documents: unknown sort %q", filter.Sort)
+	}
+	direction, compare := "ASC", ">"
+	if filter.Descending {
+		direction, compare = "DESC", "<"
+	}
+
+	query := r.db.WithContext(ctx).
+		Table("documents").
+		Select(`documents.id, documents.owner_id, documents.title, documents.content_mode, documents.version,
+			documents.folder_id, documents.template, documents.source_document_id, documents.workspace_id,
+			documents.created_at, documents.updated_at, access.role`).
+		Joins("JOIN (?) AS access ON access.document_id = documents.id", r.documentAccess(filter.UserID))
+
+	switch filter.Role {
+	case "owned":
+		query = query.Where("documents.owner_id = ?", filter.UserID)
+	case "shared":
+		query = query.Where("documents.owner_id <> ?", filter.UserID)
+	case "editor", "viewer":
+		query = query.Where("documents.owner_id <> ? AND access.role = ?", filter.UserID, filter.Role)
+	}
+	if filter.FolderID != "" {
+		query = query.Where("documents.folder_id = ?", filter.FolderID)
+	}
+	if filter.WorkspaceID != "" {
+		query = query.Where("documents.workspace_id = ?", filter.WorkspaceID)
+	}
+	if filter.Templates {
+		query = query.Where("(documents.template = ? OR (documents.template = ? AND documents.owner_id = ?))",
+			TemplateShared, TemplatePersonal, filter.UserID)
+	}
+	if len(filter.Tags) > 0 {
+		tagged := r.db.Table("document_tags").
+			Select("document_tags.document_id").
+			Joins("JOIN tags ON tags.id = document_tags.tag_id").
+			Where("tags.name IN ?", filter.Tags)
+		if filter.MatchAllTags {
+			tagged = tagged.Group("document_tags.document_id").Having("count(*) = ?", len(filter.Tags))
+		}
+		query = query.Where("documents.id IN (?)", tagged)
+	}
+	if filter.TitlePrefix != "" {
+		query = query.Where("documents.title ILIKE ?", likeEscaper.Replace(filter.TitlePrefix)+"%")
+	}
+	if !filter.UpdatedAfter.IsZero() {
+		query = query.Where("documents.updated_at > ?", filter.UpdatedAfter)
+	}
+	if !filter.UpdatedBefore.IsZero() {
+		query = query.Where("documents.updated_at < ?", filter.UpdatedBefore)
+	}
+	if filter.AfterID != "" {
+		query = query.Where(fmt.Sprintf("(%s, documents.id) %s (?, ?)", column, compare), filter.AfterValue, filter.AfterID)
+	}
+
+	var items []DocumentListItem
+	err := query.
+		Order(fmt.Sprintf("%s %s, documents.id %s", column, direction, direction)).
+		Limit(filter.Limit).
+		Scan(&items).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to list documents: %w", err)
+	}
+	return items, nil
+}
+
+// SearchUserDocuments implements DocumentRepository. Documents are filtered
+// like in GetUserDocuments and ranked with ts_rank, the snippets are only
+// computed for the page returned.
+func (r *PostgresDocumentRepositoryImpl) SearchUserDocuments(ctx context.Context, userID, query string, limit, offset int) ([]DocumentSearchResult, error) {
+	var results []DocumentSearchResult
+	err := r.db.WithContext(ctx).Raw(`SELECT matches.id, matches.owner_id, matches.title, matches.role, matches.content_mode,
+			matches.version, matches.created_at, matches.updated_at, matches.rank,
+			ts_headline(@config, matches.title, matches.query, @title_options) AS title_highlight,
+			ts_headline(@config, matches.search_text, matches.query, @snippet_options) AS snippet
+		FROM (
+			SELECT documents.id, documents.owner_id, documents.title, documents.content_mode, documents.version,
+				documents.created_at, documents.updated_at, coalesce(documents.search_text, '') AS search_text, query,
+				access.role, ts_rank(documents.search_vector, query) AS rank
+			FROM documents
+			CROSS JOIN websearch_to_tsquery(@config, @query) AS query
+			JOIN (`+documentAccessSQL+`) AS access ON access.document_id = documents.id
+			WHERE documents.search_vector @@ query
+			ORDER BY rank DESC, documents.updated_at DESC, documents.id
+			LIMIT @limit OFFSET @offset
+		) AS matches
+		ORDER BY matches.rank DESC, matches.updated_at DESC, matches.id`,
+		map[string]interface{}{
+			"config":          gorm.Expr("'" + searchConfig + "'::regconfig"),
+			"user":            userID,
+			"query":           query,
+			"limit":           limit,
+			"offset":          offset,
+			"title_options":   "HighlightAll=true, StartSel=" + highlightStart + ", StopSel=" + highlightStop,
+			"snippet_options": "MaxFragments=2, MaxWords=30, MinWords=10, FragmentDelimiter=\" … \", StartSel=" + highlightStart + ", StopSel=" + highlightStop,
+		},
+	).Scan(&results).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to search documents: %w", err)
+	}
+	return results, nil
+}
+
+// SuggestUserDocuments implements DocumentRepository. Titles are matched
+// with the pg_trgm word similarity operator, which tolerates typos and
+// matches the query against any part of the title, or by prefix for queries
+// too short to have trigrams. Both use the trigram index on the title.
+func (r *PostgresDocumentRepositoryImpl) SuggestUserDocuments(ctx context.Context, userID, query string, limit int) ([]DocumentSuggestion, error) {
+	var suggestions []DocumentSuggestion
+	err := r.db.WithContext(ctx).
+		Table("documents").
+		Select("documents.id, documents.title, access.role, word_similarity(?, documents.title) AS score", query).
+		Joins("JOIN (?) AS access ON access.document_id = documents.id", r.documentAccess(userID)).
+		Where("(? <% documents.title OR documents.title ILIKE ?)", query, likeEscaper.Replace(query)+"%").
+		Order("score DESC, documents.updated_at DESC, documents.id").
+		Limit(limit).
+		Scan(&suggestions).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to suggest documents: %w", err)
+	}
+	return suggestions, nil
+}
+
+// RebuildSearchIndex indexes the documents written before the search index
+// existed, batchSize at a time, and returns how many were indexed
+func (r *PostgresDocumentRepositoryImpl) RebuildSearchIndex(ctx context.Context, batchSize int) (int, error) {
+	indexed := 0
+	for {
+		documents, err := gorm.G[Document](r.db).
+			Select("id", "content").
+			Where("search_vector IS NULL").
+			Limit(batchSize).
+			Find(ctx)
+		if err != nil {
+			return indexed, fmt.Errorf("failed to find documents to index: %w", err)
+		}
+		if len(documents) == 0 {
+			return indexed, nil
+		}
+		for _, document := range documents {
+			err := r.db.WithContext(ctx).Model(&Document{}).
+				Where("id = ?", document.ID).
+				UpdateColumns(indexUpdates(map[string]interface{}{}, "", document.Content)).Error
+			if err != nil {
+				return indexed, fmt.Errorf("failed to index document %s: %w", document.ID, err)
+			}
+			indexed++
+		}
+	}
+}
+
+// GetDocumentRevisions implements DocumentRepository. Content is not loaded.
+func (r *PostgresDocumentRepositoryImpl) GetDocumentRevisions(ctx context.Context, documentID string) ([]DocumentRevision, error) {
+	revisions, err := gorm.G[DocumentRevision](r.db).
+		Omit("content").
+		Where("document_id = ?", documentID).
+		Order("version DESC").
+		Find(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to find document revisions: %w", err)
+	}
+	return revisions, nil
+}
+
+// FindDocumentRevision implements DocumentRepository
+func (r *PostgresDocumentRepositoryImpl) FindDocumentRevision(ctx context.Context, documentID string, version int64) *DocumentRevision {
+	revision, err := gorm.G[DocumentRevision](r.db).
+		Where("document_id = ? AND version = ?", documentID, version).
+		First(ctx)
+	if err != nil {
+		return nil
+	}
+	return &revision
+}
+
+// PruneDocumentRevisions implements DocumentRepository. A revision is kept if
+// it is one of the keepLast most recent ones or was created after keepSince;
+// the most recent revision is always kept.
+func (r *PostgresDocumentRepositoryImpl) PruneDocumentRevisions(ctx context.Context, documentID string, keepLast int, keepSince time.Time) error {
+	if keepLast < 1 {
+		keepLast = 1
+	}
+	err := r.db.WithContext(ctx).Exec(
+		`DELETE FROM document_revisions
+		WHERE document_id = ? AND created_at < ?
+		AND version NOT IN (
+			SELECT version FROM document_revisions WHERE document_id = ? ORDER BY version DESC LIMIT ?
+		)`,
+		documentID, keepSince, documentID, keepLast,
+	).Error
+	if err != nil {
+		return fmt.Errorf("failed to prune document revisions: %w", err)
+	}
+	return nil
+}
+
+// AppendDocumentSteps implements DocumentRepository. The content, the steps
+// and the resulting revision are written in one transaction, and only if the
+// document is still at version; the version then moves forward by one per
+// step.
+func (r *PostgresDocumentRepositoryImpl) AppendDocumentSteps(ctx context.Context, documentID string, version int64, content *pgtype.JSONB, steps []DocumentStep, authorID string) error {
+	return r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		result := tx.Model(&Document{}).
+			Where("id = ? AND version = ?", documentID, version).
+			Updates(indexUpdates(map[string]interface{}{
+				"content": content,
+				"version": version + int64(len(steps)),
+			}, "", content))
+		if result.Error != nil {
+			return fmt.Errorf("failed to apply document steps: %w", result.Error)
+		}
+		if result.RowsAffected < 1 {
+			if r.documentExists(ctx, documentID) {
+				return fmt.Errorf("failed to apply document steps: %w", ErrVersionConflict)
+			}
+			return fmt.Errorf("failed to apply document steps: no document matched")
+		}
+
+		if len(steps) > 0 {
+			if err := gorm.G[DocumentStep](tx).CreateInBatches(ctx, &steps, 100); err != nil {
+				return fmt.Errorf("failed to store document steps: %w", err)
+			}
+		}
+		return snapshotRevision(tx, documentID, authorID)
+	})
+}
+
+// GetDocumentSteps implements DocumentRepository. It returns the steps that
+// produced the versions after since, oldest first.
+func (r *PostgresDocumentRepositoryImpl) GetDocumentSteps(ctx context.Context, documentID string, since int64) ([]DocumentStep, error) {
+	steps, err := gorm.G[DocumentStep](r.db).
+		Where("document_id = ? AND version > ?", documentID, since).
+		Order("version ASC").
+		Find(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to find document steps: %w", err)
+	}
+	return steps, nil
+}
+
+// PruneDocumentSteps implements DocumentRepository. Only the keepLast most
+// recent steps are kept.
+func (r *PostgresDocumentRepositoryImpl) PruneDocumentSteps(ctx context.Context, documentID string, keepLast int) error {
+	err := r.db.WithContext(ctx).Exec(
+		`DELETE FROM document_steps
+		WHERE document_id = ?
+		AND version <= (SELECT version FROM documents WHERE id = ? AND deleted_at IS NULL) - ?`,
+		documentID, documentID, keepLast,
+	).Error
+	if err != nil {
+		return fmt.Errorf("failed to prune document steps: %w", err)
+	}
+	return nil
+}
+
+// AppendDocumentUpdate implements DocumentRepository
+func (r *PostgresDocumentRepositoryImpl) AppendDocumentUpdate(ctx context.Context, update DocumentUpdate) error {
+	if err := gorm.G[DocumentUpdate](r.db).Create(ctx, &update); err != nil {
+		return fmt.Errorf("failed to store document update: %w", err)
+	}
+	return nil
+}
+
+// GetDocumentUpdates implements DocumentRepository
+func (r *PostgresDocumentRepositoryImpl) GetDocumentUpdates(ctx context.Context, documentID string) ([]DocumentUpdate, error) {
+	updates, err := gorm.G[DocumentUpdate](r.db).
+		Where("document_id = ?", documentID).
+		Order("created_at ASC").
+		Find(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to find document updates: %w", err)
+	}
+	return updates, nil
+}
+
+// CountDocumentUpdates implements DocumentRepository
+func (r *PostgresDocumentRepositoryImpl) CountDocumentUpdates(ctx context.Context, documentID string) (int64, error) {
+	count, err := gorm.G[DocumentUpdate](r.db).Where("document_id = ?", documentID).Count(ctx, "id")
+	if err != nil {
+		return 0, fmt.Errorf("failed to count document updates: %w", err)
+	}
+	return count, nil
+}
+
+// CompactDocumentUpdates implements DocumentRepository. It stores the merged
+// snapshot, records it as a revision and drops the updates it contains in one
+// transaction, provided the document is still at version.
+func (r *PostgresDocumentRepositoryImpl) CompactDocumentUpdates(ctx context.Context, documentID string, version int64, content *pgtype.JSONB, updateIDs []string, authorID string) error {
+	return r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		result := tx.Model(&Document{}).
+			Where("id = ? AND version = ?", documentID, version).
+			Updates(indexUpdates(map[string]interface{}{
+				"content": content,
+				"version": gorm.Expr("version + 1"),
+			}, "", content))
+		if result.Error != nil {
+			return fmt.Errorf("failed to compact document updates: %w", result.Error)
+		}
+		if result.RowsAffected < 1 {
+			return fmt.Errorf("failed to compact document updates: %w", ErrVersionConflict)
+		}
+		if err := snapshotRevision(tx, documentID, authorID); err != nil {
+			return err
+		}
+
+		if len(updateIDs) == 0 {
+			return nil
+		}
+		if _, err := gorm.G[DocumentUpdate](tx).Where("id IN ?", updateIDs).Delete(ctx); err != nil {
+			return fmt.Errorf("failed to delete compacted updates: %w", err)
+		}
+		return nil
+	})
+}
+
+// AcquireDocumentLock implements DocumentRepository. The document row is
+// locked while checking for conflicts so that two users cannot acquire
+// overlapping locks at the same time.
+func (r *PostgresDocumentRepositoryImpl) AcquireDocumentLock(ctx context.Context, lock DocumentLock) (*DocumentLock, error) {
+	err := r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var document Document
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Select("id").
+			Where("id = ?", lock.DocumentID).
+			First(&document).Error; err != nil {
+			return fmt.Errorf("failed to lock document: %w", err)
+		}
+
+		if _, err := gorm.G[DocumentLock](tx).
+			Where("document_id = ? AND expires_at <= ?", lock.DocumentID, time.Now()).
+			Delete(ctx); err != nil {
+			return fmt.Errorf("failed to delete expired locks: %w", err)
+		}
+
+		active, err := gorm.G[DocumentLock](tx).Where("document_id = ?", lock.DocumentID).Find(ctx)
+		if err != nil {
+			return fmt.Errorf("failed to find document locks: %w", err)
+		}
+		for _, other := range active {
+			if lock.ConflictsWith(&other) {
+				return &LockedError{Lock: other}
+			}
+		}
+
+		if err := gorm.G[DocumentLock](tx).Create(ctx, &lock); err != nil {
+			return fmt.Errorf("failed to create document lock: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &lock, nil
+}
+
+// RenewDocumentLock implements DocumentRepository
+func (r *PostgresDocumentRepositoryImpl) RenewDocumentLock(ctx context.Context, documentID, lockID, holderID string, expiresAt time.Time) (*DocumentLock, error) {
+	var lock DocumentLock
+	result := r.db.WithContext(ctx).
+		Model(&lock).
+		Clauses(clause.Returning{}).
+		Where("id = ? AND document_id = ? AND holder_id = ? AND expires_at > ?", lockID, documentID, holderID, time.Now()).
+		Update("expires_at", expiresAt)
+	if result.Error != nil {
+		return nil, fmt.Errorf("failed to renew document lock: %w", result.Error)
+	}
+	if result.RowsAffected < 1 {
+		return nil, ErrLockNotFound
+	}
+	return &lock, nil
+}
+
+// ReleaseDocumentLocks implements DocumentRepository. Empty lockID or
+// holderID match any lock.
+func (r *PostgresDocumentRepositoryImpl) ReleaseDocumentLocks(ctx context.Context, documentID, lockID, holderID string) (int64, error) {
+	query := gorm.G[DocumentLock](r.db).Where("document_id = ? AND expires_at > ?", documentID, time.Now())
+	if lockID != "" {
+		query = query.Where("id = ?", lockID)
+	}
+	if holderID != "" {
+		query = query.Where("holder_id = ?", holderID)
+	}
+	rows, err := query.Delete(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("failed to release document locks: %w", err)
+	}
+	return int64(rows), nil
+}
+
+// GetDocumentLocks implements DocumentRepository, expired locks are left out
+func (r *PostgresDocumentRepositoryImpl) GetDocumentLocks(ctx context.Context, documentID string) ([]DocumentLock, error) {
+	locks, err := gorm.G[DocumentLock](r.db).
+		Where("document_id = ? AND expires_at > ?", documentID, time.Now()).
+		Order("created_at ASC").
+		Find(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to find document locks: %w", err)
+	}
+	return locks, nil
+}
+
+// UpdateDocumentLockRanges implements DocumentRepository
+func (r *PostgresDocumentRepositoryImpl) UpdateDocumentLockRanges(ctx context.Context, locks []DocumentLock) error {
+	return r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		for _, lock := range locks {
+			if err := tx.Model(&DocumentLock{}).
+				Where("id = ?", lock.ID).
+				Updates(map[string]interface{}{
+					"range_from": lock.From,
+					"range_to":   lock.To,
+				}).Error; err != nil {
+				return fmt.Errorf("failed to update lock range: %w", err)
+			}
+		}
+		return nil
+	})
+}
+
+// DeleteExpiredLocks implements DocumentRepository
+func (r *PostgresDocumentRepositoryImpl) DeleteExpiredLocks(ctx context.Context, before time.Time) (int64, error) {
+	rows, err := gorm.G[DocumentLock](r.db).Where("expires_at <= ?", before).Delete(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("failed to delete expired locks: %w", err)
+	}
+	return int64(rows), nil
+}
+
+// folderTreeLock serializes the changes to the folder tree, so that two
+// concurrent moves cannot form a loop
+const folderTreeLock = 0x666f6c64
+
+// CreateFolder implements DocumentRepository
+func (r *PostgresDocumentRepositoryImpl) CreateFolder(ctx context.Context, folder Folder) (*Folder, error) {
+	if err := gorm.G[Folder](r.db).Create(ctx, &folder); err != nil {
+		return nil, fmt.Errorf("failed to create folder: %w", err)
+	}
+	return &folder, nil
+}
+
+// GetFolderWithPermission implements DocumentRepository
+func (r *PostgresDocumentRepositoryImpl) GetFolderWithPermission(ctx context.Context, userID, folderID string) (*Folder, string) {
+	folder, err := gorm.G[Folder](r.db).Where("id = ?", folderID).First(ctx)
+	if err != nil {
+		return nil, ""
+	}
+	role, err := r.folderRole(ctx, userID, folderID)
+	if err != nil || role == "" {
+		return nil, ""
+	}
+	return &folder, string(role)
+}
+
+// GetChildFolders implements DocumentRepository
+func (r *PostgresDocumentRepositoryImpl) GetChildFolders(ctx context.Context, folderID string) ([]Folder, error) {
+	folders, err := gorm.G[Folder](r.db).Where("parent_id = ?", folderID).Order("name, id").Find(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to find folders: %w", err)
+	}
+	return folders, nil
+}
+
+// GetUserRootFolders implements DocumentRepository. These are the folders the
+// user can access whose parent they cannot, top-level folders included.
+func (r *PostgresDocumentRepositoryImpl) GetUserRootFolders(ctx context.Context, userID string) ([]FolderListItem, error) {
+	var folders []FolderListItem
+	err := r.db.WithContext(ctx).
+		Table("folders").
+		Select("folders.id, folders.parent_id, folders.owner_id, folders.name, folders.created_at, folders.updated_at, access.role").
+		Joins("JOIN (?) AS access ON access.folder_id = folders.id", r.folderAccess(userID)).
+		Joins("LEFT JOIN (?) AS parent_access ON parent_access.folder_id = folders.parent_id", r.folderAccess(userID)).
+		Where("parent_access.folder_id IS NULL").
+		Order("folders.name, folders.id").
+		Scan(&folders).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to find folders: %w", err)
+	}
+	return folders, nil
+}
+
+// RenameFolder implements DocumentRepository
+func (r *PostgresDocumentRepositoryImpl) RenameFolder(ctx context.Context, folderID, name string) error {
+	rows, err := gorm.G[Folder](r.db).Where("id = ?", folderID).Update(ctx, "name", name)
+	if err != nil {
+		return fmt.Errorf("failed to rename folder: %w", err)
+	}
+	if rows < 1 {
+		return fmt.Errorf("failed to rename folder: %w", ErrFolderNotFound)
+	}
+	return nil
+}
+
+// MoveFolder implements DocumentRepository. Moving a folder inside itself or
+// one of its subfolders fails with ErrInvalidFolder.
+func (r *PostgresDocumentRepositoryImpl) MoveFolder(ctx context.Context, folderID string, parentID *string) error {
+	err := r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Exec("SELECT pg_advisory_xact_lock(?)", folderTreeLock).Error; err != nil {
+			return err
+		}
+		if parentID != nil {
+			var loops bool
+			err := tx.Raw(`WITH RECURSIVE `+folderAncestorsSQL+` SELECT EXISTS (SELECT 1 FROM ancestors WHERE id = @moved)`,
+				map[string]interface{}{"folder": *parentID, "moved": folderID},
+			).Scan(&loops).Error
+			if err != nil {
+				return err
+			}
+			if loops {
+				return fmt.Errorf("%w: a folder cannot be moved inside itself", ErrInvalidFolder)
+			}
+		}
+		result := tx.Model(&Folder{}).Where("id = ?", folderID).Update("parent_id", parentID)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected < 1 {
+			return ErrFolderNotFound
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to move folder: %w", err)
+	}
+	return nil
+}
+
+// DeleteFolder implements DocumentRepository. The documents and subfolders of
+// the folder are moved to its parent, or to the top level.
+func (r *PostgresDocumentRepositoryImpl) DeleteFolder(ctx context.Context, folderID string) error {
+	err := r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Exec("SELECT pg_advisory_xact_lock(?)", folderTreeLock).Error; err != nil {
+			return err
+		}
+		folder, err := gorm.G[Folder](tx).Where("id = ?", folderID).First(ctx)
+		if err != nil {
+			return err
+		}
+		if err := tx.Model(&Document{}).
+			Where("folder_id = ?", folderID).
+			UpdateColumn("folder_id", folder.ParentID).Error; err != nil {
+			return err
+		}
+		if err := tx.Model(&Folder{}).
+			Where("parent_id = ?", folderID).
+			UpdateColumn("parent_id", folder.ParentID).Error; err != nil {
+			return err
+		}
+		_, err = gorm.G[Folder](tx).Where("id = ?", folderID).Delete(ctx)
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("failed to delete folder: %w", err)
+	}
+	return nil
+}
+
+// MoveDocument implements DocumentRepository. A nil folderID moves the
+// document out of any folder.
+func (r *PostgresDocumentRepositoryImpl) MoveDocument(ctx context.Context, documentID string, folderID *string) error {
+	result := r.db.WithContext(ctx).
+		Model(&Document{}).
+		Where("id = ?", documentID).
+		UpdateColumn("folder_id", folderID)
+	if result.Error != nil {
+		return fmt.Errorf("failed to move document: %w", result.Error)
+	}
+	if result.RowsAffected < 1 {
+		return fmt.Errorf("failed to move document: no document matched")
+	}
+	return nil
+}
+
+// SetDocumentTemplate implements DocumentRepository
+func (r *PostgresDocumentRepositoryImpl) SetDocumentTemplate(ctx context.Context, documentID string, visibility TemplateVisibility) error {
+	result := r.db.WithContext(ctx).
+		Model(&Document{}).
+		Where("id = ?", documentID).
+		UpdateColumn("template", visibility)
+	if result.Error != nil {
+		return fmt.Errorf("failed to update template: %w", result.Error)
+	}
+	if result.RowsAffected < 1 {
+		return fmt.Errorf("failed to update template: no document matched")
+	}
+	return nil
+}
+
+// GetFolderPermissions implements DocumentRepository
+func (r *PostgresDocumentRepositoryImpl) GetFolderPermissions(ctx context.Context, folderID string) ([]FolderPermission, error) {
+	permissions, err := gorm.G[FolderPermission](r.db).Where("folder_id = ?", folderID).Find(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to find folder permissions: %w", err)
+	}
+	return permissions, nil
+}
+
+// SetFolderPermission implements DocumentRepository. The role of users the
+// folder is already shared with is replaced.
+func (r *PostgresDocumentRepositoryImpl) SetFolderPermission(ctx context.Context, permission FolderPermission) error {
+	err := r.db.WithContext(ctx).
+		Clauses(clause.OnConflict{
+			Columns:   []clause.Column{{Name: "folder_id"}, {Name: "user_id"}},
+			DoUpdates: clause.AssignmentColumns([]string{"role"}),
+		}).
+		Create(&permission).Error
+	if err != nil {
+		return fmt.Errorf("failed to set folder permission: %w", err)
+	}
+	return nil
+}
+
+// RemoveFolderPermission implements DocumentRepository
+func (r *PostgresDocumentRepositoryImpl) RemoveFolderPermission(ctx context.Context, userID, folderID string) error {
+	if _, err := gorm.G[FolderPermission](r.db).Where("folder_id = ? AND user_id = ?", folderID, userID).Delete(ctx); err != nil {
+		return fmt.Errorf("failed deleting folder permission record: %w", err)
+	}
+	return nil
+}
+
+// GetFolderTreeDocumentIDs implements DocumentRepository
+func (r *PostgresDocumentRepositoryImpl) GetFolderTreeDocumentIDs(ctx context.Context, folderID string) ([]string, error) {
+	var ids []string
+	err := r.db.WithContext(ctx).Raw(`WITH RECURSIVE `+folderTreeSQL+`
+		SELECT documents.id FROM documents JOIN tree ON documents.folder_id = tree.id
+		WHERE documents.deleted_at IS NULL`,
+		map[string]interface{}{"folder": folderID},
+	).Scan(&ids).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to find folder documents: %w", err)
+	}
+	return ids, nil
+}
+
+// GetFolderGrantees implements DocumentRepository
+func (r *PostgresDocumentRepositoryImpl) GetFolderGrantees(ctx context.Context, folderID string) ([]string, error) {
+	var ids []string
+	err := r.db.WithContext(ctx).Raw(`WITH RECURSIVE `+folderAncestorsSQL+`
+		SELECT ancestors.owner_id FROM ancestors
+		UNION
+		SELECT folder_permissions.user_id FROM folder_permissions JOIN ancestors ON folder_permissions.folder_id = ancestors.id`,
+		map[string]interface{}{"folder": folderID},
+	).Scan(&ids).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to find folder grantees: %w", err)
+	}
+	return ids, nil
+}
+
+// AddDocumentTags implements DocumentRepository. Tags are created the first
+// time they are used, tags the document already has are left as they are.
+func (r *PostgresDocumentRepositoryImpl) AddDocumentTags(ctx context.Context, documentID string, names []string) error {
+	err := r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		tags := make([]Tag, len(names))
+		for i, name := range names {
+			tags[i] = Tag{Name: name}
+		}
+		if err := tx.Clauses(clause.OnConflict{
+			Columns:   []clause.Column{{Name: "name"}},
+			DoNothing: true,
+		}).Create(&tags).Error; err != nil {
+			return err
+		}
+		return tx.Exec(`INSERT INTO document_tags (document_id, tag_id)
+			SELECT ?, tags.id FROM tags WHERE tags.name IN ?
+			ON CONFLICT DO NOTHING`, documentID, names).Error
+	})
+	if err != nil {
+		return fmt.Errorf("failed to tag document: %w", err)
+	}
+	return nil
+}
+
+// RemoveDocumentTag implements DocumentRepository
+func (r *PostgresDocumentRepositoryImpl) RemoveDocumentTag(ctx context.Context, documentID, name string) error {
+	err := r.db.WithContext(ctx).Exec(`DELETE FROM document_tags
+		WHERE document_id = ? AND tag_id IN (SELECT tags.id FROM tags WHERE tags.name = ?)`, documentID, name).Error
+	if err != nil {
+		return fmt.Errorf("failed to untag document: %w", err)
+	}
+	return nil
+}
+
+// GetDocumentTags implements DocumentRepository. Documents without tags are
+// left out of the map.
+func (r *PostgresDocumentRepositoryImpl) GetDocumentTags(ctx context.Context, documentIDs []string) (map[string][]Tag, error) {
+	tags := make(map[string][]Tag, len(documentIDs))
+	if len(documentIDs) == 0 {
+		return tags, nil
+	}
+	var rows []struct {
+		DocumentID string
+		Tag
+	}
+	err := r.db.WithContext(ctx).
+		Table("document_tags").
+		Select("document_tags.document_id, tags.*").
+		Joins("JOIN tags ON tags.id = document_tags.tag_id").
+		Where("document_tags.document_id IN ?", documentIDs).
+		Order("tags.name").
+		Scan(&rows).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to find document tags: %w", err)
+	}
+	for _, row := range rows {
+		tags[row.DocumentID] = append(tags[row.DocumentID], row.Tag)
+	}
+	return tags, nil
+}
+
+// GetUserTags implements DocumentRepository. Only the documents the user can
+// access are counted.
+func (r *PostgresDocumentRepositoryImpl) GetUserTags(ctx context.Context, userID string) ([]TagCount, error) {
+	var counts []TagCount
+	err := r.db.WithContext(ctx).
+		Table("document_tags").
+		Select("tags.name, count(*) AS count").
+		Joins("JOIN tags ON tags.id = document_tags.tag_id").
+		Joins("JOIN (?) AS access ON access.document_id = document_tags.document_id", r.documentAccess(userID)).
+		Group("tags.name").
+		Order("count DESC, tags.name").
+		Scan(&counts).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to count tags: %w", err)
+	}
+	return counts, nil
+}
+
+// GetTrashedDocuments implements DocumentRepository. The most recently
+// deleted documents come first.
+func (r *PostgresDocumentRepositoryImpl) GetTrashedDocuments(ctx context.Context, userID string) ([]Document, error) {
+	var documents []Document
+	err := r.db.WithContext(ctx).
+		Unscoped().
+		Where("deleted_at IS NOT NULL AND id IN (?)", r.ownedTrash(userID)).
+		Order("deleted_at DESC, id").
+		Find(&documents).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to find trashed documents: %w", err)
+	}
+	return documents, nil
+}
+
+// RestoreDocument implements DocumentRepository
+func (r *PostgresDocumentRepositoryImpl) RestoreDocument(ctx context.Context, userID, documentID string) error {
+	result := r.db.WithContext(ctx).
+		Unscoped().
+		Model(&Document{}).
+		Where("id = ? AND deleted_at IS NOT NULL AND id IN (?)", documentID, r.ownedTrash(userID)).
+		UpdateColumn("deleted_at", nil)
+	if result.Error != nil {
+		return fmt.Errorf("failed to restore document: %w", result.Error)
+	}
+	if result.RowsAffected < 1 {
+		return fmt.Errorf("failed to restore document: %w", ErrNotInTrash)
+	}
+	return nil
+}
+
+// PurgeDocument implements DocumentRepository. Only trashed documents can be
+// purged, their permissions, history and tags go with them.
+func (r *PostgresDocumentRepositoryImpl) PurgeDocument(ctx context.Context, userID, documentID string) error {
+	result := r.db.WithContext(ctx).
+		Unscoped().
+		Where("id = ? AND deleted_at IS NOT NULL AND id IN (?)", documentID, r.ownedTrash(userID)).
+		Delete(&Document{})
+	if result.Error != nil {
+		return fmt.Errorf("failed to purge document: %w", result.Error)
+	}
+	if result.RowsAffected < 1 {
+		return fmt.Errorf("failed to purge document: %w", ErrNotInTrash)
+	}
+	return nil
+}
+
+// PurgeTrash implements DocumentRepository. Documents are deleted batchSize
+// at a time to keep transactions short.
+func (r *PostgresDocumentRepositoryImpl) PurgeTrash(ctx context.Context, deletedBefore time.Time, batchSize int) (int64, error) {
+	var purged int64
+	for {
+		result := r.db.WithContext(ctx).Exec(
+			`DELETE FROM documents WHERE id IN (
+				SELECT id FROM documents WHERE deleted_at < ? ORDER BY deleted_at LIMIT ?
+			)`,
+			deletedBefore, batchSize,
+		)
+		if result.Error != nil {
+			return purged, fmt.Errorf("failed to purge trash: %w", result.Error)
+		}
+		purged += result.RowsAffected
+		if result.RowsAffected < int64(batchSize) {
+			return purged, nil
+		}
+	}
+}
+
+// CreateOwnershipTransfer implements DocumentRepository
+func (r *PostgresDocumentRepositoryImpl) CreateOwnershipTransfer(ctx context.Context, transfer OwnershipTransfer) (*OwnershipTransfer, error) {
+	transfer.CreatedAt = time.Now()
+	err := r.db.WithContext(ctx).
+		Clauses(clause.OnConflict{
+			Columns:   []clause.Column{{Name: "document_id"}},
+			DoUpdates: clause.AssignmentColumns([]string{"from_user_id", "to_user_id", "keep_as_editor", "created_at"}),
+		}).
+		Create(&transfer).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to create ownership transfer: %w", err)
+	}
+	return &transfer, nil
+}
+
+// FindOwnershipTransfer implements DocumentRepository
+func (r *PostgresDocumentRepositoryImpl) FindOwnershipTransfer(ctx context.Context, documentID string) *OwnershipTransfer {
+	transfer, err := gorm.G[OwnershipTransfer](r.db).Where("document_id = ?", documentID).First(ctx)
+	if err != nil {
+		return nil
+	}
+	return &transfer
+}
+
+// GetIncomingTransfers implements DocumentRepository. Transfers of trashed
+// documents are left out.
+func (r *PostgresDocumentRepositoryImpl) GetIncomingTransfers(ctx context.Context, userID string) ([]OwnershipTransfer, error) {
+	transfers, err := gorm.G[OwnershipTransfer](r.db).
+		Preload("Document", nil).
+		Where("to_user_id = ? AND document_id IN (SELECT id FROM documents WHERE deleted_at IS NULL)", userID).
+		Order("created_at DESC").
+		Find(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to find ownership transfers: %w", err)
+	}
+	return transfers, nil
+}
+
+// DeleteOwnershipTransfer implements DocumentRepository
+func (r *PostgresDocumentRepositoryImpl) DeleteOwnershipTransfer(ctx context.Context, documentID string) error {
+	rows, err := gorm.G[OwnershipTransfer](r.db).Where("document_id = ?", documentID).Delete(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to delete ownership transfer: %w", err)
+	}
+	if rows < 1 {
+		return fmt.Errorf("failed to delete ownership transfer: %w", ErrTransferNotFound)
+	}
+	return nil
+}
+
+// DeclineOwnershipTransfer implements DocumentRepository
+func (r *PostgresDocumentRepositoryImpl) DeclineOwnershipTransfer(ctx context.Context, userID, transferID string) error {
+	rows, err := gorm.G[OwnershipTransfer](r.db).Where("id = ? AND to_user_id = ?", transferID, userID).Delete(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to decline ownership transfer: %w", err)
+	}
+	if rows < 1 {
+		return fmt.Errorf("failed to decline ownership transfer: %w", ErrTransferNotFound)
+	}
+	return nil
+}
+
+// AcceptOwnershipTransfer implements DocumentRepository. The owner of the
+// document and its owner permission row change together, the permission
+// the recipient had as a collaborator is dropped. The document leaves the
+// folders of the previous owner, whose roles would still apply otherwise.
+func (r *PostgresDocumentRepositoryImpl) AcceptOwnershipTransfer(ctx context.Context, userID, transferID string) (*OwnershipTransfer, error) {
+	var transfer OwnershipTransfer
+	err := r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("id = ? AND to_user_id = ?", transferID, userID).
+			First(&transfer).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return ErrTransferNotFound
+			}
+			return err
+		}
+
+		var document Document
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("id = ? AND owner_id = ?", transfer.DocumentID, transfer.FromUserID).
+			First(&document).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return ErrTransferNotFound
+			}
+			return err
+		}
+		transfer.Document = &document
+
+		if err := tx.Model(&Document{}).
+			Where("id = ?", document.ID).
+			UpdateColumns(map[string]interface{}{
+				"owner_id":  transfer.ToUserID,
+				"folder_id": nil,
+			}).Error; err != nil {
+			return err
+		}
+
+		if _, err := gorm.G[DocumentPermission](tx).
+			Where("document_id = ? AND user_id = ?", document.ID, transfer.ToUserID).
+			Delete(ctx); err != nil {
+			return err
+		}
+		swapped := tx.Model(&DocumentPermission{}).
+			Where("document_id = ? AND user_id = ? AND role = ?", document.ID, transfer.FromUserID, RoleOwner).
+			UpdateColumn("user_id", transfer.ToUserID)
+		if swapped.Error != nil {
+			return swapped.Error
+		}
+		if swapped.RowsAffected < 1 {
+			if err := gorm.G[DocumentPermission](tx).Create(ctx, &DocumentPermission{
+				DocumentID: document.ID,
+				UserID:     transfer.ToUserID,
+				Role:       RoleOwner,
+			}); err != nil {
+				return err
+			}
+		}
+		if transfer.KeepAsEditor {
+			if err := gorm.G[DocumentPermission](tx).Create(ctx, &DocumentPermission{
+				DocumentID: document.ID,
+				UserID:     transfer.FromUserID,
+				Role:       RoleEditor,
+			}); err != nil {
+				return err
+			}
+		}
+
+		_, err := gorm.G[OwnershipTransfer](tx).Where("id = ?", transfer.ID).Delete(ctx)
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to accept ownership transfer: %w", err)
+	}
+	return &transfer, nil
+}
+
+// CreateWorkspace implements DocumentRepository. The members of workspace
+// are created along with it.
+func (r *PostgresDocumentRepositoryImpl) CreateWorkspace(ctx context.Context, workspace Workspace) (*Workspace, error) {
+	if err := gorm.G[Workspace](r.db).Create(ctx, &workspace); err != nil {
+		return nil, fmt.Errorf("failed to create workspace: %w", err)
+	}
+	return &workspace, nil
+}
+
+// GetWorkspaceWithRole implements DocumentRepository
+func (r *PostgresDocumentRepositoryImpl) GetWorkspaceWithRole(ctx context.Context, userID, workspaceID string) (*Workspace, WorkspaceRole) {
+	workspace, err := gorm.G[Workspace](r.db).Where("id = ?", workspaceID).First(ctx)
+	if err != nil {
+		return nil, ""
+	}
+	role, err := r.workspaceRole(ctx, userID, workspaceID)
+	if err != nil || role == "" {
+		return nil, ""
+	}
+	return &workspace, role
+}
+
+// workspaceRole returns the role of the user in the workspace, empty for
+// users who are not members
+func (r *PostgresDocumentRepositoryImpl) workspaceRole(ctx context.Context, userID, workspaceID string) (WorkspaceRole, error) {
+	member, err := gorm.G[WorkspaceMember](r.db).
+		Where("workspace_id = ? AND user_id = ?", workspaceID, userID).
+		First(ctx)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return "", nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to resolve workspace role: %w", err)
+	}
+	return member.Role, nil
+}
+
+// GetUserWorkspaces implements DocumentRepository
+func (r *PostgresDocumentRepositoryImpl) GetUserWorkspaces(ctx context.Context, userID string) ([]WorkspaceListItem, error) {
+	var workspaces []WorkspaceListItem
+	err := r.db.WithContext(ctx).
+		Table("workspaces").
+		Select("workspaces.id, workspaces.name, workspaces.created_at, workspaces.updated_at, workspace_members.role").
+		Joins("JOIN workspace_members ON workspace_members.workspace_id = workspaces.id").
+		Where("workspace_members.user_id = ?", userID).
+		Order("workspaces.name, workspaces.id").
+		Scan(&workspaces).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to find workspaces: %w", err)
+	}
+	return workspaces, nil
+}
+
+// GetWorkspaceMembers implements DocumentRepository
+func (r *PostgresDocumentRepositoryImpl) GetWorkspaceMembers(ctx context.Context, workspaceID string) ([]WorkspaceMember, error) {
+	members, err := gorm.G[WorkspaceMember](r.db).
+		Where("workspace_id = ?", workspaceID).
+		Order("created_at, id").
+		Find(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to find workspace members: %w", err)
+	}
+	return members, nil
+}
+
+// SetWorkspaceMember implements DocumentRepository. The workspace row is
+// locked so that concurrent changes cannot remove its last admin.
+func (r *PostgresDocumentRepositoryImpl) SetWorkspaceMember(ctx context.Context, member WorkspaceMember) error {
+	err := r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if member.Role != WorkspaceRoleAdmin {
+			if err := r.keepWorkspaceAdmin(ctx, tx, member.WorkspaceID, member.UserID); err != nil {
+				return err
+			}
+		}
+		return tx.Clauses(clause.OnConflict{
+			Columns:   []clause.Column{{Name: "workspace_id"}, {Name: "user_id"}},
+			DoUpdates: clause.AssignmentColumns([]string{"role"}),
+		}).Create(&member).Error
+	})
+	if err != nil {
+		return fmt.Errorf("failed to set workspace member: %w", err)
+	}
+	return nil
+}
+
+// RemoveWorkspaceMember implements DocumentRepository
+func (r *PostgresDocumentRepositoryImpl) RemoveWorkspaceMember(ctx context.Context, workspaceID, userID string) error {
+	err := r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := r.keepWorkspaceAdmin(ctx, tx, workspaceID, userID); err != nil {
+			return err
+		}
+		_, err := gorm.G[WorkspaceMember](tx).
+			Where("workspace_id = ? AND user_id = ?", workspaceID, userID).
+			Delete(ctx)
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("failed to remove workspace member: %w", err)
+	}
+	return nil
+}
+
+// keepWorkspaceAdmin locks the workspace and returns ErrInvalidWorkspace
+// when it has no admin other than userID
+func (r *PostgresDocumentRepositoryImpl) keepWorkspaceAdmin(ctx context.Context, tx *gorm.DB, workspaceID, userID string) error {
+	var workspace Workspace
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+		Select("id").
+		Where("id = ?", workspaceID).
+		First(&workspace).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return ErrWorkspaceNotFound
+		}
+		return err
+	}
+	admins, err := gorm.G[WorkspaceMember](tx).
+		Where("workspace_id = ? AND user_id <> ? AND role = ?", workspaceID, userID, WorkspaceRoleAdmin).
+		Count(ctx, "id")
+	if err != nil {
+		return err
+	}
+	if admins < 1 {
+		return fmt.Errorf("%w: the workspace needs at least one admin", ErrInvalidWorkspace)
+	}
+	return nil
+}
+
+// GetWorkspaceDocumentIDs implements DocumentRepository
+func (r *PostgresDocumentRepositoryImpl) GetWorkspaceDocumentIDs(ctx context.Context, workspaceID string) ([]string, error) {
+	var ids []string
+	err := r.db.WithContext(ctx).
+		Model(&Document{}).
+		Where("workspace_id = ?", workspaceID).
+		Pluck("id", &ids).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to find workspace documents: %w", err)
+	}
+	return ids, nil
+}
+
+// SetDocumentWorkspace implements DocumentRepository
+func (r *PostgresDocumentRepositoryImpl) SetDocumentWorkspace(ctx context.Context, documentID string, workspaceID *string) error {
+	result := r.db.WithContext(ctx).
+		Model(&Document{}).
+		Where("id = ?", documentID).
+		UpdateColumn("workspace_id", workspaceID)
+	if result.Error != nil {
+		return fmt.Errorf("failed to move document to workspace: %w", result.Error)
+	}
+	if result.RowsAffected < 1 {
+		return fmt.Errorf("failed to move document to workspace: no document matched")
+	}
+	return nil
+}
+
 func (r *PostgresDocumentRepositoryImpl) GetDB() *gorm.DB {
 	return r.db
 }
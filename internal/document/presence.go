@@ -0,0 +1,355 @@
+package internal
+
+import (
+	"context"
+	"hash/fnv"
+	"sort"
+	"sync"
+	"time"
+)
+
+const presenceSubscriberBuffer = 64
+
+// presenceColors is the palette colors are picked from for users that do not
+// choose one
+var presenceColors = []string{
+	"#e6194b", "#3cb44b", "#4363d8", "#f58231", "#911eb4",
+	"#46f0f0", "#f032e6", "#9a6324", "#008080", "#800000",
+}
+
+type PresenceEventType string
+
+const (
+	PresenceJoined  PresenceEventType = "joined"
+	PresenceUpdated PresenceEventType = "updated"
+	PresenceLeft    PresenceEventType = "left"
+)
+
+// PresenceSelection is a cursor (Anchor == Head) or a selection, in document
+// positions as understood by the client editor
+type PresenceSelection struct {
+	Anchor int `json:"anchor" binding:"min=0"`
+	Head   int `json:"head" binding:"min=0"`
+}
+
+// Presence is the ephemeral state of one editor session in a document. A user
+// has one session per open editor.
+type Presence struct {
+	SessionID string             `json:"session_id"`
+	UserID    string             `json:"user_id"`
+	Color     string             `json:"color"`
+	Selection *PresenceSelection `json:"selection"`
+	LastSeen  time.Time          `json:"last_seen"`
+	ExpiresAt time.Time          `json:"expires_at"`
+}
+
+type PresenceEvent struct {
+	Type       PresenceEventType
+	DocumentID string
+	Presence   Presence
+}
+
+// PresenceTracker keeps, in memory, the sessions present in each document.
+// Sessions expire unless they send a heartbeat within the TTL.
+type PresenceTracker struct {
+	service *DocumentService
+	ttl     time.Duration
+
+	mu sync.Mutex
+	// documents maps document IDs to sessions keyed by presenceKey
+	documents   map[string]map[string]*Presence
+	subscribers map[string]map[*PresenceSubscription]struct{}
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// PresenceSubscription receives the presence events of a document until it is
+// closed, by the subscriber or by the tracker when access is revoked
+type PresenceSubscription struct {
+	Events chan PresenceEvent
+	userID string
+	once   sync.Once
+	closed chan struct{}
+}
+
+// NewPresenceTracker creates a tracker and starts expiring stale sessions
+func NewPresenceTracker(service *DocumentService, ttl time.Duration) *PresenceTracker {
+	if ttl <= 0 {
+		ttl = 30 * time.Second
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	tracker := &PresenceTracker{
+		service:     service,
+		ttl:         ttl,
+		documents:   make(map[string]map[string]*Presence),
+		subscribers: make(map[string]map[*PresenceSubscription]struct{}),
+		ctx:         ctx,
+		cancel:      cancel,
+		done:        make(chan struct{}),
+	}
+	service.Subscribe(tracker.onDocumentEvent)
+	go tracker.run()
+	return tracker
+}
+
+// TTL is how long a session stays present without a heartbeat
+func (t *PresenceTracker) TTL() time.Duration {
+	return t.ttl
+}
+
+// Heartbeat creates or refreshes a session. A nil selection keeps the last
+// known one, an empty color keeps the current color or picks one for the user.
+func (t *PresenceTracker) Heartbeat(documentID, userID, sessionID, color string, selection *PresenceSelection) Presence {
+	now := time.Now()
+
+	t.mu.Lock()
+	sessions, exists := t.documents[documentID]
+	if !exists {
+		sessions = make(map[string]*Presence)
+		t.documents[documentID] = sessions
+	}
+	key := presenceKey(userID, sessionID)
+	presence, exists := sessions[key]
+	eventType := PresenceUpdated
+	if !exists {
+		presence = &Presence{SessionID: sessionID, UserID: userID, Color: presenceColor(userID)}
+		sessions[key] = presence
+		eventType = PresenceJoined
+	}
+	changed := !exists
+	if color != "" && color != presence.Color {
+		presence.Color = color
+		changed = true
+	}
+	if selection != nil && (presence.Selection == nil || *presence.Selection != *selection) {
+		s := *selection
+		presence.Selection = &s
+		changed = true
+	}
+	presence.LastSeen = now
+	presence.ExpiresAt = now.Add(t.ttl)
+	result := *presence
+
+	// Plain heartbeats only extend the lease, they are not worth an event
+	if changed {
+		t.publish(PresenceEvent{Type: eventType, DocumentID: documentID, Presence: result})
+	}
+	t.mu.Unlock()
+	return result
+}
+
+// Leave removes a session, it reports whether the session existed
+func (t *PresenceTracker) Leave(documentID, userID, sessionID string) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	sessions := t.documents[documentID]
+	key := presenceKey(userID, sessionID)
+	presence, exists := sessions[key]
+	if exists {
+		delete(sessions, key)
+		if len(sessions) == 0 {
+			delete(t.documents, documentID)
+		}
+		t.publish(PresenceEvent{Type: PresenceLeft, DocumentID: documentID, Presence: *presence})
+	}
+	return exists
+}
+
+// List returns the live sessions of a document ordered by user and session
+func (t *PresenceTracker) List(documentID string) []Presence {
+	now := time.Now()
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	result := make([]Presence, 0, len(t.documents[documentID]))
+	for _, presence := range t.documents[documentID] {
+		if presence.ExpiresAt.After(now) {
+			result = append(result, *presence)
+		}
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].UserID != result[j].UserID {
+			return result[i].UserID < result[j].UserID
+		}
+		return result[i].SessionID < result[j].SessionID
+	})
+	return result
+}
+
+// Subscribe opens a subscription to the presence events of a document for a
+// user that already passed the document access middleware
+func (t *PresenceTracker) Subscribe(documentID, userID string) *PresenceSubscription {
+	sub := &PresenceSubscription{
+		Events: make(chan PresenceEvent, presenceSubscriberBuffer),
+		userID: userID,
+		closed: make(chan struct{}),
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.ctx.Err() != nil {
+		sub.close()
+		return sub
+	}
+	subs, exists := t.subscribers[documentID]
+	if !exists {
+		subs = make(map[*PresenceSubscription]struct{})
+		t.subscribers[documentID] = subs
+	}
+	subs[sub] = struct{}{}
+	return sub
+}
+
+// Unsubscribe closes a subscription and stops delivering events to it
+func (t *PresenceTracker) Unsubscribe(documentID string, sub *PresenceSubscription) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if subs, exists := t.subscribers[documentID]; exists {
+		delete(subs, sub)
+		if len(subs) == 0 {
+			delete(t.subscribers, documentID)
+		}
+	}
+	sub.close()
+}
+
+// Stop ends the expiry loop and closes every subscription
+func (t *PresenceTracker) Stop() {
+	t.cancel()
+	<-t.done
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for documentID, subs := range t.subscribers {
+		for sub := range subs {
+			sub.close()
+		}
+		delete(t.subscribers, documentID)
+	}
+}
+
+// Closed is closed once the subscription ends
+func (s *PresenceSubscription) Closed() <-chan struct{} {
+	return s.closed
+}
+
+func (s *PresenceSubscription) close() {
+	s.once.Do(func() {
+		close(s.closed)
+	})
+}
+
+// deliver queues an event without blocking, subscribers too slow to keep up
+// are closed
+func (s *PresenceSubscription) deliver(event PresenceEvent) {
+	select {
+	case <-s.closed:
+	case s.Events <- event:
+	default:
+		s.close()
+	}
+}
+
+// publish delivers an event to the subscribers of its document. Callers hold
+// t.mu, so that events reach subscribers in the order the changes were made.
+func (t *PresenceTracker) publish(event PresenceEvent) {
+	for sub := range t.subscribers[event.DocumentID] {
+		sub.deliver(event)
+	}
+}
+
+func (t *PresenceTracker) run() {
+	defer close(t.done)
+
+	ticker := time.NewTicker(t.ttl / 2)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			t.expire(time.Now())
+		case <-t.ctx.Done():
+			return
+		}
+	}
+}
+
+// expire drops the sessions whose lease ran out
+func (t *PresenceTracker) expire(now time.Time) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for documentID, sessions := range t.documents {
+		for key, presence := range sessions {
+			if !presence.ExpiresAt.After(now) {
+				delete(sessions, key)
+				t.publish(PresenceEvent{Type: PresenceLeft, DocumentID: documentID, Presence: *presence})
+			}
+		}
+		if len(sessions) == 0 {
+			delete(t.documents, documentID)
+		}
+	}
+}
+
+// onDocumentEvent drops the presence of users that lost access to a document
+func (t *PresenceTracker) onDocumentEvent(event DocumentEvent) {
+	switch event.Type {
+	case EventDocumentDeleted:
+		t.removeDocument(event.DocumentID)
+	case EventCollaboratorRemoved:
+		// The permission lookup hits the database, keep it off the
+		// goroutine publishing the event
+		go t.revoke(event.DocumentID, event.UserID)
+	}
+}
+
+func (t *PresenceTracker) removeDocument(documentID string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	delete(t.documents, documentID)
+	for sub := range t.subscribers[documentID] {
+		sub.close()
+	}
+	delete(t.subscribers, documentID)
+}
+
+// revoke removes the sessions and subscriptions of a user unless they still
+// reach the document some other way
+func (t *PresenceTracker) revoke(documentID, userID string) {
+	document, permission := t.service.GetDocumentWithPermission(t.ctx, userID, documentID)
+	if document != nil && validatePermission(permission, string(RoleViewer)) {
+		return
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	sessions := t.documents[documentID]
+	for key, presence := range sessions {
+		if presence.UserID == userID {
+			delete(sessions, key)
+			t.publish(PresenceEvent{Type: PresenceLeft, DocumentID: documentID, Presence: *presence})
+		}
+	}
+	if sessions != nil && len(sessions) == 0 {
+		delete(t.documents, documentID)
+	}
+	for sub := range t.subscribers[documentID] {
+		if sub.userID == userID {
+			sub.close()
+			delete(t.subscribers[documentID], sub)
+		}
+	}
+}
+
+func presenceKey(userID, sessionID string) string {
+	return userID + "/" + sessionID
+}
+
+// presenceColor picks a stable color for a user
+func presenceColor(userID string) string {
+	h := fnv.New32a()
+	h.Write([]byte(userID))
+	return presenceColors[h.Sum32()%uint32(len(presenceColors))]
+}
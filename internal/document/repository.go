@@ -2,6 +2,9 @@ package internal
 
 import (
 	"context"
+	"time"
+
+	"github.com/jackc/pgtype"
 )
 
 type DocumentRepository interface {
@@ -9,11 +12,113 @@ type DocumentRepository interface {
 	RemoveDocumentPermission(ctx context.Context, userID, documentID string) error
 	CreateDocumentPermission(ctx context.Context, permission DocumentPermission) error
 
-	DeleteDocument(ctx context.Context, documentID string) error
-	UpdateDocument(ctx context.Context, document Document) error
+	DeleteDocument(ctx context.Context, documentID string, version int64) error
+	// GetTrashedDocuments, RestoreDocument and PurgeDocument reach the
+	// trashed documents userID has owner access to, inherited or not
+	GetTrashedDocuments(ctx context.Context, userID string) ([]Document, error)
+	RestoreDocument(ctx context.Context, userID, documentID string) error
+	PurgeDocument(ctx context.Context, userID, documentID string) error
+	// PurgeTrash permanently deletes the documents trashed before
+	// deletedBefore and returns how many there were
+	PurgeTrash(ctx context.Context, deletedBefore time.Time, batchSize int) (int64, error)
+	// UpdateDocument and UpdateDocumentContent record the revision they
+	// produce, attributed to authorID, in the same transaction
+	UpdateDocument(ctx context.Context, document Document, authorID string) error
+	UpdateDocumentContent(ctx context.Context, documentID string, version int64, content *pgtype.JSONB, authorID string) error
+	// SetDocumentTemplate makes the document a template, an empty visibility
+	// turns it back into a plain document
+	SetDocumentTemplate(ctx context.Context, documentID string, visibility TemplateVisibility) error
+	// CreateDocument creates document along with the owner permission and
+	// its first revision
 	CreateDocument(ctx context.Context, document Document) (*Document, error)
+	// DuplicateDocument creates document as a copy of its SourceDocumentID
+	// with the given permissions, and the revisions of the source when
+	// copyRevisions is set
+	DuplicateDocument(ctx context.Context, document Document, permissions []DocumentPermission, copyRevisions bool) (*Document, error)
 	GetUserDocuments(ctx context.Context, userID string, userIsOwner bool) ([]Document, error)
+	// GetUserDocumentsPage returns up to limit of the documents GetUserDocuments
+	// returns, ordered by ID and starting after afterID
+	GetUserDocumentsPage(ctx context.Context, userID, afterID string, limit int) ([]Document, error)
+	GetUserRoles(ctx context.Context, userID string, documentIDs []string) (map[string]Role, error)
+	ListUserDocuments(ctx context.Context, filter DocumentListFilter) ([]DocumentListItem, error)
+	SearchUserDocuments(ctx context.Context, userID, query string, limit, offset int) ([]DocumentSearchResult, error)
+	SuggestUserDocuments(ctx context.Context, userID, query string, limit int) ([]DocumentSuggestion, error)
 	FindDocument(ctx context.Context, userID, documentID string) *Document
+	FindDocumentByID(ctx context.Context, documentID string) *Document
+
+	GetDocumentRevisions(ctx context.Context, documentID string) ([]DocumentRevision, error)
+	FindDocumentRevision(ctx context.Context, documentID string, version int64) *DocumentRevision
+	PruneDocumentRevisions(ctx context.Context, documentID string, keepLast int, keepSince time.Time) error
+
+	// AppendDocumentSteps records the resulting revision, attributed to
+	// authorID, in the same transaction
+	AppendDocumentSteps(ctx context.Context, documentID string, version int64, content *pgtype.JSONB, steps []DocumentStep, authorID string) error
+	GetDocumentSteps(ctx context.Context, documentID string, since int64) ([]DocumentStep, error)
+	PruneDocumentSteps(ctx context.Context, documentID string, keepLast int) error
+
+	AppendDocumentUpdate(ctx context.Context, update DocumentUpdate) error
+	GetDocumentUpdates(ctx context.Context, documentID string) ([]DocumentUpdate, error)
+	CountDocumentUpdates(ctx context.Context, documentID string) (int64, error)
+	// CompactDocumentUpdates records the snapshot as a revision attributed to
+	// authorID in the same transaction
+	CompactDocumentUpdates(ctx context.Context, documentID string, version int64, content *pgtype.JSONB, updateIDs []string, authorID string) error
+
+	AcquireDocumentLock(ctx context.Context, lock DocumentLock) (*DocumentLock, error)
+	RenewDocumentLock(ctx context.Context, documentID, lockID, holderID string, expiresAt time.Time) (*DocumentLock, error)
+	ReleaseDocumentLocks(ctx context.Context, documentID, lockID, holderID string) (int64, error)
+	GetDocumentLocks(ctx context.Context, documentID string) ([]DocumentLock, error)
+	UpdateDocumentLockRanges(ctx context.Context, locks []DocumentLock) error
+	// DeleteExpiredLocks deletes the locks that expired before and returns
+	// how many there were
+	DeleteExpiredLocks(ctx context.Context, before time.Time) (int64, error)
 
 	GetDocumentWithPermission(ctx context.Context, userID, documentID string) (*Document, string)
+
+	CreateFolder(ctx context.Context, folder Folder) (*Folder, error)
+	GetFolderWithPermission(ctx context.Context, userID, folderID string) (*Folder, string)
+	GetChildFolders(ctx context.Context, folderID string) ([]Folder, error)
+	GetUserRootFolders(ctx context.Context, userID string) ([]FolderListItem, error)
+	RenameFolder(ctx context.Context, folderID, name string) error
+	MoveFolder(ctx context.Context, folderID string, parentID *string) error
+	DeleteFolder(ctx context.Context, folderID string) error
+	MoveDocument(ctx context.Context, documentID string, folderID *string) error
+	GetFolderPermissions(ctx context.Context, folderID string) ([]FolderPermission, error)
+	SetFolderPermission(ctx context.Context, permission FolderPermission) error
+	RemoveFolderPermission(ctx context.Context, userID, folderID string) error
+	// GetFolderTreeDocumentIDs returns the documents inside the folder and its
+	// subfolders
+	GetFolderTreeDocumentIDs(ctx context.Context, folderID string) ([]string, error)
+	// GetFolderGrantees returns the users that own or were granted a role on
+	// the folder or one of its ancestors
+	GetFolderGrantees(ctx context.Context, folderID string) ([]string, error)
+
+	// CreateOwnershipTransfer stores the transfer, replacing the pending
+	// transfer of the document if there is one
+	CreateOwnershipTransfer(ctx context.Context, transfer OwnershipTransfer) (*OwnershipTransfer, error)
+	FindOwnershipTransfer(ctx context.Context, documentID string) *OwnershipTransfer
+	GetIncomingTransfers(ctx context.Context, userID string) ([]OwnershipTransfer, error)
+	DeleteOwnershipTransfer(ctx context.Context, documentID string) error
+	DeclineOwnershipTransfer(ctx context.Context, userID, transferID string) error
+	// AcceptOwnershipTransfer makes userID the owner of the document of the
+	// transfer, which is returned with the document as it was before
+	AcceptOwnershipTransfer(ctx context.Context, userID, transferID string) (*OwnershipTransfer, error)
+
+	CreateWorkspace(ctx context.Context, workspace Workspace) (*Workspace, error)
+	GetWorkspaceWithRole(ctx context.Context, userID, workspaceID string) (*Workspace, WorkspaceRole)
+	GetUserWorkspaces(ctx context.Context, userID string) ([]WorkspaceListItem, error)
+	GetWorkspaceMembers(ctx context.Context, workspaceID string) ([]WorkspaceMember, error)
+	// SetWorkspaceMember adds a member or changes their role, and
+	// RemoveWorkspaceMember removes one. Both fail with ErrInvalidWorkspace
+	// when the workspace would be left without admins.
+	SetWorkspaceMember(ctx context.Context, member WorkspaceMember) error
+	RemoveWorkspaceMember(ctx context.Context, workspaceID, userID string) error
+	GetWorkspaceDocumentIDs(ctx context.Context, workspaceID string) ([]string, error)
+	// SetDocumentWorkspace moves a document to a workspace, a nil
+	// workspaceID takes it out of any workspace
+	SetDocumentWorkspace(ctx context.Context, documentID string, workspaceID *string) error
+
+	AddDocumentTags(ctx context.Context, documentID string, names []string) error
+	RemoveDocumentTag(ctx context.Context, documentID, name string) error
+	GetDocumentTags(ctx context.Context, documentIDs []string) (map[string][]Tag, error)
+	GetUserTags(ctx context.Context, userID string) ([]TagCount, error)
 }
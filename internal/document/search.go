@@ -0,0 +1,162 @@
+package internal
+
+import (
+	"context"
+	"fmt"
+	"html"
+	"strings"
+	"time"
+
+	"github.com/emaforlin/ce-document-service/pkg/prosemirror"
+	"github.com/jackc/pgtype"
+	"gorm.io/gorm"
+)
+
+// searchConfig is the text search configuration of the search index. The
+// simple configuration does not stem, which suits documents in any language.
+const searchConfig = "simple"
+
+// maxSearchText bounds the text indexed per document, Postgres rejects
+// tsvectors over 1MB
+const maxSearchText = 256 << 10
+
+// Search results mark matches with these control characters, which are
+// removed from indexed text, so that the text around them can be escaped
+// before they are turned into mark elements
+const (
+	highlightStart = "\x02"
+	highlightStop  = "\x03"
+)
+
+const defaultSearchLimit = 20
+
+const defaultSuggestLimit = 10
+
+// DocumentSearchResult is a document matching a search, Snippet and
+// TitleHighlight hold the matches between highlightStart and highlightStop
+type DocumentSearchResult struct {
+	ID             string
+	OwnerID        string
+	Title          string
+	Role           Role
+	ContentMode    ContentMode
+	Version        int64
+	CreatedAt      time.Time
+	UpdatedAt      time.Time
+	Rank           float64
+	TitleHighlight string
+	Snippet        string
+}
+
+// SearchDocuments runs a full-text search over the titles and content of the
+// documents data.UserID can access, best matches first. The query follows the
+// web search syntax of Postgres: quoted phrases, OR and -word are supported.
+func (s *DocumentService) SearchDocuments(ctx context.Context, data SearchDocumentsDTO) ([]DocumentSearchResult, error) {
+	query := strings.TrimSpace(data.Query)
+	if query == "" {
+		return nil, fmt.Errorf("%w: empty search query", ErrInvalidSearch)
+	}
+	limit := data.Limit
+	if limit <= 0 {
+		limit = defaultSearchLimit
+	}
+	results, err := s.repo.SearchUserDocuments(ctx, data.UserID, query, limit, data.Offset)
+	if err != nil {
+		return nil, fmt.Errorf("failed to search documents: %w", err)
+	}
+	return results, nil
+}
+
+// DocumentSuggestion is a document whose title resembles a type-ahead query
+type DocumentSuggestion struct {
+	ID    string
+	Title string
+	Role  Role
+	// Score is the trigram word similarity of the query and the title,
+	// between 0 and 1
+	Score float64
+}
+
+// SuggestDocuments returns the documents data.UserID can access whose titles
+// resemble data.Query, which may be misspelled or cut short, best matches
+// first
+func (s *DocumentService) SuggestDocuments(ctx context.Context, data SuggestDocumentsDTO) ([]DocumentSuggestion, error) {
+	query := strings.TrimSpace(data.Query)
+	if query == "" {
+		return nil, fmt.Errorf("%w: empty search query", ErrInvalidSearch)
+	}
+	limit := data.Limit
+	if limit <= 0 {
+		limit = defaultSuggestLimit
+	}
+	suggestions, err := s.repo.SuggestUserDocuments(ctx, data.UserID, query, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to suggest documents: %w", err)
+	}
+	return suggestions, nil
+}
+
+// highlightHTML escapes a search snippet and wraps its matches in mark
+// elements
+func highlightHTML(snippet string) string {
+	snippet = html.EscapeString(snippet)
+	snippet = strings.ReplaceAll(snippet, highlightStart, "<mark>")
+	return strings.ReplaceAll(snippet, highlightStop, "</mark>")
+}
+
+// contentText extracts the text of stored content for the search index, with
+// a line break after every node that is not text. Yjs snapshots are not
+// indexed, those documents are found by title only.
+func contentText(content *pgtype.JSONB) string {
+	doc, err := decodeContent(content)
+	if err != nil || doc.Type != "doc" {
+		return ""
+	}
+	var text strings.Builder
+	writeSearchText(&text, doc)
+	return strings.TrimSpace(strings.ToValidUTF8(text.String(), ""))
+}
+
+func writeSearchText(b *strings.Builder, node *prosemirror.Node) {
+	if b.Len() >= maxSearchText {
+		return
+	}
+	if node.IsText() {
+		text := strings.NewReplacer(highlightStart, "", highlightStop, "").Replace(node.Text)
+		if remaining := maxSearchText - b.Len(); len(text) > remaining {
+			text = text[:remaining]
+		}
+		b.WriteString(text)
+		return
+	}
+	for _, child := range node.Content {
+		writeSearchText(b, child)
+	}
+	b.WriteByte('\n')
+}
+
+// searchVectorSQL is the expression computing the search_vector column from
+// the title and text expressions given
+func searchVectorSQL(title, text string) string {
+	return fmt.Sprintf("setweight(to_tsvector('%[1]s', coalesce(%[2]s, '')), 'A') || setweight(to_tsvector('%[1]s', coalesce(%[3]s, '')), 'B')",
+		searchConfig, title, text)
+}
+
+// indexUpdates adds the search columns to the column updates of a write. An
+// empty title keeps the stored title and nil content the indexed text.
+func indexUpdates(updates map[string]interface{}, title string, content *pgtype.JSONB) map[string]interface{} {
+	titleExpr, textExpr := "title", "search_text"
+	var args []interface{}
+	if title != "" {
+		titleExpr = "?"
+		args = append(args, title)
+	}
+	if content != nil {
+		text := contentText(content)
+		updates["search_text"] = text
+		textExpr = "?"
+		args = append(args, text)
+	}
+	updates["search_vector"] = gorm.Expr(searchVectorSQL(titleExpr, textExpr), args...)
+	return updates
+}
@@ -2,15 +2,45 @@ package internal
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"log"
+	"time"
+
+	"github.com/emaforlin/ce-document-service/pkg/config"
+	"github.com/emaforlin/ce-document-service/pkg/prosemirror"
+	"github.com/emaforlin/ce-document-service/pkg/prosemirror/markdown"
+	"github.com/emaforlin/ce-document-service/pkg/prosemirror/transform"
+	"github.com/emaforlin/ce-document-service/pkg/yjs"
+	"github.com/jackc/pgtype"
 )
 
 type DocumentService struct {
-	repo DocumentRepository
+	repo     DocumentRepository
+	schema   *prosemirror.Schema
+	markdown *markdown.Serializer
+	config   config.DocumentConfig
+	events   eventBus
+}
+
+// Subscribe registers a listener for document events. Listeners run on the
+// goroutine that made the change and must not block.
+func (s *DocumentService) Subscribe(listener func(DocumentEvent)) {
+	s.events.subscribe(listener)
+}
+
+func (s *DocumentService) DeleteDocument(ctx context.Context, documentID string, version int64) error {
+	if err := s.repo.DeleteDocument(ctx, documentID, version); err != nil {
+		return err
+	}
+	s.events.publish(DocumentEvent{Type: EventDocumentDeleted, DocumentID: documentID})
+	return nil
 }
 
-func (s *DocumentService) DeleteDocument(ctx context.Context, documentID string) error {
-	return s.repo.DeleteDocument(ctx, documentID)
+// FindDocument returns a document by ID without any permission check
+func (s *DocumentService) FindDocument(ctx context.Context, documentID string) *Document {
+	return s.repo.FindDocumentByID(ctx, documentID)
 }
 
 // GetDocumentWithPermission gets a specific document and the user's permission level
@@ -18,16 +48,587 @@ func (s *DocumentService) GetDocumentWithPermission(ctx context.Context, userID,
 	return s.repo.GetDocumentWithPermission(ctx, userID, documentID)
 }
 
-func (s *DocumentService) UpdateDocumentMetadata(ctx context.Context, data UpdateDocumentDTO) error {
+// UpdateDocumentMetadata updates the document if it is still at data.Version
+// and returns the new version
+func (s *DocumentService) UpdateDocumentMetadata(ctx context.Context, data UpdateDocumentDTO) (int64, error) {
 	if err := s.repo.UpdateDocument(ctx, Document{
-		ID:    data.DocumentID,
-		Title: data.Title,
+		ID:      data.DocumentID,
+		Title:   data.Title,
+		Version: data.Version,
+	}, data.UserID); err != nil {
+		return 0, fmt.Errorf("failed to update document metadata: %w", err)
+	}
+	s.pruneRevisions(ctx, data.DocumentID)
+	s.events.publish(DocumentEvent{
+		Type:       EventMetadataUpdated,
+		DocumentID: data.DocumentID,
+		UserID:     data.UserID,
+		Version:    data.Version + 1,
+	})
+	return data.Version + 1, nil
+}
+
+// UpdateDocumentContent validates the content against the schema and stores it
+// if the document is still at data.Version, returning the new version.
+// Schema violations are returned as a *prosemirror.ValidationError.
+func (s *DocumentService) UpdateDocumentContent(ctx context.Context, data UpdateDocumentContentDTO) (int64, error) {
+	doc, err := s.schema.NodeFromJSON(data.Content)
+	if err != nil {
+		return 0, err
+	}
+	return s.storeContent(ctx, data.DocumentID, data.UserID, data.Version, doc)
+}
+
+// storeContent persists already validated content on top of version and
+// returns the new version
+func (s *DocumentService) storeContent(ctx context.Context, documentID, userID string, version int64, doc *prosemirror.Node) (int64, error) {
+	content, err := encodeContent(doc)
+	if err != nil {
+		return 0, err
+	}
+
+	if err := s.repo.UpdateDocumentContent(ctx, documentID, version, content, userID); err != nil {
+		return 0, fmt.Errorf("failed to update document content: %w", err)
+	}
+	s.pruneRevisions(ctx, documentID)
+	s.events.publish(DocumentEvent{
+		Type:       EventContentUpdated,
+		DocumentID: documentID,
+		UserID:     userID,
+		Version:    version + 1,
+	})
+	return version + 1, nil
+}
+
+// ApplyDocumentSteps applies ProseMirror steps made on top of data.Version and
+// returns the new version, which moves forward by one per step. Steps made on
+// an outdated version are rejected with a *StaleStepsError carrying the steps
+// the client missed, or ErrStepsUnavailable when it has to reload instead.
+func (s *DocumentService) ApplyDocumentSteps(ctx context.Context, data ApplyStepsDTO) (int64, error) {
+	document := data.Document
+	if data.Version != document.Version {
+		return 0, s.staleSteps(ctx, document.ID, data.Version)
+	}
+	if len(data.Steps) == 0 {
+		return 0, fmt.Errorf("%w: no steps given", ErrInvalidSteps)
+	}
+
+	doc, err := decodeContent(document.Content)
+	if err != nil {
+		return 0, err
+	}
+	steps := make([]transform.Step, len(data.Steps))
+	for i, raw := range data.Steps {
+		if steps[i], err = transform.StepFromJSON(s.schema, raw); err != nil {
+			return 0, fmt.Errorf("%w: step %d: %v", ErrInvalidSteps, i, err)
+		}
+	}
+	tr, err := transform.ApplySteps(doc, steps)
+	if err != nil {
+		return 0, fmt.Errorf("%w: %v", ErrInvalidSteps, err)
+	}
+	if err := s.schema.Check(tr.Doc); err != nil {
+		return 0, err
+	}
+	locks, err := s.repo.GetDocumentLocks(ctx, document.ID)
+	if err != nil {
+		return 0, err
+	}
+	if err := s.checkStepLocks(locks, data.UserID, tr); err != nil {
+		return 0, err
+	}
+
+	content, err := encodeContent(tr.Doc)
+	if err != nil {
+		return 0, err
+	}
+	records := make([]DocumentStep, len(steps))
+	for i, step := range steps {
+		stepJSON := &pgtype.JSONB{}
+		if err := stepJSON.Set(step); err != nil {
+			return 0, fmt.Errorf("failed to encode step: %w", err)
+		}
+		records[i] = DocumentStep{
+			DocumentID: document.ID,
+			Version:    data.Version + int64(i) + 1,
+			Step:       stepJSON,
+			ClientID:   string(data.ClientID),
+			AuthorID:   data.UserID,
+		}
+	}
+
+	if err := s.repo.AppendDocumentSteps(ctx, document.ID, data.Version, content, records, data.UserID); err != nil {
+		if errors.Is(err, ErrVersionConflict) {
+			return 0, s.staleSteps(ctx, document.ID, data.Version)
+		}
+		return 0, err
+	}
+	version := data.Version + int64(len(records))
+
+	s.mapLockRanges(ctx, locks, &tr.Mapping)
+	s.pruneRevisions(ctx, document.ID)
+	if s.config.StepKeepLast > 0 {
+		if err := s.repo.PruneDocumentSteps(ctx, document.ID, s.config.StepKeepLast); err != nil {
+			log.Printf("document %s: %v", document.ID, err)
+		}
+	}
+	s.events.publish(DocumentEvent{
+		Type:       EventStepsApplied,
+		DocumentID: document.ID,
+		UserID:     data.UserID,
+		Version:    version,
+		Steps:      records,
+	})
+	return version, nil
+}
+
+// GetDocumentSteps returns the steps leading from version since to the
+// current version of the document, or ErrStepsUnavailable when some of them
+// were not recorded
+func (s *DocumentService) GetDocumentSteps(ctx context.Context, document *Document, since int64) ([]DocumentStep, error) {
+	if since > document.Version {
+		return nil, ErrStepsUnavailable
+	}
+	if since == document.Version {
+		return []DocumentStep{}, nil
+	}
+	steps, err := s.repo.GetDocumentSteps(ctx, document.ID, since)
+	if err != nil {
+		return nil, err
+	}
+	// Content writes move the version without steps, leaving holes
+	for i, step := range steps {
+		if step.Version != since+int64(i)+1 {
+			return nil, ErrStepsUnavailable
+		}
+	}
+	if int64(len(steps)) < document.Version-since {
+		return nil, ErrStepsUnavailable
+	}
+	return steps[:document.Version-since], nil
+}
+
+// staleSteps builds the error returned for steps made on an outdated version
+func (s *DocumentService) staleSteps(ctx context.Context, documentID string, version int64) error {
+	document := s.repo.FindDocumentByID(ctx, documentID)
+	if document == nil {
+		return fmt.Errorf("document not found")
+	}
+	steps, err := s.GetDocumentSteps(ctx, document, version)
+	if err != nil {
+		return err
+	}
+	return &StaleStepsError{Version: document.Version, Steps: steps}
+}
+
+// applyStoredSteps replays recorded steps on top of doc
+func (s *DocumentService) applyStoredSteps(doc *prosemirror.Node, records []DocumentStep) (*prosemirror.Node, error) {
+	steps := make([]transform.Step, len(records))
+	for i, record := range records {
+		step, err := transform.StepFromJSON(s.schema, record.Step.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		steps[i] = step
+	}
+	tr, err := transform.ApplySteps(doc, steps)
+	if err != nil {
+		return nil, err
+	}
+	return tr.Doc, nil
+}
+
+// yjsSnapshot is the Content of documents in the yjs content mode, holding
+// every compacted update merged into one
+type yjsSnapshot struct {
+	Type   string `json:"type"`
+	Update []byte `json:"update"`
+}
+
+// PushDocumentUpdate appends a Yjs update to the update log of a document and
+// compacts the log once it reaches the configured threshold
+func (s *DocumentService) PushDocumentUpdate(ctx context.Context, data PushUpdateDTO) error {
+	if _, err := yjs.DecodeUpdate(data.Update); err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidUpdate, err)
+	}
+	if err := s.repo.AppendDocumentUpdate(ctx, DocumentUpdate{
+		DocumentID: data.Document.ID,
+		Update:     data.Update,
+		AuthorID:   data.UserID,
 	}); err != nil {
-		return fmt.Errorf("failed to update document metadata")
+		return err
+	}
+
+	if s.config.YjsCompactThreshold > 0 {
+		count, err := s.repo.CountDocumentUpdates(ctx, data.Document.ID)
+		if err != nil {
+			log.Printf("document %s: %v", data.Document.ID, err)
+		} else if count >= int64(s.config.YjsCompactThreshold) {
+			if err := s.CompactDocumentUpdates(ctx, data.Document.ID); err != nil {
+				log.Printf("document %s: %v", data.Document.ID, err)
+			}
+		}
+	}
+	return nil
+}
+
+// GetDocumentUpdate returns the Yjs state of a document as a single update.
+// When stateVector is given only what the sender of the state vector is
+// missing is returned.
+func (s *DocumentService) GetDocumentUpdate(ctx context.Context, documentID string, stateVector []byte) ([]byte, error) {
+	var sv yjs.StateVector
+	if len(stateVector) > 0 {
+		var err error
+		if sv, err = yjs.DecodeStateVector(stateVector); err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrInvalidUpdate, err)
+		}
+	}
+	state, _, err := s.yjsState(ctx, documentID)
+	if err != nil {
+		return nil, err
+	}
+	return state.Diff(sv).Encode(), nil
+}
+
+// GetDocumentStateVector returns the encoded Yjs state vector of a document
+func (s *DocumentService) GetDocumentStateVector(ctx context.Context, documentID string) ([]byte, error) {
+	state, _, err := s.yjsState(ctx, documentID)
+	if err != nil {
+		return nil, err
+	}
+	return state.StateVector().Encode(), nil
+}
+
+// CompactDocumentUpdates merges the update log of a document into its
+// snapshot. A compaction racing with another one is dropped, the updates it
+// would have merged stay in the log for the next one.
+func (s *DocumentService) CompactDocumentUpdates(ctx context.Context, documentID string) error {
+	state, document, err := s.yjsState(ctx, documentID)
+	if err != nil {
+		return err
+	}
+	if len(document.Updates) == 0 {
+		return nil
+	}
+
+	content := &pgtype.JSONB{}
+	if err := content.Set(yjsSnapshot{Type: "yjs", Update: state.Encode()}); err != nil {
+		return fmt.Errorf("failed to encode document snapshot: %w", err)
+	}
+	ids := make([]string, len(document.Updates))
+	for i, update := range document.Updates {
+		ids[i] = update.ID
+	}
+	// The revision goes to the author of the latest update it merges
+	author := document.Updates[len(document.Updates)-1].AuthorID
+	err = s.repo.CompactDocumentUpdates(ctx, documentID, document.Version, content, ids, author)
+	if errors.Is(err, ErrVersionConflict) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	s.pruneRevisions(ctx, documentID)
+	return nil
+}
+
+// yjsState merges the snapshot of a document with its pending updates. The
+// returned document holds the updates that were merged.
+func (s *DocumentService) yjsState(ctx context.Context, documentID string) (*yjs.Update, *Document, error) {
+	// The log is read first: an update compacted in between is then found in
+	// both, which merging tolerates, instead of in neither
+	updates, err := s.repo.GetDocumentUpdates(ctx, documentID)
+	if err != nil {
+		return nil, nil, err
+	}
+	document := s.repo.FindDocumentByID(ctx, documentID)
+	if document == nil {
+		return nil, nil, fmt.Errorf("document not found")
+	}
+	document.Updates = updates
+
+	decoded := make([]*yjs.Update, 0, len(updates)+1)
+	if document.Content != nil && document.Content.Status == pgtype.Present {
+		var snapshot yjsSnapshot
+		if err := json.Unmarshal(document.Content.Bytes, &snapshot); err != nil {
+			return nil, nil, fmt.Errorf("failed to decode document snapshot: %w", err)
+		}
+		if len(snapshot.Update) > 0 {
+			update, err := yjs.DecodeUpdate(snapshot.Update)
+			if err != nil {
+				return nil, nil, fmt.Errorf("failed to decode document snapshot: %w", err)
+			}
+			decoded = append(decoded, update)
+		}
+	}
+	for _, record := range updates {
+		update, err := yjs.DecodeUpdate(record.Update)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to decode document update %s: %w", record.ID, err)
+		}
+		decoded = append(decoded, update)
+	}
+	return yjs.MergeUpdates(decoded...), document, nil
+}
+
+// LockDocument acquires a lock on the document, or on a node range of it, for
+// data.UserID. Locks held by other users that overlap it are returned in a
+// *LockedError.
+func (s *DocumentService) LockDocument(ctx context.Context, data LockDocumentDTO) (*DocumentLock, error) {
+	ttl, err := s.lockTTL(data.TTL)
+	if err != nil {
+		return nil, err
+	}
+	lock := DocumentLock{
+		DocumentID: data.Document.ID,
+		HolderID:   data.UserID,
+		ExpiresAt:  time.Now().Add(ttl),
+	}
+
+	if data.From != nil || data.To != nil {
+		if data.From == nil || data.To == nil || *data.From >= *data.To {
+			return nil, fmt.Errorf("%w: from and to must both be set with from < to", ErrInvalidLock)
+		}
+		if data.Document.ContentMode != ContentModeProseMirror {
+			return nil, fmt.Errorf("%w: node ranges are only supported for %s documents", ErrInvalidLock, ContentModeProseMirror)
+		}
+		doc, err := decodeContent(data.Document.Content)
+		if err != nil {
+			return nil, err
+		}
+		if size := s.schema.ContentSize(doc.Content); *data.To > size {
+			return nil, fmt.Errorf("%w: the document content ends at %d", ErrInvalidLock, size)
+		}
+		lock.From, lock.To = data.From, data.To
+	}
+
+	return s.repo.AcquireDocumentLock(ctx, lock)
+}
+
+// RenewDocumentLock extends the lease of a lock held by data.UserID
+func (s *DocumentService) RenewDocumentLock(ctx context.Context, data RenewLockDTO) (*DocumentLock, error) {
+	ttl, err := s.lockTTL(data.TTL)
+	if err != nil {
+		return nil, err
+	}
+	return s.repo.RenewDocumentLock(ctx, data.DocumentID, data.LockID, data.UserID, time.Now().Add(ttl))
+}
+
+// UnlockDocument releases locks and returns ErrLockNotFound when none matched
+func (s *DocumentService) UnlockDocument(ctx context.Context, data UnlockDocumentDTO) error {
+	released, err := s.repo.ReleaseDocumentLocks(ctx, data.DocumentID, data.LockID, data.HolderID)
+	if err != nil {
+		return err
+	}
+	if released == 0 {
+		return ErrLockNotFound
+	}
+	return nil
+}
+
+// GetDocumentLocks returns the locks currently held on a document
+func (s *DocumentService) GetDocumentLocks(ctx context.Context, documentID string) ([]DocumentLock, error) {
+	return s.repo.GetDocumentLocks(ctx, documentID)
+}
+
+// CheckDocumentLock returns a *LockedError if another user holds a lock that
+// blocks userID from writing. Range locks only count when includeRanges is
+// set, writes that cannot tell which part of the document they change must
+// set it.
+func (s *DocumentService) CheckDocumentLock(ctx context.Context, documentID, userID string, includeRanges bool) error {
+	locks, err := s.repo.GetDocumentLocks(ctx, documentID)
+	if err != nil {
+		return err
+	}
+	for _, lock := range locks {
+		if lock.HolderID != userID && (includeRanges || !lock.IsRange()) {
+			return &LockedError{Lock: lock}
+		}
+	}
+	return nil
+}
+
+// checkStepLocks refuses steps that touch a node range locked by another
+// user. Lock ranges refer to the document before the first step and are
+// mapped through the earlier steps. Steps that do not move positions, like
+// mark and attribute steps, are checked against the range they change, and
+// document attribute steps are refused while any range is locked.
+func (s *DocumentService) checkStepLocks(locks []DocumentLock, userID string, tr *transform.Transform) error {
+	for _, lock := range locks {
+		if lock.HolderID == userID || !lock.IsRange() {
+			continue
+		}
+		from, to := *lock.From, *lock.To
+		for i, stepMap := range tr.Mapping.Maps {
+			touched := false
+			switch step := tr.Steps[i].(type) {
+			case *transform.AddMarkStep:
+				touched = lock.Covers(step.From, step.To)
+			case *transform.RemoveMarkStep:
+				touched = lock.Covers(step.From, step.To)
+			case *transform.AddNodeMarkStep:
+				touched = lock.Covers(step.Pos, step.Pos+1)
+			case *transform.RemoveNodeMarkStep:
+				touched = lock.Covers(step.Pos, step.Pos+1)
+			case *transform.AttrStep:
+				touched = lock.Covers(step.Pos, step.Pos+1)
+			case *transform.DocAttrStep:
+				touched = true
+			default:
+				stepMap.ForEach(func(oldStart, oldEnd, _, _ int) {
+					touched = touched || lock.Covers(oldStart, oldEnd)
+				})
+			}
+			if touched {
+				return &LockedError{Lock: lock}
+			}
+			from, to = stepMap.Map(from, 1), stepMap.Map(to, -1)
+			to = max(from, to)
+			lock.From, lock.To = &from, &to
+		}
 	}
 	return nil
 }
 
+// mapLockRanges moves the range locks of a document through applied steps so
+// that they keep covering the same nodes
+func (s *DocumentService) mapLockRanges(ctx context.Context, locks []DocumentLock, mapping *transform.Mapping) {
+	var moved []DocumentLock
+	for _, lock := range locks {
+		if !lock.IsRange() {
+			continue
+		}
+		from, to := mapping.Map(*lock.From, 1), mapping.Map(*lock.To, -1)
+		if from == *lock.From && to == *lock.To {
+			continue
+		}
+		// A range whose content was deleted collapses, it stays empty
+		// until released
+		to = max(from, to)
+		lock.From, lock.To = &from, &to
+		moved = append(moved, lock)
+	}
+	if len(moved) == 0 {
+		return
+	}
+	if err := s.repo.UpdateDocumentLockRanges(ctx, moved); err != nil {
+		log.Printf("document %s: %v", moved[0].DocumentID, err)
+	}
+}
+
+// lockTTL turns a TTL in seconds into a lease, applying the configured
+// default and maximum
+func (s *DocumentService) lockTTL(seconds int64) (time.Duration, error) {
+	if seconds == 0 {
+		return s.config.LockDefaultTTL, nil
+	}
+	ttl := time.Duration(seconds) * time.Second
+	if s.config.LockMaxTTL > 0 && ttl > s.config.LockMaxTTL {
+		return 0, fmt.Errorf("%w: ttl cannot exceed %s", ErrInvalidLock, s.config.LockMaxTTL)
+	}
+	return ttl, nil
+}
+
+func (s *DocumentService) GetDocumentRevisions(ctx context.Context, documentID string) ([]DocumentRevision, error) {
+	revisions, err := s.repo.GetDocumentRevisions(ctx, documentID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch document revisions: %w", err)
+	}
+	return revisions, nil
+}
+
+func (s *DocumentService) GetDocumentRevision(ctx context.Context, documentID string, version int64) (*DocumentRevision, error) {
+	revision := s.repo.FindDocumentRevision(ctx, documentID, version)
+	if revision == nil {
+		return nil, ErrRevisionNotFound
+	}
+	return revision, nil
+}
+
+// RestoreDocumentRevision brings back the title and content of a revision as a
+// new version of the document and returns that version
+func (s *DocumentService) RestoreDocumentRevision(ctx context.Context, data RestoreRevisionDTO) (int64, error) {
+	revision := s.repo.FindDocumentRevision(ctx, data.DocumentID, data.Revision)
+	if revision == nil {
+		return 0, ErrRevisionNotFound
+	}
+
+	content := revision.Content
+	if content == nil {
+		// A nil content would leave the current content in place
+		content = &pgtype.JSONB{Status: pgtype.Null}
+	}
+
+	if err := s.repo.UpdateDocument(ctx, Document{
+		ID:      data.DocumentID,
+		Title:   revision.Title,
+		Content: content,
+		Version: data.Version,
+	}, data.UserID); err != nil {
+		return 0, fmt.Errorf("failed to restore document revision: %w", err)
+	}
+	s.pruneRevisions(ctx, data.DocumentID)
+	s.events.publish(DocumentEvent{
+		Type:       EventContentUpdated,
+		DocumentID: data.DocumentID,
+		UserID:     data.UserID,
+		Version:    data.Version + 1,
+	})
+	return data.Version + 1, nil
+}
+
+// DiffDocument compares two versions of a document. A zero version stands for
+// the current state of the document.
+func (s *DocumentService) DiffDocument(ctx context.Context, data DiffDocumentDTO) (*prosemirror.DiffNode, error) {
+	from, err := s.documentContentAt(ctx, data.Document, data.From)
+	if err != nil {
+		return nil, err
+	}
+	to, err := s.documentContentAt(ctx, data.Document, data.To)
+	if err != nil {
+		return nil, err
+	}
+	return s.schema.Diff(from, to), nil
+}
+
+func (s *DocumentService) documentContentAt(ctx context.Context, document *Document, version int64) (*prosemirror.Node, error) {
+	if version == 0 || version == document.Version {
+		return decodeContent(document.Content)
+	}
+	revision := s.repo.FindDocumentRevision(ctx, document.ID, version)
+	if revision == nil {
+		return nil, fmt.Errorf("version %d: %w", version, ErrRevisionNotFound)
+	}
+	return decodeContent(revision.Content)
+}
+
+// pruneRevisions applies the retention policy to the revisions of a document.
+// Failures are logged since the document write already succeeded.
+func (s *DocumentService) pruneRevisions(ctx context.Context, documentID string) {
+	keepLast, keepDays := s.config.RevisionKeepLast, s.config.RevisionKeepDays
+	if keepLast < 1 && keepDays < 1 {
+		return
+	}
+	keepSince := time.Now()
+	if keepDays > 0 {
+		keepSince = keepSince.AddDate(0, 0, -keepDays)
+	}
+	if err := s.repo.PruneDocumentRevisions(ctx, documentID, keepLast, keepSince); err != nil {
+		log.Printf("document %s: %v", documentID, err)
+	}
+}
+
+// MarkdownSerializer is the serializer used by the markdown export, custom node
+// and mark renderers can be registered on it
+func (s *DocumentService) MarkdownSerializer() *markdown.Serializer {
+	return s.markdown
+}
+
+// Schema returns the schema document content is validated against
+func (s *DocumentService) Schema() *prosemirror.Schema {
+	return s.schema
+}
+
 func (s *DocumentService) getDocumentCollaborators(ctx context.Context, documentID string) ([]DocumentPermission, error) {
 	permissions := s.repo.GetDocumentPermissions(ctx, documentID)
 	if len(permissions) < 1 {
@@ -40,6 +641,11 @@ func (s *DocumentService) RemoveDocumentCollaborator(ctx context.Context, data R
 	if err := s.repo.RemoveDocumentPermission(ctx, data.UserID, data.DocumentID); err != nil {
 		return fmt.Errorf("failed to remove document collaborator: %w", err)
 	}
+	s.events.publish(DocumentEvent{
+		Type:       EventCollaboratorRemoved,
+		DocumentID: data.DocumentID,
+		UserID:     data.UserID,
+	})
 	return nil
 }
 
@@ -56,21 +662,46 @@ func (s *DocumentService) AddCollaboratorToDocument(ctx context.Context, data Ad
 
 func (s *DocumentService) CreateNewDocument(ctx context.Context, data CreateDocumentDTO) (*Document, error) {
 	var err error
+	if data.TemplateID != nil {
+		if data, err = s.applyTemplate(ctx, data); err != nil {
+			return nil, fmt.Errorf("failed to create a new document: %w", err)
+		}
+	}
+	mode := data.ContentMode
+	if mode == "" {
+		mode = ContentModeProseMirror
+	}
+	var content *pgtype.JSONB
+	if data.Content != nil {
+		if mode != ContentModeProseMirror {
+			return nil, fmt.Errorf("failed to create a new document: content requires the %s content mode", ContentModeProseMirror)
+		}
+		if content, err = encodeContent(data.Content); err != nil {
+			return nil, fmt.Errorf("failed to create a new document: %w", err)
+		}
+	}
+	if data.FolderID != nil {
+		if err := s.checkFolderRole(ctx, data.OwnerID, *data.FolderID, RoleEditor); err != nil {
+			return nil, fmt.Errorf("failed to create a new document: %w", err)
+		}
+	}
+	if data.WorkspaceID != nil {
+		if err := s.checkWorkspaceRole(ctx, data.OwnerID, *data.WorkspaceID, WorkspaceRoleMember); err != nil {
+			return nil, fmt.Errorf("failed to create a new document: %w", err)
+		}
+	}
 	doc, err := s.repo.CreateDocument(ctx, Document{
-		Title:   data.Title,
-		OwnerID: data.OwnerID,
-		Content: nil,
+		Title:       data.Title,
+		OwnerID:     data.OwnerID,
+		Content:     content,
+		Version:     1,
+		ContentMode: mode,
+		FolderID:    data.FolderID,
+		WorkspaceID: data.WorkspaceID,
 	})
 	if err != nil {
 		return nil, fmt.Errorf("failed to create a new document: %w", err)
 	}
-
-	s.repo.CreateDocumentPermission(ctx, DocumentPermission{
-		DocumentID: doc.ID,
-		UserID:     data.OwnerID,
-		Role:       RoleOwner,
-	})
-
 	return doc, nil
 }
 
@@ -87,12 +718,45 @@ func (s *DocumentService) GetOneDocument(ctx context.Context, data GetOneDocumen
 	return document
 }
 
-func NewDocumentService(documentsRepository DocumentRepository) (*DocumentService, error) {
+// decodeContent parses the stored JSONB content, an empty document is
+// returned for documents that have no content yet
+func decodeContent(content *pgtype.JSONB) (*prosemirror.Node, error) {
+	if content == nil || content.Status != pgtype.Present {
+		return &prosemirror.Node{Type: "doc"}, nil
+	}
+	var doc prosemirror.Node
+	if err := json.Unmarshal(content.Bytes, &doc); err != nil {
+		return nil, fmt.Errorf("failed to decode document content: %w", err)
+	}
+	return &doc, nil
+}
+
+// encodeContent serializes a validated document into its JSONB column value
+func encodeContent(doc *prosemirror.Node) (*pgtype.JSONB, error) {
+	content := &pgtype.JSONB{}
+	if err := content.Set(doc); err != nil {
+		return nil, fmt.Errorf("failed to encode document content: %w", err)
+	}
+	return content, nil
+}
+
+func NewDocumentService(documentsRepository DocumentRepository, cfg config.DocumentConfig) (*DocumentService, error) {
 	if documentsRepository == nil {
 		return nil, fmt.Errorf("error creating the documents service: documentsRepository cannot be nil")
 	}
 
+	schema := prosemirror.DefaultSchema()
+	if cfg.SchemaPath != "" {
+		var err error
+		if schema, err = prosemirror.LoadSchema(cfg.SchemaPath); err != nil {
+			return nil, fmt.Errorf("error creating the documents service: %w", err)
+		}
+	}
+
 	return &DocumentService{
-		repo: documentsRepository,
+		repo:     documentsRepository,
+		schema:   schema,
+		markdown: markdown.NewSerializer(schema),
+		config:   cfg,
 	}, nil
 }
@@ -0,0 +1,101 @@
+package internal
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+const (
+	maxTagLength = 64
+	// maxTagsPerRequest bounds the tags added or filtered on at once
+	maxTagsPerRequest = 20
+)
+
+// TagCount is a tag with the number of documents the user can access that
+// carry it
+type TagCount struct {
+	Name  string
+	Count int64
+}
+
+// TagDocument adds tags to a document and returns all of its tags
+func (s *DocumentService) TagDocument(ctx context.Context, data TagDocumentDTO) ([]Tag, error) {
+	names, err := tagNames(data.Tags)
+	if err != nil {
+		return nil, fmt.Errorf("failed to tag document: %w", err)
+	}
+	if err := s.repo.AddDocumentTags(ctx, data.DocumentID, names); err != nil {
+		return nil, fmt.Errorf("failed to tag document: %w", err)
+	}
+	return s.GetDocumentTags(ctx, data.DocumentID)
+}
+
+// UntagDocument removes a tag from a document and returns the tags left
+func (s *DocumentService) UntagDocument(ctx context.Context, data UntagDocumentDTO) ([]Tag, error) {
+	name, err := tagName(data.Tag)
+	if err != nil {
+		return nil, fmt.Errorf("failed to untag document: %w", err)
+	}
+	if err := s.repo.RemoveDocumentTag(ctx, data.DocumentID, name); err != nil {
+		return nil, fmt.Errorf("failed to untag document: %w", err)
+	}
+	return s.GetDocumentTags(ctx, data.DocumentID)
+}
+
+// GetDocumentTags returns the tags of a document sorted by name
+func (s *DocumentService) GetDocumentTags(ctx context.Context, documentID string) ([]Tag, error) {
+	tags, err := s.repo.GetDocumentTags(ctx, []string{documentID})
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch document tags: %w", err)
+	}
+	return tags[documentID], nil
+}
+
+// GetUserTags returns the tags on the documents userID can access, most used
+// first
+func (s *DocumentService) GetUserTags(ctx context.Context, userID string) ([]TagCount, error) {
+	tags, err := s.repo.GetUserTags(ctx, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch tags: %w", err)
+	}
+	return tags, nil
+}
+
+// tagName normalizes a tag name, tags are matched regardless of case and of
+// surrounding and repeated spaces
+func tagName(name string) (string, error) {
+	name = strings.ToLower(strings.Join(strings.Fields(name), " "))
+	if name == "" {
+		return "", fmt.Errorf("%w: tags cannot be blank", ErrInvalidTag)
+	}
+	if utf8.RuneCountInString(name) > maxTagLength {
+		return "", fmt.Errorf("%w: tags are limited to %d characters", ErrInvalidTag, maxTagLength)
+	}
+	if strings.IndexFunc(name, unicode.IsControl) >= 0 {
+		return "", fmt.Errorf("%w: tags cannot contain control characters", ErrInvalidTag)
+	}
+	return name, nil
+}
+
+// tagNames normalizes tag names and drops duplicates
+func tagNames(names []string) ([]string, error) {
+	if len(names) > maxTagsPerRequest {
+		return nil, fmt.Errorf("%w: at most %d tags at once", ErrInvalidTag, maxTagsPerRequest)
+	}
+	seen := make(map[string]bool, len(names))
+	normalized := make([]string, 0, len(names))
+	for _, name := range names {
+		name, err := tagName(name)
+		if err != nil {
+			return nil, err
+		}
+		if !seen[name] {
+			seen[name] = true
+			normalized = append(normalized, name)
+		}
+	}
+	return normalized, nil
+}
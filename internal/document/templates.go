@@ -0,0 +1,105 @@
+package internal
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+
+	"github.com/emaforlin/ce-document-service/pkg/prosemirror"
+)
+
+// templatePlaceholder matches {{name}} placeholders, spaces around the name
+// are allowed
+var templatePlaceholder = regexp.MustCompile(`\{\{\s*([A-Za-z_][A-Za-z0-9_.-]*)\s*\}\}`)
+
+// SetTemplate makes a document a personal or shared template
+func (s *DocumentService) SetTemplate(ctx context.Context, data SetTemplateDTO) error {
+	if err := s.repo.SetDocumentTemplate(ctx, data.DocumentID, data.Visibility); err != nil {
+		return fmt.Errorf("failed to set template: %w", err)
+	}
+	return nil
+}
+
+// UnsetTemplate turns a template back into a plain document, documents
+// created from it are left untouched
+func (s *DocumentService) UnsetTemplate(ctx context.Context, documentID string) error {
+	if err := s.repo.SetDocumentTemplate(ctx, documentID, ""); err != nil {
+		return fmt.Errorf("failed to unset template: %w", err)
+	}
+	return nil
+}
+
+// findTemplate returns the template userID wants to create a document from.
+// Personal templates are only usable by their owner, shared ones by everyone
+// who can view them.
+func (s *DocumentService) findTemplate(ctx context.Context, userID, templateID string) (*Document, error) {
+	document, permission := s.repo.GetDocumentWithPermission(ctx, userID, templateID)
+	if document == nil || document.ContentMode != ContentModeProseMirror {
+		return nil, ErrTemplateNotFound
+	}
+	switch document.Template {
+	case TemplatePersonal:
+		if document.OwnerID == userID {
+			return document, nil
+		}
+	case TemplateShared:
+		if validatePermission(permission, string(RoleViewer)) {
+			return document, nil
+		}
+	}
+	return nil, ErrTemplateNotFound
+}
+
+// applyTemplate fills the title and content of data from its template
+func (s *DocumentService) applyTemplate(ctx context.Context, data CreateDocumentDTO) (CreateDocumentDTO, error) {
+	if data.ContentMode != "" && data.ContentMode != ContentModeProseMirror {
+		return data, fmt.Errorf("templates require the %s content mode", ContentModeProseMirror)
+	}
+	template, err := s.findTemplate(ctx, data.OwnerID, *data.TemplateID)
+	if err != nil {
+		return data, err
+	}
+	content, err := decodeContent(template.Content)
+	if err != nil {
+		return data, err
+	}
+	data.ContentMode = ContentModeProseMirror
+	data.Content = fillTemplate(content, data.Variables)
+	if data.Title == "" {
+		data.Title = fillTemplateText(template.Title, data.Variables)
+	}
+	return data, nil
+}
+
+// fillTemplate replaces the placeholders in the text of node and its
+// descendants. Adjacent text nodes are joined first so placeholders split
+// across them by an editor are still found, and text left empty is dropped.
+func fillTemplate(node *prosemirror.Node, variables map[string]string) *prosemirror.Node {
+	if len(node.Content) == 0 {
+		return node
+	}
+	content := prosemirror.JoinText(node.Content)
+	for i, child := range content {
+		if child.IsText() {
+			filled := *child
+			filled.Text = fillTemplateText(child.Text, variables)
+			content[i] = &filled
+			continue
+		}
+		content[i] = fillTemplate(child, variables)
+	}
+	node.Content = prosemirror.JoinText(content)
+	return node
+}
+
+// fillTemplateText replaces the placeholders in text, placeholders without a
+// variable are kept as they are
+func fillTemplateText(text string, variables map[string]string) string {
+	return templatePlaceholder.ReplaceAllStringFunc(text, func(placeholder string) string {
+		name := templatePlaceholder.FindStringSubmatch(placeholder)[1]
+		if value, ok := variables[name]; ok {
+			return value
+		}
+		return placeholder
+	})
+}
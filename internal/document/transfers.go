@@ -0,0 +1,85 @@
+package internal
+
+import (
+	"context"
+	"fmt"
+)
+
+// TransferOwnership offers data.Document to data.ToUserID. Nothing changes
+// until the recipient accepts, a new offer replaces the pending one.
+func (s *DocumentService) TransferOwnership(ctx context.Context, data TransferOwnershipDTO) (*OwnershipTransfer, error) {
+	if data.Document.OwnerID != data.UserID {
+		return nil, fmt.Errorf("failed to transfer ownership: %w: only the owner of the document can transfer it", ErrInvalidTransfer)
+	}
+	if data.ToUserID == data.UserID {
+		return nil, fmt.Errorf("failed to transfer ownership: %w: the document is already owned by the user", ErrInvalidTransfer)
+	}
+	transfer, err := s.repo.CreateOwnershipTransfer(ctx, OwnershipTransfer{
+		DocumentID:   data.Document.ID,
+		FromUserID:   data.UserID,
+		ToUserID:     data.ToUserID,
+		KeepAsEditor: data.KeepAsEditor,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to transfer ownership: %w", err)
+	}
+	return transfer, nil
+}
+
+// GetOwnershipTransfer returns the pending transfer of a document
+func (s *DocumentService) GetOwnershipTransfer(ctx context.Context, documentID string) (*OwnershipTransfer, error) {
+	transfer := s.repo.FindOwnershipTransfer(ctx, documentID)
+	if transfer == nil {
+		return nil, ErrTransferNotFound
+	}
+	return transfer, nil
+}
+
+// CancelOwnershipTransfer withdraws the pending transfer of a document
+func (s *DocumentService) CancelOwnershipTransfer(ctx context.Context, documentID string) error {
+	if err := s.repo.DeleteOwnershipTransfer(ctx, documentID); err != nil {
+		return fmt.Errorf("failed to cancel ownership transfer: %w", err)
+	}
+	return nil
+}
+
+// GetIncomingTransfers returns the transfers waiting for userID to answer
+func (s *DocumentService) GetIncomingTransfers(ctx context.Context, userID string) ([]OwnershipTransfer, error) {
+	transfers, err := s.repo.GetIncomingTransfers(ctx, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch ownership transfers: %w", err)
+	}
+	return transfers, nil
+}
+
+// DeclineOwnershipTransfer turns down a transfer addressed to userID
+func (s *DocumentService) DeclineOwnershipTransfer(ctx context.Context, userID, transferID string) error {
+	if err := s.repo.DeclineOwnershipTransfer(ctx, userID, transferID); err != nil {
+		return fmt.Errorf("failed to decline ownership transfer: %w", err)
+	}
+	return nil
+}
+
+// AcceptOwnershipTransfer makes userID the owner of the document of a
+// transfer addressed to them. The previous owner, and anyone who reached the
+// document through the folder it was in, get their access checked again.
+func (s *DocumentService) AcceptOwnershipTransfer(ctx context.Context, userID, transferID string) (*OwnershipTransfer, error) {
+	transfer, err := s.repo.AcceptOwnershipTransfer(ctx, userID, transferID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to accept ownership transfer: %w", err)
+	}
+
+	changed := folderAccess{
+		documentIDs: []string{transfer.DocumentID},
+		userIDs:     []string{transfer.FromUserID, transfer.ToUserID},
+	}
+	if folderID := transfer.Document.FolderID; folderID != nil {
+		grantees, err := s.repo.GetFolderGrantees(ctx, *folderID)
+		if err != nil {
+			return nil, fmt.Errorf("failed to accept ownership transfer: %w", err)
+		}
+		changed.userIDs = append(changed.userIDs, grantees...)
+	}
+	changed.publish(&s.events)
+	return transfer, nil
+}
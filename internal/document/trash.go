@@ -0,0 +1,148 @@
+package internal
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+)
+
+// purgeBatchSize is the number of documents the purger deletes per statement
+const purgeBatchSize = 100
+
+// TrashedDocument is a document in the trash. PurgeAt is when it will be
+// deleted for good, nil when trashed documents are kept.
+type TrashedDocument struct {
+	Document
+	PurgeAt *time.Time
+}
+
+// GetTrash returns the trashed documents userID could have deleted, that is
+// those they have owner access to, most recent first
+func (s *DocumentService) GetTrash(ctx context.Context, userID string) ([]TrashedDocument, error) {
+	documents, err := s.repo.GetTrashedDocuments(ctx, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch trash: %w", err)
+	}
+	ids := make([]string, len(documents))
+	for i, document := range documents {
+		ids[i] = document.ID
+	}
+	tags, err := s.repo.GetDocumentTags(ctx, ids)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch trash: %w", err)
+	}
+
+	trash := make([]TrashedDocument, len(documents))
+	for i, document := range documents {
+		document.Tags = tags[document.ID]
+		trash[i] = TrashedDocument{Document: document}
+		if s.config.TrashRetention > 0 {
+			purgeAt := document.DeletedAt.Time.Add(s.config.TrashRetention)
+			trash[i].PurgeAt = &purgeAt
+		}
+	}
+	return trash, nil
+}
+
+// RestoreDocument takes a document userID has owner access to out of the trash
+func (s *DocumentService) RestoreDocument(ctx context.Context, userID, documentID string) error {
+	return s.repo.RestoreDocument(ctx, userID, documentID)
+}
+
+// PurgeDocument permanently deletes a document userID has owner access to
+// from the trash
+func (s *DocumentService) PurgeDocument(ctx context.Context, userID, documentID string) error {
+	return s.repo.PurgeDocument(ctx, userID, documentID)
+}
+
+// PurgeTrash permanently deletes the documents trashed before deletedBefore
+func (s *DocumentService) PurgeTrash(ctx context.Context, deletedBefore time.Time) (int64, error) {
+	return s.repo.PurgeTrash(ctx, deletedBefore, purgeBatchSize)
+}
+
+// PurgeExpiredLocks deletes the locks that expired before now, they are
+// otherwise only cleared when the document is locked again
+func (s *DocumentService) PurgeExpiredLocks(ctx context.Context, now time.Time) (int64, error) {
+	return s.repo.DeleteExpiredLocks(ctx, now)
+}
+
+// TrashPurger periodically deletes the documents that stayed in the trash
+// longer than the retention period, along with expired document locks
+type TrashPurger struct {
+	service   *DocumentService
+	retention time.Duration
+	interval  time.Duration
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// NewTrashPurger creates a purger and starts it. Trashed documents are kept
+// when retention is zero, expired locks are deleted either way.
+func NewTrashPurger(service *DocumentService, retention, interval time.Duration) *TrashPurger {
+	if interval <= 0 {
+		interval = time.Hour
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	purger := &TrashPurger{
+		service:   service,
+		retention: retention,
+		interval:  interval,
+		ctx:       ctx,
+		cancel:    cancel,
+		done:      make(chan struct{}),
+	}
+	go purger.run()
+	return purger
+}
+
+// Stop stops the purger, waiting for a running purge to end
+func (p *TrashPurger) Stop() {
+	p.cancel()
+	<-p.done
+}
+
+func (p *TrashPurger) run() {
+	defer close(p.done)
+
+	ticker := time.NewTicker(p.interval)
+	defer ticker.Stop()
+
+	p.purge(time.Now())
+	for {
+		select {
+		case now := <-ticker.C:
+			p.purge(now)
+		case <-p.ctx.Done():
+			return
+		}
+	}
+}
+
+func (p *TrashPurger) purge(now time.Time) {
+	if p.retention > 0 {
+		purged, err := p.service.PurgeTrash(p.ctx, now.Add(-p.retention))
+		if err != nil {
+			if p.ctx.Err() == nil {
+				log.Printf("trash purge: %v", err)
+			}
+			return
+		}
+		if purged > 0 {
+			log.Printf("trash purge: deleted %d documents", purged)
+		}
+	}
+
+	expired, err := p.service.PurgeExpiredLocks(p.ctx, now)
+	if err != nil {
+		if p.ctx.Err() == nil {
+			log.Printf("trash purge: %v", err)
+		}
+		return
+	}
+	if expired > 0 {
+		log.Printf("trash purge: deleted %d expired locks", expired)
+	}
+}
@@ -0,0 +1,130 @@
+package internal
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// WorkspaceListItem is a workspace of a listing with the role of the user
+type WorkspaceListItem struct {
+	ID        string
+	Name      string
+	Role      WorkspaceRole
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
+
+// CreateWorkspace creates a workspace with data.UserID as its first admin
+func (s *DocumentService) CreateWorkspace(ctx context.Context, data CreateWorkspaceDTO) (*Workspace, error) {
+	name := strings.TrimSpace(data.Name)
+	if name == "" {
+		return nil, fmt.Errorf("failed to create workspace: %w: the name cannot be blank", ErrInvalidWorkspace)
+	}
+	workspace, err := s.repo.CreateWorkspace(ctx, Workspace{
+		Name:    name,
+		Members: []WorkspaceMember{{UserID: data.UserID, Role: WorkspaceRoleAdmin}},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create workspace: %w", err)
+	}
+	return workspace, nil
+}
+
+// GetUserWorkspaces returns the workspaces userID is a member of
+func (s *DocumentService) GetUserWorkspaces(ctx context.Context, userID string) ([]WorkspaceListItem, error) {
+	workspaces, err := s.repo.GetUserWorkspaces(ctx, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch workspaces: %w", err)
+	}
+	return workspaces, nil
+}
+
+// GetWorkspaceWithRole returns a workspace and the role of userID in it, nil
+// when userID is not a member
+func (s *DocumentService) GetWorkspaceWithRole(ctx context.Context, userID, workspaceID string) (*Workspace, WorkspaceRole) {
+	return s.repo.GetWorkspaceWithRole(ctx, userID, workspaceID)
+}
+
+func (s *DocumentService) GetWorkspaceMembers(ctx context.Context, workspaceID string) ([]WorkspaceMember, error) {
+	members, err := s.repo.GetWorkspaceMembers(ctx, workspaceID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch workspace members: %w", err)
+	}
+	return members, nil
+}
+
+// SetWorkspaceMember adds a member to a workspace or changes their role
+func (s *DocumentService) SetWorkspaceMember(ctx context.Context, data SetWorkspaceMemberDTO) error {
+	if err := s.repo.SetWorkspaceMember(ctx, WorkspaceMember{
+		WorkspaceID: data.WorkspaceID,
+		UserID:      data.UserID,
+		Role:        data.Role,
+	}); err != nil {
+		return fmt.Errorf("failed to set workspace member: %w", err)
+	}
+	return s.workspaceAccessChanged(ctx, data.WorkspaceID, data.UserID)
+}
+
+// RemoveWorkspaceMember removes a member from a workspace, the documents
+// shared with them one by one stay shared
+func (s *DocumentService) RemoveWorkspaceMember(ctx context.Context, data RemoveWorkspaceMemberDTO) error {
+	if err := s.repo.RemoveWorkspaceMember(ctx, data.WorkspaceID, data.UserID); err != nil {
+		return fmt.Errorf("failed to remove workspace member: %w", err)
+	}
+	return s.workspaceAccessChanged(ctx, data.WorkspaceID, data.UserID)
+}
+
+// MoveDocumentToWorkspace moves a document into a workspace data.UserID is a
+// member of, or out of its workspace. The admins of the workspace it leaves
+// lose their implicit owner access and get their access checked again.
+func (s *DocumentService) MoveDocumentToWorkspace(ctx context.Context, data MoveDocumentWorkspaceDTO) error {
+	if data.WorkspaceID != nil {
+		if err := s.checkWorkspaceRole(ctx, data.UserID, *data.WorkspaceID, WorkspaceRoleMember); err != nil {
+			return fmt.Errorf("failed to move document to workspace: %w", err)
+		}
+	}
+	revoked := folderAccess{documentIDs: []string{data.Document.ID}}
+	if data.Document.WorkspaceID != nil {
+		members, err := s.repo.GetWorkspaceMembers(ctx, *data.Document.WorkspaceID)
+		if err != nil {
+			return fmt.Errorf("failed to move document to workspace: %w", err)
+		}
+		for _, member := range members {
+			if member.Role == WorkspaceRoleAdmin {
+				revoked.userIDs = append(revoked.userIDs, member.UserID)
+			}
+		}
+	}
+	if err := s.repo.SetDocumentWorkspace(ctx, data.Document.ID, data.WorkspaceID); err != nil {
+		return fmt.Errorf("failed to move document to workspace: %w", err)
+	}
+	revoked.publish(&s.events)
+	return nil
+}
+
+// workspaceAccessChanged tells the subscribers to check again the access of
+// userID to the documents of the workspace, which depends on being an admin
+func (s *DocumentService) workspaceAccessChanged(ctx context.Context, workspaceID, userID string) error {
+	documentIDs, err := s.repo.GetWorkspaceDocumentIDs(ctx, workspaceID)
+	if err != nil {
+		return fmt.Errorf("failed to update workspace access: %w", err)
+	}
+	folderAccess{documentIDs: documentIDs, userIDs: []string{userID}}.publish(&s.events)
+	return nil
+}
+
+// checkWorkspaceRole returns ErrWorkspaceNotFound unless userID is a member
+// of the workspace, and ErrWorkspaceForbidden unless they have at least the
+// required role
+func (s *DocumentService) checkWorkspaceRole(ctx context.Context, userID, workspaceID string, required WorkspaceRole) error {
+	workspace, role := s.repo.GetWorkspaceWithRole(ctx, userID, workspaceID)
+	if workspace == nil {
+		return ErrWorkspaceNotFound
+	}
+	if workspaceRoleLevels[role] < workspaceRoleLevels[required] {
+		return fmt.Errorf("%w: %s role required", ErrWorkspaceForbidden, required)
+	}
+	return nil
+}
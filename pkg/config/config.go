@@ -25,9 +25,47 @@ type DatabaseConfig struct {
 	Name string
 }
 
+type DocumentConfig struct {
+	// SchemaPath points to a JSON ProseMirror schema spec, the built-in
+	// schema is used when empty
+	SchemaPath string
+	// RevisionKeepLast and RevisionKeepDays form the revision retention
+	// policy: a revision is kept if it is one of the last RevisionKeepLast
+	// revisions or younger than RevisionKeepDays. Zero disables a rule, when
+	// both are zero revisions are kept forever.
+	RevisionKeepLast int
+	RevisionKeepDays int
+	// StepKeepLast is the number of most recent ProseMirror steps kept per
+	// document for collaborators catching up, zero keeps every step
+	StepKeepLast int
+	// YjsCompactThreshold is the number of pending Yjs updates after which
+	// the update log of a document is compacted into its snapshot, zero
+	// disables compaction
+	YjsCompactThreshold int
+	// LockDefaultTTL is the lease of document locks acquired without a TTL,
+	// LockMaxTTL bounds the TTL clients can ask for
+	LockDefaultTTL time.Duration
+	LockMaxTTL     time.Duration
+	// PresenceTTL is how long an editor session stays present in a document
+	// without a heartbeat
+	PresenceTTL time.Duration
+	// CollabFlushInterval is how often the collaboration hub persists the
+	// state edited over websockets
+	CollabFlushInterval time.Duration
+	// TrashRetention is how long deleted documents stay in the trash before
+	// they are purged, zero keeps them until they are removed by hand.
+	// TrashPurgeInterval is how often the trash is checked.
+	TrashRetention     time.Duration
+	TrashPurgeInterval time.Duration
+	// CollabAllowedOrigins lists the origins browsers may open collaboration
+	// websockets from, only the origin of the service itself when empty
+	CollabAllowedOrigins []string
+}
+
 type Config struct {
 	server   ServerConfig
 	database DatabaseConfig
+	document DocumentConfig
 }
 
 func (c Config) GetServerConf() ServerConfig {
@@ -38,11 +76,16 @@ func (c Config) GetDatabaseConf() DatabaseConfig {
 	return c.database
 }
 
+func (c Config) GetDocumentConf() DocumentConfig {
+	return c.document
+}
+
 func Load() {
 	once.Do(func() {
 		config = &Config{
 			server:   loadServerConfig(),
 			database: loadDatabaseConfig(),
+			document: loadDocumentConfig(),
 		}
 	})
 }
@@ -70,3 +113,24 @@ func loadDatabaseConfig() DatabaseConfig {
 		Name: getEnv("DB_NAME", "document_service"),
 	}
 }
+
+func loadDocumentConfig() DocumentConfig {
+	return DocumentConfig{
+		SchemaPath:       getEnv("CONTENT_SCHEMA_PATH", ""),
+		RevisionKeepLast: getEnvInt("REVISION_KEEP_LAST", 100),
+		RevisionKeepDays: getEnvInt("REVISION_KEEP_DAYS", 30),
+		StepKeepLast:     getEnvInt("STEP_KEEP_LAST", 1000),
+
+		YjsCompactThreshold: getEnvInt("YJS_COMPACT_THRESHOLD", 100),
+
+		CollabFlushInterval: getEnvDuration("COLLAB_FLUSH_INTERVAL", 5*time.Second),
+		PresenceTTL:         getEnvDuration("PRESENCE_TTL", 30*time.Second),
+		LockDefaultTTL:      getEnvDuration("LOCK_DEFAULT_TTL", 5*time.Minute),
+		LockMaxTTL:          getEnvDuration("LOCK_MAX_TTL", time.Hour),
+
+		TrashRetention:     getEnvDuration("TRASH_RETENTION", 30*24*time.Hour),
+		TrashPurgeInterval: getEnvDuration("TRASH_PURGE_INTERVAL", time.Hour),
+
+		CollabAllowedOrigins: getEnvList("COLLAB_ALLOWED_ORIGINS"),
+	}
+}
@@ -0,0 +1,51 @@
+// Package docx converts ProseMirror documents to and from Office Open XML
+// word processing packages (.docx files).
+package docx
+
+import (
+	"encoding/xml"
+	"strconv"
+	"strings"
+)
+
+const (
+	relationshipsNS   = "http://schemas.openxmlformats.org/package/2006/relationships"
+	officeDocumentRel = "http://schemas.openxmlformats.org/officeDocument/2006/relationships/officeDocument"
+	stylesRel         = "http://schemas.openxmlformats.org/officeDocument/2006/relationships/styles"
+	numberingRel      = "http://schemas.openxmlformats.org/officeDocument/2006/relationships/numbering"
+	hyperlinkRel      = "http://schemas.openxmlformats.org/officeDocument/2006/relationships/hyperlink"
+	imageRel          = "http://schemas.openxmlformats.org/officeDocument/2006/relationships/image"
+	corePropertiesRel = "http://schemas.openxmlformats.org/package/2006/relationships/metadata/core-properties"
+	appPropertiesRel  = "http://schemas.openxmlformats.org/officeDocument/2006/relationships/extended-properties"
+)
+
+// escape writes text with the characters XML reserves escaped. Characters
+// XML cannot represent are replaced.
+func escape(b *strings.Builder, text string) {
+	xml.EscapeText(b, []byte(text))
+}
+
+func escapeString(text string) string {
+	var b strings.Builder
+	escape(&b, text)
+	return b.String()
+}
+
+func attrString(value any) string {
+	s, _ := value.(string)
+	return s
+}
+
+func attrInt(value any, fallback int) int {
+	switch v := value.(type) {
+	case float64:
+		return int(v)
+	case int:
+		return v
+	case string:
+		if n, err := strconv.Atoi(v); err == nil {
+			return n
+		}
+	}
+	return fallback
+}
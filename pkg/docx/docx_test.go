@@ -0,0 +1,215 @@
+package docx
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/emaforlin/ce-document-service/pkg/prosemirror"
+)
+
+var schema = prosemirror.DefaultSchema()
+
+// toJSON marshals a node without escaping HTML characters, so expected
+// documents can be written as they are
+func toJSON(t *testing.T, node *prosemirror.Node) string {
+	t.Helper()
+	var out bytes.Buffer
+	encoder := json.NewEncoder(&out)
+	encoder.SetEscapeHTML(false)
+	if err := encoder.Encode(node); err != nil {
+		t.Fatalf("marshal: %v", err)
+	}
+	return strings.TrimSpace(out.String())
+}
+
+// pack zips files into a package
+func pack(t *testing.T, files map[string]string) []byte {
+	t.Helper()
+	var out bytes.Buffer
+	zw := zip.NewWriter(&out)
+	for name, content := range files {
+		f, err := zw.Create(name)
+		if err != nil {
+			t.Fatalf("zip: %v", err)
+		}
+		f.Write([]byte(content))
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatalf("zip: %v", err)
+	}
+	return out.Bytes()
+}
+
+const (
+	documentStart = `<w:document xmlns:w="http://schemas.openxmlformats.org/wordprocessingml/2006/main" xmlns:r="http://schemas.openxmlformats.org/officeDocument/2006/relationships"><w:body>`
+	documentEnd   = `</w:body></w:document>`
+	relsStart     = `<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">`
+	relsEnd       = `</Relationships>`
+)
+
+func TestRenderParseRoundTrip(t *testing.T) {
+	tests := []struct {
+		name string
+		doc  string
+		// want is the parsed document, the input when empty
+		want string
+	}{
+		{
+			name: "empty document",
+			doc:  `{"type":"doc","content":[{"type":"paragraph"}]}`,
+		},
+		{
+			name: "paragraph",
+			doc:  `{"type":"doc","content":[{"type":"paragraph","content":[{"type":"text","text":"hello world"}]}]}`,
+		},
+		{
+			name: "heading and marks",
+			doc:  `{"type":"doc","content":[{"type":"heading","attrs":{"level":2},"content":[{"type":"text","text":"Title"}]},{"type":"paragraph","content":[{"type":"text","text":"a "},{"type":"text","marks":[{"type":"bold"},{"type":"italic"}],"text":"b"},{"type":"text","marks":[{"type":"underline"}],"text":"c"},{"type":"text","marks":[{"type":"strike"}],"text":"d"},{"type":"text","marks":[{"type":"subscript"}],"text":"e"},{"type":"text","marks":[{"type":"superscript"}],"text":"f"}]}]}`,
+		},
+		{
+			name: "link",
+			doc:  `{"type":"doc","content":[{"type":"paragraph","content":[{"type":"text","marks":[{"type":"link","attrs":{"href":"https://example.com/?a=1&b=2"}}],"text":"link"}]}]}`,
+		},
+		{
+			name: "nested and ordered lists",
+			doc:  `{"type":"doc","content":[{"type":"bulletList","content":[{"type":"listItem","content":[{"type":"paragraph","content":[{"type":"text","text":"one"}]},{"type":"bulletList","content":[{"type":"listItem","content":[{"type":"paragraph","content":[{"type":"text","text":"nested"}]}]}]}]}]},{"type":"orderedList","attrs":{"start":3},"content":[{"type":"listItem","content":[{"type":"paragraph","content":[{"type":"text","text":"three"}]}]}]}]}`,
+		},
+		{
+			name: "code block and quote",
+			doc:  `{"type":"doc","content":[{"type":"codeBlock","content":[{"type":"text","text":"a := 1\n\tb <- c"}]},{"type":"blockquote","content":[{"type":"paragraph","content":[{"type":"text","text":"quote"}]}]}]}`,
+		},
+		{
+			name: "special characters and breaks",
+			doc:  `{"type":"doc","content":[{"type":"paragraph","content":[{"type":"text","text":"a"},{"type":"hardBreak"},{"type":"text","text":"  b <&> \"c\""}]}]}`,
+		},
+		{
+			name: "table headers come back bold",
+			doc:  `{"type":"doc","content":[{"type":"table","content":[{"type":"tableRow","content":[{"type":"tableHeader","content":[{"type":"paragraph","content":[{"type":"text","text":"h"}]}]}]},{"type":"tableRow","content":[{"type":"tableCell","content":[{"type":"paragraph","content":[{"type":"text","text":"1"}]}]}]}]}]}`,
+			want: `{"type":"doc","content":[{"type":"table","content":[{"type":"tableRow","content":[{"type":"tableHeader","attrs":{"colspan":1,"rowspan":1},"content":[{"type":"paragraph","content":[{"type":"text","marks":[{"type":"bold"}],"text":"h"}]}]}]},{"type":"tableRow","content":[{"type":"tableCell","attrs":{"colspan":1,"rowspan":1},"content":[{"type":"paragraph","content":[{"type":"text","text":"1"}]}]}]}]},{"type":"paragraph"}]}`,
+		},
+		{
+			name: "rules and task lists are flattened",
+			doc:  `{"type":"doc","content":[{"type":"horizontalRule"},{"type":"taskList","content":[{"type":"taskItem","attrs":{"checked":true},"content":[{"type":"paragraph","content":[{"type":"text","text":"done"}]}]}]}]}`,
+			want: `{"type":"doc","content":[{"type":"paragraph"},{"type":"paragraph","content":[{"type":"text","text":"☒ done"}]}]}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			doc, err := schema.NodeFromJSON([]byte(tt.doc))
+			if err != nil {
+				t.Fatalf("invalid test document: %v", err)
+			}
+			data, err := Render(schema, doc, Options{Title: "Notes & <more>", Created: time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)})
+			if err != nil {
+				t.Fatalf("Render: %v", err)
+			}
+			parsed, title, err := Parse(data)
+			if err != nil {
+				t.Fatalf("Parse: %v", err)
+			}
+			if title != "Notes & <more>" {
+				t.Errorf("title = %q, want %q", title, "Notes & <more>")
+			}
+			if err := schema.Check(parsed); err != nil {
+				t.Errorf("parsed document is invalid: %v", err)
+			}
+			want := tt.want
+			if want == "" {
+				want = tt.doc
+			}
+			if got := toJSON(t, parsed); got != want {
+				t.Errorf("round trip =\n%s\nwant\n%s", got, want)
+			}
+		})
+	}
+}
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		name  string
+		files map[string]string
+		want  string
+	}{
+		{
+			name:  "no paragraphs",
+			files: map[string]string{"word/document.xml": documentStart + documentEnd},
+			want:  `{"type":"doc","content":[{"type":"paragraph"}]}`,
+		},
+		{
+			name: "run properties and tracked changes",
+			files: map[string]string{
+				"word/document.xml": documentStart + `<w:p><w:r><w:rPr><w:b/><w:i w:val="0"/></w:rPr><w:t>bold</w:t></w:r><w:r><w:t xml:space="preserve"> plain</w:t></w:r><w:del><w:r><w:delText>gone</w:delText></w:r></w:del><w:ins><w:r><w:t>!</w:t></w:r></w:ins></w:p>` + documentEnd,
+			},
+			want: `{"type":"doc","content":[{"type":"paragraph","content":[{"type":"text","marks":[{"type":"bold"}],"text":"bold"},{"type":"text","text":" plain!"}]}]}`,
+		},
+		{
+			name: "hyperlink fields and unsafe links",
+			files: map[string]string{
+				"word/document.xml":            documentStart + `<w:p><w:r><w:fldChar w:fldCharType="begin"/></w:r><w:r><w:instrText> HYPERLINK "https://example.com" </w:instrText></w:r><w:r><w:fldChar w:fldCharType="separate"/></w:r><w:r><w:t>site</w:t></w:r><w:r><w:fldChar w:fldCharType="end"/></w:r><w:hyperlink r:id="rId9"><w:r><w:t>bad</w:t></w:r></w:hyperlink></w:p>` + documentEnd,
+				"word/_rels/document.xml.rels": relsStart + `<Relationship Id="rId9" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/hyperlink" Target="javascript:alert(1)" TargetMode="External"/>` + relsEnd,
+			},
+			want: `{"type":"doc","content":[{"type":"paragraph","content":[{"type":"text","marks":[{"type":"link","attrs":{"href":"https://example.com"}}],"text":"site"},{"type":"text","text":"bad"}]}]}`,
+		},
+		{
+			name: "localized heading style and numbering",
+			files: map[string]string{
+				"word/document.xml":            documentStart + `<w:p><w:pPr><w:pStyle w:val="Titre1"/></w:pPr><w:r><w:t>Heading</w:t></w:r></w:p><w:p><w:pPr><w:numPr><w:ilvl w:val="0"/><w:numId w:val="1"/></w:numPr></w:pPr><w:r><w:t>item</w:t></w:r></w:p>` + documentEnd,
+				"word/_rels/document.xml.rels": relsStart + `<Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/styles" Target="styles.xml"/><Relationship Id="rId2" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/numbering" Target="numbering.xml"/>` + relsEnd,
+				"word/styles.xml":              `<w:styles xmlns:w="http://schemas.openxmlformats.org/wordprocessingml/2006/main"><w:style w:type="paragraph" w:styleId="Titre1"><w:name w:val="heading 1"/></w:style></w:styles>`,
+				"word/numbering.xml":           `<w:numbering xmlns:w="http://schemas.openxmlformats.org/wordprocessingml/2006/main"><w:abstractNum w:abstractNumId="0"><w:lvl w:ilvl="0"><w:start w:val="1"/><w:numFmt w:val="decimal"/></w:lvl></w:abstractNum><w:num w:numId="1"><w:abstractNumId w:val="0"/></w:num></w:numbering>`,
+			},
+			want: `{"type":"doc","content":[{"type":"heading","attrs":{"level":1},"content":[{"type":"text","text":"Heading"}]},{"type":"orderedList","content":[{"type":"listItem","content":[{"type":"paragraph","content":[{"type":"text","text":"item"}]}]}]}]}`,
+		},
+		{
+			name: "strict package with a custom main part",
+			files: map[string]string{
+				"_rels/.rels":  relsStart + `<Relationship Id="rId1" Type="http://purl.oclc.org/ooxml/officeDocument/relationships/officeDocument" Target="/doc/main.xml"/>` + relsEnd,
+				"doc/main.xml": documentStart + `<w:p><w:r><w:t>strict</w:t></w:r></w:p>` + documentEnd,
+			},
+			want: `{"type":"doc","content":[{"type":"paragraph","content":[{"type":"text","text":"strict"}]}]}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			parsed, _, err := Parse(pack(t, tt.files))
+			if err != nil {
+				t.Fatalf("Parse: %v", err)
+			}
+			if err := schema.Check(parsed); err != nil {
+				t.Errorf("parsed document is invalid: %v", err)
+			}
+			if got := toJSON(t, parsed); got != tt.want {
+				t.Errorf("Parse() =\n%s\nwant\n%s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseMalformed(t *testing.T) {
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{"not a zip", []byte("not a zip")},
+		{"empty", nil},
+		{"no main part", pack(t, map[string]string{"other.xml": "<a/>"})},
+		{"no body", pack(t, map[string]string{"word/document.xml": `<w:document xmlns:w="x"></w:document>`})},
+		{"truncated XML", pack(t, map[string]string{"word/document.xml": `<w:document><w:body><w:p>`})},
+		{"missing main part", pack(t, map[string]string{"_rels/.rels": relsStart + `<Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/officeDocument" Target="missing.xml"/>` + relsEnd})},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if doc, _, err := Parse(tt.data); !errors.Is(err, ErrInvalidPackage) {
+				t.Errorf("Parse() = %v, %v, want %v", doc, err, ErrInvalidPackage)
+			}
+		})
+	}
+}
@@ -0,0 +1,755 @@
+package docx
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"io"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/emaforlin/ce-document-service/pkg/prosemirror"
+)
+
+// maxPartSize bounds the uncompressed size of the parts read from a package,
+// zip files compress XML well enough to hide gigabytes
+const maxPartSize = 64 << 20
+
+var ErrInvalidPackage = errors.New("invalid .docx package")
+
+// Parse converts the body of a .docx package into a ProseMirror document
+// using the node and mark types of the default schema. It returns the title
+// stored in the package properties, if any. Paragraphs, headings, lists,
+// tables, code, quotes, bold, italic, underline, strikethrough, sub and
+// superscripts and hyperlinks are kept; images and other objects are
+// dropped.
+func Parse(data []byte) (*prosemirror.Node, string, error) {
+	archive, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return nil, "", ErrInvalidPackage
+	}
+	p := &pkg{files: make(map[string]*zip.File)}
+	for _, f := range archive.File {
+		p.files[strings.TrimPrefix(f.Name, "/")] = f
+	}
+
+	mainPart := "word/document.xml"
+	if rels, err := p.rels(""); err == nil {
+		for _, rel := range rels {
+			if rel.kind == officeDocumentRel {
+				mainPart = strings.TrimPrefix(rel.target, "/")
+			}
+		}
+	}
+	document, err := p.element(mainPart)
+	if err != nil {
+		return nil, "", err
+	}
+	body := document.child("body")
+	if body == nil {
+		return nil, "", ErrInvalidPackage
+	}
+
+	r := &reader{
+		links:     make(map[string]string),
+		styles:    make(map[string]*paragraphStyle),
+		numbering: make(map[string]map[int]numberingLevel),
+	}
+	rels, _ := p.rels(mainPart)
+	dir := path.Dir(mainPart)
+	for _, rel := range rels {
+		switch rel.kind {
+		case hyperlinkRel:
+			r.links[rel.id] = rel.target
+		case stylesRel:
+			if styles, err := p.element(path.Join(dir, rel.target)); err == nil {
+				r.readStyles(styles)
+			}
+		case numberingRel:
+			if numbering, err := p.element(path.Join(dir, rel.target)); err == nil {
+				r.readNumbering(numbering)
+			}
+		}
+	}
+
+	doc := &prosemirror.Node{Type: "doc", Content: r.blocks(body.Children)}
+	if len(doc.Content) == 0 {
+		doc.Content = []*prosemirror.Node{{Type: "paragraph"}}
+	}
+
+	title := ""
+	if core, err := p.element("docProps/core.xml"); err == nil {
+		if t := core.child("title"); t != nil {
+			title = strings.TrimSpace(t.Text)
+		}
+	}
+	return doc, title, nil
+}
+
+// element is a generic XML element. Names are compared without namespaces,
+// which also accepts the strict variant of the format.
+type element struct {
+	XMLName  xml.Name
+	Attrs    []xml.Attr `xml:",any,attr"`
+	Children []element  `xml:",any"`
+	Text     string     `xml:",chardata"`
+}
+
+func (e *element) name() string {
+	return e.XMLName.Local
+}
+
+func (e *element) attr(name string) string {
+	for _, a := range e.Attrs {
+		if a.Name.Local == name {
+			return a.Value
+		}
+	}
+	return ""
+}
+
+func (e *element) child(name string) *element {
+	if e == nil {
+		return nil
+	}
+	for i := range e.Children {
+		if e.Children[i].name() == name {
+			return &e.Children[i]
+		}
+	}
+	return nil
+}
+
+// value returns the w:val attribute of a child element, ok reports whether
+// the child exists
+func (e *element) value(name string) (string, bool) {
+	child := e.child(name)
+	if child == nil {
+		return "", false
+	}
+	return child.attr("val"), true
+}
+
+// toggle reads an on/off property like w:b, which is on unless its value
+// says otherwise
+func (e *element) toggle(name string) bool {
+	value, ok := e.value(name)
+	return ok && value != "0" && value != "false" && value != "off" && value != "none"
+}
+
+type pkg struct {
+	files map[string]*zip.File
+}
+
+func (p *pkg) element(name string) (*element, error) {
+	f, exists := p.files[name]
+	if !exists {
+		return nil, ErrInvalidPackage
+	}
+	rc, err := f.Open()
+	if err != nil {
+		return nil, ErrInvalidPackage
+	}
+	defer rc.Close()
+	data, err := io.ReadAll(io.LimitReader(rc, maxPartSize+1))
+	if err != nil || len(data) > maxPartSize {
+		return nil, ErrInvalidPackage
+	}
+	var e element
+	if err := xml.Unmarshal(data, &e); err != nil {
+		return nil, ErrInvalidPackage
+	}
+	return &e, nil
+}
+
+// rels reads the relationships of a part, "" is the package itself
+func (p *pkg) rels(part string) ([]relationship, error) {
+	name := "_rels/.rels"
+	if part != "" {
+		name = path.Join(path.Dir(part), "_rels", path.Base(part)+".rels")
+	}
+	e, err := p.element(name)
+	if err != nil {
+		return nil, err
+	}
+	var rels []relationship
+	for _, child := range e.Children {
+		rels = append(rels, relationship{
+			id:       child.attr("Id"),
+			kind:     strictRelationship(child.attr("Type")),
+			target:   child.attr("Target"),
+			external: child.attr("TargetMode") == "External",
+		})
+	}
+	return rels, nil
+}
+
+// strictRelationship maps the relationship types of strict packages to the
+// transitional ones
+func strictRelationship(kind string) string {
+	const strict = "http://purl.oclc.org/ooxml/officeDocument/relationships/"
+	if rest, ok := strings.CutPrefix(kind, strict); ok {
+		return "http://schemas.openxmlformats.org/officeDocument/2006/relationships/" + rest
+	}
+	return kind
+}
+
+type reader struct {
+	// links maps relationship IDs to hyperlink targets
+	links     map[string]string
+	styles    map[string]*paragraphStyle
+	numbering map[string]map[int]numberingLevel
+}
+
+// paragraphStyle is what a paragraph style says about the block it makes
+type paragraphStyle struct {
+	name     string
+	basedOn  string
+	outline  int
+	numID    string
+	numLevel int
+}
+
+type numberingLevel struct {
+	ordered bool
+	start   int
+}
+
+var headingName = regexp.MustCompile(`^heading\s*([1-6])$`)
+
+func (r *reader) readStyles(styles *element) {
+	for _, s := range styles.Children {
+		if s.name() != "style" || s.attr("type") != "paragraph" {
+			continue
+		}
+		style := &paragraphStyle{outline: -1}
+		style.name, _ = s.value("name")
+		style.basedOn, _ = s.value("basedOn")
+		if pPr := s.child("pPr"); pPr != nil {
+			if level, ok := pPr.value("outlineLvl"); ok {
+				style.outline, _ = strconv.Atoi(level)
+			}
+			if numPr := pPr.child("numPr"); numPr != nil {
+				style.numID, _ = numPr.value("numId")
+				level, _ := numPr.value("ilvl")
+				style.numLevel, _ = strconv.Atoi(level)
+			}
+		}
+		r.styles[s.attr("styleId")] = style
+	}
+}
+
+func (r *reader) readNumbering(numbering *element) {
+	abstracts := make(map[string]map[int]numberingLevel)
+	for _, n := range numbering.Children {
+		if n.name() != "abstractNum" {
+			continue
+		}
+		levels := make(map[int]numberingLevel)
+		for _, lvl := range n.Children {
+			if lvl.name() != "lvl" {
+				continue
+			}
+			level, _ := strconv.Atoi(lvl.attr("ilvl"))
+			format, _ := lvl.value("numFmt")
+			start, _ := lvl.value("start")
+			l := numberingLevel{ordered: format != "bullet" && format != "none" && format != ""}
+			l.start, _ = strconv.Atoi(start)
+			levels[level] = l
+		}
+		abstracts[n.attr("abstractNumId")] = levels
+	}
+	for _, n := range numbering.Children {
+		if n.name() != "num" {
+			continue
+		}
+		abstractID, _ := n.value("abstractNumId")
+		levels := make(map[int]numberingLevel)
+		for level, l := range abstracts[abstractID] {
+			levels[level] = l
+		}
+		for _, override := range n.Children {
+			if override.name() != "lvlOverride" {
+				continue
+			}
+			level, _ := strconv.Atoi(override.attr("ilvl"))
+			if start, ok := override.value("startOverride"); ok {
+				l := levels[level]
+				l.start, _ = strconv.Atoi(start)
+				levels[level] = l
+			}
+		}
+		r.numbering[n.attr("numId")] = levels
+	}
+}
+
+// style resolves a property through the basedOn chain of a style
+func (r *reader) style(id string, property func(*paragraphStyle) bool) *paragraphStyle {
+	for depth := 0; id != "" && depth < 16; depth++ {
+		style, exists := r.styles[id]
+		if !exists {
+			return nil
+		}
+		if property(style) {
+			return style
+		}
+		id = style.basedOn
+	}
+	return nil
+}
+
+// blockKind is how a paragraph is converted
+type blockKind int
+
+const (
+	kindParagraph blockKind = iota
+	kindHeading
+	kindListItem
+	kindCode
+	kindQuote
+)
+
+type paragraphInfo struct {
+	kind    blockKind
+	level   int
+	ordered bool
+	start   int
+	numID   string
+	align   string
+}
+
+// classify works out from the paragraph properties and its style whether a
+// paragraph is a heading, a list item, code or a quote
+func (r *reader) classify(p *element) paragraphInfo {
+	info := paragraphInfo{}
+	pPr := p.child("pPr")
+	styleID, _ := pPr.value("pStyle")
+	name := ""
+	if style := r.styles[styleID]; style != nil {
+		name = strings.ToLower(style.name)
+	} else {
+		name = strings.ToLower(styleID)
+	}
+	if jc, ok := pPr.value("jc"); ok {
+		switch jc {
+		case "center":
+			info.align = "center"
+		case "right", "end":
+			info.align = "right"
+		case "both", "distribute":
+			info.align = "justify"
+		}
+	}
+
+	numID, level := "", 0
+	if numPr := pPr.child("numPr"); numPr != nil {
+		numID, _ = numPr.value("numId")
+		ilvl, _ := numPr.value("ilvl")
+		level, _ = strconv.Atoi(ilvl)
+	} else if style := r.style(styleID, func(s *paragraphStyle) bool { return s.numID != "" }); style != nil {
+		numID, level = style.numID, style.numLevel
+	}
+	if numID != "" && numID != "0" {
+		info.kind = kindListItem
+		info.numID = numID
+		info.level = min(max(level, 0), 8)
+		if l, exists := r.numbering[numID][level]; exists {
+			info.ordered = l.ordered
+			info.start = l.start
+		}
+		return info
+	}
+
+	outline := -1
+	if value, ok := pPr.value("outlineLvl"); ok {
+		outline, _ = strconv.Atoi(value)
+	} else if style := r.style(styleID, func(s *paragraphStyle) bool { return s.outline >= 0 }); style != nil {
+		outline = style.outline
+	}
+	switch {
+	case name == "title":
+		info.kind, info.level = kindHeading, 1
+	case headingName.MatchString(name):
+		info.kind = kindHeading
+		info.level, _ = strconv.Atoi(headingName.FindStringSubmatch(name)[1])
+	case outline >= 0 && outline < 6:
+		info.kind, info.level = kindHeading, outline+1
+	case strings.Contains(name, "code") || strings.Contains(name, "preformatted") || strings.Contains(name, "source"):
+		info.kind = kindCode
+	case strings.Contains(name, "quote"):
+		info.kind = kindQuote
+	}
+	return info
+}
+
+// blocks converts the block level elements of the body, a table cell or a
+// content control
+func (r *reader) blocks(elements []element) []*prosemirror.Node {
+	var blocks []*prosemirror.Node
+	// lists are the open lists by level, code and quote are the blocks
+	// consecutive paragraphs are being merged into
+	var lists []*prosemirror.Node
+	var listIDs []string
+	var code, quote *prosemirror.Node
+
+	for i := range elements {
+		e := &elements[i]
+		switch e.name() {
+		case "p":
+			info := r.classify(e)
+			if info.kind != kindListItem {
+				lists, listIDs = nil, nil
+			}
+			if info.kind != kindCode {
+				code = nil
+			}
+			if info.kind != kindQuote {
+				quote = nil
+			}
+
+			switch info.kind {
+			case kindListItem:
+				item := &prosemirror.Node{Type: "listItem", Content: []*prosemirror.Node{r.paragraph(e, "paragraph", info)}}
+				kind := "bulletList"
+				if info.ordered {
+					kind = "orderedList"
+				}
+				for len(lists) > info.level+1 {
+					lists, listIDs = lists[:len(lists)-1], listIDs[:len(listIDs)-1]
+				}
+				// A list of another kind at the same level starts a new list
+				if len(lists) == info.level+1 && (lists[info.level].Type != kind || listIDs[info.level] != info.numID) {
+					lists, listIDs = lists[:info.level], listIDs[:info.level]
+				}
+				for len(lists) < info.level+1 {
+					list := &prosemirror.Node{Type: kind}
+					if kind == "orderedList" && info.start > 1 {
+						list.Attrs = map[string]any{"start": float64(info.start)}
+					}
+					if len(lists) == 0 {
+						blocks = append(blocks, list)
+					} else {
+						parent := lists[len(lists)-1]
+						if len(parent.Content) == 0 {
+							parent.Content = append(parent.Content, &prosemirror.Node{Type: "listItem", Content: []*prosemirror.Node{{Type: "paragraph"}}})
+						}
+						last := parent.Content[len(parent.Content)-1]
+						last.Content = append(last.Content, list)
+					}
+					lists, listIDs = append(lists, list), append(listIDs, info.numID)
+				}
+				top := lists[len(lists)-1]
+				top.Content = append(top.Content, item)
+			case kindHeading:
+				heading := r.paragraph(e, "heading", info)
+				heading.Attrs["level"] = float64(min(max(info.level, 1), 6))
+				blocks = append(blocks, heading)
+			case kindCode:
+				text := r.plainText(e)
+				if code == nil {
+					code = &prosemirror.Node{Type: "codeBlock"}
+					blocks = append(blocks, code)
+				} else {
+					text = "\n" + text
+				}
+				if text != "" {
+					if len(code.Content) == 0 {
+						code.Content = []*prosemirror.Node{{Type: "text", Text: text}}
+					} else {
+						code.Content[0].Text += text
+					}
+				}
+			case kindQuote:
+				if quote == nil {
+					quote = &prosemirror.Node{Type: "blockquote"}
+					blocks = append(blocks, quote)
+				}
+				quote.Content = append(quote.Content, r.paragraph(e, "paragraph", info))
+			default:
+				blocks = append(blocks, r.paragraph(e, "paragraph", info))
+			}
+		case "tbl":
+			lists, listIDs, code, quote = nil, nil, nil, nil
+			if table := r.table(e); table != nil {
+				blocks = append(blocks, table)
+			}
+		case "sdt":
+			lists, listIDs, code, quote = nil, nil, nil, nil
+			blocks = append(blocks, r.blocks(e.child("sdtContent").childrenOrNil())...)
+		case "customXml", "ins", "moveTo":
+			lists, listIDs, code, quote = nil, nil, nil, nil
+			blocks = append(blocks, r.blocks(e.Children)...)
+		}
+	}
+	return blocks
+}
+
+func (e *element) childrenOrNil() []element {
+	if e == nil {
+		return nil
+	}
+	return e.Children
+}
+
+func (r *reader) paragraph(p *element, nodeType string, info paragraphInfo) *prosemirror.Node {
+	node := &prosemirror.Node{Type: nodeType, Attrs: map[string]any{}, Content: r.inline(p)}
+	if info.align != "" {
+		node.Attrs["textAlign"] = info.align
+	}
+	if len(node.Attrs) == 0 && nodeType == "paragraph" {
+		node.Attrs = nil
+	}
+	return node
+}
+
+// run is a run of text with the hyperlink around it
+type run struct {
+	element *element
+	link    string
+}
+
+// collectRuns flattens the runs of a paragraph, looking into hyperlinks,
+// tracked insertions and other wrappers. Deleted text is skipped.
+func (r *reader) collectRuns(e *element, link string, runs *[]run) {
+	for i := range e.Children {
+		child := &e.Children[i]
+		switch child.name() {
+		case "r":
+			*runs = append(*runs, run{element: child, link: link})
+		case "hyperlink":
+			target := link
+			if href, exists := r.links[child.attr("id")]; exists {
+				target = href
+			}
+			r.collectRuns(child, target, runs)
+		case "sdt":
+			if content := child.child("sdtContent"); content != nil {
+				r.collectRuns(content, link, runs)
+			}
+		case "fldSimple":
+			target := link
+			if href := hyperlinkField(child.attr("instr")); href != "" {
+				target = href
+			}
+			r.collectRuns(child, target, runs)
+		case "ins", "moveTo", "smartTag", "customXml":
+			r.collectRuns(child, link, runs)
+		}
+	}
+}
+
+var hyperlinkInstr = regexp.MustCompile(`^\s*HYPERLINK\s+(?:\\[a-z]\s+)*"([^"]+)"`)
+
+// hyperlinkField returns the target of a HYPERLINK field instruction
+func hyperlinkField(instr string) string {
+	if match := hyperlinkInstr.FindStringSubmatch(instr); match != nil {
+		return match[1]
+	}
+	return ""
+}
+
+// inline converts the runs of a paragraph into text nodes with marks. Field
+// codes are skipped, the results of HYPERLINK fields become links.
+func (r *reader) inline(p *element) []*prosemirror.Node {
+	var runs []run
+	r.collectRuns(p, "", &runs)
+
+	var nodes []*prosemirror.Node
+	add := func(text string, marks []*prosemirror.Mark) {
+		if text == "" {
+			return
+		}
+		if n := len(nodes); n > 0 && nodes[n-1].IsText() && sameMarks(nodes[n-1].Marks, marks) {
+			nodes[n-1].Text += text
+			return
+		}
+		nodes = append(nodes, &prosemirror.Node{Type: "text", Text: text, Marks: marks})
+	}
+
+	// Complex fields are made of runs: begin, the instruction, separate, the
+	// displayed result and end
+	const (
+		noField = iota
+		fieldInstr
+		fieldResult
+	)
+	field, instr, fieldLink := noField, "", ""
+	for _, current := range runs {
+		marks := runMarks(current.element.child("rPr"))
+		link := current.link
+		if field == fieldResult && fieldLink != "" {
+			link = fieldLink
+		}
+		if href, ok := prosemirror.SafeLinkURL(link); ok && link != "" {
+			marks = append(marks, &prosemirror.Mark{Type: "link", Attrs: map[string]any{"href": href}})
+		}
+
+		for i := range current.element.Children {
+			c := &current.element.Children[i]
+			switch c.name() {
+			case "fldChar":
+				switch c.attr("fldCharType") {
+				case "begin":
+					field, instr = fieldInstr, ""
+				case "separate":
+					field, fieldLink = fieldResult, hyperlinkField(instr)
+				case "end":
+					field, fieldLink = noField, ""
+				}
+			case "instrText":
+				instr += c.Text
+			}
+			if field == fieldInstr {
+				continue
+			}
+			switch c.name() {
+			case "t":
+				add(c.Text, marks)
+			case "tab", "ptab":
+				add("\t", marks)
+			case "noBreakHyphen", "softHyphen":
+				if c.name() == "noBreakHyphen" {
+					add("-", marks)
+				}
+			case "br", "cr":
+				// Page and column breaks have no equivalent
+				if kind := c.attr("type"); kind == "" || kind == "textWrapping" {
+					nodes = append(nodes, &prosemirror.Node{Type: "hardBreak"})
+				}
+			case "sym":
+				if code, err := strconv.ParseUint(c.attr("char"), 16, 32); err == nil && code >= 0x20 && (code < 0xF000 || code > 0xF0FF) {
+					add(string(rune(code)), marks)
+				}
+			}
+		}
+	}
+	return nodes
+}
+
+// runMarks converts run properties into marks
+func runMarks(rPr *element) []*prosemirror.Mark {
+	if rPr == nil {
+		return nil
+	}
+	var marks []*prosemirror.Mark
+	if rPr.toggle("b") {
+		marks = append(marks, &prosemirror.Mark{Type: "bold"})
+	}
+	if rPr.toggle("i") {
+		marks = append(marks, &prosemirror.Mark{Type: "italic"})
+	}
+	if rPr.toggle("u") {
+		marks = append(marks, &prosemirror.Mark{Type: "underline"})
+	}
+	if rPr.toggle("strike") || rPr.toggle("dstrike") {
+		marks = append(marks, &prosemirror.Mark{Type: "strike"})
+	}
+	if value, ok := rPr.value("highlight"); ok && value != "none" {
+		marks = append(marks, &prosemirror.Mark{Type: "highlight"})
+	}
+	if style, _ := rPr.value("rStyle"); strings.Contains(strings.ToLower(style), "code") {
+		marks = append(marks, &prosemirror.Mark{Type: "code"})
+	}
+	switch value, _ := rPr.value("vertAlign"); value {
+	case "superscript":
+		marks = append(marks, &prosemirror.Mark{Type: "superscript"})
+	case "subscript":
+		marks = append(marks, &prosemirror.Mark{Type: "subscript"})
+	}
+	return marks
+}
+
+func sameMarks(a, b []*prosemirror.Mark) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i].Type != b[i].Type || attrString(a[i].Attr("href")) != attrString(b[i].Attr("href")) {
+			return false
+		}
+	}
+	return true
+}
+
+// plainText returns the text of a paragraph without marks, for code blocks
+func (r *reader) plainText(p *element) string {
+	var b strings.Builder
+	for _, node := range r.inline(p) {
+		if node.Type == "hardBreak" {
+			b.WriteString("\n")
+		}
+		b.WriteString(node.Text)
+	}
+	return b.String()
+}
+
+// table converts a table, header rows become tableHeader cells and cells
+// merged vertically become a cell with a rowspan
+func (r *reader) table(tbl *element) *prosemirror.Node {
+	table := &prosemirror.Node{Type: "table"}
+	// restarts holds, by grid column, the cell a vertical merge started in
+	restarts := make(map[int]*prosemirror.Node)
+	for i := range tbl.Children {
+		tr := &tbl.Children[i]
+		if tr.name() != "tr" {
+			continue
+		}
+		header := tr.child("trPr").toggle("tblHeader")
+		row := &prosemirror.Node{Type: "tableRow"}
+		column := 0
+		for j := range tr.Children {
+			tc := &tr.Children[j]
+			if tc.name() != "tc" {
+				continue
+			}
+			tcPr := tc.child("tcPr")
+			span := 1
+			if value, ok := tcPr.value("gridSpan"); ok {
+				span = max(1, atoi(value))
+			}
+			vMerge, merged := tcPr.value("vMerge")
+			if merged && vMerge != "restart" {
+				// The cell continues the one above
+				if start := restarts[column]; start != nil {
+					start.Attrs["rowspan"] = float64(attrInt(start.Attrs["rowspan"], 1) + 1)
+				}
+				column += span
+				continue
+			}
+
+			cellType := "tableCell"
+			if header {
+				cellType = "tableHeader"
+			}
+			content := r.blocks(tc.Children)
+			if len(content) == 0 {
+				content = []*prosemirror.Node{{Type: "paragraph"}}
+			}
+			cell := &prosemirror.Node{
+				Type:    cellType,
+				Attrs:   map[string]any{"colspan": float64(span), "rowspan": float64(1)},
+				Content: content,
+			}
+			if merged {
+				restarts[column] = cell
+			} else {
+				delete(restarts, column)
+			}
+			row.Content = append(row.Content, cell)
+			column += span
+		}
+		table.Content = append(table.Content, row)
+	}
+	if len(table.Content) == 0 {
+		return nil
+	}
+	return table
+}
+
+func atoi(value string) int {
+	n, _ := strconv.Atoi(value)
+	return n
+}
@@ -0,0 +1,761 @@
+package docx
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/base64"
+	"encoding/xml"
+	"fmt"
+	"image"
+	_ "image/gif"
+	_ "image/jpeg"
+	_ "image/png"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/emaforlin/ce-document-service/pkg/prosemirror"
+)
+
+// Options describe the package written by Render
+type Options struct {
+	Title   string
+	Created time.Time
+	Updated time.Time
+}
+
+const (
+	// contentWidth is the width between the margins of an A4 page with one
+	// inch margins, in twentieths of a point
+	contentWidth = 9026
+	// emuPerPixel converts pixels at 96 DPI to the English Metric Units
+	// DrawingML sizes are given in
+	emuPerPixel = 9525
+	maxImageEMU = contentWidth * 635
+	listIndent  = 720
+)
+
+// Render writes a ProseMirror document as a .docx package
+func Render(schema *prosemirror.Schema, content *prosemirror.Node, options Options) ([]byte, error) {
+	w := &writer{
+		schema: schema,
+		links:  make(map[string]string),
+		images: make(map[string]*media),
+		nextID: 3,
+	}
+	w.blocks(content.Content, paragraphProps{})
+	if w.body.Len() == 0 {
+		w.body.WriteString("<w:p/>")
+	}
+
+	var out bytes.Buffer
+	zw := zip.NewWriter(&out)
+	parts := []struct {
+		name, content string
+	}{
+		{"[Content_Types].xml", w.contentTypes()},
+		{"_rels/.rels", packageRels},
+		{"docProps/core.xml", coreProperties(options)},
+		{"docProps/app.xml", appProperties},
+		{"word/document.xml", documentHeader + w.body.String() + documentFooter},
+		{"word/styles.xml", styles},
+		{"word/numbering.xml", w.numbering()},
+		{"word/_rels/document.xml.rels", w.documentRels()},
+	}
+	for _, part := range parts {
+		f, err := zw.Create(part.name)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := f.Write([]byte(part.content)); err != nil {
+			return nil, err
+		}
+	}
+	for _, m := range w.media {
+		// Images are compressed already
+		f, err := zw.CreateHeader(&zip.FileHeader{Name: "word/media/" + m.name, Method: zip.Store})
+		if err != nil {
+			return nil, err
+		}
+		if _, err := f.Write(m.data); err != nil {
+			return nil, err
+		}
+	}
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+	return out.Bytes(), nil
+}
+
+type writer struct {
+	schema *prosemirror.Schema
+	body   strings.Builder
+
+	// rels are the relationships of the main part past the styles (rId1) and
+	// the numbering (rId2)
+	rels   []relationship
+	nextID int
+	// links maps hyperlink targets to relationship IDs
+	links  map[string]string
+	images map[string]*media
+	media  []*media
+
+	nums []num
+	// listNums are the numbering instances of the list being written, by kind
+	listNums map[string]int
+
+	drawings int
+}
+
+type relationship struct {
+	id, kind, target string
+	external         bool
+}
+
+type media struct {
+	name   string
+	relID  string
+	data   []byte
+	width  int
+	height int
+}
+
+// num is a numbering instance, lists restart their numbers by using a new one
+type num struct {
+	abstract int
+	starts   map[int]int
+}
+
+// paragraphProps are the paragraph properties inherited from the blocks
+// around a paragraph
+type paragraphProps struct {
+	style string
+	// indent is the left indentation in twentieths of a point
+	indent int
+	// level is the nesting depth of lists around the paragraph
+	level int
+	// numID numbers the next paragraph as a list item, it is not inherited by
+	// the following paragraphs of the item
+	numID int
+	bold  bool
+}
+
+const (
+	bulletAbstract = iota
+	orderedAbstract
+)
+
+func (w *writer) addRel(kind, target string, external bool) string {
+	id := "rId" + strconv.Itoa(w.nextID)
+	w.nextID++
+	w.rels = append(w.rels, relationship{id: id, kind: kind, target: target, external: external})
+	return id
+}
+
+func (w *writer) blocks(nodes []*prosemirror.Node, props paragraphProps) {
+	for _, node := range nodes {
+		w.block(node, props)
+		props.numID = 0
+	}
+}
+
+func (w *writer) block(node *prosemirror.Node, props paragraphProps) {
+	switch node.Type {
+	case "paragraph":
+		w.paragraph(node, props)
+	case "heading":
+		level := min(max(attrInt(node.Attr("level"), 1), 1), 6)
+		props.style = "Heading" + strconv.Itoa(level)
+		w.paragraph(node, props)
+	case "blockquote":
+		props.style = "Quote"
+		props.indent += listIndent
+		w.blocks(node.Content, props)
+	case "bulletList", "orderedList":
+		w.list(node, props)
+	case "taskList":
+		props.level++
+		props.indent += listIndent
+		for _, item := range node.Content {
+			box := "☐ "
+			if checked, _ := item.Attr("checked").(bool); checked {
+				box = "☒ "
+			}
+			for i, child := range item.Content {
+				if i == 0 && child.Type == "paragraph" {
+					w.taskParagraph(child, props, box)
+					continue
+				}
+				w.block(child, props)
+			}
+		}
+	case "codeBlock":
+		props.style = "Code"
+		w.codeBlock(node, props)
+	case "horizontalRule":
+		w.body.WriteString(`<w:p><w:pPr><w:pBdr><w:bottom w:val="single" w:sz="6" w:space="1" w:color="auto"/></w:pBdr></w:pPr></w:p>`)
+	case "image":
+		w.image(node, props)
+	case "table":
+		w.table(node, props)
+	default:
+		if len(node.Content) > 0 && w.schema.IsInline(node.Content[0]) {
+			w.paragraph(node, props)
+			return
+		}
+		w.blocks(node.Content, props)
+	}
+}
+
+// list numbers the first paragraph of each item, the other blocks of an
+// item are indented to line up with it
+func (w *writer) list(node *prosemirror.Node, props paragraphProps) {
+	top := w.listNums == nil
+	if top {
+		w.listNums = make(map[string]int)
+		defer func() { w.listNums = nil }()
+	}
+
+	abstract := bulletAbstract
+	if node.Type == "orderedList" {
+		abstract = orderedAbstract
+	}
+	id, exists := w.listNums[node.Type]
+	if !exists {
+		w.nums = append(w.nums, num{abstract: abstract, starts: make(map[int]int)})
+		id = len(w.nums)
+		w.listNums[node.Type] = id
+	}
+	if !top {
+		props.level++
+	}
+	if start := attrInt(node.Attr("start"), 1); abstract == orderedAbstract && start != 1 {
+		if _, set := w.nums[id-1].starts[props.level]; !set {
+			w.nums[id-1].starts[props.level] = start
+		}
+	}
+	props.style = "ListParagraph"
+	props.indent = 0
+	for _, item := range node.Content {
+		itemProps := props
+		itemProps.numID = id
+		w.blocks(item.Content, itemProps)
+	}
+}
+
+func (w *writer) paragraph(node *prosemirror.Node, props paragraphProps) {
+	w.body.WriteString("<w:p>")
+	w.writeParagraphProps(node, props)
+	w.inline(node.Content, props.bold)
+	w.body.WriteString("</w:p>")
+}
+
+func (w *writer) taskParagraph(node *prosemirror.Node, props paragraphProps, box string) {
+	w.body.WriteString("<w:p>")
+	w.writeParagraphProps(node, props)
+	w.run(nil, box, props.bold)
+	w.inline(node.Content, props.bold)
+	w.body.WriteString("</w:p>")
+}
+
+// writeParagraphProps writes the w:pPr element, its children follow the order
+// of the schema
+func (w *writer) writeParagraphProps(node *prosemirror.Node, props paragraphProps) {
+	var b strings.Builder
+	if props.style != "" {
+		b.WriteString(`<w:pStyle w:val="` + props.style + `"/>`)
+	}
+	switch {
+	case props.numID != 0:
+		fmt.Fprintf(&b, `<w:numPr><w:ilvl w:val="%d"/><w:numId w:val="%d"/></w:numPr>`, props.level, props.numID)
+	case props.style == "ListParagraph":
+		// Later blocks of a list item line up with its text
+		fmt.Fprintf(&b, `<w:ind w:left="%d"/>`, listIndent*(props.level+1))
+	case props.indent > 0:
+		fmt.Fprintf(&b, `<w:ind w:left="%d"/>`, props.indent)
+	}
+	if node != nil {
+		switch node.Attr("textAlign") {
+		case "center":
+			b.WriteString(`<w:jc w:val="center"/>`)
+		case "right":
+			b.WriteString(`<w:jc w:val="right"/>`)
+		case "justify":
+			b.WriteString(`<w:jc w:val="both"/>`)
+		}
+	}
+	if b.Len() > 0 {
+		w.body.WriteString("<w:pPr>" + b.String() + "</w:pPr>")
+	}
+}
+
+// inline writes runs for inline content, text with the same link is wrapped
+// in a single hyperlink
+func (w *writer) inline(nodes []*prosemirror.Node, bold bool) {
+	openLink := ""
+	for _, node := range nodes {
+		href := linkTarget(node)
+		if href != openLink {
+			if openLink != "" {
+				w.body.WriteString("</w:hyperlink>")
+			}
+			if href != "" {
+				w.body.WriteString(`<w:hyperlink r:id="` + w.linkRel(href) + `" w:history="1">`)
+			}
+			openLink = href
+		}
+
+		switch {
+		case node.IsText():
+			w.run(node.Marks, node.Text, bold)
+		case node.Type == "hardBreak":
+			w.body.WriteString("<w:r><w:br/></w:r>")
+		default:
+			if text := node.TextContent(); text != "" {
+				w.run(node.Marks, text, bold)
+			}
+		}
+	}
+	if openLink != "" {
+		w.body.WriteString("</w:hyperlink>")
+	}
+}
+
+// linkTarget returns the target of the link mark of a node if it can be
+// followed from outside the editor
+func linkTarget(node *prosemirror.Node) string {
+	for _, mark := range node.Marks {
+		if href := externalLink(mark); href != "" {
+			return href
+		}
+	}
+	return ""
+}
+
+// externalLink returns the target of a link mark when it is an absolute URL,
+// relative links have nothing to resolve against outside the editor
+func externalLink(mark *prosemirror.Mark) string {
+	if mark.Type != "link" {
+		return ""
+	}
+	href, ok := prosemirror.SafeLinkURL(attrString(mark.Attr("href")))
+	if parsed, err := url.Parse(href); ok && err == nil && parsed.Scheme != "" {
+		return href
+	}
+	return ""
+}
+
+func (w *writer) linkRel(href string) string {
+	if id, exists := w.links[href]; exists {
+		return id
+	}
+	id := w.addRel(hyperlinkRel, href, true)
+	w.links[href] = id
+	return id
+}
+
+// run writes text with the run properties of its marks, tabs and newlines
+// become the matching run content
+func (w *writer) run(marks []*prosemirror.Mark, text string, bold bool) {
+	w.body.WriteString("<w:r>")
+	w.writeRunProps(marks, bold)
+	w.runText(text)
+	w.body.WriteString("</w:r>")
+}
+
+// writeRunProps writes the w:rPr element, its children follow the order of the
+// schema
+func (w *writer) writeRunProps(marks []*prosemirror.Mark, bold bool) {
+	var style string
+	var italic, strike, underline, highlight bool
+	vertAlign := ""
+	for _, mark := range marks {
+		switch mark.Type {
+		case "bold":
+			bold = true
+		case "italic":
+			italic = true
+		case "strike":
+			strike = true
+		case "underline":
+			underline = true
+		case "highlight":
+			highlight = true
+		case "code":
+			style = "CodeChar"
+		case "subscript":
+			vertAlign = "subscript"
+		case "superscript":
+			vertAlign = "superscript"
+		case "link":
+			if style == "" && externalLink(mark) != "" {
+				style = "Hyperlink"
+			}
+		}
+	}
+
+	var b strings.Builder
+	if style != "" {
+		b.WriteString(`<w:rStyle w:val="` + style + `"/>`)
+	}
+	if bold {
+		b.WriteString("<w:b/>")
+	}
+	if italic {
+		b.WriteString("<w:i/>")
+	}
+	if strike {
+		b.WriteString("<w:strike/>")
+	}
+	if highlight {
+		b.WriteString(`<w:highlight w:val="yellow"/>`)
+	}
+	if underline {
+		b.WriteString(`<w:u w:val="single"/>`)
+	}
+	if vertAlign != "" {
+		b.WriteString(`<w:vertAlign w:val="` + vertAlign + `"/>`)
+	}
+	if b.Len() > 0 {
+		w.body.WriteString("<w:rPr>" + b.String() + "</w:rPr>")
+	}
+}
+
+func (w *writer) runText(text string) {
+	start := 0
+	flush := func(end int) {
+		if end > start {
+			w.body.WriteString(`<w:t xml:space="preserve">`)
+			escape(&w.body, text[start:end])
+			w.body.WriteString("</w:t>")
+		}
+	}
+	for i, r := range text {
+		switch r {
+		case '\t':
+			flush(i)
+			w.body.WriteString("<w:tab/>")
+			start = i + 1
+		case '\n':
+			flush(i)
+			w.body.WriteString("<w:br/>")
+			start = i + 1
+		}
+	}
+	flush(len(text))
+}
+
+func (w *writer) codeBlock(node *prosemirror.Node, props paragraphProps) {
+	w.body.WriteString("<w:p>")
+	w.writeParagraphProps(nil, props)
+	if text := node.TextContent(); text != "" {
+		w.body.WriteString("<w:r>")
+		w.runText(text)
+		w.body.WriteString("</w:r>")
+	}
+	w.body.WriteString("</w:p>")
+}
+
+// image embeds inline image data. Remote images become a link, Word would
+// otherwise fetch them from wherever the document is opened.
+func (w *writer) image(node *prosemirror.Node, props paragraphProps) {
+	src, _ := prosemirror.SafeImageURL(attrString(node.Attr("src")))
+	alt := attrString(node.Attr("alt"))
+	m := w.embed(src)
+	if m == nil {
+		label := alt
+		if label == "" {
+			label = "image"
+		}
+		w.paragraph(&prosemirror.Node{Type: "paragraph", Content: []*prosemirror.Node{{
+			Type:  "text",
+			Text:  "[" + label + "]",
+			Marks: []*prosemirror.Mark{{Type: "link", Attrs: map[string]any{"href": src}}},
+		}}}, props)
+		return
+	}
+
+	cx, cy := m.width*emuPerPixel, m.height*emuPerPixel
+	if cx > maxImageEMU {
+		cy = cy * maxImageEMU / cx
+		cx = maxImageEMU
+	}
+	w.drawings++
+	id := w.drawings
+
+	w.body.WriteString("<w:p>")
+	w.writeParagraphProps(node, props)
+	fmt.Fprintf(&w.body, `<w:r><w:drawing><wp:inline distT="0" distB="0" distL="0" distR="0"><wp:extent cx="%d" cy="%d"/>`, cx, cy)
+	fmt.Fprintf(&w.body, `<wp:docPr id="%d" name="Picture %d" descr="%s"/>`, id, id, escapeString(alt))
+	w.body.WriteString(`<a:graphic><a:graphicData uri="http://schemas.openxmlformats.org/drawingml/2006/picture"><pic:pic>`)
+	fmt.Fprintf(&w.body, `<pic:nvPicPr><pic:cNvPr id="%d" name="%s"/><pic:cNvPicPr/></pic:nvPicPr>`, id, m.name)
+	fmt.Fprintf(&w.body, `<pic:blipFill><a:blip r:embed="%s"/><a:stretch><a:fillRect/></a:stretch></pic:blipFill>`, m.relID)
+	fmt.Fprintf(&w.body, `<pic:spPr><a:xfrm><a:off x="0" y="0"/><a:ext cx="%d" cy="%d"/></a:xfrm><a:prstGeom prst="rect"><a:avLst/></a:prstGeom></pic:spPr>`, cx, cy)
+	w.body.WriteString("</pic:pic></a:graphicData></a:graphic></wp:inline></w:drawing></w:r></w:p>")
+}
+
+// embed adds the image of a data URL to the package, images used more than
+// once are stored once
+func (w *writer) embed(src string) *media {
+	if m, exists := w.images[src]; exists {
+		return m
+	}
+	var m *media
+	if comma := strings.IndexByte(src, ','); strings.HasPrefix(src, "data:") && comma > 0 {
+		data, err := base64.StdEncoding.DecodeString(strings.Join(strings.Fields(src[comma+1:]), ""))
+		if err == nil {
+			if config, format, err := image.DecodeConfig(bytes.NewReader(data)); err == nil && config.Width > 0 && config.Height > 0 {
+				name := fmt.Sprintf("image%d.%s", len(w.media)+1, format)
+				m = &media{name: name, data: data, width: config.Width, height: config.Height}
+				m.relID = w.addRel(imageRel, "media/"+name, false)
+				w.media = append(w.media, m)
+			}
+		}
+	}
+	w.images[src] = m
+	return m
+}
+
+// table writes a table with columns of equal width. Cells spanning rows are
+// continued in the rows below by merged cells.
+func (w *writer) table(node *prosemirror.Node, props paragraphProps) {
+	columns := 0
+	for _, row := range node.Content {
+		span := 0
+		for _, cell := range row.Content {
+			span += max(attrInt(cell.Attr("colspan"), 1), 1)
+		}
+		columns = max(columns, span)
+	}
+	if columns == 0 {
+		return
+	}
+	width := (contentWidth - props.indent) / columns
+
+	w.body.WriteString(`<w:tbl><w:tblPr><w:tblStyle w:val="TableGrid"/><w:tblW w:w="0" w:type="auto"/>`)
+	if props.indent > 0 {
+		fmt.Fprintf(&w.body, `<w:tblInd w:w="%d" w:type="dxa"/>`, props.indent)
+	}
+	w.body.WriteString(`<w:tblLook w:val="04A0" w:firstRow="1" w:lastRow="0" w:firstColumn="0" w:lastColumn="0" w:noHBand="0" w:noVBand="1"/></w:tblPr><w:tblGrid>`)
+	for range columns {
+		fmt.Fprintf(&w.body, `<w:gridCol w:w="%d"/>`, width)
+	}
+	w.body.WriteString("</w:tblGrid>")
+
+	// merged holds, for each column, the rows still covered by a cell above
+	// and how many columns that cell spans
+	type merge struct{ rows, span int }
+	merged := make([]merge, columns)
+	for _, row := range node.Content {
+		header := len(row.Content) > 0
+		for _, cell := range row.Content {
+			header = header && cell.Type == "tableHeader"
+		}
+		w.body.WriteString("<w:tr>")
+		if header {
+			w.body.WriteString("<w:trPr><w:tblHeader/></w:trPr>")
+		}
+
+		column := 0
+		continueMerges := func() {
+			for column < columns && merged[column].rows > 0 {
+				span := merged[column].span
+				merged[column].rows--
+				w.cellStart(width, span, "continue", false)
+				w.body.WriteString("<w:p/></w:tc>")
+				column += span
+			}
+		}
+		for _, cell := range row.Content {
+			continueMerges()
+			if column >= columns {
+				break
+			}
+			span := min(max(attrInt(cell.Attr("colspan"), 1), 1), columns-column)
+			vMerge := ""
+			if rows := attrInt(cell.Attr("rowspan"), 1); rows > 1 {
+				vMerge = "restart"
+				merged[column] = merge{rows: rows - 1, span: span}
+			}
+			w.cellStart(width, span, vMerge, cell.Type == "tableHeader")
+			before := w.body.Len()
+			w.blocks(cell.Content, paragraphProps{bold: cell.Type == "tableHeader"})
+			// Cells must contain a paragraph, tables are followed by one
+			if w.body.Len() == before {
+				w.body.WriteString("<w:p/>")
+			}
+			w.body.WriteString("</w:tc>")
+			column += span
+		}
+		continueMerges()
+		// Pad short rows so every row fills the grid
+		for ; column < columns; column++ {
+			w.cellStart(width, 1, "", false)
+			w.body.WriteString("<w:p/></w:tc>")
+		}
+		w.body.WriteString("</w:tr>")
+	}
+	w.body.WriteString("</w:tbl>")
+	// Word merges tables that follow each other
+	w.body.WriteString("<w:p/>")
+}
+
+func (w *writer) cellStart(width, span int, vMerge string, header bool) {
+	fmt.Fprintf(&w.body, `<w:tc><w:tcPr><w:tcW w:w="%d" w:type="dxa"/>`, width*span)
+	if span > 1 {
+		fmt.Fprintf(&w.body, `<w:gridSpan w:val="%d"/>`, span)
+	}
+	switch vMerge {
+	case "restart":
+		w.body.WriteString(`<w:vMerge w:val="restart"/>`)
+	case "continue":
+		w.body.WriteString(`<w:vMerge/>`)
+	}
+	if header {
+		w.body.WriteString(`<w:shd w:val="clear" w:color="auto" w:fill="F2F2F2"/>`)
+	}
+	w.body.WriteString("</w:tcPr>")
+}
+
+func (w *writer) contentTypes() string {
+	var b strings.Builder
+	b.WriteString(xml.Header)
+	b.WriteString(`<Types xmlns="http://schemas.openxmlformats.org/package/2006/content-types">`)
+	b.WriteString(`<Default Extension="rels" ContentType="application/vnd.openxmlformats-package.relationships+xml"/>`)
+	b.WriteString(`<Default Extension="xml" ContentType="application/xml"/>`)
+	b.WriteString(`<Default Extension="png" ContentType="image/png"/>`)
+	b.WriteString(`<Default Extension="jpeg" ContentType="image/jpeg"/>`)
+	b.WriteString(`<Default Extension="gif" ContentType="image/gif"/>`)
+	b.WriteString(`<Override PartName="/word/document.xml" ContentType="application/vnd.openxmlformats-officedocument.wordprocessingml.document.main+xml"/>`)
+	b.WriteString(`<Override PartName="/word/styles.xml" ContentType="application/vnd.openxmlformats-officedocument.wordprocessingml.styles+xml"/>`)
+	b.WriteString(`<Override PartName="/word/numbering.xml" ContentType="application/vnd.openxmlformats-officedocument.wordprocessingml.numbering+xml"/>`)
+	b.WriteString(`<Override PartName="/docProps/core.xml" ContentType="application/vnd.openxmlformats-package.core-properties+xml"/>`)
+	b.WriteString(`<Override PartName="/docProps/app.xml" ContentType="application/vnd.openxmlformats-officedocument.extended-properties+xml"/>`)
+	b.WriteString(`</Types>`)
+	return b.String()
+}
+
+func (w *writer) documentRels() string {
+	var b strings.Builder
+	b.WriteString(xml.Header)
+	b.WriteString(`<Relationships xmlns="` + relationshipsNS + `">`)
+	b.WriteString(`<Relationship Id="rId1" Type="` + stylesRel + `" Target="styles.xml"/>`)
+	b.WriteString(`<Relationship Id="rId2" Type="` + numberingRel + `" Target="numbering.xml"/>`)
+	for _, rel := range w.rels {
+		b.WriteString(`<Relationship Id="` + rel.id + `" Type="` + rel.kind + `" Target="`)
+		xml.EscapeText(&b, []byte(rel.target))
+		b.WriteString(`"`)
+		if rel.external {
+			b.WriteString(` TargetMode="External"`)
+		}
+		b.WriteString(`/>`)
+	}
+	b.WriteString(`</Relationships>`)
+	return b.String()
+}
+
+// numbering defines a bullet and a decimal multilevel list, and the
+// instances used by the lists of the document
+func (w *writer) numbering() string {
+	var b strings.Builder
+	b.WriteString(xml.Header)
+	b.WriteString(`<w:numbering xmlns:w="http://schemas.openxmlformats.org/wordprocessingml/2006/main">`)
+
+	bullets := []string{"•", "◦", "▪"}
+	formats := []string{"decimal", "lowerLetter", "lowerRoman"}
+	for abstract := range 2 {
+		fmt.Fprintf(&b, `<w:abstractNum w:abstractNumId="%d"><w:multiLevelType w:val="hybridMultilevel"/>`, abstract)
+		for level := range 9 {
+			format, text := "bullet", bullets[level%len(bullets)]
+			if abstract == orderedAbstract {
+				format, text = formats[level%len(formats)], fmt.Sprintf("%%%d.", level+1)
+			}
+			fmt.Fprintf(&b, `<w:lvl w:ilvl="%d"><w:start w:val="1"/><w:numFmt w:val="%s"/><w:lvlText w:val="%s"/><w:lvlJc w:val="left"/>`, level, format, text)
+			fmt.Fprintf(&b, `<w:pPr><w:ind w:left="%d" w:hanging="360"/></w:pPr></w:lvl>`, listIndent*(level+1))
+		}
+		b.WriteString(`</w:abstractNum>`)
+	}
+	for i, n := range w.nums {
+		fmt.Fprintf(&b, `<w:num w:numId="%d"><w:abstractNumId w:val="%d"/>`, i+1, n.abstract)
+		for level := range 9 {
+			if start, set := n.starts[level]; set {
+				fmt.Fprintf(&b, `<w:lvlOverride w:ilvl="%d"><w:startOverride w:val="%d"/></w:lvlOverride>`, level, start)
+			}
+		}
+		b.WriteString(`</w:num>`)
+	}
+	b.WriteString(`</w:numbering>`)
+	return b.String()
+}
+
+func coreProperties(options Options) string {
+	var b strings.Builder
+	b.WriteString(xml.Header)
+	b.WriteString(`<cp:coreProperties xmlns:cp="http://schemas.openxmlformats.org/package/2006/metadata/core-properties" xmlns:dc="http://purl.org/dc/elements/1.1/" xmlns:dcterms="http://purl.org/dc/terms/" xmlns:xsi="http://www.w3.org/2001/XMLSchema-instance">`)
+	if options.Title != "" {
+		b.WriteString("<dc:title>" + escapeString(options.Title) + "</dc:title>")
+	}
+	if !options.Created.IsZero() {
+		b.WriteString(`<dcterms:created xsi:type="dcterms:W3CDTF">` + options.Created.UTC().Format(time.RFC3339) + `</dcterms:created>`)
+	}
+	if !options.Updated.IsZero() {
+		b.WriteString(`<dcterms:modified xsi:type="dcterms:W3CDTF">` + options.Updated.UTC().Format(time.RFC3339) + `</dcterms:modified>`)
+	}
+	b.WriteString(`</cp:coreProperties>`)
+	return b.String()
+}
+
+const packageRels = xml.Header + `<Relationships xmlns="` + relationshipsNS + `">` +
+	`<Relationship Id="rId1" Type="` + officeDocumentRel + `" Target="word/document.xml"/>` +
+	`<Relationship Id="rId2" Type="` + corePropertiesRel + `" Target="docProps/core.xml"/>` +
+	`<Relationship Id="rId3" Type="` + appPropertiesRel + `" Target="docProps/app.xml"/>` +
+	`</Relationships>`
+
+const appProperties = xml.Header + `<Properties xmlns="http://schemas.openxmlformats.org/officeDocument/2006/extended-properties"><Application>ce-document-service</Application></Properties>`
+
+const documentHeader = xml.Header + `<w:document` +
+	` xmlns:w="http://schemas.openxmlformats.org/wordprocessingml/2006/main"` +
+	` xmlns:r="http://schemas.openxmlformats.org/officeDocument/2006/relationships"` +
+	` xmlns:wp="http://schemas.openxmlformats.org/drawingml/2006/wordprocessingDrawing"` +
+	` xmlns:a="http://schemas.openxmlformats.org/drawingml/2006/main"` +
+	` xmlns:pic="http://schemas.openxmlformats.org/drawingml/2006/picture"><w:body>`
+
+// documentFooter closes the body with an A4 section with one inch margins
+const documentFooter = `<w:sectPr><w:pgSz w:w="11906" w:h="16838"/>` +
+	`<w:pgMar w:top="1440" w:right="1440" w:bottom="1440" w:left="1440" w:header="708" w:footer="708" w:gutter="0"/>` +
+	`</w:sectPr></w:body></w:document>`
+
+// styles defines the paragraph and character styles the writer refers to,
+// using the names of the built-in Word styles so they are recognized
+var styles = xml.Header + `<w:styles xmlns:w="http://schemas.openxmlformats.org/wordprocessingml/2006/main">` +
+	`<w:docDefaults><w:rPrDefault><w:rPr><w:rFonts w:ascii="Calibri" w:hAnsi="Calibri" w:eastAsia="Calibri" w:cs="Calibri"/><w:sz w:val="22"/><w:szCs w:val="22"/><w:lang w:val="en-US"/></w:rPr></w:rPrDefault>` +
+	`<w:pPrDefault><w:pPr><w:spacing w:after="160" w:line="259" w:lineRule="auto"/></w:pPr></w:pPrDefault></w:docDefaults>` +
+	`<w:style w:type="paragraph" w:default="1" w:styleId="Normal"><w:name w:val="Normal"/><w:qFormat/></w:style>` +
+	`<w:style w:type="character" w:default="1" w:styleId="DefaultParagraphFont"><w:name w:val="Default Paragraph Font"/><w:uiPriority w:val="1"/><w:semiHidden/></w:style>` +
+	headingStyle(1, 32) + headingStyle(2, 26) + headingStyle(3, 24) + headingStyle(4, 22) + headingStyle(5, 22) + headingStyle(6, 22) +
+	`<w:style w:type="paragraph" w:styleId="Quote"><w:name w:val="Quote"/><w:basedOn w:val="Normal"/><w:next w:val="Normal"/><w:qFormat/>` +
+	`<w:pPr><w:pBdr><w:left w:val="single" w:sz="18" w:space="8" w:color="D0D7DE"/></w:pBdr></w:pPr><w:rPr><w:i/><w:color w:val="59636E"/></w:rPr></w:style>` +
+	`<w:style w:type="paragraph" w:styleId="ListParagraph"><w:name w:val="List Paragraph"/><w:basedOn w:val="Normal"/><w:qFormat/>` +
+	`<w:pPr><w:spacing w:after="60"/><w:ind w:left="720"/></w:pPr></w:style>` +
+	`<w:style w:type="paragraph" w:styleId="Code"><w:name w:val="Code"/><w:basedOn w:val="Normal"/><w:qFormat/>` +
+	`<w:pPr><w:shd w:val="clear" w:color="auto" w:fill="F6F8FA"/><w:spacing w:after="160" w:line="240" w:lineRule="auto"/></w:pPr>` +
+	`<w:rPr><w:rFonts w:ascii="Courier New" w:hAnsi="Courier New" w:cs="Courier New"/><w:sz w:val="20"/><w:szCs w:val="20"/></w:rPr></w:style>` +
+	`<w:style w:type="character" w:styleId="CodeChar"><w:name w:val="Code Char"/><w:basedOn w:val="DefaultParagraphFont"/>` +
+	`<w:rPr><w:rFonts w:ascii="Courier New" w:hAnsi="Courier New" w:cs="Courier New"/><w:sz w:val="20"/><w:szCs w:val="20"/><w:shd w:val="clear" w:color="auto" w:fill="F6F8FA"/></w:rPr></w:style>` +
+	`<w:style w:type="character" w:styleId="Hyperlink"><w:name w:val="Hyperlink"/><w:basedOn w:val="DefaultParagraphFont"/>` +
+	`<w:rPr><w:color w:val="0563C1"/><w:u w:val="single"/></w:rPr></w:style>` +
+	`<w:style w:type="table" w:default="1" w:styleId="TableNormal"><w:name w:val="Normal Table"/><w:semiHidden/>` +
+	`<w:tblPr><w:tblInd w:w="0" w:type="dxa"/><w:tblCellMar><w:top w:w="0" w:type="dxa"/><w:left w:w="108" w:type="dxa"/><w:bottom w:w="0" w:type="dxa"/><w:right w:w="108" w:type="dxa"/></w:tblCellMar></w:tblPr></w:style>` +
+	`<w:style w:type="table" w:styleId="TableGrid"><w:name w:val="Table Grid"/><w:basedOn w:val="TableNormal"/>` +
+	`<w:pPr><w:spacing w:after="0" w:line="240" w:lineRule="auto"/></w:pPr>` +
+	`<w:tblPr><w:tblBorders><w:top w:val="single" w:sz="4" w:space="0" w:color="auto"/><w:left w:val="single" w:sz="4" w:space="0" w:color="auto"/>` +
+	`<w:bottom w:val="single" w:sz="4" w:space="0" w:color="auto"/><w:right w:val="single" w:sz="4" w:space="0" w:color="auto"/>` +
+	`<w:insideH w:val="single" w:sz="4" w:space="0" w:color="auto"/><w:insideV w:val="single" w:sz="4" w:space="0" w:color="auto"/></w:tblBorders></w:tblPr></w:style>` +
+	`</w:styles>`
+
+func headingStyle(level, size int) string {
+	return fmt.Sprintf(`<w:style w:type="paragraph" w:styleId="Heading%d"><w:name w:val="heading %d"/><w:basedOn w:val="Normal"/><w:next w:val="Normal"/><w:qFormat/>`+
+		`<w:pPr><w:keepNext/><w:keepLines/><w:spacing w:before="240" w:after="80"/><w:outlineLvl w:val="%d"/></w:pPr>`+
+		`<w:rPr><w:b/><w:sz w:val="%d"/><w:szCs w:val="%d"/></w:rPr></w:style>`, level, level, level-1, size, size)
+}
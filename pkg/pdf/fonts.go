@@ -0,0 +1,159 @@
+package pdf
+
+import "unicode/utf8"
+
+// Font is one of the standard Type 1 fonts every PDF reader provides, so no
+// font program has to be embedded. They cover the WinAnsi character set.
+type Font int
+
+const (
+	Helvetica Font = iota
+	HelveticaBold
+	HelveticaOblique
+	HelveticaBoldOblique
+	Courier
+	CourierBold
+	CourierOblique
+	CourierBoldOblique
+)
+
+var fontNames = [...]string{
+	Helvetica:            "Helvetica",
+	HelveticaBold:        "Helvetica-Bold",
+	HelveticaOblique:     "Helvetica-Oblique",
+	HelveticaBoldOblique: "Helvetica-BoldOblique",
+	Courier:              "Courier",
+	CourierBold:          "Courier-Bold",
+	CourierOblique:       "Courier-Oblique",
+	CourierBoldOblique:   "Courier-BoldOblique",
+}
+
+// Name is the PostScript name of the font
+func (f Font) Name() string {
+	return fontNames[f]
+}
+
+// Monospace reports whether f belongs to the Courier family
+func (f Font) Monospace() bool {
+	return f >= Courier
+}
+
+// Bold reports whether f is a bold face
+func (f Font) Bold() bool {
+	return f == HelveticaBold || f == HelveticaBoldOblique || f == CourierBold || f == CourierBoldOblique
+}
+
+// Italic reports whether f is an oblique face
+func (f Font) Italic() bool {
+	return f == HelveticaOblique || f == HelveticaBoldOblique || f == CourierOblique || f == CourierBoldOblique
+}
+
+// Style returns the face of the family of f with the given weight and slant
+func (f Font) Style(bold, italic bool) Font {
+	base := Helvetica
+	if f.Monospace() {
+		base = Courier
+	}
+	switch {
+	case bold && italic:
+		return base + 3
+	case bold:
+		return base + 1
+	case italic:
+		return base + 2
+	}
+	return base
+}
+
+// Ascent and Descent are the extents of the standard fonts above and below
+// the baseline, in thousandths of the font size
+const (
+	Ascent  = 718
+	Descent = 207
+)
+
+// Width returns the width of text set in f at size points
+func (f Font) Width(text string, size float64) float64 {
+	if f.Monospace() {
+		return float64(utf8.RuneCountInString(text)) * 600 * size / 1000
+	}
+	widths := &helveticaWidths
+	if f.Bold() {
+		widths = &helveticaBoldWidths
+	}
+	total := 0
+	for _, r := range text {
+		total += widths[encodeRune(r)]
+	}
+	return float64(total) * size / 1000
+}
+
+// Encode converts text to the WinAnsi encoding the standard fonts are set
+// in. Characters outside of it are replaced with a question mark.
+func Encode(text string) []byte {
+	out := make([]byte, 0, len(text))
+	for _, r := range text {
+		out = append(out, encodeRune(r))
+	}
+	return out
+}
+
+// winAnsiSpecials maps the characters WinAnsi places in 0x80-0x9F
+var winAnsiSpecials = map[rune]byte{
+	'€': 0x80, '‚': 0x82, 'ƒ': 0x83, '„': 0x84, '…': 0x85, '†': 0x86, '‡': 0x87,
+	'ˆ': 0x88, '‰': 0x89, 'Š': 0x8A, '‹': 0x8B, 'Œ': 0x8C, 'Ž': 0x8E, '‘': 0x91,
+	'’': 0x92, '“': 0x93, '”': 0x94, '•': 0x95, '–': 0x96, '—': 0x97, '˜': 0x98,
+	'™': 0x99, 'š': 0x9A, '›': 0x9B, 'œ': 0x9C, 'ž': 0x9E, 'Ÿ': 0x9F,
+}
+
+func encodeRune(r rune) byte {
+	switch {
+	case r >= 0x20 && r < 0x7F, r >= 0xA0 && r <= 0xFF:
+		return byte(r)
+	case r == '\t', r == '\u2002', r == '\u2003', r == '\u2009', r == '\u202F':
+		return ' '
+	case r == '\u2010', r == '\u2011':
+		return '-'
+	}
+	if b, ok := winAnsiSpecials[r]; ok {
+		return b
+	}
+	return '?'
+}
+
+// helveticaWidths and helveticaBoldWidths are the advance widths of the
+// WinAnsi characters, from the Adobe font metrics. Oblique faces share the
+// widths of the upright ones.
+var helveticaWidths = [256]int{
+	0x20: 278, 278, 355, 556, 556, 889, 667, 191, 333, 333, 389, 584, 278, 333, 278, 278,
+	556, 556, 556, 556, 556, 556, 556, 556, 556, 556, 278, 278, 584, 584, 584, 556,
+	1015, 667, 667, 722, 722, 667, 611, 778, 722, 278, 500, 667, 556, 833, 722, 778,
+	667, 778, 722, 667, 611, 722, 667, 944, 667, 667, 611, 278, 278, 278, 469, 556,
+	333, 556, 556, 500, 556, 556, 278, 556, 556, 222, 222, 500, 222, 833, 556, 556,
+	556, 556, 333, 500, 278, 556, 500, 722, 500, 500, 500, 334, 260, 334, 584, 0,
+	556, 0, 222, 556, 333, 1000, 556, 556, 333, 1000, 667, 333, 1000, 0, 611, 0,
+	0, 222, 222, 333, 333, 350, 556, 1000, 333, 1000, 500, 333, 944, 0, 500, 667,
+	278, 333, 556, 556, 556, 556, 260, 556, 333, 737, 370, 556, 584, 333, 737, 333,
+	400, 584, 333, 333, 333, 556, 537, 278, 333, 333, 365, 556, 834, 834, 834, 611,
+	667, 667, 667, 667, 667, 667, 1000, 722, 667, 667, 667, 667, 278, 278, 278, 278,
+	722, 722, 778, 778, 778, 778, 778, 584, 778, 722, 722, 722, 722, 667, 667, 611,
+	556, 556, 556, 556, 556, 556, 889, 500, 556, 556, 556, 556, 278, 278, 278, 278,
+	556, 556, 556, 556, 556, 556, 556, 584, 611, 556, 556, 556, 556, 500, 556, 500,
+}
+
+var helveticaBoldWidths = [256]int{
+	0x20: 278, 333, 474, 556, 556, 889, 722, 238, 333, 333, 389, 584, 278, 333, 278, 278,
+	556, 556, 556, 556, 556, 556, 556, 556, 556, 556, 333, 333, 584, 584, 584, 611,
+	975, 722, 722, 722, 722, 667, 611, 778, 722, 278, 556, 722, 611, 833, 722, 778,
+	667, 778, 722, 667, 611, 722, 667, 944, 667, 667, 611, 333, 278, 333, 584, 556,
+	333, 556, 611, 556, 611, 556, 333, 611, 611, 278, 278, 556, 278, 889, 611, 611,
+	611, 611, 389, 556, 333, 611, 556, 778, 556, 556, 500, 389, 280, 389, 584, 0,
+	556, 0, 278, 556, 500, 1000, 556, 556, 333, 1000, 667, 333, 1000, 0, 611, 0,
+	0, 278, 278, 500, 500, 350, 556, 1000, 333, 1000, 556, 333, 944, 0, 500, 667,
+	278, 333, 556, 556, 556, 556, 280, 556, 333, 737, 370, 556, 584, 333, 737, 333,
+	400, 584, 333, 333, 333, 611, 556, 278, 333, 333, 365, 556, 834, 834, 834, 611,
+	722, 722, 722, 722, 722, 722, 1000, 722, 667, 667, 667, 667, 278, 278, 278, 278,
+	722, 722, 778, 778, 778, 778, 778, 584, 778, 722, 722, 722, 722, 667, 667, 611,
+	556, 556, 556, 556, 556, 556, 889, 556, 556, 556, 556, 556, 278, 278, 278, 278,
+	611, 611, 611, 611, 611, 611, 611, 584, 611, 611, 611, 611, 611, 556, 611, 556,
+}
@@ -0,0 +1,119 @@
+package pdf
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/color"
+	_ "image/gif"
+	"image/jpeg"
+	_ "image/png"
+)
+
+// maxImagePixels bounds the size of decoded images, a small compressed file
+// can otherwise claim gigabytes of pixels
+const maxImagePixels = 40_000_000
+
+var (
+	ErrUnsupportedImage = errors.New("unsupported image format")
+	ErrImageTooLarge    = errors.New("image too large")
+)
+
+// Image is a raster image embedded in a Document, it can be drawn on any
+// number of pages
+type Image struct {
+	id         int
+	width      int
+	height     int
+	colorSpace string
+	filter     string
+	decode     string
+	data       []byte
+	// mask is the alpha channel of the image, nil when it is opaque
+	mask []byte
+}
+
+// Width returns the width of the image in pixels
+func (i *Image) Width() int {
+	return i.width
+}
+
+// Height returns the height of the image in pixels
+func (i *Image) Height() int {
+	return i.height
+}
+
+// AddImage embeds a JPEG, PNG or GIF image. JPEG files are embedded as they
+// are, other formats are decoded and recompressed.
+func (d *Document) AddImage(data []byte) (*Image, error) {
+	config, format, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, ErrUnsupportedImage
+	}
+	if config.Width <= 0 || config.Height <= 0 || config.Width*config.Height > maxImagePixels {
+		return nil, ErrImageTooLarge
+	}
+
+	img := &Image{id: len(d.images), width: config.Width, height: config.Height}
+	if format == "jpeg" {
+		img.filter = "DCTDecode"
+		img.data = data
+		switch config.ColorModel {
+		case color.GrayModel:
+			img.colorSpace = "DeviceGray"
+		case color.CMYKModel:
+			// Adobe writes CMYK JPEGs inverted
+			img.colorSpace = "DeviceCMYK"
+			img.decode = "[1 0 1 0 1 0 1 0]"
+		default:
+			img.colorSpace = "DeviceRGB"
+		}
+		// Make sure the data decodes, readers show broken images as errors
+		if _, err := jpeg.Decode(bytes.NewReader(data)); err != nil {
+			return nil, ErrUnsupportedImage
+		}
+	} else {
+		decoded, _, err := image.Decode(bytes.NewReader(data))
+		if err != nil {
+			return nil, ErrUnsupportedImage
+		}
+		if err := img.setPixels(decoded); err != nil {
+			return nil, err
+		}
+	}
+
+	d.images = append(d.images, img)
+	return img, nil
+}
+
+// setPixels stores the pixels of img as deflated RGB samples and, when some
+// are not opaque, a separate alpha mask
+func (i *Image) setPixels(img image.Image) error {
+	bounds := img.Bounds()
+	rgb := make([]byte, 0, i.width*i.height*3)
+	alpha := make([]byte, 0, i.width*i.height)
+	opaque := true
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			c := color.NRGBAModel.Convert(img.At(x, y)).(color.NRGBA)
+			rgb = append(rgb, c.R, c.G, c.B)
+			alpha = append(alpha, c.A)
+			if c.A != 0xff {
+				opaque = false
+			}
+		}
+	}
+
+	var err error
+	i.colorSpace = "DeviceRGB"
+	i.filter = "FlateDecode"
+	if i.data, err = deflate(rgb); err != nil {
+		return err
+	}
+	if !opaque {
+		if i.mask, err = deflate(alpha); err != nil {
+			return err
+		}
+	}
+	return nil
+}
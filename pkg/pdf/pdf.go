@@ -0,0 +1,353 @@
+// Package pdf writes PDF documents using the standard Type 1 fonts and
+// embedded raster images, without external tools.
+package pdf
+
+import (
+	"bytes"
+	"compress/zlib"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"time"
+	"unicode/utf16"
+)
+
+// Page sizes in points
+var (
+	A3     = Size{Width: 841.89, Height: 1190.55}
+	A4     = Size{Width: 595.28, Height: 841.89}
+	A5     = Size{Width: 419.53, Height: 595.28}
+	Letter = Size{Width: 612, Height: 792}
+	Legal  = Size{Width: 612, Height: 1008}
+)
+
+// Size is the size of a page in points
+type Size struct {
+	Width  float64
+	Height float64
+}
+
+// Color is an RGB color with components between 0 and 1
+type Color struct {
+	R, G, B float64
+}
+
+var Black = Color{}
+
+// Document is a PDF document being assembled in memory. Coordinates passed to
+// the drawing methods have their origin at the top left corner of the page
+// and grow downwards, like the layout of a text.
+type Document struct {
+	size   Size
+	pages  []*Page
+	images []*Image
+	fonts  map[Font]bool
+
+	Title    string
+	Author   string
+	Created  time.Time
+	Modified time.Time
+}
+
+// Page is a page of a Document
+type Page struct {
+	document *Document
+	content  bytes.Buffer
+	links    []link
+}
+
+type link struct {
+	x, y, width, height float64
+	uri                 string
+}
+
+// New creates an empty document whose pages have the given size
+func New(size Size) *Document {
+	return &Document{size: size, fonts: make(map[Font]bool)}
+}
+
+// Size returns the page size of the document
+func (d *Document) Size() Size {
+	return d.size
+}
+
+// AddPage appends a blank page to the document
+func (d *Document) AddPage() *Page {
+	page := &Page{document: d}
+	d.pages = append(d.pages, page)
+	return page
+}
+
+// Pages returns the pages added so far
+func (d *Document) Pages() []*Page {
+	return d.pages
+}
+
+// Text draws text with its baseline at y
+func (p *Page) Text(x, y float64, font Font, size float64, color Color, text string) {
+	if text == "" {
+		return
+	}
+	p.document.fonts[font] = true
+	fmt.Fprintf(&p.content, "BT %s rg /F%d %s Tf %s %s Td ", color, font, num(size), num(x), num(p.y(y)))
+	writeString(&p.content, Encode(text))
+	p.content.WriteString(" Tj ET\n")
+}
+
+// Rect fills a rectangle whose top left corner is at x, y
+func (p *Page) Rect(x, y, width, height float64, color Color) {
+	fmt.Fprintf(&p.content, "%s rg %s %s %s %s re f\n", color, num(x), num(p.y(y+height)), num(width), num(height))
+}
+
+// StrokeRect outlines a rectangle whose top left corner is at x, y
+func (p *Page) StrokeRect(x, y, width, height, lineWidth float64, color Color) {
+	fmt.Fprintf(&p.content, "%s RG %s w %s %s %s %s re S\n", color, num(lineWidth), num(x), num(p.y(y+height)), num(width), num(height))
+}
+
+// Line draws a straight line
+func (p *Page) Line(x1, y1, x2, y2, lineWidth float64, color Color) {
+	fmt.Fprintf(&p.content, "%s RG %s w %s %s m %s %s l S\n", color, num(lineWidth), num(x1), num(p.y(y1)), num(x2), num(p.y(y2)))
+}
+
+// Image draws an image scaled to width and height, with its top left corner
+// at x, y
+func (p *Page) Image(image *Image, x, y, width, height float64) {
+	fmt.Fprintf(&p.content, "q %s 0 0 %s %s %s cm /Im%d Do Q\n", num(width), num(height), num(x), num(p.y(y+height)), image.id)
+}
+
+// Link makes a rectangle of the page open uri when clicked
+func (p *Page) Link(x, y, width, height float64, uri string) {
+	p.links = append(p.links, link{x: x, y: y, width: width, height: height, uri: uri})
+}
+
+// y converts a distance from the top of the page into a PDF coordinate
+func (p *Page) y(y float64) float64 {
+	return p.document.size.Height - y
+}
+
+func (c Color) String() string {
+	return num(c.R) + " " + num(c.G) + " " + num(c.B)
+}
+
+// WriteTo writes the document in the PDF format
+func (d *Document) WriteTo(w io.Writer) (int64, error) {
+	out := &writer{}
+	out.WriteString("%PDF-1.4\n%\xe2\xe3\xcf\xd3\n")
+
+	// Object numbers are assigned up front so objects can refer to each other
+	catalogID, pagesID, infoID := 1, 2, 3
+	next := 4
+	fontIDs := make(map[Font]int)
+	for font := Helvetica; font <= CourierBoldOblique; font++ {
+		if d.fonts[font] {
+			fontIDs[font] = next
+			next++
+		}
+	}
+	imageIDs := make([]int, len(d.images))
+	for i, image := range d.images {
+		imageIDs[i] = next
+		next++
+		if image.mask != nil {
+			next++
+		}
+	}
+	pageIDs := make([]int, len(d.pages))
+	for i, page := range d.pages {
+		pageIDs[i] = next
+		// The page, its content stream and one annotation per link
+		next += 2 + len(page.links)
+	}
+
+	out.object(catalogID, fmt.Sprintf("<< /Type /Catalog /Pages %d 0 R >>", pagesID))
+
+	kids := ""
+	for i, id := range pageIDs {
+		if i > 0 {
+			kids += " "
+		}
+		kids += fmt.Sprintf("%d 0 R", id)
+	}
+	out.object(pagesID, fmt.Sprintf("<< /Type /Pages /Kids [%s] /Count %d /MediaBox [0 0 %s %s] >>",
+		kids, len(d.pages), num(d.size.Width), num(d.size.Height)))
+
+	info := "<< /Producer " + textString("ce-document-service")
+	if d.Title != "" {
+		info += " /Title " + textString(d.Title)
+	}
+	if d.Author != "" {
+		info += " /Author " + textString(d.Author)
+	}
+	if !d.Created.IsZero() {
+		info += " /CreationDate " + dateString(d.Created)
+	}
+	if !d.Modified.IsZero() {
+		info += " /ModDate " + dateString(d.Modified)
+	}
+	out.object(infoID, info+" >>")
+
+	fonts := ""
+	for font := Helvetica; font <= CourierBoldOblique; font++ {
+		if id, ok := fontIDs[font]; ok {
+			out.object(id, fmt.Sprintf("<< /Type /Font /Subtype /Type1 /BaseFont /%s /Encoding /WinAnsiEncoding >>", font.Name()))
+			fonts += fmt.Sprintf(" /F%d %d 0 R", font, id)
+		}
+	}
+
+	images := ""
+	for i, image := range d.images {
+		id := imageIDs[i]
+		dict := fmt.Sprintf("/Type /XObject /Subtype /Image /Width %d /Height %d /ColorSpace /%s /BitsPerComponent 8 /Filter /%s",
+			image.width, image.height, image.colorSpace, image.filter)
+		if image.decode != "" {
+			dict += " /Decode " + image.decode
+		}
+		if image.mask != nil {
+			dict += fmt.Sprintf(" /SMask %d 0 R", id+1)
+		}
+		out.stream(id, dict, image.data)
+		if image.mask != nil {
+			out.stream(id+1, fmt.Sprintf("/Type /XObject /Subtype /Image /Width %d /Height %d /ColorSpace /DeviceGray /BitsPerComponent 8 /Filter /FlateDecode",
+				image.width, image.height), image.mask)
+		}
+		images += fmt.Sprintf(" /Im%d %d 0 R", image.id, id)
+	}
+
+	resources := "<< /ProcSet [/PDF /Text /ImageB /ImageC]"
+	if fonts != "" {
+		resources += " /Font <<" + fonts + " >>"
+	}
+	if images != "" {
+		resources += " /XObject <<" + images + " >>"
+	}
+	resources += " >>"
+
+	for i, page := range d.pages {
+		id := pageIDs[i]
+		annots := ""
+		for j := range page.links {
+			annotID := id + 2 + j
+			if j > 0 {
+				annots += " "
+			}
+			annots += fmt.Sprintf("%d 0 R", annotID)
+		}
+		dict := fmt.Sprintf("<< /Type /Page /Parent %d 0 R /Resources %s /Contents %d 0 R", pagesID, resources, id+1)
+		if annots != "" {
+			dict += " /Annots [" + annots + "]"
+		}
+		out.object(id, dict+" >>")
+
+		content, err := deflate(page.content.Bytes())
+		if err != nil {
+			return 0, err
+		}
+		out.stream(id+1, "/Filter /FlateDecode", content)
+
+		for j, link := range page.links {
+			var uri bytes.Buffer
+			writeString(&uri, []byte(link.uri))
+			out.object(id+2+j, fmt.Sprintf("<< /Type /Annot /Subtype /Link /Rect [%s %s %s %s] /Border [0 0 0] /A << /Type /Action /S /URI /URI %s >> >>",
+				num(link.x), num(page.y(link.y+link.height)), num(link.x+link.width), num(page.y(link.y)), uri.String()))
+		}
+	}
+
+	xref := out.Len()
+	fmt.Fprintf(out, "xref\n0 %d\n0000000000 65535 f \n", next)
+	for id := 1; id < next; id++ {
+		fmt.Fprintf(out, "%010d 00000 n \n", out.offsets[id])
+	}
+	fmt.Fprintf(out, "trailer\n<< /Size %d /Root %d 0 R /Info %d 0 R >>\nstartxref\n%d\n%%%%EOF\n", next, catalogID, infoID, xref)
+
+	return out.WriteTo(w)
+}
+
+// Bytes returns the document in the PDF format
+func (d *Document) Bytes() ([]byte, error) {
+	var out bytes.Buffer
+	if _, err := d.WriteTo(&out); err != nil {
+		return nil, err
+	}
+	return out.Bytes(), nil
+}
+
+// writer records the offset of each object for the cross-reference table
+type writer struct {
+	bytes.Buffer
+	offsets map[int]int
+}
+
+func (w *writer) object(id int, body string) {
+	w.begin(id)
+	w.WriteString(body)
+	w.WriteString("\nendobj\n")
+}
+
+func (w *writer) stream(id int, dict string, data []byte) {
+	w.begin(id)
+	fmt.Fprintf(w, "<< %s /Length %d >>\nstream\n", dict, len(data))
+	w.Write(data)
+	w.WriteString("\nendstream\nendobj\n")
+}
+
+func (w *writer) begin(id int) {
+	if w.offsets == nil {
+		w.offsets = make(map[int]int)
+	}
+	w.offsets[id] = w.Len()
+	fmt.Fprintf(w, "%d 0 obj\n", id)
+}
+
+func deflate(data []byte) ([]byte, error) {
+	var out bytes.Buffer
+	zw := zlib.NewWriter(&out)
+	if _, err := zw.Write(data); err != nil {
+		return nil, err
+	}
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+	return out.Bytes(), nil
+}
+
+// writeString writes a literal string, escaping the characters that delimit it
+func writeString(out *bytes.Buffer, data []byte) {
+	out.WriteByte('(')
+	for _, b := range data {
+		switch b {
+		case '(', ')', '\\':
+			out.WriteByte('\\')
+			out.WriteByte(b)
+		case '\r':
+			out.WriteString(`\r`)
+		case '\n':
+			out.WriteString(`\n`)
+		default:
+			out.WriteByte(b)
+		}
+	}
+	out.WriteByte(')')
+}
+
+// textString encodes text outside of content streams, like the document
+// title, in UTF-16 so it is not limited to WinAnsi
+func textString(text string) string {
+	var out bytes.Buffer
+	out.WriteString("<FEFF")
+	for _, unit := range utf16.Encode([]rune(text)) {
+		fmt.Fprintf(&out, "%04X", unit)
+	}
+	out.WriteString(">")
+	return out.String()
+}
+
+func dateString(t time.Time) string {
+	return "(D:" + t.UTC().Format("20060102150405") + "Z)"
+}
+
+// num formats a number with at most two decimals, which is precise enough
+// for points
+func num(value float64) string {
+	return strconv.FormatFloat(math.Round(value*100)/100, 'f', -1, 64)
+}
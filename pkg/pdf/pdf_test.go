@@ -0,0 +1,408 @@
+package pdf
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"image"
+	"image/color"
+	"image/png"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/emaforlin/ce-document-service/pkg/prosemirror"
+)
+
+var streamPattern = regexp.MustCompile(`(?s)<< ([^\n]*) /Length (\d+) >>\nstream\n`)
+
+// contentStreams inflates the content streams of a PDF written by WriteTo
+func contentStreams(t *testing.T, data []byte) string {
+	t.Helper()
+	var out strings.Builder
+	for _, match := range streamPattern.FindAllSubmatchIndex(data, -1) {
+		if string(data[match[2]:match[3]]) != "/Filter /FlateDecode" {
+			continue
+		}
+		length, _ := strconv.Atoi(string(data[match[4]:match[5]]))
+		zr, err := zlib.NewReader(bytes.NewReader(data[match[1] : match[1]+length]))
+		if err != nil {
+			t.Fatalf("content stream: %v", err)
+		}
+		content, err := io.ReadAll(zr)
+		if err != nil {
+			t.Fatalf("content stream: %v", err)
+		}
+		out.Write(content)
+	}
+	return out.String()
+}
+
+// checkStructure verifies the header, the trailer and that every entry of
+// the cross-reference table points at its object
+func checkStructure(t *testing.T, data []byte) {
+	t.Helper()
+	if !bytes.HasPrefix(data, []byte("%PDF-1.4\n")) || !bytes.HasSuffix(data, []byte("%%EOF\n")) {
+		t.Fatal("missing PDF header or trailer")
+	}
+	start := bytes.LastIndex(data, []byte("startxref\n"))
+	xref, err := strconv.Atoi(strings.Fields(string(data[start+len("startxref\n"):]))[0])
+	if err != nil || !bytes.HasPrefix(data[xref:], []byte("xref\n")) {
+		t.Fatalf("startxref does not point at the xref table")
+	}
+	lines := strings.Split(string(data[xref:]), "\n")
+	var first, count int
+	fmt.Sscanf(lines[1], "%d %d", &first, &count)
+	for id := 1; id < count; id++ {
+		offset, _ := strconv.Atoi(lines[2+id][:10])
+		if want := fmt.Sprintf("%d 0 obj\n", id); !bytes.HasPrefix(data[offset:], []byte(want)) {
+			t.Errorf("xref entry %d points at %q", id, data[offset:min(offset+10, len(data))])
+		}
+	}
+	if !strings.Contains(string(data[xref:]), fmt.Sprintf("/Size %d ", count)) {
+		t.Errorf("trailer size does not match the %d xref entries", count)
+	}
+}
+
+func pngImage(t *testing.T, width, height int, alpha uint8) []byte {
+	t.Helper()
+	img := image.NewNRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			img.SetNRGBA(x, y, color.NRGBA{R: 200, G: 100, B: 50, A: alpha})
+		}
+	}
+	var out bytes.Buffer
+	if err := png.Encode(&out, img); err != nil {
+		t.Fatalf("png: %v", err)
+	}
+	return out.Bytes()
+}
+
+func TestDocumentWriteTo(t *testing.T) {
+	d := New(A4)
+	d.Title = "Report (draft)"
+	d.Author = "Zoë"
+	d.Created = time.Date(2024, 3, 1, 9, 30, 0, 0, time.UTC)
+	page := d.AddPage()
+	page.Text(72, 72, HelveticaBold, 12, Black, `a (b) \c`)
+	page.Rect(10, 20, 30, 40, Color{R: 1})
+	page.Link(72, 60, 100, 14, "https://example.com/a(b)")
+	image, err := d.AddImage(pngImage(t, 2, 3, 0x80))
+	if err != nil {
+		t.Fatalf("AddImage: %v", err)
+	}
+	d.AddPage().Image(image, 0, 0, 20, 30)
+
+	data, err := d.Bytes()
+	if err != nil {
+		t.Fatalf("Bytes: %v", err)
+	}
+	checkStructure(t, data)
+
+	want := []string{
+		"/Type /Pages /Kids [",
+		"/Count 2 /MediaBox [0 0 595.28 841.89]",
+		"/Title <FEFF005200650070006F0072007400200028006400720061006600740029>",
+		"/Author <FEFF005A006F00EB>",
+		"/CreationDate (D:20240301093000Z)",
+		"/BaseFont /Helvetica-Bold /Encoding /WinAnsiEncoding",
+		"/Width 2 /Height 3 /ColorSpace /DeviceRGB",
+		"/SMask ",
+		`/URI (https://example.com/a\(b\))`,
+		"/Rect [72 767.89 172 781.89]",
+	}
+	for _, s := range want {
+		if !bytes.Contains(data, []byte(s)) {
+			t.Errorf("document is missing %q", s)
+		}
+	}
+	if bytes.Contains(data, []byte("/ModDate")) {
+		t.Error("document has a modification date but none was set")
+	}
+
+	content := contentStreams(t, data)
+	for _, s := range []string{
+		`/F1 12 Tf 72 769.89 Td (a \(b\) \\c) Tj ET`,
+		"1 0 0 rg 10 781.89 30 40 re f",
+		"q 20 0 0 30 0 811.89 cm /Im0 Do Q",
+	} {
+		if !strings.Contains(content, s) {
+			t.Errorf("content is missing %q", s)
+		}
+	}
+}
+
+func TestEmptyDocument(t *testing.T) {
+	data, err := New(Letter).Bytes()
+	if err != nil {
+		t.Fatalf("Bytes: %v", err)
+	}
+	checkStructure(t, data)
+	if !bytes.Contains(data, []byte("/Kids [] /Count 0")) {
+		t.Error("empty document has pages")
+	}
+}
+
+func TestEncode(t *testing.T) {
+	tests := []struct {
+		text string
+		want []byte
+	}{
+		{"plain", []byte("plain")},
+		{"café", []byte{'c', 'a', 'f', 0xe9}},
+		{"€ – “q”", []byte{0x80, ' ', 0x96, ' ', 0x93, 'q', 0x94}},
+		{"a\tb c", []byte("a b c")},
+		{"non‑breaking", []byte("non-breaking")},
+		{"日本", []byte("??")},
+		{"😀", []byte("?")},
+		{"\x00\n", []byte("??")},
+	}
+	for _, tt := range tests {
+		t.Run(tt.text, func(t *testing.T) {
+			if got := Encode(tt.text); !bytes.Equal(got, tt.want) {
+				t.Errorf("Encode(%q) = %v, want %v", tt.text, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFontWidth(t *testing.T) {
+	tests := []struct {
+		font Font
+		text string
+		size float64
+		want float64
+	}{
+		{Helvetica, "", 12, 0},
+		{Helvetica, "Hi", 10, 9.44},
+		{HelveticaBold, "Hi", 10, 10},
+		{HelveticaOblique, "Hi", 10, 9.44},
+		{Courier, "Hi!", 10, 18},
+		{CourierBoldOblique, "日本", 10, 12},
+		{Helvetica, "日", 10, 5.56},
+	}
+	for _, tt := range tests {
+		t.Run(tt.font.Name()+" "+tt.text, func(t *testing.T) {
+			if got := tt.font.Width(tt.text, tt.size); num(got) != num(tt.want) {
+				t.Errorf("Width(%q, %v) = %v, want %v", tt.text, tt.size, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFontStyle(t *testing.T) {
+	tests := []struct {
+		font         Font
+		bold, italic bool
+		want         Font
+	}{
+		{Helvetica, false, false, Helvetica},
+		{Helvetica, true, false, HelveticaBold},
+		{HelveticaBold, false, true, HelveticaOblique},
+		{HelveticaOblique, true, true, HelveticaBoldOblique},
+		{CourierBoldOblique, false, false, Courier},
+		{Courier, true, true, CourierBoldOblique},
+	}
+	for _, tt := range tests {
+		got := tt.font.Style(tt.bold, tt.italic)
+		if got != tt.want {
+			t.Errorf("%s.Style(%v, %v) = %s, want %s", tt.font.Name(), tt.bold, tt.italic, got.Name(), tt.want.Name())
+		}
+		if got.Bold() != tt.bold || got.Italic() != tt.italic || got.Monospace() != tt.font.Monospace() {
+			t.Errorf("%s reports the wrong style", got.Name())
+		}
+	}
+}
+
+func TestNum(t *testing.T) {
+	tests := []struct {
+		value float64
+		want  string
+	}{
+		{0, "0"},
+		{12, "12"},
+		{0.5, "0.5"},
+		{1.005, "1"},
+		{841.886, "841.89"},
+		{-3.14159, "-3.14"},
+	}
+	for _, tt := range tests {
+		if got := num(tt.value); got != tt.want {
+			t.Errorf("num(%v) = %q, want %q", tt.value, got, tt.want)
+		}
+	}
+}
+
+func TestAddImage(t *testing.T) {
+	tests := []struct {
+		name       string
+		data       []byte
+		err        error
+		colorSpace string
+		mask       bool
+	}{
+		{"opaque png", pngImage(t, 4, 2, 0xff), nil, "DeviceRGB", false},
+		{"translucent png", pngImage(t, 4, 2, 0x40), nil, "DeviceRGB", true},
+		{"not an image", []byte("hello"), ErrUnsupportedImage, "", false},
+		{"empty", nil, ErrUnsupportedImage, "", false},
+		{"truncated png", pngImage(t, 4, 2, 0xff)[:40], ErrUnsupportedImage, "", false},
+		{"truncated jpeg", []byte{0xff, 0xd8, 0xff, 0xdb, 0x00}, ErrUnsupportedImage, "", false},
+		{"too many pixels", pngHeader(10_000, 10_000), ErrImageTooLarge, "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := New(A4)
+			image, err := d.AddImage(tt.data)
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("AddImage() error = %v, want %v", err, tt.err)
+			}
+			if err != nil {
+				if len(d.images) != 0 {
+					t.Error("a rejected image was embedded")
+				}
+				return
+			}
+			if image.colorSpace != tt.colorSpace || (image.mask != nil) != tt.mask {
+				t.Errorf("image = %s with mask %v, want %s with mask %v", image.colorSpace, image.mask != nil, tt.colorSpace, tt.mask)
+			}
+			if image.Width() != 4 || image.Height() != 2 {
+				t.Errorf("image is %dx%d, want 4x2", image.Width(), image.Height())
+			}
+		})
+	}
+}
+
+// pngHeader is the start of a PNG claiming the given size, enough for
+// image.DecodeConfig
+func pngHeader(width, height int) []byte {
+	var out bytes.Buffer
+	png.Encode(&out, image.NewGray(image.Rect(0, 0, 1, 1)))
+	data := out.Bytes()[:33]
+	for i, v := range []int{width, height} {
+		data[16+4*i] = byte(v >> 24)
+		data[17+4*i] = byte(v >> 16)
+		data[18+4*i] = byte(v >> 8)
+		data[19+4*i] = byte(v)
+	}
+	binary.BigEndian.PutUint32(data[29:], crc32.ChecksumIEEE(data[12:29]))
+	return data
+}
+
+func TestRender(t *testing.T) {
+	schema := prosemirror.DefaultSchema()
+	dataURL := "data:image/png;base64," + base64.StdEncoding.EncodeToString(pngImage(t, 8, 8, 0xff))
+	long := `{"type":"paragraph","content":[{"type":"text","text":"` + strings.Repeat("lorem ipsum dolor sit amet ", 40) + `"}]}`
+
+	tests := []struct {
+		name    string
+		doc     string
+		title   string
+		pages   int
+		content []string
+		images  int
+	}{
+		{
+			name:    "empty document",
+			doc:     `{"type":"doc","content":[{"type":"paragraph"}]}`,
+			pages:   2,
+			content: []string{"(Untitled document) Tj", "(Page 1 of 1) Tj"},
+		},
+		{
+			name:    "headings and marks",
+			doc:     `{"type":"doc","content":[{"type":"heading","attrs":{"level":1},"content":[{"type":"text","text":"Intro"}]},{"type":"paragraph","content":[{"type":"text","text":"plain "},{"type":"text","marks":[{"type":"bold"}],"text":"bold"}]}]}`,
+			title:   "Notes",
+			pages:   2,
+			content: []string{"(Notes) Tj", "/F1 22 Tf", "(Intro) Tj", "/F0 11 Tf", "(plain ) Tj", "/F1 11 Tf", "(bold) Tj"},
+		},
+		{
+			name:    "lists and code",
+			doc:     `{"type":"doc","content":[{"type":"orderedList","attrs":{"start":3},"content":[{"type":"listItem","content":[{"type":"paragraph","content":[{"type":"text","text":"third"}]}]}]},{"type":"codeBlock","content":[{"type":"text","text":"x := 1"}]}]}`,
+			pages:   2,
+			content: []string{"(3.) Tj", "(third) Tj", "(x := 1) Tj", "/F4 9.5 Tf"},
+		},
+		{
+			name:    "inline image",
+			doc:     `{"type":"doc","content":[{"type":"image","attrs":{"src":"` + dataURL + `"}},{"type":"image","attrs":{"src":"` + dataURL + `"}}]}`,
+			pages:   2,
+			content: []string{"/Im0 Do"},
+			images:  1,
+		},
+		{
+			name:    "remote image is not fetched",
+			doc:     `{"type":"doc","content":[{"type":"image","attrs":{"src":"https://example.com/a.png","alt":"chart"}}]}`,
+			pages:   2,
+			content: []string{"([chart]) Tj"},
+		},
+		{
+			name:    "broken inline image",
+			doc:     `{"type":"doc","content":[{"type":"image","attrs":{"src":"data:image/png;base64,AAAA"}}]}`,
+			pages:   2,
+			content: []string{"([image]) Tj"},
+		},
+		{
+			name:    "long document",
+			doc:     `{"type":"doc","content":[` + strings.Repeat(long+",", 9) + long + `]}`,
+			pages:   5,
+			content: []string{"(Page 1 of 4) Tj", "(Page 4 of 4) Tj"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			doc, err := schema.NodeFromJSON([]byte(tt.doc))
+			if err != nil {
+				t.Fatalf("invalid test document: %v", err)
+			}
+			data, err := Render(schema, doc, Options{Size: A4, Margins: Margins{72, 72, 72, 72}, Title: tt.title})
+			if err != nil {
+				t.Fatalf("Render: %v", err)
+			}
+			checkStructure(t, data)
+			if want := fmt.Sprintf("/Count %d ", tt.pages); !bytes.Contains(data, []byte(want)) {
+				t.Errorf("document does not have %d pages", tt.pages)
+			}
+			if got := bytes.Count(data, []byte("/Subtype /Image")); got != tt.images {
+				t.Errorf("document embeds %d images, want %d", got, tt.images)
+			}
+			content := contentStreams(t, data)
+			for _, s := range tt.content {
+				if !strings.Contains(content, s) {
+					t.Errorf("content is missing %q", s)
+				}
+			}
+		})
+	}
+}
+
+func TestRenderInvalidMargins(t *testing.T) {
+	schema := prosemirror.DefaultSchema()
+	doc, err := schema.NodeFromJSON([]byte(`{"type":"doc","content":[{"type":"paragraph"}]}`))
+	if err != nil {
+		t.Fatalf("invalid test document: %v", err)
+	}
+	tests := []struct {
+		name    string
+		size    Size
+		margins Margins
+	}{
+		{"no width left", A4, Margins{Left: 300, Right: 300}},
+		{"no height left", A5, Margins{Top: 250, Bottom: 250}},
+		{"negative margin", A4, Margins{Top: -1}},
+		{"zero size", Size{}, Margins{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := Render(schema, doc, Options{Size: tt.size, Margins: tt.margins}); !errors.Is(err, ErrInvalidMargins) {
+				t.Errorf("Render() error = %v, want %v", err, ErrInvalidMargins)
+			}
+		})
+	}
+}
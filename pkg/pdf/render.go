@@ -0,0 +1,825 @@
+package pdf
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/emaforlin/ce-document-service/pkg/prosemirror"
+)
+
+var ErrInvalidMargins = errors.New("margins leave no room for content")
+
+// minContentSize is the smallest width and height, in points, the margins
+// must leave for content
+const minContentSize = 144
+
+// Margins are the distances in points between the edges of the page and the
+// content
+type Margins struct {
+	Top, Right, Bottom, Left float64
+}
+
+// Options lay out a rendered document
+type Options struct {
+	Size    Size
+	Margins Margins
+	// Title is shown on the title page and stored in the document info
+	Title   string
+	Created time.Time
+	Updated time.Time
+}
+
+var (
+	textColor   = Color{R: 0.12, G: 0.14, B: 0.16}
+	mutedColor  = Color{R: 0.35, G: 0.39, B: 0.43}
+	linkColor   = Color{R: 0.04, G: 0.41, B: 0.85}
+	borderColor = Color{R: 0.82, G: 0.84, B: 0.87}
+	codeColor   = Color{R: 0.95, G: 0.96, B: 0.97}
+	markColor   = Color{R: 1, G: 0.97, B: 0.77}
+)
+
+const (
+	bodySize      = 11
+	codeSize      = 9.5
+	lineSpacing   = 1.4
+	blockGap      = 8
+	listIndent    = 20
+	quoteIndent   = 16
+	cellPadding   = 5
+	codePadding   = 6
+	footerSize    = 9
+	titleSize     = 28
+	pixelsToPoint = 0.75
+)
+
+var headingSizes = [...]float64{22, 18, 15, 13, 11.5, 11}
+
+// Render lays out a ProseMirror document on pages of the given size, after a
+// title page, and numbers the content pages
+func Render(schema *prosemirror.Schema, content *prosemirror.Node, options Options) ([]byte, error) {
+	margins := options.Margins
+	if options.Size.Width-margins.Left-margins.Right < minContentSize ||
+		options.Size.Height-margins.Top-margins.Bottom < minContentSize ||
+		margins.Top < 0 || margins.Right < 0 || margins.Bottom < 0 || margins.Left < 0 {
+		return nil, ErrInvalidMargins
+	}
+
+	document := New(options.Size)
+	document.Title = options.Title
+	document.Created = options.Created
+	document.Modified = options.Updated
+
+	r := &renderer{
+		schema:   schema,
+		document: document,
+		options:  options,
+		top:      margins.Top,
+		bottom:   options.Size.Height - margins.Bottom,
+		left:     margins.Left,
+		right:    options.Size.Width - margins.Right,
+		color:    textColor,
+		images:   make(map[string]*Image),
+	}
+	r.renderTitlePage()
+	r.renderBlock(content)
+	r.numberPages()
+
+	return document.Bytes()
+}
+
+type renderer struct {
+	schema   *prosemirror.Schema
+	document *Document
+	options  Options
+
+	page *Page
+	// y is the top of the next line, top and bottom bound the content area
+	y, top, bottom float64
+	// left and right bound the content of the current block
+	left, right float64
+	// pending is the space to leave before the next line, dropped at the top
+	// of a page
+	pending float64
+	color   Color
+	// bars are the x positions of the blockquote bars drawn next to lines
+	bars   []float64
+	marker *marker
+	images map[string]*Image
+}
+
+// style is how a piece of inline text is drawn. It is comparable, so runs of
+// text with the same style can be merged.
+type style struct {
+	font       Font
+	size       float64
+	color      Color
+	background *Color
+	underline  bool
+	strike     bool
+	link       string
+	// rise shifts the baseline up for superscripts and down for subscripts
+	rise float64
+}
+
+type fragment struct {
+	text  string
+	style style
+	width float64
+}
+
+type line struct {
+	fragments []fragment
+	width     float64
+	height    float64
+	size      float64
+}
+
+// marker is the bullet, number or checkbox drawn next to the first line of a
+// list item
+type marker struct {
+	x        float64
+	text     string
+	checkbox bool
+	checked  bool
+}
+
+func (r *renderer) renderTitlePage() {
+	page := r.document.AddPage()
+	width := r.right - r.left
+
+	title := strings.TrimSpace(r.options.Title)
+	if title == "" {
+		title = "Untitled document"
+	}
+	lines := r.wrap([]fragment{{text: title, style: style{font: HelveticaBold, size: titleSize, color: textColor}}}, width)
+
+	y := r.top + (r.bottom-r.top)/3
+	for _, l := range lines {
+		r.drawLine(page, l, r.left+(width-l.width)/2, y)
+		y += l.height
+	}
+	y += 12
+	page.Line(r.left+width/3, y, r.right-width/3, y, 0.75, borderColor)
+	y += 24
+
+	if !r.options.Updated.IsZero() {
+		updated := "Last updated " + r.options.Updated.UTC().Format("January 2, 2006 15:04 MST")
+		page.Text(r.left+(width-Helvetica.Width(updated, bodySize))/2, y, Helvetica, bodySize, mutedColor, updated)
+	}
+}
+
+// numberPages adds "Page n of m" to the footer of every content page
+func (r *renderer) numberPages() {
+	pages := r.document.Pages()[1:]
+	y := r.options.Size.Height - max(r.options.Margins.Bottom/2, footerSize)
+	for i, page := range pages {
+		text := fmt.Sprintf("Page %d of %d", i+1, len(pages))
+		x := (r.options.Size.Width - Helvetica.Width(text, footerSize)) / 2
+		page.Text(x, y, Helvetica, footerSize, mutedColor, text)
+	}
+}
+
+func (r *renderer) newPage() {
+	r.page = r.document.AddPage()
+	r.y = r.top
+	r.pending = 0
+}
+
+// gap asks for space before the next line, gaps next to each other collapse
+func (r *renderer) gap(height float64) {
+	r.pending = max(r.pending, height)
+}
+
+// reserve makes room for height points of content, starting a new page when
+// the current one is full
+func (r *renderer) reserve(height float64) {
+	if r.page == nil {
+		r.newPage()
+		return
+	}
+	if r.y == r.top {
+		r.pending = 0
+		return
+	}
+	if r.y+r.pending+height > r.bottom {
+		r.newPage()
+		return
+	}
+	r.advance(r.pending)
+	r.pending = 0
+}
+
+// advance moves down, extending the blockquote bars over the space
+func (r *renderer) advance(height float64) {
+	for _, x := range r.bars {
+		r.page.Line(x, r.y, x, min(r.y+height, r.bottom), 2.5, borderColor)
+	}
+	r.y += height
+}
+
+func (r *renderer) baseStyle() style {
+	return style{font: Helvetica, size: bodySize, color: r.color}
+}
+
+func (r *renderer) renderBlock(node *prosemirror.Node) {
+	switch node.Type {
+	case "paragraph":
+		r.renderLines(r.layoutInline(node, r.baseStyle(), r.right-r.left), 0)
+		r.gap(blockGap)
+	case "heading":
+		level := min(max(attrInt(node.Attr("level"), 1), 1), len(headingSizes))
+		base := r.baseStyle()
+		base.font = HelveticaBold
+		base.size = headingSizes[level-1]
+		r.gap(base.size * 0.8)
+		lines := r.layoutInline(node, base, r.right-r.left)
+		// Keep the heading with the first lines that follow it
+		r.renderLines(lines, 2*bodySize*lineSpacing)
+		r.gap(base.size * 0.4)
+	case "blockquote":
+		r.bars = append(r.bars, r.left+1.25)
+		left, color := r.left, r.color
+		r.left += quoteIndent
+		r.color = mutedColor
+		r.renderChildren(node)
+		r.left, r.color = left, color
+		r.bars = r.bars[:len(r.bars)-1]
+		r.gap(blockGap)
+	case "bulletList", "orderedList", "taskList":
+		r.renderList(node)
+	case "codeBlock":
+		r.renderCodeBlock(node)
+	case "horizontalRule":
+		r.reserve(blockGap * 2)
+		r.flushMarker()
+		r.page.Line(r.left, r.y+blockGap, r.right, r.y+blockGap, 0.75, borderColor)
+		r.advance(blockGap * 2)
+		r.gap(blockGap)
+	case "image":
+		r.renderImage(node)
+	case "table":
+		r.renderTable(node)
+	default:
+		if len(node.Content) > 0 && r.schema.IsInline(node.Content[0]) {
+			r.renderLines(r.layoutInline(node, r.baseStyle(), r.right-r.left), 0)
+			r.gap(blockGap)
+			return
+		}
+		r.renderChildren(node)
+	}
+}
+
+func (r *renderer) renderChildren(node *prosemirror.Node) {
+	for _, child := range node.Content {
+		r.renderBlock(child)
+	}
+}
+
+// renderLines draws lines in the current block, keep is extra room wanted
+// below the first line so it does not end up alone at the bottom of a page
+func (r *renderer) renderLines(lines []line, keep float64) {
+	for i, l := range lines {
+		if i == 0 {
+			r.reserve(l.height + keep)
+		} else {
+			r.reserve(l.height)
+		}
+		r.drawMarker(l.height, l.size)
+		r.drawLine(r.page, l, r.left, r.y)
+		r.advance(l.height)
+	}
+}
+
+func (r *renderer) renderList(node *prosemirror.Node) {
+	start := attrInt(node.Attr("start"), 1)
+	indent := float64(listIndent)
+	if node.Type == "orderedList" {
+		widest := strconv.Itoa(start+len(node.Content)-1) + "."
+		indent = max(indent, Helvetica.Width(widest, bodySize)+8)
+	}
+
+	left := r.left
+	r.left += indent
+	for i, item := range node.Content {
+		m := &marker{x: left}
+		switch node.Type {
+		case "bulletList":
+			m.text = "•"
+			m.x = r.left - 10
+		case "orderedList":
+			m.text = strconv.Itoa(start+i) + "."
+			m.x = r.left - 6 - Helvetica.Width(m.text, bodySize)
+		case "taskList":
+			m.checkbox = true
+			m.checked, _ = item.Attr("checked").(bool)
+			m.x = r.left - 14
+		}
+		r.marker = m
+		r.renderChildren(item)
+		// Items without text still get their marker
+		if r.marker != nil {
+			r.renderLines([]line{{height: bodySize * lineSpacing, size: bodySize}}, 0)
+		}
+		r.gap(3)
+	}
+	r.left = left
+	r.gap(blockGap)
+}
+
+// drawMarker draws the pending list marker next to a line of the given
+// height and font size starting at the current position
+func (r *renderer) drawMarker(height, size float64) {
+	m := r.marker
+	if m == nil {
+		return
+	}
+	r.marker = nil
+	baseline := r.y + baselineOffset(height, max(size, bodySize))
+	if !m.checkbox {
+		r.page.Text(m.x, baseline, Helvetica, bodySize, r.color, m.text)
+		return
+	}
+	box := bodySize * 0.75
+	top := baseline - box
+	r.page.StrokeRect(m.x, top, box, box, 0.75, r.color)
+	if m.checked {
+		r.page.Line(m.x+box*0.2, top+box*0.55, m.x+box*0.42, top+box*0.78, 1.25, r.color)
+		r.page.Line(m.x+box*0.42, top+box*0.78, m.x+box*0.82, top+box*0.22, 1.25, r.color)
+	}
+}
+
+// flushMarker draws the pending list marker next to blocks that do not start
+// with a line of text
+func (r *renderer) flushMarker() {
+	r.drawMarker(bodySize*lineSpacing, bodySize)
+}
+
+func (r *renderer) renderCodeBlock(node *prosemirror.Node) {
+	width := r.right - r.left
+	base := style{font: Courier, size: codeSize, color: textColor}
+	perLine := max(int((width-2*codePadding)/Courier.Width(" ", codeSize)), 1)
+
+	var lines []line
+	text := strings.ReplaceAll(strings.TrimSuffix(node.TextContent(), "\n"), "\t", "    ")
+	for _, source := range strings.Split(text, "\n") {
+		runes := []rune(source)
+		for {
+			chunk := runes[:min(len(runes), perLine)]
+			runes = runes[len(chunk):]
+			l := line{height: codeSize * lineSpacing, size: codeSize}
+			if len(chunk) > 0 {
+				l.fragments = []fragment{{text: string(chunk), style: base, width: Courier.Width(string(chunk), codeSize)}}
+			}
+			lines = append(lines, l)
+			if len(runes) == 0 {
+				break
+			}
+		}
+	}
+
+	r.reserve(codePadding + lines[0].height)
+	r.flushMarker()
+	r.page.Rect(r.left, r.y, width, codePadding, codeColor)
+	r.advance(codePadding)
+	for _, l := range lines {
+		if r.y+l.height > r.bottom {
+			r.newPage()
+		}
+		r.page.Rect(r.left, r.y, width, l.height, codeColor)
+		r.drawLine(r.page, l, r.left+codePadding, r.y)
+		r.advance(l.height)
+	}
+	if r.y+codePadding <= r.bottom {
+		r.page.Rect(r.left, r.y, width, codePadding, codeColor)
+		r.advance(codePadding)
+	}
+	r.gap(blockGap)
+}
+
+func (r *renderer) renderImage(node *prosemirror.Node) {
+	src, _ := prosemirror.SafeImageURL(attrString(node.Attr("src")))
+	image := r.image(src)
+	if image == nil {
+		r.renderImagePlaceholder(node, src)
+		return
+	}
+
+	width := float64(image.Width()) * pixelsToPoint
+	height := float64(image.Height()) * pixelsToPoint
+	if scale := min((r.right-r.left)/width, (r.bottom-r.top)/height, 1); scale < 1 {
+		width *= scale
+		height *= scale
+	}
+	r.reserve(height)
+	r.flushMarker()
+	r.page.Image(image, r.left, r.y, width, height)
+	r.advance(height)
+	r.gap(blockGap)
+}
+
+// renderImagePlaceholder stands in for images that cannot be embedded. Only
+// inline data is embedded, fetching remote images while rendering would let
+// documents make the server issue arbitrary requests.
+func (r *renderer) renderImagePlaceholder(node *prosemirror.Node, src string) {
+	label := attrString(node.Attr("alt"))
+	if label == "" {
+		label = attrString(node.Attr("title"))
+	}
+	if label == "" {
+		label = "image"
+	}
+	s := r.baseStyle()
+	s.font = HelveticaOblique
+	s.color = mutedColor
+	if isExternalURL(src) {
+		s.color = linkColor
+		s.underline = true
+		s.link = src
+	}
+	r.renderLines(r.wrap([]fragment{{text: "[" + label + "]", style: s}}, r.right-r.left), 0)
+	r.gap(blockGap)
+}
+
+// image decodes and embeds an inline data URL, images used more than once
+// are embedded once
+func (r *renderer) image(src string) *Image {
+	if image, ok := r.images[src]; ok {
+		return image
+	}
+	var image *Image
+	if comma := strings.IndexByte(src, ','); strings.HasPrefix(src, "data:") && comma > 0 {
+		data, err := base64.StdEncoding.DecodeString(strings.Join(strings.Fields(src[comma+1:]), ""))
+		if err == nil {
+			image, _ = r.document.AddImage(data)
+		}
+	}
+	r.images[src] = image
+	return image
+}
+
+// renderTable lays out tables with columns of equal width. Rows move to the
+// next page as a whole unless they are taller than a page, then they are
+// split between lines.
+func (r *renderer) renderTable(node *prosemirror.Node) {
+	columns := 0
+	for _, row := range node.Content {
+		span := 0
+		for _, cell := range row.Content {
+			span += max(attrInt(cell.Attr("colspan"), 1), 1)
+		}
+		columns = max(columns, span)
+	}
+	if columns == 0 {
+		return
+	}
+	columnWidth := (r.right - r.left) / float64(columns)
+
+	for _, row := range node.Content {
+		var cells []tableCell
+		x := r.left
+		height := 0.0
+		for _, c := range row.Content {
+			span := min(max(attrInt(c.Attr("colspan"), 1), 1), columns-len(cells))
+			if span <= 0 {
+				break
+			}
+			header := c.Type == "tableHeader"
+			lines := r.layoutCell(c, header, columnWidth*float64(span)-2*cellPadding)
+			cells = append(cells, tableCell{x: x, width: columnWidth * float64(span), header: header, lines: lines})
+			x += columnWidth * float64(span)
+			height = max(height, linesHeight(lines))
+		}
+		height += 2 * cellPadding
+
+		if height <= r.bottom-r.top {
+			r.reserve(height)
+		} else {
+			r.reserve(bodySize*lineSpacing + 2*cellPadding)
+		}
+		r.flushMarker()
+		for {
+			r.renderRowChunk(cells)
+			if !anyLines(cells) {
+				break
+			}
+			r.newPage()
+		}
+	}
+	r.gap(blockGap)
+}
+
+// layoutCell lays out the blocks of a table cell. Cells get the text of
+// nested blocks other than paragraphs and headings.
+func (r *renderer) layoutCell(node *prosemirror.Node, header bool, width float64) []line {
+	base := r.baseStyle()
+	if header {
+		base.font = HelveticaBold
+	}
+	var lines []line
+	for _, block := range node.Content {
+		if len(block.Content) > 0 && r.schema.IsInline(block.Content[0]) {
+			lines = append(lines, r.layoutInline(block, base, width)...)
+		} else if text := block.TextContent(); text != "" {
+			lines = append(lines, r.wrap([]fragment{{text: text, style: base}}, width)...)
+		}
+	}
+	return lines
+}
+
+type tableCell struct {
+	x, width float64
+	header   bool
+	// lines are the lines of the cell left to draw
+	lines []line
+}
+
+// renderRowChunk draws as many lines of each cell of a row as fit on the
+// page, at least one when the row starts the page
+func (r *renderer) renderRowChunk(cells []tableCell) {
+	available := r.bottom - r.y - 2*cellPadding
+	chunk := 0.0
+	taken := make([][]line, len(cells))
+	for i := range cells {
+		used := 0.0
+		for len(cells[i].lines) > 0 {
+			l := cells[i].lines[0]
+			if used+l.height > available && (used > 0 || r.y > r.top) {
+				break
+			}
+			taken[i] = append(taken[i], l)
+			cells[i].lines = cells[i].lines[1:]
+			used += l.height
+		}
+		chunk = max(chunk, used)
+	}
+	chunk += 2 * cellPadding
+
+	for i, c := range cells {
+		if c.header {
+			r.page.Rect(c.x, r.y, c.width, chunk, codeColor)
+		}
+		y := r.y + cellPadding
+		for _, l := range taken[i] {
+			r.drawLine(r.page, l, c.x+cellPadding, y)
+			y += l.height
+		}
+		r.page.StrokeRect(c.x, r.y, c.width, chunk, 0.75, borderColor)
+	}
+	r.advance(chunk)
+}
+
+func anyLines(cells []tableCell) bool {
+	for _, c := range cells {
+		if len(c.lines) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+func linesHeight(lines []line) float64 {
+	height := 0.0
+	for _, l := range lines {
+		height += l.height
+	}
+	return height
+}
+
+// layoutInline breaks the inline content of a node into lines
+func (r *renderer) layoutInline(node *prosemirror.Node, base style, width float64) []line {
+	var fragments []fragment
+	for _, child := range node.Content {
+		switch {
+		case child.IsText():
+			fragments = append(fragments, fragment{text: child.Text, style: r.markStyle(base, child.Marks)})
+		case child.Type == "hardBreak":
+			fragments = append(fragments, fragment{text: "\n", style: base})
+		case child.Type == "image":
+			label := attrString(child.Attr("alt"))
+			if label == "" {
+				label = "image"
+			}
+			s := base
+			s.font = s.font.Style(s.font.Bold(), true)
+			s.color = mutedColor
+			fragments = append(fragments, fragment{text: "[" + label + "]", style: s})
+		default:
+			if text := child.TextContent(); text != "" {
+				fragments = append(fragments, fragment{text: text, style: r.markStyle(base, child.Marks)})
+			}
+		}
+	}
+	lines := r.wrap(fragments, width)
+	if len(lines) == 0 {
+		// Empty paragraphs keep their height
+		lines = append(lines, line{height: base.size * lineSpacing, size: base.size})
+	}
+	return lines
+}
+
+func (r *renderer) markStyle(base style, marks []*prosemirror.Mark) style {
+	s := base
+	bold, italic := s.font.Bold(), s.font.Italic()
+	for _, mark := range marks {
+		switch mark.Type {
+		case "bold":
+			bold = true
+		case "italic":
+			italic = true
+		case "code":
+			s.font = Courier
+			s.size = base.size * 0.9
+			s.background = &codeColor
+		case "strike":
+			s.strike = true
+		case "underline":
+			s.underline = true
+		case "highlight":
+			s.background = &markColor
+		case "superscript":
+			s.size = base.size * 0.7
+			s.rise = base.size * 0.35
+		case "subscript":
+			s.size = base.size * 0.7
+			s.rise = -base.size * 0.15
+		case "link":
+			if href, ok := prosemirror.SafeLinkURL(attrString(mark.Attr("href"))); ok && isExternalURL(href) {
+				s.link = href
+				s.color = linkColor
+				s.underline = true
+			}
+		}
+	}
+	s.font = s.font.Style(bold, italic)
+	return s
+}
+
+// wrap breaks fragments into lines no wider than width. Lines break at
+// spaces and newlines, words wider than a line are broken anywhere.
+func (r *renderer) wrap(fragments []fragment, width float64) []line {
+	var lines []line
+	var current line
+	var word []fragment
+	wordWidth := 0.0
+	// trailing is the width of the spaces ending the current line
+	trailing := 0.0
+
+	finish := func() {
+		current.width -= trailing
+		if n := len(current.fragments); n > 0 && trailing > 0 {
+			last := &current.fragments[n-1]
+			last.text = strings.TrimRight(last.text, " ")
+			last.width = last.style.font.Width(last.text, last.style.size)
+		}
+		if current.size == 0 {
+			current.size = bodySize
+		}
+		current.height = current.size * lineSpacing
+		lines = append(lines, current)
+		current, trailing = line{}, 0
+	}
+	add := func(f fragment) {
+		current.size = max(current.size, f.style.size)
+		current.width += f.width
+		if n := len(current.fragments); n > 0 && current.fragments[n-1].style == f.style {
+			current.fragments[n-1].text += f.text
+			current.fragments[n-1].width += f.width
+			return
+		}
+		current.fragments = append(current.fragments, f)
+	}
+	flushWord := func() {
+		if len(word) == 0 {
+			return
+		}
+		if current.width+wordWidth > width && len(current.fragments) > 0 {
+			finish()
+		}
+		for _, f := range word {
+			// Break words that do not fit on a line of their own
+			for current.width+f.width > width && utf8.RuneCountInString(f.text) > 1 {
+				head := fitRunes(f, width-current.width)
+				if head == "" {
+					if len(current.fragments) == 0 {
+						head = string([]rune(f.text)[:1])
+					} else {
+						finish()
+						continue
+					}
+				}
+				add(fragment{text: head, style: f.style, width: f.style.font.Width(head, f.style.size)})
+				finish()
+				f.text = f.text[len(head):]
+				f.width = f.style.font.Width(f.text, f.style.size)
+			}
+			add(f)
+		}
+		trailing = 0
+		word, wordWidth = nil, 0
+	}
+
+	for _, f := range fragments {
+		for f.text != "" {
+			i := strings.IndexAny(f.text, " \n")
+			if i < 0 {
+				i = len(f.text)
+			}
+			if i > 0 {
+				piece := fragment{text: f.text[:i], style: f.style}
+				piece.width = f.style.font.Width(piece.text, f.style.size)
+				word = append(word, piece)
+				wordWidth += piece.width
+			}
+			if i == len(f.text) {
+				break
+			}
+			flushWord()
+			if f.text[i] == '\n' {
+				current.size = max(current.size, f.style.size)
+				finish()
+			} else if len(current.fragments) > 0 {
+				space := fragment{text: " ", style: f.style, width: f.style.font.Width(" ", f.style.size)}
+				add(space)
+				trailing += space.width
+			}
+			f.text = f.text[i+1:]
+		}
+	}
+	flushWord()
+	if len(current.fragments) > 0 {
+		finish()
+	}
+	return lines
+}
+
+// fitRunes returns the longest prefix of the fragment text narrower than width
+func fitRunes(f fragment, width float64) string {
+	used := 0.0
+	for i, r := range f.text {
+		used += f.style.font.Width(string(r), f.style.size)
+		if used > width {
+			return f.text[:i]
+		}
+	}
+	return f.text
+}
+
+// drawLine draws a line with its top left corner at x, y
+func (r *renderer) drawLine(page *Page, l line, x, y float64) {
+	baseline := y + baselineOffset(l.height, l.size)
+	for _, f := range l.fragments {
+		s := f.style
+		base := baseline - s.rise
+		if s.background != nil {
+			page.Rect(x-1, base-s.size*Ascent/1000-1, f.width+2, s.size*(Ascent+Descent)/1000+2, *s.background)
+		}
+		page.Text(x, base, s.font, s.size, s.color, f.text)
+		if s.underline {
+			page.Line(x, base+s.size*0.12, x+f.width, base+s.size*0.12, s.size*0.05, s.color)
+		}
+		if s.strike {
+			page.Line(x, base-s.size*0.28, x+f.width, base-s.size*0.28, s.size*0.05, s.color)
+		}
+		if s.link != "" {
+			page.Link(x, y, f.width, l.height, s.link)
+		}
+		x += f.width
+	}
+}
+
+// baselineOffset places the baseline so the text is centered in the line
+func baselineOffset(height, size float64) float64 {
+	return (height-size*(Ascent+Descent)/1000)/2 + size*Ascent/1000
+}
+
+// isExternalURL reports whether a link can be followed from a PDF, relative
+// URLs have nothing to resolve against
+func isExternalURL(raw string) bool {
+	parsed, err := url.Parse(raw)
+	return err == nil && parsed.Scheme != "" && parsed.Scheme != "data"
+}
+
+func attrString(value any) string {
+	s, _ := value.(string)
+	return s
+}
+
+func attrInt(value any, fallback int) int {
+	switch v := value.(type) {
+	case float64:
+		return int(v)
+	case int:
+		return v
+	case string:
+		if n, err := strconv.Atoi(v); err == nil {
+			return n
+		}
+	}
+	return fallback
+}
@@ -0,0 +1,376 @@
+package prosemirror
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// contentExpr is a compiled content expression ("block+", "paragraph block*",
+// "(listItem | taskItem)+", ...). It is matched against the sequence of child
+// node types using a Thompson NFA, like ContentMatch does in prosemirror-model.
+type contentExpr struct {
+	source string
+	start  int
+	accept int
+	edges  [][]nfaEdge
+}
+
+type nfaEdge struct {
+	// types is the set of node types consumed by this edge, nil for epsilon
+	types map[string]bool
+	to    int
+}
+
+type exprKind int
+
+const (
+	exprName exprKind = iota
+	exprChoice
+	exprSeq
+	exprRepeat
+)
+
+type exprAST struct {
+	kind  exprKind
+	types []string
+	exprs []*exprAST
+	min   int
+	max   int // -1 for unbounded
+}
+
+func compileContentExpr(source string, resolve func(name string) ([]string, error)) (*contentExpr, error) {
+	tokens := tokenizeContentExpr(source)
+	p := &exprParser{source: source, tokens: tokens, resolve: resolve}
+	if len(tokens) == 0 {
+		return &contentExpr{source: source, start: 0, accept: 0, edges: [][]nfaEdge{nil}}, nil
+	}
+	ast, err := p.parseChoice()
+	if err != nil {
+		return nil, err
+	}
+	if p.pos < len(tokens) {
+		return nil, fmt.Errorf("unexpected token %q in content expression %q", tokens[p.pos], source)
+	}
+
+	expr := &contentExpr{source: source}
+	expr.start = expr.newState()
+	expr.accept = expr.compile(ast, expr.start)
+	return expr, nil
+}
+
+func (e *contentExpr) newState() int {
+	e.edges = append(e.edges, nil)
+	return len(e.edges) - 1
+}
+
+func (e *contentExpr) connect(from, to int, types map[string]bool) {
+	e.edges[from] = append(e.edges[from], nfaEdge{types: types, to: to})
+}
+
+// compile adds the states for ast starting at from and returns the state
+// reached once ast has been matched
+func (e *contentExpr) compile(ast *exprAST, from int) int {
+	switch ast.kind {
+	case exprName:
+		to := e.newState()
+		types := make(map[string]bool, len(ast.types))
+		for _, t := range ast.types {
+			types[t] = true
+		}
+		e.connect(from, to, types)
+		return to
+	case exprSeq:
+		cur := from
+		for _, sub := range ast.exprs {
+			cur = e.compile(sub, cur)
+		}
+		return cur
+	case exprChoice:
+		to := e.newState()
+		for _, sub := range ast.exprs {
+			end := e.compile(sub, from)
+			e.connect(end, to, nil)
+		}
+		return to
+	case exprRepeat:
+		cur := from
+		for i := 0; i < ast.min; i++ {
+			cur = e.compile(ast.exprs[0], cur)
+		}
+		if ast.max == -1 {
+			loop := e.newState()
+			e.connect(cur, loop, nil)
+			end := e.compile(ast.exprs[0], loop)
+			e.connect(end, loop, nil)
+			return loop
+		}
+		to := e.newState()
+		e.connect(cur, to, nil)
+		for i := ast.min; i < ast.max; i++ {
+			cur = e.compile(ast.exprs[0], cur)
+			e.connect(cur, to, nil)
+		}
+		return to
+	}
+	return from
+}
+
+// closure returns the set of states reachable from states through epsilon edges
+func (e *contentExpr) closure(states map[int]bool) map[int]bool {
+	result := make(map[int]bool, len(states))
+	stack := make([]int, 0, len(states))
+	for s := range states {
+		result[s] = true
+		stack = append(stack, s)
+	}
+	for len(stack) > 0 {
+		s := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		for _, edge := range e.edges[s] {
+			if edge.types == nil && !result[edge.to] {
+				result[edge.to] = true
+				stack = append(stack, edge.to)
+			}
+		}
+	}
+	return result
+}
+
+// match runs the child types through the automaton. It returns the index of
+// the first child that could not be matched, len(types) if every child
+// matched but the content is incomplete, or -1 if the sequence is valid.
+func (e *contentExpr) match(types []string) int {
+	states := e.closure(map[int]bool{e.start: true})
+	for i, t := range types {
+		next := make(map[int]bool)
+		for s := range states {
+			for _, edge := range e.edges[s] {
+				if edge.types != nil && edge.types[t] {
+					next[edge.to] = true
+				}
+			}
+		}
+		if len(next) == 0 {
+			return i
+		}
+		states = e.closure(next)
+	}
+	if !states[e.accept] {
+		return len(types)
+	}
+	return -1
+}
+
+// allowsContent reports whether the expression can match anything but the
+// empty sequence
+func (e *contentExpr) allowsContent() bool {
+	for _, edges := range e.edges {
+		for _, edge := range edges {
+			if edge.types != nil {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// expected lists the node types that would be accepted after the given prefix
+func (e *contentExpr) expected(types []string) []string {
+	states := e.closure(map[int]bool{e.start: true})
+	for _, t := range types {
+		next := make(map[int]bool)
+		for s := range states {
+			for _, edge := range e.edges[s] {
+				if edge.types != nil && edge.types[t] {
+					next[edge.to] = true
+				}
+			}
+		}
+		states = e.closure(next)
+	}
+	seen := map[string]bool{}
+	var result []string
+	for s := range states {
+		for _, edge := range e.edges[s] {
+			for t := range edge.types {
+				if !seen[t] {
+					seen[t] = true
+					result = append(result, t)
+				}
+			}
+		}
+	}
+	sort.Strings(result)
+	return result
+}
+
+type exprParser struct {
+	source  string
+	tokens  []string
+	pos     int
+	resolve func(name string) ([]string, error)
+}
+
+func (p *exprParser) peek() string {
+	if p.pos < len(p.tokens) {
+		return p.tokens[p.pos]
+	}
+	return ""
+}
+
+func (p *exprParser) parseChoice() (*exprAST, error) {
+	var exprs []*exprAST
+	for {
+		seq, err := p.parseSeq()
+		if err != nil {
+			return nil, err
+		}
+		exprs = append(exprs, seq)
+		if p.peek() != "|" {
+			break
+		}
+		p.pos++
+	}
+	if len(exprs) == 1 {
+		return exprs[0], nil
+	}
+	return &exprAST{kind: exprChoice, exprs: exprs}, nil
+}
+
+func (p *exprParser) parseSeq() (*exprAST, error) {
+	var exprs []*exprAST
+	for p.pos < len(p.tokens) && p.peek() != ")" && p.peek() != "|" {
+		sub, err := p.parseSubscript()
+		if err != nil {
+			return nil, err
+		}
+		exprs = append(exprs, sub)
+	}
+	if len(exprs) == 0 {
+		return nil, fmt.Errorf("empty sequence in content expression %q", p.source)
+	}
+	if len(exprs) == 1 {
+		return exprs[0], nil
+	}
+	return &exprAST{kind: exprSeq, exprs: exprs}, nil
+}
+
+func (p *exprParser) parseSubscript() (*exprAST, error) {
+	expr, err := p.parseAtom()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		switch p.peek() {
+		case "+":
+			p.pos++
+			expr = &exprAST{kind: exprRepeat, exprs: []*exprAST{expr}, min: 1, max: -1}
+		case "*":
+			p.pos++
+			expr = &exprAST{kind: exprRepeat, exprs: []*exprAST{expr}, min: 0, max: -1}
+		case "?":
+			p.pos++
+			expr = &exprAST{kind: exprRepeat, exprs: []*exprAST{expr}, min: 0, max: 1}
+		case "{":
+			p.pos++
+			if expr, err = p.parseRange(expr); err != nil {
+				return nil, err
+			}
+		default:
+			return expr, nil
+		}
+	}
+}
+
+func (p *exprParser) parseRange(expr *exprAST) (*exprAST, error) {
+	min, err := p.parseNum()
+	if err != nil {
+		return nil, err
+	}
+	max := min
+	if p.peek() == "," {
+		p.pos++
+		if p.peek() != "}" {
+			if max, err = p.parseNum(); err != nil {
+				return nil, err
+			}
+		} else {
+			max = -1
+		}
+	}
+	if p.peek() != "}" {
+		return nil, fmt.Errorf("unclosed brace in content expression %q", p.source)
+	}
+	p.pos++
+	return &exprAST{kind: exprRepeat, exprs: []*exprAST{expr}, min: min, max: max}, nil
+}
+
+func (p *exprParser) parseNum() (int, error) {
+	n, err := strconv.Atoi(p.peek())
+	if err != nil {
+		return 0, fmt.Errorf("expected number in content expression %q, got %q", p.source, p.peek())
+	}
+	p.pos++
+	return n, nil
+}
+
+func (p *exprParser) parseAtom() (*exprAST, error) {
+	tok := p.peek()
+	if tok == "(" {
+		p.pos++
+		expr, err := p.parseChoice()
+		if err != nil {
+			return nil, err
+		}
+		if p.peek() != ")" {
+			return nil, fmt.Errorf("missing closing paren in content expression %q", p.source)
+		}
+		p.pos++
+		return expr, nil
+	}
+	if tok == "" || !isNameToken(tok) {
+		return nil, fmt.Errorf("unexpected token %q in content expression %q", tok, p.source)
+	}
+	p.pos++
+	types, err := p.resolve(tok)
+	if err != nil {
+		return nil, err
+	}
+	return &exprAST{kind: exprName, types: types}, nil
+}
+
+func tokenizeContentExpr(source string) []string {
+	var tokens []string
+	var cur strings.Builder
+	flush := func() {
+		if cur.Len() > 0 {
+			tokens = append(tokens, cur.String())
+			cur.Reset()
+		}
+	}
+	for _, r := range source {
+		switch {
+		case unicode.IsSpace(r):
+			flush()
+		case strings.ContainsRune("()|+*?{},", r):
+			flush()
+			tokens = append(tokens, string(r))
+		default:
+			cur.WriteRune(r)
+		}
+	}
+	flush()
+	return tokens
+}
+
+func isNameToken(tok string) bool {
+	for _, r := range tok {
+		if !unicode.IsLetter(r) && !unicode.IsDigit(r) && r != '_' && r != '-' {
+			return false
+		}
+	}
+	return true
+}
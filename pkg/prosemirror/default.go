@@ -0,0 +1,116 @@
+package prosemirror
+
+// DefaultSchemaSpec mirrors the node and mark set of the TipTap StarterKit
+// plus the task list, table, image and link extensions used by the editor.
+func DefaultSchemaSpec() SchemaSpec {
+	noMarks := ""
+	optional := func(def any, validate string) AttrSpec {
+		return AttrSpec{Default: def, HasDefault: true, Validate: validate}
+	}
+
+	return SchemaSpec{
+		TopNode: "doc",
+		Nodes: map[string]NodeSpec{
+			"doc":  {Content: "block+"},
+			"text": {Group: "inline"},
+			"paragraph": {
+				Content: "inline*",
+				Group:   "block",
+				Attrs:   map[string]AttrSpec{"textAlign": optional(nil, "string|null")},
+			},
+			"heading": {
+				Content: "inline*",
+				Group:   "block",
+				Attrs: map[string]AttrSpec{
+					"level":     optional(float64(1), "number"),
+					"textAlign": optional(nil, "string|null"),
+				},
+			},
+			"blockquote": {Content: "block+", Group: "block"},
+			"codeBlock": {
+				Content: "text*",
+				Group:   "block",
+				Marks:   &noMarks,
+				Attrs:   map[string]AttrSpec{"language": optional(nil, "string|null")},
+			},
+			"horizontalRule": {Group: "block"},
+			"bulletList":     {Content: "listItem+", Group: "block list"},
+			"orderedList": {
+				Content: "listItem+",
+				Group:   "block list",
+				Attrs: map[string]AttrSpec{
+					"start": optional(float64(1), "number"),
+					"type":  optional(nil, "string|null"),
+				},
+			},
+			"listItem": {Content: "paragraph block*"},
+			"taskList": {Content: "taskItem+", Group: "block list"},
+			"taskItem": {
+				Content: "paragraph block*",
+				Attrs:   map[string]AttrSpec{"checked": optional(false, "boolean")},
+			},
+			"table":    {Content: "tableRow+", Group: "block"},
+			"tableRow": {Content: "(tableCell | tableHeader)*"},
+			"tableCell": {
+				Content: "block+",
+				Attrs:   tableCellAttrs(optional),
+			},
+			"tableHeader": {
+				Content: "block+",
+				Attrs:   tableCellAttrs(optional),
+			},
+			"image": {
+				Group: "block",
+				Attrs: map[string]AttrSpec{
+					"src":   {Validate: "string"},
+					"alt":   optional(nil, "string|null"),
+					"title": optional(nil, "string|null"),
+				},
+			},
+			"hardBreak": {Group: "inline", Inline: true},
+		},
+		Marks: map[string]MarkSpec{
+			"bold":        {},
+			"italic":      {},
+			"underline":   {},
+			"strike":      {},
+			"code":        {},
+			"subscript":   {},
+			"superscript": {},
+			"highlight": {
+				Attrs: map[string]AttrSpec{"color": optional(nil, "string|null")},
+			},
+			"link": {
+				Attrs: map[string]AttrSpec{
+					"href":   {Validate: "string"},
+					"target": optional(nil, "string|null"),
+					"rel":    optional(nil, "string|null"),
+					"class":  optional(nil, "string|null"),
+				},
+			},
+		},
+	}
+}
+
+func tableCellAttrs(optional func(any, string) AttrSpec) map[string]AttrSpec {
+	return map[string]AttrSpec{
+		"colspan":  optional(float64(1), "number"),
+		"rowspan":  optional(float64(1), "number"),
+		"colwidth": optional(nil, "array|null"),
+	}
+}
+
+var defaultSchema = mustCompile(DefaultSchemaSpec())
+
+// DefaultSchema returns the compiled default schema
+func DefaultSchema() *Schema {
+	return defaultSchema
+}
+
+func mustCompile(spec SchemaSpec) *Schema {
+	schema, err := NewSchema(spec)
+	if err != nil {
+		panic("prosemirror: invalid default schema: " + err.Error())
+	}
+	return schema
+}
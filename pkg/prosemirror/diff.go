@@ -0,0 +1,387 @@
+package prosemirror
+
+import (
+	"fmt"
+	"unicode/utf8"
+)
+
+// DiffStatus tells how a node or inline segment changed between two documents
+type DiffStatus string
+
+const (
+	DiffEqual    DiffStatus = "equal"
+	DiffInserted DiffStatus = "inserted"
+	DiffDeleted  DiffStatus = "deleted"
+	DiffModified DiffStatus = "modified"
+)
+
+// DiffNode is a node of the structural diff tree between two documents
+type DiffNode struct {
+	Status  DiffStatus
+	Old     *Node
+	New     *Node
+	OldPath string
+	NewPath string
+	// Attrs holds the attribute changes of a modified node
+	Attrs map[string]AttrChange
+	// Children is set for modified nodes with block content
+	Children []*DiffNode
+	// Inline is set for modified textblocks
+	Inline []InlineSegment
+}
+
+// AttrChange is the old and new value of a changed attribute
+type AttrChange struct {
+	Old any `json:"old"`
+	New any `json:"new"`
+}
+
+// InlineSegment is a run of inline content inside a modified textblock. For
+// DiffModified segments the text is unchanged but its marks differ.
+type InlineSegment struct {
+	Status DiffStatus
+	Old    []*Node
+	New    []*Node
+	// OldOffset and NewOffset are the positions of the segment inside the
+	// parent content, in ProseMirror (UTF-16) units
+	OldOffset int
+	NewOffset int
+}
+
+// Change is a single entry of the machine readable diff
+type Change struct {
+	Op       string                `json:"op"`
+	Path     string                `json:"path"`
+	OldPath  string                `json:"old_path,omitempty"`
+	NodeType string                `json:"node_type"`
+	Node     *Node                 `json:"node,omitempty"`
+	Attrs    map[string]AttrChange `json:"attrs,omitempty"`
+	Text     []TextChange          `json:"text,omitempty"`
+}
+
+// TextChange describes a change inside the inline content of a textblock.
+// Offset is relative to the new content for inserts and mark changes and to
+// the old content for deletes.
+type TextChange struct {
+	Op       string  `json:"op"`
+	Offset   int     `json:"offset"`
+	Text     string  `json:"text"`
+	OldMarks []*Mark `json:"old_marks,omitempty"`
+	NewMarks []*Mark `json:"new_marks,omitempty"`
+}
+
+// Diff compares two documents of the schema structurally
+func (s *Schema) Diff(old, new *Node) *DiffNode {
+	return differ{schema: s}.diffNode(old, new, "$", "$")
+}
+
+type differ struct {
+	schema *Schema
+}
+
+func (df differ) diffNode(old, new *Node, oldPath, newPath string) *DiffNode {
+	if old.Equal(new) {
+		return &DiffNode{Status: DiffEqual, Old: old, New: new, OldPath: oldPath, NewPath: newPath}
+	}
+
+	result := &DiffNode{
+		Status:  DiffModified,
+		Old:     old,
+		New:     new,
+		OldPath: oldPath,
+		NewPath: newPath,
+		Attrs:   diffAttrs(old.Attrs, new.Attrs),
+	}
+
+	if df.hasInlineContent(old) || df.hasInlineContent(new) {
+		result.Inline = diffInline(old.Content, new.Content)
+		return result
+	}
+
+	ops := diffSequences(len(old.Content), len(new.Content), func(i, j int) bool {
+		return old.Content[i].Equal(new.Content[j])
+	})
+
+	childPath := func(path string, i int) string {
+		return fmt.Sprintf("%s.content[%d]", path, i)
+	}
+
+	// Removed and added children between two unchanged ones are paired by
+	// node type so that edits show up as modifications
+	var deleted, inserted []int
+	flush := func() {
+		pairs := diffSequences(len(deleted), len(inserted), func(i, j int) bool {
+			return old.Content[deleted[i]].Type == new.Content[inserted[j]].Type
+		})
+		for _, op := range pairs {
+			switch op.kind {
+			case editEqual:
+				i, j := deleted[op.old], inserted[op.new]
+				result.Children = append(result.Children,
+					df.diffNode(old.Content[i], new.Content[j], childPath(oldPath, i), childPath(newPath, j)))
+			case editDelete:
+				i := deleted[op.old]
+				result.Children = append(result.Children,
+					&DiffNode{Status: DiffDeleted, Old: old.Content[i], OldPath: childPath(oldPath, i)})
+			case editInsert:
+				j := inserted[op.new]
+				result.Children = append(result.Children,
+					&DiffNode{Status: DiffInserted, New: new.Content[j], NewPath: childPath(newPath, j)})
+			}
+		}
+		deleted, inserted = nil, nil
+	}
+
+	for _, op := range ops {
+		switch op.kind {
+		case editEqual:
+			flush()
+			result.Children = append(result.Children, &DiffNode{
+				Status:  DiffEqual,
+				Old:     old.Content[op.old],
+				New:     new.Content[op.new],
+				OldPath: childPath(oldPath, op.old),
+				NewPath: childPath(newPath, op.new),
+			})
+		case editDelete:
+			deleted = append(deleted, op.old)
+		case editInsert:
+			inserted = append(inserted, op.new)
+		}
+	}
+	flush()
+
+	return result
+}
+
+func diffAttrs(old, new map[string]any) map[string]AttrChange {
+	changes := map[string]AttrChange{}
+	for key, oldValue := range old {
+		newValue, ok := new[key]
+		if !ok || !AttrsEqual(map[string]any{key: oldValue}, map[string]any{key: newValue}) {
+			changes[key] = AttrChange{Old: oldValue, New: newValue}
+		}
+	}
+	for key, newValue := range new {
+		if _, ok := old[key]; !ok {
+			changes[key] = AttrChange{Old: nil, New: newValue}
+		}
+	}
+	if len(changes) == 0 {
+		return nil
+	}
+	return changes
+}
+
+func (df differ) hasInlineContent(node *Node) bool {
+	for _, child := range node.Content {
+		if nodeType, ok := df.schema.Nodes[child.Type]; child.IsText() || ok && nodeType.IsInline() {
+			return true
+		}
+	}
+	return false
+}
+
+// inlineToken is a single character of text or an inline leaf node
+type inlineToken struct {
+	text  string
+	node  *Node
+	marks []*Mark
+}
+
+func (t inlineToken) equal(other inlineToken) bool {
+	if t.node != nil || other.node != nil {
+		return t.node != nil && other.node != nil && t.node.Type == other.node.Type && AttrsEqual(t.node.Attrs, other.node.Attrs)
+	}
+	return t.text == other.text
+}
+
+func (t inlineToken) size() int {
+	if t.node != nil {
+		return 1
+	}
+	return utf16Len(t.text)
+}
+
+func tokenize(nodes []*Node) []inlineToken {
+	var tokens []inlineToken
+	for _, node := range nodes {
+		if !node.IsText() {
+			tokens = append(tokens, inlineToken{node: node, marks: node.Marks})
+			continue
+		}
+		for text := node.Text; text != ""; {
+			_, size := utf8.DecodeRuneInString(text)
+			tokens = append(tokens, inlineToken{text: text[:size], marks: node.Marks})
+			text = text[size:]
+		}
+	}
+	return tokens
+}
+
+// joinTokens rebuilds inline nodes from tokens, merging adjacent text with the
+// same marks
+func joinTokens(tokens []inlineToken) []*Node {
+	var nodes []*Node
+	for _, token := range tokens {
+		if token.node != nil {
+			nodes = append(nodes, token.node)
+			continue
+		}
+		if n := len(nodes); n > 0 && nodes[n-1].IsText() && MarksEqual(nodes[n-1].Marks, token.marks) {
+			nodes[n-1].Text += token.text
+			continue
+		}
+		nodes = append(nodes, &Node{Type: "text", Text: token.text, Marks: token.marks})
+	}
+	return nodes
+}
+
+func diffInline(old, new []*Node) []InlineSegment {
+	oldTokens, newTokens := tokenize(old), tokenize(new)
+	ops := diffSequences(len(oldTokens), len(newTokens), func(i, j int) bool {
+		return oldTokens[i].equal(newTokens[j])
+	})
+
+	var segments []InlineSegment
+	var cur *InlineSegment
+	var curOld, curNew []inlineToken
+	oldOffset, newOffset := 0, 0
+
+	emit := func() {
+		if cur != nil {
+			cur.Old = joinTokens(curOld)
+			cur.New = joinTokens(curNew)
+			segments = append(segments, *cur)
+		}
+		cur, curOld, curNew = nil, nil, nil
+	}
+	start := func(status DiffStatus) {
+		if cur == nil || cur.Status != status {
+			emit()
+			cur = &InlineSegment{Status: status, OldOffset: oldOffset, NewOffset: newOffset}
+		}
+	}
+
+	for _, op := range ops {
+		switch op.kind {
+		case editEqual:
+			oldToken, newToken := oldTokens[op.old], newTokens[op.new]
+			if MarksEqual(oldToken.marks, newToken.marks) {
+				start(DiffEqual)
+			} else {
+				start(DiffModified)
+			}
+			curOld = append(curOld, oldToken)
+			curNew = append(curNew, newToken)
+			oldOffset += oldToken.size()
+			newOffset += newToken.size()
+		case editDelete:
+			start(DiffDeleted)
+			curOld = append(curOld, oldTokens[op.old])
+			oldOffset += oldTokens[op.old].size()
+		case editInsert:
+			start(DiffInserted)
+			curNew = append(curNew, newTokens[op.new])
+			newOffset += newTokens[op.new].size()
+		}
+	}
+	emit()
+	return segments
+}
+
+// Changes flattens the diff tree into a list of changes in document order
+func (d *DiffNode) Changes() []Change {
+	var changes []Change
+	d.collectChanges(&changes)
+	return changes
+}
+
+func (d *DiffNode) collectChanges(changes *[]Change) {
+	switch d.Status {
+	case DiffEqual:
+		return
+	case DiffInserted:
+		*changes = append(*changes, Change{Op: "insert", Path: d.NewPath, NodeType: d.New.Type, Node: d.New})
+		return
+	case DiffDeleted:
+		*changes = append(*changes, Change{Op: "delete", Path: d.OldPath, NodeType: d.Old.Type, Node: d.Old})
+		return
+	}
+
+	if d.Old.Type != d.New.Type {
+		*changes = append(*changes,
+			Change{Op: "delete", Path: d.OldPath, NodeType: d.Old.Type, Node: d.Old},
+			Change{Op: "insert", Path: d.NewPath, NodeType: d.New.Type, Node: d.New})
+		return
+	}
+
+	change := Change{Op: "modify", Path: d.NewPath, NodeType: d.New.Type, Attrs: d.Attrs}
+	if d.OldPath != d.NewPath {
+		change.OldPath = d.OldPath
+	}
+	for _, segment := range d.Inline {
+		switch segment.Status {
+		case DiffInserted:
+			change.Text = append(change.Text, TextChange{Op: "insert", Offset: segment.NewOffset, Text: inlineText(segment.New)})
+		case DiffDeleted:
+			change.Text = append(change.Text, TextChange{Op: "delete", Offset: segment.OldOffset, Text: inlineText(segment.Old)})
+		case DiffModified:
+			for i, node := range segment.New {
+				change.Text = append(change.Text, TextChange{
+					Op:       "marks",
+					Offset:   segment.NewOffset + inlineSize(segment.New[:i]),
+					Text:     inlineText([]*Node{node}),
+					OldMarks: marksAt(segment.Old, inlineSize(segment.New[:i])),
+					NewMarks: node.Marks,
+				})
+			}
+		}
+	}
+	if change.Attrs != nil || change.Text != nil || d.Inline == nil && len(d.Children) == 0 {
+		*changes = append(*changes, change)
+	}
+
+	for _, child := range d.Children {
+		child.collectChanges(changes)
+	}
+}
+
+func inlineText(nodes []*Node) string {
+	text := ""
+	for _, node := range nodes {
+		if node.IsText() {
+			text += node.Text
+		} else {
+			text += "￼"
+		}
+	}
+	return text
+}
+
+func inlineSize(nodes []*Node) int {
+	size := 0
+	for _, node := range nodes {
+		if node.IsText() {
+			size += node.TextLength()
+		} else {
+			size++
+		}
+	}
+	return size
+}
+
+// marksAt returns the marks of the inline node covering offset
+func marksAt(nodes []*Node, offset int) []*Mark {
+	for _, node := range nodes {
+		size := 1
+		if node.IsText() {
+			size = node.TextLength()
+		}
+		if offset < size {
+			return node.Marks
+		}
+		offset -= size
+	}
+	return nil
+}
@@ -0,0 +1,105 @@
+package prosemirror
+
+import "strings"
+
+// HTML renders the diff as an HTML fragment of the new document with removed
+// content in <del> and added content in <ins> elements. Elements that were
+// added, removed or had their attributes changed also get a diff-* class.
+func (d *DiffNode) HTML(schema *Schema) string {
+	var b strings.Builder
+	r := HTMLRenderer{Schema: schema}
+	d.render(&b, r)
+	return b.String()
+}
+
+func (d *DiffNode) render(b *strings.Builder, r HTMLRenderer) {
+	switch d.Status {
+	case DiffEqual:
+		r.RenderNode(b, d.New)
+	case DiffInserted:
+		renderWrapped(b, r, d.New, "ins", "diff-inserted")
+	case DiffDeleted:
+		renderWrapped(b, r, d.Old, "del", "diff-deleted")
+	case DiffModified:
+		if d.Old.Type != d.New.Type {
+			renderWrapped(b, r, d.Old, "del", "diff-deleted")
+			renderWrapped(b, r, d.New, "ins", "diff-inserted")
+			return
+		}
+
+		open, close := r.Tags(d.New)
+		if d.Attrs != nil {
+			open = withClass(open, "diff-modified")
+		}
+		b.WriteString(open)
+		if d.Inline != nil {
+			for _, segment := range d.Inline {
+				switch segment.Status {
+				case DiffEqual:
+					r.RenderInline(b, segment.New)
+				case DiffInserted:
+					b.WriteString("<ins>")
+					r.RenderInline(b, segment.New)
+					b.WriteString("</ins>")
+				case DiffDeleted:
+					b.WriteString("<del>")
+					r.RenderInline(b, segment.Old)
+					b.WriteString("</del>")
+				case DiffModified:
+					b.WriteString(`<del class="diff-marks">`)
+					r.RenderInline(b, segment.Old)
+					b.WriteString(`</del><ins class="diff-marks">`)
+					r.RenderInline(b, segment.New)
+					b.WriteString("</ins>")
+				}
+			}
+		} else {
+			for _, child := range d.Children {
+				child.render(b, r)
+			}
+		}
+		b.WriteString(close)
+	}
+}
+
+// renderWrapped renders an added or removed node. Text is wrapped in the ins
+// or del element while the structure is kept, so list items and table cells
+// stay valid children of their parents.
+func renderWrapped(b *strings.Builder, r HTMLRenderer, node *Node, tag, class string) {
+	if node.IsText() {
+		b.WriteString("<" + tag + ">")
+		r.RenderInline(b, []*Node{node})
+		b.WriteString("</" + tag + ">")
+		return
+	}
+
+	open, close := r.Tags(node)
+	if len(node.Content) == 0 {
+		b.WriteString("<" + tag + ` class="` + class + `">` + open + close + "</" + tag + ">")
+		return
+	}
+
+	b.WriteString(withClass(open, class))
+	if r.isInlineContent(node.Content) {
+		b.WriteString("<" + tag + ">")
+		r.RenderInline(b, node.Content)
+		b.WriteString("</" + tag + ">")
+	} else {
+		for _, child := range node.Content {
+			renderWrapped(b, r, child, tag, class)
+		}
+	}
+	b.WriteString(close)
+}
+
+// withClass adds a class attribute to the first element of an opening tag
+func withClass(open, class string) string {
+	if !strings.HasPrefix(open, "<") {
+		return open
+	}
+	end := strings.IndexAny(open, " >")
+	if end < 0 {
+		return open
+	}
+	return open[:end] + ` class="` + class + `"` + open[end:]
+}
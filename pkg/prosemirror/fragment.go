@@ -0,0 +1,235 @@
+package prosemirror
+
+import (
+	"strings"
+	"unicode/utf16"
+)
+
+// The functions in this file mirror the Fragment and Node helpers of
+// prosemirror-model. Nodes are treated as immutable: every operation returns
+// new nodes and leaves its inputs untouched.
+
+// IsLeaf reports whether the node cannot have content according to the schema
+func (s *Schema) IsLeaf(n *Node) bool {
+	if n.IsText() {
+		return true
+	}
+	if nodeType, ok := s.Nodes[n.Type]; ok {
+		return nodeType.IsLeaf()
+	}
+	return len(n.Content) == 0
+}
+
+// IsInline reports whether the node is inline content
+func (s *Schema) IsInline(n *Node) bool {
+	if n.IsText() {
+		return true
+	}
+	if nodeType, ok := s.Nodes[n.Type]; ok {
+		return nodeType.IsInline()
+	}
+	return false
+}
+
+// NodeSize is the size of the node in ProseMirror positions
+func (s *Schema) NodeSize(n *Node) int {
+	if n.IsText() {
+		return n.TextLength()
+	}
+	if s.IsLeaf(n) {
+		return 1
+	}
+	return s.ContentSize(n.Content) + 2
+}
+
+// ContentSize is the size of a fragment in ProseMirror positions
+func (s *Schema) ContentSize(content []*Node) int {
+	size := 0
+	for _, child := range content {
+		size += s.NodeSize(child)
+	}
+	return size
+}
+
+// withContent returns a copy of the node markup holding the given content
+func withContent(n *Node, content []*Node) *Node {
+	return &Node{Type: n.Type, Attrs: n.Attrs, Marks: n.Marks, Content: content}
+}
+
+// withText returns a copy of a text node with different text
+func withText(n *Node, text string) *Node {
+	return &Node{Type: n.Type, Attrs: n.Attrs, Marks: n.Marks, Text: text}
+}
+
+// withMarks returns a copy of the node with a different mark set
+func withMarks(n *Node, marks []*Mark) *Node {
+	return &Node{Type: n.Type, Attrs: n.Attrs, Marks: marks, Content: n.Content, Text: n.Text}
+}
+
+// sameMarkup reports whether two nodes have the same type, attributes and marks
+func sameMarkup(a, b *Node) bool {
+	return a.Type == b.Type && AttrsEqual(a.Attrs, b.Attrs) && MarksEqual(a.Marks, b.Marks)
+}
+
+// cutNode returns the part of the node between from and to, which are offsets
+// into the text for text nodes and into the content otherwise
+func (s *Schema) cutNode(n *Node, from, to int) *Node {
+	if n.IsText() {
+		if from == 0 && to == n.TextLength() {
+			return n
+		}
+		return withText(n, sliceUTF16(n.Text, from, to))
+	}
+	if from == 0 && to == s.ContentSize(n.Content) {
+		return n
+	}
+	return withContent(n, s.cutFragment(n.Content, from, to))
+}
+
+// cutFragment returns the part of the content between from and to
+func (s *Schema) cutFragment(content []*Node, from, to int) []*Node {
+	var result []*Node
+	if to <= from {
+		return result
+	}
+	pos := 0
+	for _, child := range content {
+		if pos >= to {
+			break
+		}
+		size := s.NodeSize(child)
+		end := pos + size
+		if end > from {
+			if pos < from || end > to {
+				if child.IsText() {
+					child = s.cutNode(child, max(0, from-pos), min(child.TextLength(), to-pos))
+				} else {
+					child = s.cutNode(child, max(0, from-pos-1), min(s.ContentSize(child.Content), to-pos-1))
+				}
+			}
+			result = append(result, child)
+		}
+		pos = end
+	}
+	return result
+}
+
+// appendNode adds a node to a fragment, joining it with a preceding text node
+// with the same marks
+func appendNode(content []*Node, node *Node) []*Node {
+	if n := len(content); n > 0 && node.IsText() && content[n-1].IsText() && sameMarkup(content[n-1], node) {
+		joined := withText(content[n-1], content[n-1].Text+node.Text)
+		out := make([]*Node, n, n+1)
+		copy(out, content)
+		out[n-1] = joined
+		return out
+	}
+	out := make([]*Node, len(content), len(content)+1)
+	copy(out, content)
+	return append(out, node)
+}
+
+// appendFragment concatenates two fragments, joining text at the seam
+func appendFragment(a, b []*Node) []*Node {
+	if len(b) == 0 {
+		return a
+	}
+	if len(a) == 0 {
+		return b
+	}
+	out := appendNode(a, b[0])
+	return append(out, b[1:]...)
+}
+
+// normalizeFragment joins adjacent text nodes with the same marks and drops
+// empty text nodes
+func normalizeFragment(content []*Node) []*Node {
+	var out []*Node
+	for _, node := range content {
+		if node.IsText() && node.Text == "" {
+			continue
+		}
+		out = appendNode(out, node)
+	}
+	return out
+}
+
+// JoinText joins adjacent text nodes with the same marks and drops empty text
+// nodes. It is meant for parsers, which produce many small text nodes, and
+// runs in linear time where appendNode would be quadratic.
+func JoinText(content []*Node) []*Node {
+	out := make([]*Node, 0, len(content))
+	for i := 0; i < len(content); i++ {
+		node := content[i]
+		if !node.IsText() {
+			out = append(out, node)
+			continue
+		}
+		var text strings.Builder
+		text.WriteString(node.Text)
+		for i+1 < len(content) && content[i+1].IsText() && sameMarkup(node, content[i+1]) {
+			i++
+			text.WriteString(content[i].Text)
+		}
+		if text.Len() > 0 {
+			out = append(out, withText(node, text.String()))
+		}
+	}
+	return out
+}
+
+// replaceChild returns a copy of the fragment with the child at index replaced
+func replaceChild(content []*Node, index int, node *Node) []*Node {
+	out := make([]*Node, len(content))
+	copy(out, content)
+	out[index] = node
+	return out
+}
+
+// findIndex finds the index of the child at pos inside content and the offset
+// at which that child starts. round > 0 rounds positions at a child boundary
+// to the next child.
+func (s *Schema) findIndex(content []*Node, pos, round int) (int, int) {
+	if pos == 0 {
+		return 0, 0
+	}
+	size := s.ContentSize(content)
+	if pos == size {
+		return len(content), size
+	}
+	if pos > size || pos < 0 {
+		return -1, -1
+	}
+	cur := 0
+	for i, child := range content {
+		end := cur + s.NodeSize(child)
+		if end >= pos {
+			if end == pos || round > 0 {
+				return i + 1, end
+			}
+			return i, cur
+		}
+		cur = end
+	}
+	return len(content), size
+}
+
+// sliceUTF16 cuts s between two UTF-16 code unit offsets
+func sliceUTF16(s string, from, to int) string {
+	start, end := -1, len(s)
+	units := 0
+	for i, r := range s {
+		if units >= from && start < 0 {
+			start = i
+		}
+		if units >= to {
+			end = i
+			break
+		}
+		units += utf16.RuneLen(r)
+	}
+	if start < 0 {
+		return ""
+	}
+	return s[start:end]
+}
@@ -0,0 +1,288 @@
+package prosemirror
+
+import (
+	"fmt"
+	"html"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+// HTMLRenderer serializes documents into HTML. Every text and attribute value
+// is escaped and URLs are restricted to safe schemes, so the output can be
+// embedded in a page even when the stored content is hostile.
+type HTMLRenderer struct {
+	// Schema tells inline from block nodes, the default schema when nil
+	Schema *Schema
+}
+
+// RenderHTML renders the document (or any node) as an HTML fragment
+func RenderHTML(schema *Schema, node *Node) string {
+	var b strings.Builder
+	HTMLRenderer{Schema: schema}.RenderNode(&b, node)
+	return b.String()
+}
+
+// RenderNode writes a node and its descendants
+func (r HTMLRenderer) RenderNode(b *strings.Builder, node *Node) {
+	if node.IsText() {
+		r.RenderInline(b, []*Node{node})
+		return
+	}
+	open, close := r.Tags(node)
+	b.WriteString(open)
+	r.RenderContent(b, node)
+	b.WriteString(close)
+}
+
+// RenderContent writes the children of a node
+func (r HTMLRenderer) RenderContent(b *strings.Builder, node *Node) {
+	if r.isInlineContent(node.Content) {
+		r.RenderInline(b, node.Content)
+		return
+	}
+	for _, child := range node.Content {
+		r.RenderNode(b, child)
+	}
+}
+
+// RenderInline writes a run of inline nodes, keeping marks shared by adjacent
+// nodes open instead of wrapping every node separately
+func (r HTMLRenderer) RenderInline(b *strings.Builder, nodes []*Node) {
+	var open []*Mark
+	for _, node := range nodes {
+		keep := 0
+		for keep < len(open) && keep < len(node.Marks) && MarkEqual(open[keep], node.Marks[keep]) {
+			keep++
+		}
+		for i := len(open) - 1; i >= keep; i-- {
+			_, close := r.MarkTags(open[i])
+			b.WriteString(close)
+		}
+		open = open[:keep]
+		for _, mark := range node.Marks[keep:] {
+			openTag, _ := r.MarkTags(mark)
+			b.WriteString(openTag)
+			open = append(open, mark)
+		}
+
+		if node.IsText() {
+			b.WriteString(html.EscapeString(node.Text))
+		} else {
+			openTag, closeTag := r.Tags(node)
+			b.WriteString(openTag)
+			r.RenderContent(b, node)
+			b.WriteString(closeTag)
+		}
+	}
+	for i := len(open) - 1; i >= 0; i-- {
+		_, close := r.MarkTags(open[i])
+		b.WriteString(close)
+	}
+}
+
+// Tags returns the opening and closing tags of a non-text node
+func (r HTMLRenderer) Tags(node *Node) (string, string) {
+	switch node.Type {
+	case "doc":
+		return "", ""
+	case "paragraph":
+		return "<p" + alignStyle(node) + ">", "</p>"
+	case "heading":
+		level := attrInt(node.Attr("level"), 1)
+		if level < 1 || level > 6 {
+			level = 1
+		}
+		return fmt.Sprintf("<h%d%s>", level, alignStyle(node)), fmt.Sprintf("</h%d>", level)
+	case "blockquote":
+		return "<blockquote>", "</blockquote>"
+	case "codeBlock":
+		if lang, ok := node.Attr("language").(string); ok && safeClassName.MatchString(lang) {
+			return `<pre><code class="language-` + lang + `">`, "</code></pre>"
+		}
+		return "<pre><code>", "</code></pre>"
+	case "horizontalRule":
+		return "<hr>", ""
+	case "hardBreak":
+		return "<br>", ""
+	case "bulletList":
+		return "<ul>", "</ul>"
+	case "orderedList":
+		if start := attrInt(node.Attr("start"), 1); start != 1 {
+			return fmt.Sprintf(`<ol start="%d">`, start), "</ol>"
+		}
+		return "<ol>", "</ol>"
+	case "listItem":
+		return "<li>", "</li>"
+	case "taskList":
+		return `<ul data-type="taskList">`, "</ul>"
+	case "taskItem":
+		checked := ""
+		if v, _ := node.Attr("checked").(bool); v {
+			checked = " checked"
+		}
+		return fmt.Sprintf(`<li data-type="taskItem" data-checked="%t"><label><input type="checkbox" disabled%s></label><div>`, checked != "", checked), "</div></li>"
+	case "table":
+		return "<table><tbody>", "</tbody></table>"
+	case "tableRow":
+		return "<tr>", "</tr>"
+	case "tableCell", "tableHeader":
+		tag := "td"
+		if node.Type == "tableHeader" {
+			tag = "th"
+		}
+		attrs := ""
+		if colspan := attrInt(node.Attr("colspan"), 1); colspan > 1 {
+			attrs += fmt.Sprintf(` colspan="%d"`, colspan)
+		}
+		if rowspan := attrInt(node.Attr("rowspan"), 1); rowspan > 1 {
+			attrs += fmt.Sprintf(` rowspan="%d"`, rowspan)
+		}
+		return "<" + tag + attrs + ">", "</" + tag + ">"
+	case "image":
+		src, ok := SafeImageURL(stringAttr(node.Attr("src")))
+		if !ok {
+			return "", ""
+		}
+		attrs := ` src="` + html.EscapeString(src) + `"`
+		if alt := stringAttr(node.Attr("alt")); alt != "" {
+			attrs += ` alt="` + html.EscapeString(alt) + `"`
+		}
+		if title := stringAttr(node.Attr("title")); title != "" {
+			attrs += ` title="` + html.EscapeString(title) + `"`
+		}
+		return "<img" + attrs + ">", ""
+	}
+
+	// Unknown node types keep their content visible
+	if r.isInlineContent([]*Node{node}) {
+		return `<span data-type="` + html.EscapeString(node.Type) + `">`, "</span>"
+	}
+	return `<div data-type="` + html.EscapeString(node.Type) + `">`, "</div>"
+}
+
+// MarkTags returns the opening and closing tags of a mark
+func (r HTMLRenderer) MarkTags(mark *Mark) (string, string) {
+	switch mark.Type {
+	case "bold":
+		return "<strong>", "</strong>"
+	case "italic":
+		return "<em>", "</em>"
+	case "underline":
+		return "<u>", "</u>"
+	case "strike":
+		return "<s>", "</s>"
+	case "code":
+		return "<code>", "</code>"
+	case "subscript":
+		return "<sub>", "</sub>"
+	case "superscript":
+		return "<sup>", "</sup>"
+	case "highlight":
+		if color := stringAttr(mark.Attr("color")); safeColor.MatchString(color) {
+			return `<mark style="background-color: ` + color + `">`, "</mark>"
+		}
+		return "<mark>", "</mark>"
+	case "link":
+		href, ok := SafeLinkURL(stringAttr(mark.Attr("href")))
+		if !ok {
+			return "<span>", "</span>"
+		}
+		target := ""
+		if mark.Attr("target") == "_blank" {
+			target = ` target="_blank"`
+		}
+		return `<a href="` + html.EscapeString(href) + `"` + target + ` rel="noopener noreferrer nofollow">`, "</a>"
+	}
+	return "<span>", "</span>"
+}
+
+var (
+	safeClassName = regexp.MustCompile(`^[A-Za-z0-9_+#.-]{1,32}$`)
+	safeColor     = regexp.MustCompile(`^(#[0-9A-Fa-f]{3,8}|[A-Za-z]{1,20}|rgba?\([0-9., %]{1,40}\))$`)
+	safeImageData = regexp.MustCompile(`^data:image/(png|jpeg|gif|webp);base64,[A-Za-z0-9+/=\s]+$`)
+)
+
+// SafeLinkURL returns the URL if it is relative or uses the http, https,
+// mailto or tel scheme
+func SafeLinkURL(raw string) (string, bool) {
+	return safeURL(raw, "http", "https", "mailto", "tel")
+}
+
+// SafeImageURL returns the URL if it is relative, http(s) or an inline
+// base64 raster image
+func SafeImageURL(raw string) (string, bool) {
+	if strings.HasPrefix(strings.ToLower(strings.TrimSpace(raw)), "data:") {
+		trimmed := strings.TrimSpace(raw)
+		return trimmed, safeImageData.MatchString(trimmed)
+	}
+	return safeURL(raw, "http", "https")
+}
+
+func safeURL(raw string, schemes ...string) (string, bool) {
+	trimmed := strings.TrimSpace(raw)
+	if trimmed == "" {
+		return "", false
+	}
+	for _, r := range trimmed {
+		// Control characters and whitespace inside the scheme are used to
+		// smuggle "java\tscript:" past naive checks
+		if r < 0x20 || r == 0x7f {
+			return "", false
+		}
+	}
+	parsed, err := url.Parse(trimmed)
+	if err != nil {
+		return "", false
+	}
+	if parsed.Scheme == "" {
+		return trimmed, true
+	}
+	for _, scheme := range schemes {
+		if strings.EqualFold(parsed.Scheme, scheme) {
+			return trimmed, true
+		}
+	}
+	return "", false
+}
+
+func alignStyle(node *Node) string {
+	switch node.Attr("textAlign") {
+	case "left", "center", "right", "justify":
+		return ` style="text-align: ` + node.Attr("textAlign").(string) + `"`
+	}
+	return ""
+}
+
+func (r HTMLRenderer) isInlineContent(nodes []*Node) bool {
+	schema := r.Schema
+	if schema == nil {
+		schema = defaultSchema
+	}
+	for _, node := range nodes {
+		if nodeType, ok := schema.Nodes[node.Type]; ok {
+			return nodeType.IsInline()
+		}
+		if node.IsText() || len(node.Marks) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+func stringAttr(v any) string {
+	s, _ := v.(string)
+	return s
+}
+
+func attrInt(v any, def int) int {
+	switch n := v.(type) {
+	case float64:
+		return int(n)
+	case int:
+		return n
+	case int64:
+		return int(n)
+	}
+	return def
+}
@@ -0,0 +1,577 @@
+package prosemirror
+
+import (
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// HTMLParser converts HTML into content of the default schema, the reverse
+// of HTMLRenderer. Layout elements like div and span are unwrapped, inline
+// styles for weight, slant, decoration and vertical alignment become marks so
+// that pastes from office suites keep their formatting. Elements without a
+// counterpart are reported in Warnings: unknown ones keep their text, scripts,
+// frames, media and form controls are dropped.
+type HTMLParser struct {
+	// Schema decides which marks are kept, the default schema when nil
+	Schema *Schema
+	// Warnings collects what was dropped or simplified over every call
+	Warnings Warnings
+}
+
+// Parse reads an HTML document and returns its body as a doc node with the
+// content of the title element
+func (p *HTMLParser) Parse(r io.Reader) (*Node, string, error) {
+	root, err := html.Parse(r)
+	if err != nil {
+		return nil, "", err
+	}
+	var title string
+	body := root
+	for n := range root.Descendants() {
+		if n.Type != html.ElementNode {
+			continue
+		}
+		switch n.DataAtom {
+		case atom.Title:
+			if title == "" {
+				title = strings.Join(strings.Fields(textOf(n)), " ")
+			}
+		case atom.Body:
+			if body == root {
+				body = n
+			}
+		}
+	}
+	return &Node{Type: "doc", Content: ensureBlock(p.blocks(body))}, title, nil
+}
+
+// ParseFragment converts an HTML fragment, as found inside a body element,
+// into block nodes
+func (p *HTMLParser) ParseFragment(src string) []*Node {
+	context := &html.Node{Type: html.ElementNode, Data: "body", DataAtom: atom.Body}
+	nodes, err := html.ParseFragment(strings.NewReader(src), context)
+	if err != nil {
+		return nil
+	}
+	for _, n := range nodes {
+		context.AppendChild(n)
+	}
+	return p.blocks(context)
+}
+
+func (p *HTMLParser) blocks(parent *html.Node) []*Node {
+	c := &htmlContext{textblock: &Node{Type: "paragraph"}}
+	p.walkChildren(parent, c, nil)
+	c.flush()
+	return c.blocks
+}
+
+// htmlContext collects the blocks of a container. Inline content is gathered
+// until a block element ends it, then wrapped in a copy of textblock.
+type htmlContext struct {
+	blocks    []*Node
+	inline    []*Node
+	textblock *Node
+	// task is set inside task items, whose checkbox is already represented
+	task bool
+}
+
+func (c *htmlContext) sub(textblock *Node) *htmlContext {
+	return &htmlContext{textblock: textblock, task: c.task}
+}
+
+func (c *htmlContext) flush() {
+	content := trimInline(JoinText(c.inline))
+	c.inline = nil
+	if len(content) == 0 {
+		return
+	}
+	c.blocks = append(c.blocks, &Node{Type: c.textblock.Type, Attrs: c.textblock.Attrs, Content: content})
+}
+
+func (c *htmlContext) block(node *Node) {
+	c.flush()
+	c.blocks = append(c.blocks, node)
+}
+
+// text adds collapsed text, dropping a space that would follow another one
+func (c *htmlContext) text(text string, marks []*Mark) {
+	if n := len(c.inline); strings.HasPrefix(text, " ") && (n == 0 || !c.inline[n-1].IsText() || strings.HasSuffix(c.inline[n-1].Text, " ")) {
+		text = text[1:]
+	}
+	if text != "" {
+		c.inline = append(c.inline, &Node{Type: "text", Text: text, Marks: marks})
+	}
+}
+
+var (
+	htmlSpace     = regexp.MustCompile(`[ \t\n\r\f]+`)
+	languageClass = regexp.MustCompile(`(?:^|\s)lang(?:uage)?-([A-Za-z0-9_+#.-]{1,32})`)
+)
+
+// dropped elements have no content worth keeping
+var droppedElements = map[atom.Atom]string{
+	atom.Script:   "scripts are not imported",
+	atom.Iframe:   "embedded frames are not supported",
+	atom.Frame:    "embedded frames are not supported",
+	atom.Object:   "embedded objects are not supported",
+	atom.Embed:    "embedded objects are not supported",
+	atom.Video:    "video is not supported",
+	atom.Audio:    "audio is not supported",
+	atom.Canvas:   "canvas drawings are not supported",
+	atom.Svg:      "inline SVG is not supported",
+	atom.Math:     "math markup is not supported",
+	atom.Select:   "form controls are not supported",
+	atom.Textarea: "form controls are not supported",
+}
+
+// silentElements hold metadata or alternatives, nothing is lost by skipping them
+var silentElements = map[atom.Atom]bool{
+	atom.Head: true, atom.Title: true, atom.Meta: true, atom.Link: true, atom.Style: true,
+	atom.Template: true, atom.Noscript: true, atom.Colgroup: true, atom.Col: true,
+	atom.Source: true, atom.Track: true, atom.Param: true, atom.Base: true, atom.Wbr: true,
+}
+
+// containerElements are unwrapped, their content starts and ends blocks
+var containerElements = map[atom.Atom]bool{
+	atom.Html: true, atom.Body: true, atom.Div: true, atom.Section: true, atom.Article: true,
+	atom.Main: true, atom.Header: true, atom.Footer: true, atom.Nav: true, atom.Aside: true,
+	atom.Figure: true, atom.Figcaption: true, atom.Center: true, atom.Form: true,
+	atom.Fieldset: true, atom.Legend: true, atom.Details: true, atom.Summary: true,
+	atom.Address: true, atom.Hgroup: true, atom.Li: true, atom.Dl: true, atom.Dt: true,
+	atom.Dd: true, atom.Picture: true, atom.Menu: true,
+}
+
+// transparentElements are unwrapped and keep their content inline
+var transparentElements = map[atom.Atom]bool{
+	atom.Span: true, atom.Font: true, atom.Label: true, atom.Abbr: true, atom.Time: true,
+	atom.Small: true, atom.Big: true, atom.Q: true, atom.Bdi: true, atom.Bdo: true,
+	atom.Data: true, atom.Ruby: true, atom.Rt: true, atom.Rp: true, atom.Nobr: true,
+	atom.Button: true, atom.Output: true, atom.Meter: true, atom.Progress: true,
+	atom.Acronym: true, atom.Area: true, atom.Map: true, atom.Slot: true,
+}
+
+// markElements map inline elements to the mark they stand for
+var markElements = map[atom.Atom]string{
+	atom.Strong: "bold", atom.B: "bold",
+	atom.Em: "italic", atom.I: "italic", atom.Cite: "italic", atom.Dfn: "italic", atom.Var: "italic",
+	atom.U: "underline", atom.Ins: "underline",
+	atom.S: "strike", atom.Strike: "strike", atom.Del: "strike",
+	atom.Code: "code", atom.Kbd: "code", atom.Samp: "code", atom.Tt: "code",
+	atom.Sub: "subscript", atom.Sup: "superscript",
+	atom.Mark: "highlight",
+}
+
+func (p *HTMLParser) walkChildren(n *html.Node, c *htmlContext, marks []*Mark) {
+	for child := n.FirstChild; child != nil; child = child.NextSibling {
+		p.walk(child, c, marks)
+	}
+}
+
+func (p *HTMLParser) walk(n *html.Node, c *htmlContext, marks []*Mark) {
+	switch n.Type {
+	case html.TextNode:
+		c.text(htmlSpace.ReplaceAllString(n.Data, " "), marks)
+		return
+	case html.DocumentNode:
+		p.walkChildren(n, c, marks)
+		return
+	case html.ElementNode:
+	default:
+		return
+	}
+
+	if message, ok := droppedElements[n.DataAtom]; ok {
+		p.Warnings.Add("<"+n.Data+">", message)
+		return
+	}
+	if silentElements[n.DataAtom] {
+		return
+	}
+	if mark, ok := markElements[n.DataAtom]; ok {
+		// Google Docs wraps whole documents in <b style="font-weight:normal">
+		if mark != "bold" || !strings.Contains(styleValue(n, "font-weight"), "normal") {
+			marks = p.addMark(marks, &Mark{Type: mark})
+		}
+		p.walkChildren(n, c, p.styleMarks(n, marks))
+		return
+	}
+
+	switch n.DataAtom {
+	case atom.P:
+		c.flush()
+		sub := c.sub(&Node{Type: "paragraph", Attrs: alignAttrs(n)})
+		p.walkChildren(n, sub, p.styleMarks(n, marks))
+		sub.flush()
+		c.blocks = append(c.blocks, sub.blocks...)
+	case atom.H1, atom.H2, atom.H3, atom.H4, atom.H5, atom.H6:
+		c.flush()
+		attrs := alignAttrs(n)
+		if attrs == nil {
+			attrs = map[string]any{}
+		}
+		attrs["level"] = float64(n.Data[1] - '0')
+		sub := c.sub(&Node{Type: "heading", Attrs: attrs})
+		p.walkChildren(n, sub, p.styleMarks(n, marks))
+		sub.flush()
+		c.blocks = append(c.blocks, sub.blocks...)
+	case atom.Blockquote:
+		content := p.container(n, c)
+		if len(content) > 0 {
+			c.block(&Node{Type: "blockquote", Content: content})
+		}
+	case atom.Pre:
+		c.block(p.codeBlock(n))
+	case atom.Hr:
+		c.block(&Node{Type: "horizontalRule"})
+	case atom.Br:
+		c.inline = append(c.inline, &Node{Type: "hardBreak"})
+	case atom.Ul, atom.Ol:
+		if list := p.list(n, c); list != nil {
+			c.block(list)
+		}
+	case atom.Table:
+		p.table(n, c)
+	case atom.Img:
+		src, ok := SafeImageURL(attrValue(n, "src"))
+		if !ok {
+			p.Warnings.Add("<img>", "images with a missing or unsafe source were dropped")
+			return
+		}
+		attrs := map[string]any{"src": src}
+		if alt := attrValue(n, "alt"); alt != "" {
+			attrs["alt"] = alt
+		}
+		if title := attrValue(n, "title"); title != "" {
+			attrs["title"] = title
+		}
+		c.block(&Node{Type: "image", Attrs: attrs})
+	case atom.A:
+		href := attrValue(n, "href")
+		if href != "" && !strings.HasPrefix(href, "#") {
+			if safe, ok := SafeLinkURL(href); ok {
+				marks = p.addMark(marks, &Mark{Type: "link", Attrs: map[string]any{"href": safe}})
+			} else {
+				p.Warnings.Add("unsafe link", "links with an unsupported scheme were kept as plain text")
+			}
+		}
+		p.walkChildren(n, c, p.styleMarks(n, marks))
+	case atom.Input:
+		if strings.EqualFold(attrValue(n, "type"), "checkbox") && c.task {
+			return
+		}
+		p.Warnings.Add("<input>", "form controls are not supported")
+	default:
+		switch {
+		case containerElements[n.DataAtom]:
+			c.flush()
+			p.walkChildren(n, c, p.styleMarks(n, marks))
+			c.flush()
+		case transparentElements[n.DataAtom]:
+			p.walkChildren(n, c, p.styleMarks(n, marks))
+		default:
+			p.Warnings.Add("<"+n.Data+">", "unknown elements were replaced by their content")
+			p.walkChildren(n, c, p.styleMarks(n, marks))
+		}
+	}
+}
+
+// container returns the blocks inside an element
+func (p *HTMLParser) container(n *html.Node, c *htmlContext) []*Node {
+	c.flush()
+	sub := c.sub(&Node{Type: "paragraph"})
+	p.walkChildren(n, sub, nil)
+	sub.flush()
+	return sub.blocks
+}
+
+func (p *HTMLParser) codeBlock(pre *html.Node) *Node {
+	language := languageClass.FindStringSubmatch(attrValue(pre, "class"))
+	var text strings.Builder
+	for n := range pre.Descendants() {
+		switch {
+		case n.Type == html.TextNode:
+			text.WriteString(n.Data)
+		case n.Type == html.ElementNode && n.DataAtom == atom.Br:
+			text.WriteString("\n")
+		case n.Type == html.ElementNode && n.DataAtom == atom.Code && language == nil:
+			language = languageClass.FindStringSubmatch(attrValue(n, "class"))
+		}
+	}
+	node := &Node{Type: "codeBlock"}
+	if language != nil {
+		node.Attrs = map[string]any{"language": language[1]}
+	}
+	if code := strings.TrimSuffix(text.String(), "\n"); code != "" {
+		node.Content = []*Node{{Type: "text", Text: code}}
+	}
+	return node
+}
+
+// list converts ul and ol elements. Lists whose items all start with a
+// checkbox, or are marked up the way HTMLRenderer writes task lists, become
+// task lists.
+func (p *HTMLParser) list(n *html.Node, c *htmlContext) *Node {
+	c.flush()
+	var items []*html.Node
+	for child := n.FirstChild; child != nil; child = child.NextSibling {
+		if child.Type == html.ElementNode && child.DataAtom == atom.Li {
+			items = append(items, child)
+		}
+	}
+	if len(items) == 0 {
+		return nil
+	}
+
+	task := n.DataAtom == atom.Ul
+	for _, item := range items {
+		if attrValue(n, "data-type") != "taskList" && attrValue(item, "data-type") != "taskItem" && taskCheckbox(item) == nil {
+			task = false
+		}
+	}
+
+	list := &Node{Type: "bulletList"}
+	itemType := "listItem"
+	switch {
+	case task:
+		list.Type, itemType = "taskList", "taskItem"
+	case n.DataAtom == atom.Ol:
+		list.Type = "orderedList"
+		if start, err := strconv.Atoi(attrValue(n, "start")); err == nil && start != 1 {
+			list.Attrs = map[string]any{"start": float64(start)}
+		}
+	}
+	for _, li := range items {
+		sub := c.sub(&Node{Type: "paragraph"})
+		sub.task = task
+		p.walkChildren(li, sub, nil)
+		sub.flush()
+		content := sub.blocks
+		if len(content) == 0 || content[0].Type != "paragraph" {
+			content = append([]*Node{{Type: "paragraph"}}, content...)
+		}
+		item := &Node{Type: itemType, Content: content}
+		if task {
+			checked := attrValue(li, "data-checked") == "true"
+			if box := taskCheckbox(li); box != nil {
+				checked = checked || hasAttr(box, "checked")
+			}
+			item.Attrs = map[string]any{"checked": checked}
+		}
+		list.Content = append(list.Content, item)
+	}
+	return list
+}
+
+// taskCheckbox returns the checkbox that starts a list item, if any
+func taskCheckbox(li *html.Node) *html.Node {
+	for n := range li.Descendants() {
+		switch {
+		case n.Type == html.ElementNode && n.DataAtom == atom.Input:
+			if strings.EqualFold(attrValue(n, "type"), "checkbox") {
+				return n
+			}
+			return nil
+		case n.Type == html.TextNode && strings.TrimSpace(n.Data) != "":
+			return nil
+		}
+	}
+	return nil
+}
+
+// table converts a table, its caption becomes a paragraph before it
+func (p *HTMLParser) table(n *html.Node, c *htmlContext) {
+	c.flush()
+	var rows []*Node
+	var addRows func(parent *html.Node)
+	addRows = func(parent *html.Node) {
+		for child := parent.FirstChild; child != nil; child = child.NextSibling {
+			if child.Type != html.ElementNode {
+				continue
+			}
+			switch child.DataAtom {
+			case atom.Caption:
+				if caption := p.container(child, c); len(caption) > 0 {
+					c.blocks = append(c.blocks, caption...)
+				}
+			case atom.Thead, atom.Tbody, atom.Tfoot:
+				addRows(child)
+			case atom.Tr:
+				if row := p.tableRow(child, c); row != nil {
+					rows = append(rows, row)
+				}
+			}
+		}
+	}
+	addRows(n)
+	if len(rows) > 0 {
+		c.blocks = append(c.blocks, &Node{Type: "table", Content: rows})
+	}
+}
+
+func (p *HTMLParser) tableRow(tr *html.Node, c *htmlContext) *Node {
+	row := &Node{Type: "tableRow"}
+	for cell := tr.FirstChild; cell != nil; cell = cell.NextSibling {
+		if cell.Type != html.ElementNode || (cell.DataAtom != atom.Td && cell.DataAtom != atom.Th) {
+			continue
+		}
+		cellType := "tableCell"
+		if cell.DataAtom == atom.Th {
+			cellType = "tableHeader"
+		}
+		content := p.container(cell, c)
+		if len(content) == 0 {
+			content = []*Node{{Type: "paragraph"}}
+		}
+		row.Content = append(row.Content, &Node{
+			Type: cellType,
+			Attrs: map[string]any{
+				"colspan": float64(spanAttr(cell, "colspan")),
+				"rowspan": float64(spanAttr(cell, "rowspan")),
+			},
+			Content: content,
+		})
+	}
+	if len(row.Content) == 0 {
+		return nil
+	}
+	return row
+}
+
+// maxSpan bounds colspan and rowspan like browsers do
+const maxSpan = 1000
+
+func spanAttr(n *html.Node, name string) int {
+	span, err := strconv.Atoi(strings.TrimSpace(attrValue(n, name)))
+	if err != nil || span < 1 {
+		return 1
+	}
+	return min(span, maxSpan)
+}
+
+// styleMarks adds the marks the inline style of an element stands for
+func (p *HTMLParser) styleMarks(n *html.Node, marks []*Mark) []*Mark {
+	if !hasAttr(n, "style") {
+		return marks
+	}
+	switch weight := styleValue(n, "font-weight"); weight {
+	case "bold", "bolder", "600", "700", "800", "900":
+		marks = p.addMark(marks, &Mark{Type: "bold"})
+	}
+	if style := styleValue(n, "font-style"); style == "italic" || style == "oblique" {
+		marks = p.addMark(marks, &Mark{Type: "italic"})
+	}
+	decoration := styleValue(n, "text-decoration") + " " + styleValue(n, "text-decoration-line")
+	if strings.Contains(decoration, "underline") {
+		marks = p.addMark(marks, &Mark{Type: "underline"})
+	}
+	if strings.Contains(decoration, "line-through") {
+		marks = p.addMark(marks, &Mark{Type: "strike"})
+	}
+	switch styleValue(n, "vertical-align") {
+	case "super":
+		marks = p.addMark(marks, &Mark{Type: "superscript"})
+	case "sub":
+		marks = p.addMark(marks, &Mark{Type: "subscript"})
+	}
+	return marks
+}
+
+// addMark adds a mark the schema knows about
+func (p *HTMLParser) addMark(marks []*Mark, mark *Mark) []*Mark {
+	schema := p.Schema
+	if schema == nil {
+		schema = DefaultSchema()
+	}
+	if _, ok := schema.Marks[mark.Type]; !ok {
+		return marks
+	}
+	return AddMarkToSet(marks, mark)
+}
+
+// alignAttrs returns the textAlign attribute of a paragraph or heading
+func alignAttrs(n *html.Node) map[string]any {
+	align := styleValue(n, "text-align")
+	if align == "" {
+		align = strings.ToLower(attrValue(n, "align"))
+	}
+	switch align {
+	case "left", "center", "right", "justify":
+		return map[string]any{"textAlign": align}
+	}
+	return nil
+}
+
+// styleValue returns a property of the inline style, lower cased
+func styleValue(n *html.Node, property string) string {
+	for _, declaration := range strings.Split(attrValue(n, "style"), ";") {
+		name, value, ok := strings.Cut(declaration, ":")
+		if ok && strings.EqualFold(strings.TrimSpace(name), property) {
+			value = strings.TrimSpace(strings.TrimSuffix(strings.TrimSpace(value), "!important"))
+			return strings.ToLower(value)
+		}
+	}
+	return ""
+}
+
+func attrValue(n *html.Node, name string) string {
+	for _, attr := range n.Attr {
+		if attr.Namespace == "" && attr.Key == name {
+			return attr.Val
+		}
+	}
+	return ""
+}
+
+func hasAttr(n *html.Node, name string) bool {
+	for _, attr := range n.Attr {
+		if attr.Namespace == "" && attr.Key == name {
+			return true
+		}
+	}
+	return false
+}
+
+func textOf(n *html.Node) string {
+	var text strings.Builder
+	for d := range n.Descendants() {
+		if d.Type == html.TextNode {
+			text.WriteString(d.Data)
+		}
+	}
+	return text.String()
+}
+
+// trimInline removes the whitespace at both ends of a textblock and around
+// hard breaks
+func trimInline(content []*Node) []*Node {
+	for i, node := range content {
+		if !node.IsText() {
+			continue
+		}
+		text := node.Text
+		if i == 0 || !content[i-1].IsText() {
+			text = strings.TrimLeft(text, " ")
+		}
+		if i == len(content)-1 || !content[i+1].IsText() {
+			text = strings.TrimRight(text, " ")
+		}
+		if text != node.Text {
+			content[i] = withText(node, text)
+		}
+	}
+	return JoinText(content)
+}
+
+// ensureBlock returns content that satisfies "block+"
+func ensureBlock(content []*Node) []*Node {
+	if len(content) == 0 {
+		return []*Node{{Type: "paragraph"}}
+	}
+	return content
+}
@@ -0,0 +1,188 @@
+package prosemirror
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+// normalized marshals a node after filling in default attributes, so parsed
+// and schema-built documents compare equal
+func normalized(t *testing.T, schema *Schema, node *Node) string {
+	t.Helper()
+	data, err := json.Marshal(node)
+	if err != nil {
+		t.Fatalf("marshal: %v", err)
+	}
+	node, err = schema.NodeFromJSON(data)
+	if err != nil {
+		t.Fatalf("invalid document %s: %v", data, err)
+	}
+	var out bytes.Buffer
+	encoder := json.NewEncoder(&out)
+	encoder.SetEscapeHTML(false)
+	if err := encoder.Encode(node); err != nil {
+		t.Fatalf("marshal: %v", err)
+	}
+	return strings.TrimSpace(out.String())
+}
+
+func warningConstructs(warnings Warnings) string {
+	var names []string
+	for _, warning := range warnings {
+		names = append(names, warning.Construct)
+	}
+	return strings.Join(names, ",")
+}
+
+func TestHTMLRoundTrip(t *testing.T) {
+	tests := []struct {
+		name string
+		doc  string
+	}{
+		{
+			name: "empty paragraph",
+			doc:  `{"type":"doc","content":[{"type":"paragraph"}]}`,
+		},
+		{
+			name: "heading and marks",
+			doc:  `{"type":"doc","content":[{"type":"heading","attrs":{"level":2},"content":[{"type":"text","text":"Title"}]},{"type":"paragraph","content":[{"type":"text","text":"a <b> & "},{"type":"text","marks":[{"type":"bold"},{"type":"italic"}],"text":"c"},{"type":"text","marks":[{"type":"underline"}],"text":"d"},{"type":"text","marks":[{"type":"strike"}],"text":"e"},{"type":"text","marks":[{"type":"code"}],"text":"f"},{"type":"text","marks":[{"type":"subscript"}],"text":"g"},{"type":"text","marks":[{"type":"superscript"}],"text":"h"}]}]}`,
+		},
+		{
+			name: "link and break",
+			doc:  `{"type":"doc","content":[{"type":"paragraph","content":[{"type":"text","marks":[{"type":"link","attrs":{"href":"https://example.com/?a=1&b=2"}}],"text":"link"},{"type":"hardBreak"},{"type":"text","text":"next"}]}]}`,
+		},
+		{
+			name: "lists",
+			doc:  `{"type":"doc","content":[{"type":"bulletList","content":[{"type":"listItem","content":[{"type":"paragraph","content":[{"type":"text","text":"one"}]},{"type":"orderedList","attrs":{"start":3},"content":[{"type":"listItem","content":[{"type":"paragraph","content":[{"type":"text","text":"three"}]}]}]}]}]},{"type":"taskList","content":[{"type":"taskItem","attrs":{"checked":true},"content":[{"type":"paragraph","content":[{"type":"text","text":"done"}]}]}]}]}`,
+		},
+		{
+			name: "code, quote, rule and image",
+			doc:  `{"type":"doc","content":[{"type":"codeBlock","attrs":{"language":"go"},"content":[{"type":"text","text":"a := 1\n\tb <- c"}]},{"type":"blockquote","content":[{"type":"paragraph","content":[{"type":"text","text":"quote"}]}]},{"type":"horizontalRule"},{"type":"image","attrs":{"src":"https://example.com/a.png","alt":"alt"}}]}`,
+		},
+		{
+			name: "table with spans and alignment",
+			doc:  `{"type":"doc","content":[{"type":"table","content":[{"type":"tableRow","content":[{"type":"tableHeader","content":[{"type":"paragraph","content":[{"type":"text","text":"h"}]}]}]},{"type":"tableRow","content":[{"type":"tableCell","attrs":{"colspan":2},"content":[{"type":"paragraph","attrs":{"textAlign":"center"},"content":[{"type":"text","text":"1"}]}]}]}]}]}`,
+		},
+	}
+
+	schema := DefaultSchema()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			doc, err := schema.NodeFromJSON([]byte(tt.doc))
+			if err != nil {
+				t.Fatalf("invalid test document: %v", err)
+			}
+			parser := &HTMLParser{Schema: schema}
+			parsed, _, err := parser.Parse(strings.NewReader(RenderHTML(schema, doc)))
+			if err != nil {
+				t.Fatalf("Parse: %v", err)
+			}
+			if got, want := normalized(t, schema, parsed), normalized(t, schema, doc); got != want {
+				t.Errorf("round trip =\n%s\nwant\n%s", got, want)
+			}
+			if len(parser.Warnings) > 0 {
+				t.Errorf("rendered HTML produced warnings: %v", parser.Warnings)
+			}
+		})
+	}
+}
+
+func TestHTMLParse(t *testing.T) {
+	tests := []struct {
+		name     string
+		html     string
+		want     string
+		title    string
+		warnings string
+	}{
+		{
+			name:  "empty input",
+			html:  "",
+			want:  `{"type":"doc","content":[{"type":"paragraph"}]}`,
+			title: "",
+		},
+		{
+			name:  "document with a title",
+			html:  "<html><head><title> My\n Doc </title></head><body><p>x</p></body></html>",
+			want:  `{"type":"doc","content":[{"type":"paragraph","content":[{"type":"text","text":"x"}]}]}`,
+			title: "My Doc",
+		},
+		{
+			name: "bare text",
+			html: "text only",
+			want: `{"type":"doc","content":[{"type":"paragraph","content":[{"type":"text","text":"text only"}]}]}`,
+		},
+		{
+			name: "office inline styles",
+			html: `<div><span style="font-weight:bold">b</span><span style="font-style:italic;text-decoration:underline line-through">iu</span><span style="vertical-align:super">s</span></div>`,
+			want: `{"type":"doc","content":[{"type":"paragraph","content":[{"type":"text","marks":[{"type":"bold"}],"text":"b"},{"type":"text","marks":[{"type":"italic"},{"type":"strike"},{"type":"underline"}],"text":"iu"},{"type":"text","marks":[{"type":"superscript"}],"text":"s"}]}]}`,
+		},
+		{
+			name:     "dropped and unknown elements",
+			html:     `<p onclick="x">t</p><script>alert(1)</script><iframe src="x"></iframe><custom-el>kept</custom-el>`,
+			want:     `{"type":"doc","content":[{"type":"paragraph","content":[{"type":"text","text":"t"}]},{"type":"paragraph","content":[{"type":"text","text":"kept"}]}]}`,
+			warnings: "<script>,<iframe>,<custom-el>",
+		},
+		{
+			name:     "unsafe URLs",
+			html:     `<a href="javascript:alert(1)">bad</a> <img src="javascript:x">`,
+			want:     `{"type":"doc","content":[{"type":"paragraph","content":[{"type":"text","text":"bad"}]}]}`,
+			warnings: "unsafe link,<img>",
+		},
+		{
+			name: "code language",
+			html: `<pre><code class="language-js">let a</code></pre>`,
+			want: `{"type":"doc","content":[{"type":"codeBlock","attrs":{"language":"js"},"content":[{"type":"text","text":"let a"}]}]}`,
+		},
+		{
+			name: "unclosed tags",
+			html: "<p>unclosed <b>bold <i>both</p><p>after",
+			want: `{"type":"doc","content":[{"type":"paragraph","content":[{"type":"text","text":"unclosed "},{"type":"text","marks":[{"type":"bold"}],"text":"bold "},{"type":"text","marks":[{"type":"bold"},{"type":"italic"}],"text":"both"}]},{"type":"paragraph","content":[{"type":"text","marks":[{"type":"bold"},{"type":"italic"}],"text":"after"}]}]}`,
+		},
+		{
+			name: "stray end tags and brackets",
+			html: "</p></div><<>>",
+			want: `{"type":"doc","content":[{"type":"paragraph","content":[{"type":"text","text":"<<>>"}]}]}`,
+		},
+		{
+			name:     "implied list items and unknown headings",
+			html:     "<ul><li>a<li>b</ul><h7>x</h7>",
+			want:     `{"type":"doc","content":[{"type":"bulletList","content":[{"type":"listItem","content":[{"type":"paragraph","content":[{"type":"text","text":"a"}]}]},{"type":"listItem","content":[{"type":"paragraph","content":[{"type":"text","text":"b"}]}]}]},{"type":"paragraph","content":[{"type":"text","text":"x"}]}]}`,
+			warnings: "<h7>",
+		},
+		{
+			name: "table without tbody",
+			html: "<table><tr><td>no tbody</td></tr></table>",
+			want: `{"type":"doc","content":[{"type":"table","content":[{"type":"tableRow","content":[{"type":"tableCell","attrs":{"colspan":1,"rowspan":1},"content":[{"type":"paragraph","content":[{"type":"text","text":"no tbody"}]}]}]}]}]}`,
+		},
+	}
+
+	schema := DefaultSchema()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			parser := &HTMLParser{Schema: schema}
+			doc, title, err := parser.Parse(strings.NewReader(tt.html))
+			if err != nil {
+				t.Fatalf("Parse: %v", err)
+			}
+			if err := schema.Check(doc); err != nil {
+				t.Errorf("parsed document is invalid: %v", err)
+			}
+			var out bytes.Buffer
+			encoder := json.NewEncoder(&out)
+			encoder.SetEscapeHTML(false)
+			encoder.Encode(doc)
+			if got := strings.TrimSpace(out.String()); got != tt.want {
+				t.Errorf("Parse() =\n%s\nwant\n%s", got, tt.want)
+			}
+			if title != tt.title {
+				t.Errorf("title = %q, want %q", title, tt.title)
+			}
+			if got := warningConstructs(parser.Warnings); got != tt.warnings {
+				t.Errorf("warnings = %s, want %s", got, tt.warnings)
+			}
+		})
+	}
+}
@@ -0,0 +1,798 @@
+package markdown
+
+import (
+	"html"
+	"regexp"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/emaforlin/ce-document-service/pkg/prosemirror"
+)
+
+// The inline parser follows the CommonMark reference algorithm: text is
+// scanned into a list of items, delimiter runs are kept on a stack and
+// matched into emphasis once a link closes or the text ends.
+
+// item is a piece of inline content. Containers hold the items a mark
+// applies to, node is set for hard breaks and images.
+type item struct {
+	text     string
+	node     *prosemirror.Node
+	mark     *prosemirror.Mark
+	children []*item
+	// contained is set once the item was moved into a container, it can no
+	// longer open a link or an HTML tag
+	contained  bool
+	prev, next *item
+}
+
+type delimiter struct {
+	item   *item
+	char   byte
+	length int
+	// canOpen and canClose tell whether the run is left or right flanking
+	canOpen, canClose bool
+	prev, next        *delimiter
+}
+
+// bracket is an opening [ or ![ that may become a link or an image
+type bracket struct {
+	item      *item
+	image     bool
+	active    bool
+	labelFrom int
+	prevDelim *delimiter
+	htmlDepth int
+	prev      *bracket
+}
+
+// htmlTag is an open inline HTML element that maps to a mark
+type htmlTag struct {
+	item      *item
+	name      string
+	mark      *prosemirror.Mark
+	prevDelim *delimiter
+	brackets  *bracket
+}
+
+type inlineParser struct {
+	state      *parseState
+	src        string
+	pos        int
+	head, tail *item
+	delims     *delimiter
+	brackets   *bracket
+	tags       []htmlTag
+}
+
+// htmlMarks map inline HTML elements to marks
+var htmlMarks = map[string]string{
+	"b": "bold", "strong": "bold",
+	"i": "italic", "em": "italic",
+	"u": "underline", "ins": "underline",
+	"s": "strike", "del": "strike", "strike": "strike",
+	"code": "code", "kbd": "code",
+	"sub": "subscript", "sup": "superscript",
+	"mark": "highlight",
+}
+
+var (
+	entity       = regexp.MustCompile(`^&(?:#[0-9]{1,7}|#[xX][0-9a-fA-F]{1,6}|[A-Za-z][A-Za-z0-9]{1,31});`)
+	autolinkURI  = regexp.MustCompile(`^<([A-Za-z][A-Za-z0-9+.-]{1,31}:[^\x00-\x20<>]*)>`)
+	autolinkMail = regexp.MustCompile(`^<([a-zA-Z0-9.!#$%&'*+/=?^_` + "`" + `{|}~-]+@[a-zA-Z0-9](?:[a-zA-Z0-9-]{0,61}[a-zA-Z0-9])?(?:\.[a-zA-Z0-9](?:[a-zA-Z0-9-]{0,61}[a-zA-Z0-9])?)*)>`)
+	htmlOpenTag  = regexp.MustCompile(`^<([A-Za-z][A-Za-z0-9-]*)((?:\s+[A-Za-z_:][A-Za-z0-9_.:-]*(?:\s*=\s*(?:[^\s"'=<>` + "`" + `]+|'[^']*'|"[^"]*"))?)*)\s*/?>`)
+	htmlCloseTag = regexp.MustCompile(`^</([A-Za-z][A-Za-z0-9-]*)\s*>`)
+	htmlOther    = regexp.MustCompile(`^(?:<!--(?:[^-]|-[^-])*-->|<\?[\s\S]*?\?>|<![A-Za-z][^>]*>|<!\[CDATA\[[\s\S]*?\]\]>)`)
+	htmlAttr     = regexp.MustCompile(`([A-Za-z_:][A-Za-z0-9_.:-]*)(?:\s*=\s*(?:([^\s"'=<>` + "`" + `]+)|'([^']*)'|"([^"]*)"))?`)
+	bareURL      = regexp.MustCompile(`^(?i:https?://|www\.)[^\s<]*`)
+)
+
+const (
+	specialChars = "\\`*_~[]!<&\n"
+	// asciiPunctuation are the characters a backslash escapes
+	asciiPunctuation = "!\"#$%&'()*+,-./:;<=>?@[\\]^_`{|}~"
+)
+
+// parseInline converts the raw content of a textblock into inline nodes,
+// images included
+func (s *parseState) parseInline(src string) []*prosemirror.Node {
+	p := &inlineParser{state: s, src: src}
+	for p.pos < len(p.src) {
+		switch c := p.src[p.pos]; c {
+		case '\\':
+			p.escape()
+		case '`':
+			p.codeSpan()
+		case '*', '_', '~':
+			p.delimiterRun(c)
+		case '[':
+			p.openBracket(false, 1)
+		case '!':
+			if strings.HasPrefix(p.src[p.pos:], "![") {
+				p.openBracket(true, 2)
+			} else {
+				p.addText("!")
+				p.pos++
+			}
+		case ']':
+			p.closeBracket()
+		case '<':
+			p.angleBracket()
+		case '&':
+			if m := entity.FindString(p.src[p.pos:]); m != "" {
+				p.addText(html.UnescapeString(m))
+				p.pos += len(m)
+			} else {
+				p.addText("&")
+				p.pos++
+			}
+		case '\n':
+			p.lineBreak()
+		default:
+			if p.urlAt(p.pos) {
+				p.bareURL()
+			} else {
+				p.text()
+			}
+		}
+	}
+	p.processEmphasis(nil)
+	return p.state.nodes(p.list(p.head, nil), nil)
+}
+
+func (p *inlineParser) append(it *item) *item {
+	it.prev = p.tail
+	if p.tail != nil {
+		p.tail.next = it
+	} else {
+		p.head = it
+	}
+	p.tail = it
+	return it
+}
+
+func (p *inlineParser) addText(text string) *item {
+	return p.append(&item{text: text})
+}
+
+func (p *inlineParser) remove(it *item) {
+	if it.prev != nil {
+		it.prev.next = it.next
+	} else {
+		p.head = it.next
+	}
+	if it.next != nil {
+		it.next.prev = it.prev
+	} else {
+		p.tail = it.prev
+	}
+	it.prev, it.next = nil, nil
+}
+
+// list returns the items from start up to, not including, end
+func (p *inlineParser) list(start, end *item) []*item {
+	var items []*item
+	for it := start; it != nil && it != end; it = it.next {
+		items = append(items, it)
+	}
+	return items
+}
+
+// wrap moves the items between from and to, both excluded, into a container
+// with mark. A nil to wraps up to the end.
+func (p *inlineParser) wrap(from, to *item, mark *prosemirror.Mark) *item {
+	container := &item{mark: mark, children: p.list(from.next, to)}
+	for _, child := range container.children {
+		child.contained = true
+	}
+	container.prev, container.next = from, to
+	from.next = container
+	if to != nil {
+		to.prev = container
+	} else {
+		p.tail = container
+	}
+	return container
+}
+
+// text consumes plain text up to the next character that may start markup
+func (p *inlineParser) text() {
+	start := p.pos
+	for p.pos++; p.pos < len(p.src); p.pos++ {
+		c := p.src[p.pos]
+		if strings.IndexByte(specialChars, c) >= 0 || ((c == 'h' || c == 'H' || c == 'w' || c == 'W') && p.urlAt(p.pos)) {
+			break
+		}
+	}
+	p.addText(p.src[start:p.pos])
+}
+
+func (p *inlineParser) escape() {
+	if p.pos+1 < len(p.src) {
+		next := p.src[p.pos+1]
+		if next == '\n' {
+			p.append(&item{node: &prosemirror.Node{Type: "hardBreak"}})
+			p.pos += 2
+			p.skipSpaces()
+			return
+		}
+		if strings.IndexByte(asciiPunctuation, next) >= 0 {
+			p.addText(string(next))
+			p.pos += 2
+			return
+		}
+	}
+	p.addText("\\")
+	p.pos++
+}
+
+// lineBreak turns a line ending into a hard break after two spaces, and into
+// a space otherwise
+func (p *inlineParser) lineBreak() {
+	hard := false
+	if p.tail != nil && p.tail.node == nil && p.tail.mark == nil && p.tail.children == nil {
+		trimmed := strings.TrimRight(p.tail.text, " ")
+		hard = len(p.tail.text)-len(trimmed) >= 2
+		p.tail.text = trimmed
+	}
+	if hard {
+		p.append(&item{node: &prosemirror.Node{Type: "hardBreak"}})
+	} else {
+		p.addText(" ")
+	}
+	p.pos++
+	p.skipSpaces()
+}
+
+func (p *inlineParser) skipSpaces() {
+	for p.pos < len(p.src) && (p.src[p.pos] == ' ' || p.src[p.pos] == '\t') {
+		p.pos++
+	}
+}
+
+func (p *inlineParser) codeSpan() {
+	start := p.pos
+	ticks := runLength(p.src, start, '`')
+	for i := start + ticks; i < len(p.src); {
+		j := strings.IndexByte(p.src[i:], '`')
+		if j < 0 {
+			break
+		}
+		i += j
+		closing := runLength(p.src, i, '`')
+		if closing == ticks {
+			code := strings.ReplaceAll(p.src[start+ticks:i], "\n", " ")
+			if len(code) >= 2 && code[0] == ' ' && code[len(code)-1] == ' ' && strings.Trim(code, " ") != "" {
+				code = code[1 : len(code)-1]
+			}
+			p.append(&item{mark: &prosemirror.Mark{Type: "code"}, children: []*item{{text: code}}})
+			p.pos = i + closing
+			return
+		}
+		i += closing
+	}
+	p.addText(p.src[start : start+ticks])
+	p.pos = start + ticks
+}
+
+func runLength(s string, i int, c byte) int {
+	n := 0
+	for i+n < len(s) && s[i+n] == c {
+		n++
+	}
+	return n
+}
+
+func (p *inlineParser) delimiterRun(c byte) {
+	length := runLength(p.src, p.pos, c)
+	run := p.src[p.pos : p.pos+length]
+	before, _ := utf8.DecodeLastRuneInString(p.src[:p.pos])
+	after, _ := utf8.DecodeRuneInString(p.src[p.pos+length:])
+	if p.pos == 0 {
+		before = ' '
+	}
+	if p.pos+length == len(p.src) {
+		after = ' '
+	}
+	p.pos += length
+	it := p.addText(run)
+	if c == '~' && length > 2 {
+		return
+	}
+
+	left := !unicode.IsSpace(after) && (!isPunctuation(after) || unicode.IsSpace(before) || isPunctuation(before))
+	right := !unicode.IsSpace(before) && (!isPunctuation(before) || unicode.IsSpace(after) || isPunctuation(after))
+	canOpen, canClose := left, right
+	if c == '_' {
+		canOpen = left && (!right || isPunctuation(before))
+		canClose = right && (!left || isPunctuation(after))
+	}
+	if canOpen || canClose {
+		d := &delimiter{item: it, char: c, length: length, canOpen: canOpen, canClose: canClose, prev: p.delims}
+		if p.delims != nil {
+			p.delims.next = d
+		}
+		p.delims = d
+	}
+}
+
+func isPunctuation(r rune) bool {
+	return unicode.IsPunct(r) || unicode.IsSymbol(r)
+}
+
+func (p *inlineParser) removeDelimiter(d *delimiter) {
+	if d.prev != nil {
+		d.prev.next = d.next
+	}
+	if d.next != nil {
+		d.next.prev = d.prev
+	} else {
+		p.delims = d.prev
+	}
+}
+
+type openerKey struct {
+	char     byte
+	canOpen  bool
+	lengthM3 int
+}
+
+// processEmphasis matches the delimiters above bottom into emphasis, bold
+// and strikethrough, then removes them from the stack
+func (p *inlineParser) processEmphasis(bottom *delimiter) {
+	var closer *delimiter
+	for d := p.delims; d != nil && d != bottom; d = d.prev {
+		closer = d
+	}
+	openersBottom := make(map[openerKey]*delimiter)
+
+	for closer != nil {
+		if !closer.canClose {
+			closer = closer.next
+			continue
+		}
+		key := openerKey{closer.char, closer.canOpen, closer.length % 3}
+		limit, ok := openersBottom[key]
+		if !ok {
+			limit = bottom
+		}
+		var opener *delimiter
+		for d := closer.prev; d != nil && d != limit && d != bottom; d = d.prev {
+			if d.char != closer.char || !d.canOpen {
+				continue
+			}
+			if closer.char == '~' {
+				if len(d.item.text) == len(closer.item.text) {
+					opener = d
+					break
+				}
+				continue
+			}
+			// A run that can both open and close only matches runs whose
+			// combined length is not a multiple of three
+			if (d.canClose || closer.canOpen) && (d.length+closer.length)%3 == 0 && (d.length%3 != 0 || closer.length%3 != 0) {
+				continue
+			}
+			opener = d
+			break
+		}
+
+		if opener == nil {
+			openersBottom[key] = closer.prev
+			next := closer.next
+			if !closer.canOpen {
+				p.removeDelimiter(closer)
+			}
+			closer = next
+			continue
+		}
+
+		use, markType := 1, "italic"
+		switch {
+		case closer.char == '~':
+			use, markType = len(closer.item.text), "strike"
+		case len(opener.item.text) >= 2 && len(closer.item.text) >= 2:
+			use, markType = 2, "bold"
+		}
+		opener.item.text = opener.item.text[:len(opener.item.text)-use]
+		closer.item.text = closer.item.text[:len(closer.item.text)-use]
+		p.wrap(opener.item, closer.item, &prosemirror.Mark{Type: markType})
+		opener.next, closer.prev = closer, opener
+
+		if opener.item.text == "" {
+			p.remove(opener.item)
+			p.removeDelimiter(opener)
+		}
+		if closer.item.text == "" {
+			next := closer.next
+			p.remove(closer.item)
+			p.removeDelimiter(closer)
+			closer = next
+		}
+	}
+	for p.delims != nil && p.delims != bottom {
+		p.removeDelimiter(p.delims)
+	}
+}
+
+func (p *inlineParser) openBracket(image bool, length int) {
+	it := p.addText(p.src[p.pos : p.pos+length])
+	p.pos += length
+	p.brackets = &bracket{
+		item:      it,
+		image:     image,
+		active:    true,
+		labelFrom: p.pos,
+		prevDelim: p.delims,
+		htmlDepth: len(p.tags),
+		prev:      p.brackets,
+	}
+}
+
+// closeBracket turns the text since the last opening bracket into a link or
+// an image when a destination follows
+func (p *inlineParser) closeBracket() {
+	opener := p.brackets
+	labelTo := p.pos
+	p.pos++
+	if opener == nil {
+		p.addText("]")
+		return
+	}
+	p.brackets = opener.prev
+	if !opener.active || opener.item.contained {
+		p.addText("]")
+		return
+	}
+	href, title, ok := p.linkDestination(p.src[opener.labelFrom:labelTo])
+	if !ok {
+		p.addText("]")
+		return
+	}
+	if len(p.tags) > opener.htmlDepth {
+		p.tags = p.tags[:opener.htmlDepth]
+	}
+
+	if opener.image {
+		alt := plainText(p.list(opener.item.next, nil))
+		for opener.item.next != nil {
+			p.remove(opener.item.next)
+		}
+		for p.delims != nil && p.delims != opener.prevDelim {
+			p.removeDelimiter(p.delims)
+		}
+		opener.item.text = ""
+		src, safe := prosemirror.SafeImageURL(href)
+		if !safe {
+			p.state.warnings.Add("unsafe image", "images with an unsupported source were replaced by their description")
+			opener.item.text = alt
+			return
+		}
+		attrs := map[string]any{"src": src}
+		if alt != "" {
+			attrs["alt"] = alt
+		}
+		if title != "" {
+			attrs["title"] = title
+		}
+		opener.item.node = &prosemirror.Node{Type: "image", Attrs: attrs}
+		return
+	}
+
+	p.processEmphasis(opener.prevDelim)
+	p.wrap(opener.item, nil, p.state.linkMark(href))
+	p.remove(opener.item)
+	// Links cannot contain other links
+	for b := p.brackets; b != nil; b = b.prev {
+		if !b.image {
+			b.active = false
+		}
+	}
+}
+
+// linkDestination reads what follows the closing bracket of a link: an
+// inline destination, a full, collapsed or shortcut reference
+func (p *inlineParser) linkDestination(label string) (string, string, bool) {
+	if strings.HasPrefix(p.src[p.pos:], "(") {
+		if href, title, end, ok := parseInlineDestination(p.src, p.pos+1); ok {
+			p.pos = end
+			return href, title, true
+		}
+	}
+	if strings.HasPrefix(p.src[p.pos:], "[") {
+		if end := strings.IndexByte(p.src[p.pos+1:], ']'); end >= 0 {
+			ref := p.src[p.pos+1 : p.pos+1+end]
+			if ref == "" {
+				ref = label
+			}
+			if def, ok := p.state.refs[normalizeLabel(ref)]; ok {
+				p.pos += end + 2
+				return def.href, def.title, true
+			}
+			if ref != label {
+				return "", "", false
+			}
+		}
+	}
+	if def, ok := p.state.refs[normalizeLabel(label)]; ok {
+		return def.href, def.title, true
+	}
+	return "", "", false
+}
+
+// parseInlineDestination parses `dest "title")` starting after the
+// parenthesis and returns the position after the closing one
+func parseInlineDestination(src string, pos int) (string, string, int, bool) {
+	skip := func() {
+		for pos < len(src) && (src[pos] == ' ' || src[pos] == '\t' || src[pos] == '\n') {
+			pos++
+		}
+	}
+	skip()
+	var href string
+	if pos < len(src) && src[pos] == '<' {
+		end := strings.IndexAny(src[pos+1:], "<>\n")
+		if end < 0 || src[pos+1+end] != '>' {
+			return "", "", 0, false
+		}
+		href = src[pos+1 : pos+1+end]
+		pos += end + 2
+	} else {
+		start, depth := pos, 0
+	scan:
+		for ; pos < len(src); pos++ {
+			switch c := src[pos]; {
+			case c == '\\' && pos+1 < len(src):
+				pos++
+			case c == '(':
+				depth++
+			case c == ')':
+				if depth == 0 {
+					break scan
+				}
+				depth--
+			case c <= ' ':
+				break scan
+			}
+		}
+		href = src[start:pos]
+	}
+
+	var title string
+	before := pos
+	skip()
+	if pos < len(src) && pos > before && strings.IndexByte(`"'(`, src[pos]) >= 0 {
+		closing := src[pos]
+		if closing == '(' {
+			closing = ')'
+		}
+		end := pos + 1
+		for ; end < len(src) && src[end] != closing; end++ {
+			if src[end] == '\\' {
+				end++
+			}
+		}
+		if end >= len(src) {
+			return "", "", 0, false
+		}
+		title = src[pos+1 : end]
+		pos = end + 1
+		skip()
+	}
+	if pos >= len(src) || src[pos] != ')' {
+		return "", "", 0, false
+	}
+	return unescape(href), unescape(title), pos + 1, true
+}
+
+func (p *inlineParser) angleBracket() {
+	rest := p.src[p.pos:]
+	if m := autolinkURI.FindStringSubmatch(rest); m != nil {
+		p.autolink(m[1], m[1])
+		p.pos += len(m[0])
+		return
+	}
+	if m := autolinkMail.FindStringSubmatch(rest); m != nil {
+		p.autolink("mailto:"+m[1], m[1])
+		p.pos += len(m[0])
+		return
+	}
+	if m := htmlOpenTag.FindStringSubmatch(rest); m != nil {
+		p.openTag(strings.ToLower(m[1]), m[2])
+		p.pos += len(m[0])
+		return
+	}
+	if m := htmlCloseTag.FindStringSubmatch(rest); m != nil {
+		p.closeTag(strings.ToLower(m[1]))
+		p.pos += len(m[0])
+		return
+	}
+	if m := htmlOther.FindString(rest); m != "" {
+		// Comments, processing instructions and declarations have no content
+		p.pos += len(m)
+		return
+	}
+	p.addText("<")
+	p.pos++
+}
+
+func (p *inlineParser) autolink(href, text string) {
+	p.append(&item{mark: p.state.linkMark(href), children: []*item{{text: text}}})
+}
+
+func (p *inlineParser) openTag(name, attrs string) {
+	values := make(map[string]string)
+	for _, m := range htmlAttr.FindAllStringSubmatch(attrs, -1) {
+		values[strings.ToLower(m[1])] = html.UnescapeString(m[2] + m[3] + m[4])
+	}
+	switch {
+	case name == "br":
+		p.append(&item{node: &prosemirror.Node{Type: "hardBreak"}})
+	case name == "img":
+		src, ok := prosemirror.SafeImageURL(values["src"])
+		if !ok {
+			p.state.warnings.Add("<img>", "images with a missing or unsafe source were dropped")
+			return
+		}
+		image := map[string]any{"src": src}
+		if alt := values["alt"]; alt != "" {
+			image["alt"] = alt
+		}
+		if title := values["title"]; title != "" {
+			image["title"] = title
+		}
+		p.append(&item{node: &prosemirror.Node{Type: "image", Attrs: image}})
+	case name == "a":
+		// Anchors without href are named targets and keep their content
+		var mark *prosemirror.Mark
+		if href := values["href"]; href != "" && !strings.HasPrefix(href, "#") {
+			mark = p.state.linkMark(href)
+		}
+		p.pushTag(name, mark)
+	case htmlMarks[name] != "":
+		p.pushTag(name, &prosemirror.Mark{Type: htmlMarks[name]})
+	case name == "span":
+	default:
+		p.state.warnings.Add("<"+name+">", "inline HTML was replaced by its content")
+	}
+}
+
+func (p *inlineParser) pushTag(name string, mark *prosemirror.Mark) {
+	p.tags = append(p.tags, htmlTag{
+		item:      p.addText(""),
+		name:      name,
+		mark:      mark,
+		prevDelim: p.delims,
+		brackets:  p.brackets,
+	})
+}
+
+// closeTag applies the mark of the matching open tag to the content written
+// since it was opened
+func (p *inlineParser) closeTag(name string) {
+	for i := len(p.tags) - 1; i >= 0; i-- {
+		tag := p.tags[i]
+		if tag.name != name {
+			continue
+		}
+		p.tags = p.tags[:i]
+		if tag.item.contained {
+			return
+		}
+		p.processEmphasis(tag.prevDelim)
+		if tag.item.contained {
+			return
+		}
+		// Brackets opened inside the element cannot close outside of it
+		p.brackets = tag.brackets
+		p.wrap(tag.item, nil, tag.mark)
+		p.remove(tag.item)
+		return
+	}
+}
+
+// urlAt reports whether a GFM extended autolink starts at i
+func (p *inlineParser) urlAt(i int) bool {
+	if i > 0 {
+		if before, _ := utf8.DecodeLastRuneInString(p.src[:i]); !unicode.IsSpace(before) && !strings.ContainsRune("*_~(", before) {
+			return false
+		}
+	}
+	return bareURL.MatchString(p.src[i:])
+}
+
+// bareURL links a URL written without markup. Trailing punctuation and
+// unbalanced closing parentheses are left out of it.
+func (p *inlineParser) bareURL() {
+	url := bareURL.FindString(p.src[p.pos:])
+	for {
+		trimmed := strings.TrimRight(url, "?!.,:*_~'\"")
+		if strings.HasSuffix(trimmed, ")") && strings.Count(trimmed, "(") < strings.Count(trimmed, ")") {
+			trimmed = trimmed[:len(trimmed)-1]
+		}
+		if trimmed == url {
+			break
+		}
+		url = trimmed
+	}
+	href := url
+	lower := strings.ToLower(url)
+	switch {
+	case strings.HasPrefix(lower, "www."):
+		if !strings.Contains(lower[4:], ".") {
+			p.text()
+			return
+		}
+		href = "http://" + url
+	case strings.TrimPrefix(strings.TrimPrefix(lower, "http://"), "https://") == "":
+		p.text()
+		return
+	}
+	p.autolink(href, url)
+	p.pos += len(url)
+}
+
+// linkMark returns the link mark for href, or nil when its scheme is unsafe
+// and the text stays plain
+func (s *parseState) linkMark(href string) *prosemirror.Mark {
+	safe, ok := prosemirror.SafeLinkURL(href)
+	if !ok {
+		s.warnings.Add("unsafe link", "links with an unsupported scheme were kept as plain text")
+		return nil
+	}
+	return &prosemirror.Mark{Type: "link", Attrs: map[string]any{"href": safe}}
+}
+
+// nodes converts items into inline nodes carrying marks
+func (s *parseState) nodes(items []*item, marks []*prosemirror.Mark) []*prosemirror.Node {
+	var nodes []*prosemirror.Node
+	for _, it := range items {
+		switch {
+		case it.node != nil:
+			nodes = append(nodes, it.node)
+		case it.mark != nil || it.children != nil:
+			inner := marks
+			if it.mark != nil {
+				if _, ok := s.parser.schema.Marks[it.mark.Type]; ok {
+					inner = prosemirror.AddMarkToSet(marks, it.mark)
+				}
+			}
+			nodes = append(nodes, s.nodes(it.children, inner)...)
+		case it.text != "":
+			nodes = append(nodes, &prosemirror.Node{Type: "text", Text: it.text, Marks: marks})
+		}
+	}
+	return nodes
+}
+
+func plainText(items []*item) string {
+	var text strings.Builder
+	for _, it := range items {
+		if it.node != nil {
+			if alt, ok := it.node.Attr("alt").(string); ok {
+				text.WriteString(alt)
+			}
+			continue
+		}
+		text.WriteString(it.text)
+		text.WriteString(plainText(it.children))
+	}
+	return text.String()
+}
+
+// unescape resolves backslash escapes and entities in link destinations and
+// titles
+func unescape(s string) string {
+	if !strings.ContainsAny(s, `\&`) {
+		return s
+	}
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && i+1 < len(s) && strings.IndexByte(asciiPunctuation, s[i+1]) >= 0 {
+			i++
+		}
+		b.WriteByte(s[i])
+	}
+	return html.UnescapeString(b.String())
+}
@@ -0,0 +1,249 @@
+package markdown
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/emaforlin/ce-document-service/pkg/prosemirror"
+)
+
+var schema = prosemirror.DefaultSchema()
+
+// toJSON marshals a node without escaping HTML characters, so expected
+// documents can be written as they are
+func toJSON(t *testing.T, node *prosemirror.Node) string {
+	t.Helper()
+	var out bytes.Buffer
+	encoder := json.NewEncoder(&out)
+	encoder.SetEscapeHTML(false)
+	if err := encoder.Encode(node); err != nil {
+		t.Fatalf("marshal: %v", err)
+	}
+	return strings.TrimSpace(out.String())
+}
+
+func constructs(warnings prosemirror.Warnings) []string {
+	var names []string
+	for _, warning := range warnings {
+		names = append(names, warning.Construct)
+	}
+	return names
+}
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		name     string
+		markdown string
+		want     string
+		warnings []string
+	}{
+		{
+			name:     "empty input",
+			markdown: "",
+			want:     `{"type":"doc","content":[{"type":"paragraph"}]}`,
+		},
+		{
+			name:     "heading and emphasis",
+			markdown: "# Title\n\nSome *em* and **strong** and ~~gone~~ and `code`.",
+			want:     `{"type":"doc","content":[{"type":"heading","attrs":{"level":1},"content":[{"type":"text","text":"Title"}]},{"type":"paragraph","content":[{"type":"text","text":"Some "},{"type":"text","marks":[{"type":"italic"}],"text":"em"},{"type":"text","text":" and "},{"type":"text","marks":[{"type":"bold"}],"text":"strong"},{"type":"text","text":" and "},{"type":"text","marks":[{"type":"strike"}],"text":"gone"},{"type":"text","text":" and "},{"type":"text","marks":[{"type":"code"}],"text":"code"},{"type":"text","text":"."}]}]}`,
+		},
+		{
+			name:     "setext heading",
+			markdown: "Setext\n======",
+			want:     `{"type":"doc","content":[{"type":"heading","attrs":{"level":1},"content":[{"type":"text","text":"Setext"}]}]}`,
+		},
+		{
+			name:     "ordered list start",
+			markdown: "3. three\n4. four",
+			want:     `{"type":"doc","content":[{"type":"orderedList","attrs":{"start":3},"content":[{"type":"listItem","content":[{"type":"paragraph","content":[{"type":"text","text":"three"}]}]},{"type":"listItem","content":[{"type":"paragraph","content":[{"type":"text","text":"four"}]}]}]}]}`,
+		},
+		{
+			name:     "task list",
+			markdown: "- [ ] todo\n- [x] done",
+			want:     `{"type":"doc","content":[{"type":"taskList","content":[{"type":"taskItem","attrs":{"checked":false},"content":[{"type":"paragraph","content":[{"type":"text","text":"todo"}]}]},{"type":"taskItem","attrs":{"checked":true},"content":[{"type":"paragraph","content":[{"type":"text","text":"done"}]}]}]}]}`,
+		},
+		{
+			name:     "links and autolinks",
+			markdown: "[link](https://example.com \"T\") and <https://auto.example> and www.example.com",
+			want:     `{"type":"doc","content":[{"type":"paragraph","content":[{"type":"text","marks":[{"type":"link","attrs":{"href":"https://example.com"}}],"text":"link"},{"type":"text","text":" and "},{"type":"text","marks":[{"type":"link","attrs":{"href":"https://auto.example"}}],"text":"https://auto.example"},{"type":"text","text":" and "},{"type":"text","marks":[{"type":"link","attrs":{"href":"http://www.example.com"}}],"text":"www.example.com"}]}]}`,
+		},
+		{
+			name:     "reference links",
+			markdown: "[ref][missing] [ok][r]\n\n[r]: https://r.example",
+			want:     `{"type":"doc","content":[{"type":"paragraph","content":[{"type":"text","text":"[ref][missing] "},{"type":"text","marks":[{"type":"link","attrs":{"href":"https://r.example"}}],"text":"ok"}]}]}`,
+		},
+		{
+			name:     "hard breaks",
+			markdown: "line  \nbreak\\\nagain",
+			want:     `{"type":"doc","content":[{"type":"paragraph","content":[{"type":"text","text":"line"},{"type":"hardBreak"},{"type":"text","text":"break"},{"type":"hardBreak"},{"type":"text","text":"again"}]}]}`,
+		},
+		{
+			name:     "table alignment",
+			markdown: "| a | b |\n| --- | :-: |\n| 1 | 2 |",
+			want:     `{"type":"doc","content":[{"type":"table","content":[{"type":"tableRow","content":[{"type":"tableHeader","content":[{"type":"paragraph","content":[{"type":"text","text":"a"}]}]},{"type":"tableHeader","content":[{"type":"paragraph","attrs":{"textAlign":"center"},"content":[{"type":"text","text":"b"}]}]}]},{"type":"tableRow","content":[{"type":"tableCell","content":[{"type":"paragraph","content":[{"type":"text","text":"1"}]}]},{"type":"tableCell","content":[{"type":"paragraph","attrs":{"textAlign":"center"},"content":[{"type":"text","text":"2"}]}]}]}]}]}`,
+		},
+		{
+			name:     "raw HTML",
+			markdown: "<b>bold</b> and <span>x</span>\n\n<div>block</div>\n\n<script>alert(1)</script>",
+			want:     `{"type":"doc","content":[{"type":"paragraph","content":[{"type":"text","marks":[{"type":"bold"}],"text":"bold"},{"type":"text","text":" and x"}]},{"type":"paragraph","content":[{"type":"text","text":"block"}]}]}`,
+			warnings: []string{"<script>"},
+		},
+		{
+			name:     "footnotes are kept as text",
+			markdown: "Footnote[^1].\n\n[^1]: note",
+			want:     `{"type":"doc","content":[{"type":"paragraph","content":[{"type":"text","text":"Footnote[^1]."}]},{"type":"paragraph","content":[{"type":"text","text":"[^1]: note"}]}]}`,
+			warnings: []string{"footnote"},
+		},
+		{
+			name:     "unsafe link",
+			markdown: "[x](javascript:alert(1))",
+			want:     `{"type":"doc","content":[{"type":"paragraph","content":[{"type":"text","text":"x"}]}]}`,
+			warnings: []string{"unsafe link"},
+		},
+	}
+
+	parser := NewParser(schema)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			doc, warnings := parser.Parse(tt.markdown)
+			if err := schema.Check(doc); err != nil {
+				t.Errorf("parsed document is invalid: %v", err)
+			}
+			if got := toJSON(t, doc); got != tt.want {
+				t.Errorf("Parse() =\n%s\nwant\n%s", got, tt.want)
+			}
+			if got := constructs(warnings); strings.Join(got, ",") != strings.Join(tt.warnings, ",") {
+				t.Errorf("warnings = %v, want %v", got, tt.warnings)
+			}
+		})
+	}
+}
+
+func TestParseMalformed(t *testing.T) {
+	tests := []struct {
+		name     string
+		markdown string
+		want     string
+	}{
+		{
+			name:     "unclosed emphasis",
+			markdown: "*unclosed **strong _em",
+			want:     `{"type":"doc","content":[{"type":"paragraph","content":[{"type":"text","text":"*unclosed **strong _em"}]}]}`,
+		},
+		{
+			name:     "unclosed code fence",
+			markdown: "```\nnever closed",
+			want:     `{"type":"doc","content":[{"type":"codeBlock","content":[{"type":"text","text":"never closed"}]}]}`,
+		},
+		{
+			name:     "unclosed link",
+			markdown: "[broken](",
+			want:     `{"type":"doc","content":[{"type":"paragraph","content":[{"type":"text","text":"[broken]("}]}]}`,
+		},
+		{
+			name:     "unclosed HTML tags are dropped",
+			markdown: "<b>open <i>tags",
+			want:     `{"type":"doc","content":[{"type":"paragraph","content":[{"type":"text","text":"open tags"}]}]}`,
+		},
+		{
+			name:     "NUL and invalid UTF-8",
+			markdown: "\x00 nul \xff\xfe bad",
+			want:     `{"type":"doc","content":[{"type":"paragraph","content":[{"type":"text","text":"� nul � bad"}]}]}`,
+		},
+		{
+			name:     "table without body",
+			markdown: "| a |\n| --- |",
+			want:     `{"type":"doc","content":[{"type":"table","content":[{"type":"tableRow","content":[{"type":"tableHeader","content":[{"type":"paragraph","content":[{"type":"text","text":"a"}]}]}]}]}]}`,
+		},
+		{
+			name:     "nested quote markers",
+			markdown: ">>> deep",
+			want:     `{"type":"doc","content":[{"type":"blockquote","content":[{"type":"blockquote","content":[{"type":"blockquote","content":[{"type":"paragraph","content":[{"type":"text","text":"deep"}]}]}]}]}]}`,
+		},
+	}
+
+	parser := NewParser(schema)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			doc, _ := parser.Parse(tt.markdown)
+			if err := schema.Check(doc); err != nil {
+				t.Errorf("parsed document is invalid: %v", err)
+			}
+			if got := toJSON(t, doc); got != tt.want {
+				t.Errorf("Parse() =\n%s\nwant\n%s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRoundTrip(t *testing.T) {
+	tests := []struct {
+		name     string
+		markdown string
+		// want is the serialized Markdown, the input when empty
+		want string
+	}{
+		{name: "emphasis", markdown: "# Title\n\nSome *em* and **strong** and ~~gone~~ and `code`.\n"},
+		{name: "nested lists", markdown: "- a\n- b\n  - c\n\n1. one\n2. two\n"},
+		{name: "ordered list start", markdown: "3. three\n4. four\n"},
+		{name: "task list", markdown: "- [ ] todo\n- [x] done\n"},
+		{name: "blockquote", markdown: "> quote\n>\n> more\n"},
+		{name: "fenced code", markdown: "```go\nx := 1\n```\n"},
+		{name: "image", markdown: "![alt](https://example.com/a.png)\n"},
+		{name: "aligned table", markdown: "| a | b | c |\n| :--- | :---: | ---: |\n| 1 | 2 | 3 |\n"},
+		{name: "escaped text", markdown: "\\*not em\\* and \\[brackets\\]\n"},
+		{
+			name:     "links become inline links",
+			markdown: "[link](https://example.com \"T\") and <https://auto.example>\n",
+			want:     "[link](https://example.com) and [https://auto.example](https://auto.example)\n",
+		},
+		{
+			name:     "breaks use backslashes",
+			markdown: "line  \nbreak\n",
+			want:     "line\\\nbreak\n",
+		},
+		{
+			name:     "rules and setext headings are normalized",
+			markdown: "Setext\n======\n\n***\n",
+			want:     "# Setext\n\n---\n",
+		},
+		{
+			name:     "indented code is fenced",
+			markdown: "    indented code\n",
+			want:     "```\nindented code\n```\n",
+		},
+		{
+			name:     "unclosed emphasis is escaped",
+			markdown: "*unclosed **strong _em",
+			want:     "\\*unclosed \\*\\*strong \\_em\n",
+		},
+		{
+			name:     "unclosed fence is closed",
+			markdown: "```\nnever closed",
+			want:     "```\nnever closed\n```\n",
+		},
+	}
+
+	parser := NewParser(schema)
+	serializer := NewSerializer(schema)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			doc, _ := parser.Parse(tt.markdown)
+			got := serializer.Serialize(doc)
+			want := tt.want
+			if want == "" {
+				want = tt.markdown
+			}
+			if got != want {
+				t.Errorf("Serialize() = %q, want %q", got, want)
+			}
+			// The exported Markdown imports as the same document
+			again, _ := parser.Parse(got)
+			if toJSON(t, again) != toJSON(t, doc) {
+				t.Errorf("reimport =\n%s\nwant\n%s", toJSON(t, again), toJSON(t, doc))
+			}
+		})
+	}
+}
@@ -0,0 +1,245 @@
+package markdown
+
+import (
+	"strings"
+
+	"github.com/emaforlin/ce-document-service/pkg/prosemirror"
+)
+
+// defaultNodes renders the node types of the default schema
+var defaultNodes = map[string]NodeRenderer{
+	"doc": func(state *SerializerState, node, _ *prosemirror.Node, _ int) {
+		state.RenderContent(node)
+	},
+	"paragraph": func(state *SerializerState, node, _ *prosemirror.Node, _ int) {
+		state.RenderInline(node)
+		state.CloseBlock(node)
+	},
+	"heading": func(state *SerializerState, node, _ *prosemirror.Node, _ int) {
+		level := min(max(attrInt(node.Attr("level"), 1), 1), 6)
+		state.Write(strings.Repeat("#", level) + " ")
+		state.RenderInline(node)
+		state.CloseBlock(node)
+	},
+	"blockquote": func(state *SerializerState, node, _ *prosemirror.Node, _ int) {
+		state.WrapBlock("> ", "", node, func() { state.RenderContent(node) })
+	},
+	"codeBlock": func(state *SerializerState, node, _ *prosemirror.Node, _ int) {
+		text := node.TextContent()
+		fence := "```"
+		for strings.Contains(text, fence) {
+			fence += "`"
+		}
+		language := attrString(node.Attr("language"))
+		if strings.ContainsAny(language, "`\n") {
+			language = ""
+		}
+		state.Write(fence + language + "\n")
+		state.Text(text, false)
+		state.EnsureNewLine()
+		state.Write(fence)
+		state.CloseBlock(node)
+	},
+	"horizontalRule": func(state *SerializerState, node, _ *prosemirror.Node, _ int) {
+		state.Write("---")
+		state.CloseBlock(node)
+	},
+	"bulletList": func(state *SerializerState, node, _ *prosemirror.Node, _ int) {
+		state.RenderList(node, "  ", func(int) string { return "- " })
+	},
+	"orderedList": func(state *SerializerState, node, _ *prosemirror.Node, _ int) {
+		start := attrInt(node.Attr("start"), 1)
+		if start != 1 && state.closed != nil && state.closed.Type == "paragraph" {
+			// Only a list starting at 1 can interrupt a paragraph
+			state.flushClose(2)
+		}
+		width := len(itoa(start + len(node.Content) - 1))
+		state.RenderList(node, strings.Repeat(" ", width+2), func(i int) string {
+			number := itoa(start + i)
+			return strings.Repeat(" ", width-len(number)) + number + ". "
+		})
+	},
+	"listItem": func(state *SerializerState, node, _ *prosemirror.Node, _ int) {
+		state.RenderContent(node)
+	},
+	"taskList": func(state *SerializerState, node, _ *prosemirror.Node, _ int) {
+		state.RenderList(node, "  ", func(i int) string {
+			if checked, _ := node.Content[i].Attr("checked").(bool); checked {
+				return "- [x] "
+			}
+			return "- [ ] "
+		})
+	},
+	"taskItem": func(state *SerializerState, node, _ *prosemirror.Node, _ int) {
+		state.RenderContent(node)
+	},
+	"table": renderTable,
+	"image": func(state *SerializerState, node, parent *prosemirror.Node, _ int) {
+		src, ok := prosemirror.SafeImageURL(attrString(node.Attr("src")))
+		if !ok {
+			return
+		}
+		image := "![" + state.Esc(attrString(node.Attr("alt")), false) + "](" + escapeURL(src)
+		if title := attrString(node.Attr("title")); title != "" {
+			image += ` "` + strings.ReplaceAll(title, `"`, `\"`) + `"`
+		}
+		state.Write(image + ")")
+		if !state.serializer.schema.IsInline(node) {
+			state.CloseBlock(node)
+		}
+	},
+	"hardBreak": func(state *SerializerState, _, _ *prosemirror.Node, _ int) {
+		if state.inTable {
+			state.Write("<br>")
+			return
+		}
+		state.Write("\\\n")
+	},
+	"text": func(state *SerializerState, node, _ *prosemirror.Node, _ int) {
+		state.Text(node.Text, true)
+	},
+}
+
+// defaultMarks renders the mark types of the default schema. Marks CommonMark
+// has no syntax for are written as inline HTML.
+var defaultMarks = map[string]MarkRenderer{
+	"bold":        delimiters("**", "**"),
+	"italic":      delimiters("*", "*"),
+	"strike":      delimiters("~~", "~~"),
+	"underline":   delimiters("<u>", "</u>"),
+	"highlight":   delimiters("<mark>", "</mark>"),
+	"subscript":   delimiters("<sub>", "</sub>"),
+	"superscript": delimiters("<sup>", "</sup>"),
+	"code": {
+		Open: func(_ *SerializerState, _ *prosemirror.Mark, node *prosemirror.Node) string {
+			ticks, pad := codeDelimiters(node.Text)
+			return ticks + pad
+		},
+		Close: func(_ *SerializerState, _ *prosemirror.Mark, node *prosemirror.Node) string {
+			ticks, pad := codeDelimiters(node.Text)
+			return pad + ticks
+		},
+		Raw: true,
+	},
+	"link": {
+		Open: func(_ *SerializerState, mark *prosemirror.Mark, _ *prosemirror.Node) string {
+			if _, ok := prosemirror.SafeLinkURL(attrString(mark.Attr("href"))); !ok {
+				return ""
+			}
+			return "["
+		},
+		Close: func(_ *SerializerState, mark *prosemirror.Mark, _ *prosemirror.Node) string {
+			href, ok := prosemirror.SafeLinkURL(attrString(mark.Attr("href")))
+			if !ok {
+				return ""
+			}
+			return "](" + escapeURL(href) + ")"
+		},
+	},
+}
+
+func delimiters(open, close string) MarkRenderer {
+	return MarkRenderer{
+		Open:  func(*SerializerState, *prosemirror.Mark, *prosemirror.Node) string { return open },
+		Close: func(*SerializerState, *prosemirror.Mark, *prosemirror.Node) string { return close },
+	}
+}
+
+// codeDelimiters returns a backtick run longer than any inside text, and the
+// padding needed when text starts or ends with a backtick
+func codeDelimiters(text string) (string, string) {
+	longest, run := 0, 0
+	for _, r := range text {
+		if r == '`' {
+			run++
+			longest = max(longest, run)
+		} else {
+			run = 0
+		}
+	}
+	pad := ""
+	if strings.HasPrefix(text, "`") || strings.HasSuffix(text, "`") {
+		pad = " "
+	}
+	return strings.Repeat("`", longest+1), pad
+}
+
+var urlEscaper = strings.NewReplacer(" ", "%20", "(", "%28", ")", "%29", "<", "%3C", ">", "%3E")
+
+// escapeURL makes a URL safe to use as a link destination
+func escapeURL(url string) string {
+	return urlEscaper.Replace(url)
+}
+
+// renderTable writes a GFM table. GFM tables have a single header row and
+// cells holding one line of inline content, so the first row becomes the
+// header, blocks inside cells are joined with <br> and spanned cells are
+// padded with empty ones. Column alignment comes from the header cells.
+func renderTable(state *SerializerState, node, _ *prosemirror.Node, _ int) {
+	rows := make([][]string, len(node.Content))
+	columns := 0
+	for r, row := range node.Content {
+		for _, cell := range row.Content {
+			rows[r] = append(rows[r], state.renderCell(cell))
+			for span := attrInt(cell.Attr("colspan"), 1); span > 1; span-- {
+				rows[r] = append(rows[r], "")
+			}
+		}
+		columns = max(columns, len(rows[r]))
+	}
+	if columns == 0 {
+		return
+	}
+
+	separator := make([]string, columns)
+	for i := range separator {
+		separator[i] = "---"
+	}
+	// GFM aligns whole columns, the alignment of the header cells is used
+	column := 0
+	for _, cell := range node.Content[0].Content {
+		if len(cell.Content) > 0 {
+			switch attrString(cell.Content[0].Attr("textAlign")) {
+			case "left":
+				separator[column] = ":---"
+			case "center":
+				separator[column] = ":---:"
+			case "right":
+				separator[column] = "---:"
+			}
+		}
+		column += max(attrInt(cell.Attr("colspan"), 1), 1)
+	}
+	for r, cells := range rows {
+		for len(cells) < columns {
+			cells = append(cells, "")
+		}
+		if r > 0 {
+			state.EnsureNewLine()
+		}
+		state.Write("| " + strings.Join(cells, " | ") + " |")
+		if r == 0 {
+			state.EnsureNewLine()
+			state.Write("| " + strings.Join(separator, " | ") + " |")
+		}
+	}
+	state.CloseBlock(node)
+}
+
+// renderCell renders the content of a table cell on a single line
+func (s *SerializerState) renderCell(cell *prosemirror.Node) string {
+	var blocks []string
+	for _, block := range cell.Content {
+		sub := &SerializerState{serializer: s.serializer, inTable: true}
+		if len(block.Content) > 0 && sub.isInline(block.Content[0]) {
+			sub.RenderInline(block)
+		} else {
+			sub.Text(block.TextContent(), true)
+		}
+		text := strings.TrimSpace(strings.ReplaceAll(sub.out.String(), "\n", " "))
+		if text != "" {
+			blocks = append(blocks, text)
+		}
+	}
+	return strings.Join(blocks, "<br>")
+}
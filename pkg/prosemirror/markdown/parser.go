@@ -0,0 +1,704 @@
+package markdown
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/emaforlin/ce-document-service/pkg/prosemirror"
+)
+
+// Parser converts CommonMark with the GFM table, strikethrough, task list and
+// autolink extensions into documents of the default schema. Raw HTML is
+// handed to prosemirror.HTMLParser, constructs the schema cannot hold, like
+// footnotes, are reported as warnings and kept as text.
+type Parser struct {
+	schema *prosemirror.Schema
+}
+
+// NewParser creates a parser producing content for schema, the default schema
+// when nil
+func NewParser(schema *prosemirror.Schema) *Parser {
+	if schema == nil {
+		schema = prosemirror.DefaultSchema()
+	}
+	return &Parser{schema: schema}
+}
+
+// Parse converts a Markdown document into a doc node
+func (p *Parser) Parse(text string) (*prosemirror.Node, prosemirror.Warnings) {
+	state := &parseState{parser: p, refs: make(map[string]linkReference)}
+	blocks := state.parseBlocks(splitLines(text))
+	content := state.convert(blocks)
+	if len(content) == 0 {
+		content = []*prosemirror.Node{{Type: "paragraph"}}
+	}
+	return &prosemirror.Node{Type: "doc", Content: content}, state.warnings
+}
+
+// parseState holds what is shared while parsing one document. Blocks are
+// parsed first, inline content once every link reference definition is known.
+type parseState struct {
+	parser   *Parser
+	refs     map[string]linkReference
+	warnings prosemirror.Warnings
+}
+
+type linkReference struct {
+	href, title string
+}
+
+type blockKind int
+
+const (
+	paragraphBlock blockKind = iota
+	headingBlock
+	codeBlock
+	quoteBlock
+	listBlock
+	ruleBlock
+	tableBlock
+	htmlBlock
+)
+
+// block is the structure found by the first pass, text holds the raw inline
+// content of paragraphs and headings, the text of code blocks and the source
+// of HTML blocks
+type block struct {
+	kind     blockKind
+	text     string
+	level    int
+	language string
+	children []*block
+
+	// Lists
+	ordered bool
+	task    bool
+	start   int
+	items   []listItem
+
+	// Tables, the first row is the header
+	rows  [][]string
+	align []string
+}
+
+type listItem struct {
+	checked bool
+	blocks  []*block
+}
+
+// splitLines normalizes line endings. Tabs are kept: code blocks take them
+// verbatim, and the block parsers count them as indentation up to the next
+// multiple of four columns.
+func splitLines(text string) []string {
+	text = strings.ToValidUTF8(strings.TrimPrefix(text, "\ufeff"), "\ufffd")
+	text = strings.ReplaceAll(text, "\x00", "\ufffd")
+	text = strings.ReplaceAll(text, "\r\n", "\n")
+	text = strings.ReplaceAll(text, "\r", "\n")
+	return strings.Split(strings.TrimSuffix(text, "\n"), "\n")
+}
+
+func isBlank(line string) bool {
+	return strings.TrimLeft(line, " \t") == ""
+}
+
+// splitIndent returns the width of the leading whitespace in columns and the
+// rest of the line
+func splitIndent(line string) (int, string) {
+	rest := strings.TrimLeft(line, " \t")
+	return columns(line[:len(line)-len(rest)], 0), rest
+}
+
+// columns returns the width of whitespace starting at column start
+func columns(space string, start int) int {
+	column := start
+	for i := 0; i < len(space); i++ {
+		if space[i] == '\t' {
+			column += 4 - column%4
+		} else {
+			column++
+		}
+	}
+	return column - start
+}
+
+// stripIndent removes up to n columns of leading whitespace. A tab that is
+// only partly removed leaves spaces for its remaining columns.
+func stripIndent(line string, n int) string {
+	column := 0
+	for i := 0; i < len(line); i++ {
+		if column >= n {
+			return line[i:]
+		}
+		switch line[i] {
+		case ' ':
+			column++
+		case '\t':
+			column += 4 - column%4
+			if column > n {
+				return strings.Repeat(" ", column-n) + line[i+1:]
+			}
+		default:
+			return line[i:]
+		}
+	}
+	return ""
+}
+
+var (
+	atxHeading     = regexp.MustCompile(`^(#{1,6})(?:[ \t]+|$)(.*)$`)
+	atxClosing     = regexp.MustCompile(`(?:^|[ \t]+)#+[ \t]*$`)
+	thematicBreak  = regexp.MustCompile(`^(?:(?:\*[ \t]*){3,}|(?:-[ \t]*){3,}|(?:_[ \t]*){3,})$`)
+	fenceOpen      = regexp.MustCompile("^(`{3,}|~{3,})(.*)$")
+	setextHeading  = regexp.MustCompile(`^(?:=+|-+)[ \t]*$`)
+	listMarkerExpr = regexp.MustCompile(`^([-+*]|(\d{1,9})([.)]))([ \t]+|$)`)
+	taskMarker     = regexp.MustCompile(`^\[([ xX])\](?:[ \t]+|$)`)
+	referenceDef   = regexp.MustCompile(`^\[((?:[^\[\]\\]|\\.)+)\]:[ \t]*(?:<([^<>\n]*)>|(\S+))(?:[ \t]+("(?:[^"\\]|\\.)*"|'(?:[^'\\]|\\.)*'|\((?:[^()\\]|\\.)*\)))?[ \t]*$`)
+	footnoteDef    = regexp.MustCompile(`^\[\^[^\]\s]+\]:`)
+	tableDelimiter = regexp.MustCompile(`^\|?[ \t]*:?-+:?[ \t]*(?:\|[ \t]*:?-+:?[ \t]*)*\|?[ \t]*$`)
+)
+
+// parseBlocks finds the blocks in lines, which have the markers of enclosing
+// containers already removed
+func (s *parseState) parseBlocks(lines []string) []*block {
+	var blocks []*block
+	for i := 0; i < len(lines); {
+		line := lines[i]
+		if isBlank(line) {
+			i++
+			continue
+		}
+		indent, rest := splitIndent(line)
+		if indent >= 4 {
+			var b *block
+			b, i = parseIndentedCode(lines, i)
+			blocks = append(blocks, b)
+			continue
+		}
+		if b, next, ok := parseFence(lines, i); ok {
+			blocks = append(blocks, b)
+			i = next
+			continue
+		}
+		if m := atxHeading.FindStringSubmatch(rest); m != nil {
+			text := atxClosing.ReplaceAllString(strings.TrimSpace(m[2]), "")
+			blocks = append(blocks, &block{kind: headingBlock, level: len(m[1]), text: text})
+			i++
+			continue
+		}
+		if thematicBreak.MatchString(rest) {
+			blocks = append(blocks, &block{kind: ruleBlock})
+			i++
+			continue
+		}
+		if strings.HasPrefix(rest, ">") {
+			var b *block
+			b, i = s.parseQuote(lines, i)
+			blocks = append(blocks, b)
+			continue
+		}
+		if _, ok := parseListMarker(line); ok {
+			var b *block
+			b, i = s.parseList(lines, i)
+			blocks = append(blocks, b)
+			continue
+		}
+		if kind := htmlBlockStart(rest); kind != 0 {
+			var b *block
+			b, i = parseHTMLBlock(lines, i, kind)
+			blocks = append(blocks, b)
+			continue
+		}
+		if b, next, ok := parseTable(lines, i); ok {
+			blocks = append(blocks, b)
+			i = next
+			continue
+		}
+		var paragraph []*block
+		paragraph, i = s.parseParagraph(lines, i)
+		blocks = append(blocks, paragraph...)
+	}
+	return blocks
+}
+
+// interrupts reports whether a line starts a block that ends a paragraph
+func interrupts(lines []string, i int) bool {
+	indent, rest := splitIndent(lines[i])
+	if indent >= 4 {
+		return false
+	}
+	if atxHeading.MatchString(rest) || thematicBreak.MatchString(rest) || strings.HasPrefix(rest, ">") {
+		return true
+	}
+	if isFence(rest) || isTableStart(lines, i) {
+		return true
+	}
+	if kind := htmlBlockStart(rest); kind != 0 && kind != 7 {
+		return true
+	}
+	// Only lists starting with content, and at 1 when ordered, interrupt
+	marker, ok := parseListMarker(lines[i])
+	return ok && !marker.empty && (!marker.ordered || marker.number == 1)
+}
+
+func (s *parseState) parseParagraph(lines []string, i int) ([]*block, int) {
+	var text []string
+	for ; i < len(lines) && !isBlank(lines[i]); i++ {
+		if len(text) > 0 {
+			if indent, rest := splitIndent(lines[i]); indent < 4 && setextHeading.MatchString(rest) {
+				level := 1
+				if rest[0] == '-' {
+					level = 2
+				}
+				heading := &block{kind: headingBlock, level: level, text: strings.TrimSpace(strings.Join(text, "\n"))}
+				return []*block{heading}, i + 1
+			}
+			if interrupts(lines, i) {
+				break
+			}
+		}
+		text = append(text, strings.TrimLeft(lines[i], " \t"))
+	}
+
+	// Link reference definitions lead the paragraph
+	for len(text) > 0 {
+		if footnoteDef.MatchString(text[0]) {
+			s.warnings.Add("footnote", "footnotes are not supported and were kept as text")
+			break
+		}
+		m := referenceDef.FindStringSubmatch(strings.TrimRight(text[0], " \t"))
+		if m == nil {
+			break
+		}
+		label := normalizeLabel(m[1])
+		if _, exists := s.refs[label]; !exists && label != "" {
+			href := m[2] + m[3]
+			title := ""
+			if m[4] != "" {
+				title = unescape(m[4][1 : len(m[4])-1])
+			}
+			s.refs[label] = linkReference{href: unescape(href), title: title}
+		}
+		text = text[1:]
+	}
+	if len(text) == 0 {
+		return nil, i
+	}
+	return []*block{{kind: paragraphBlock, text: strings.TrimRight(strings.Join(text, "\n"), " \t")}}, i
+}
+
+func parseIndentedCode(lines []string, i int) (*block, int) {
+	var text []string
+	for ; i < len(lines); i++ {
+		indent, _ := splitIndent(lines[i])
+		if indent < 4 && !isBlank(lines[i]) {
+			break
+		}
+		text = append(text, stripIndent(lines[i], 4))
+	}
+	for len(text) > 0 && isBlank(text[len(text)-1]) {
+		text = text[:len(text)-1]
+	}
+	return &block{kind: codeBlock, text: strings.Join(text, "\n")}, i
+}
+
+// isFence reports whether a line without its indentation opens a code fence
+func isFence(rest string) bool {
+	m := fenceOpen.FindStringSubmatch(rest)
+	return m != nil && (m[1][0] != '`' || !strings.Contains(m[2], "`"))
+}
+
+func parseFence(lines []string, i int) (*block, int, bool) {
+	indent, rest := splitIndent(lines[i])
+	if indent >= 4 || !isFence(rest) {
+		return nil, i, false
+	}
+	m := fenceOpen.FindStringSubmatch(rest)
+	fence := m[1]
+	b := &block{kind: codeBlock}
+	if info := strings.Fields(unescape(m[2])); len(info) > 0 {
+		b.language = info[0]
+	}
+
+	var text []string
+	for i++; i < len(lines); i++ {
+		lineIndent, lineRest := splitIndent(lines[i])
+		closing := strings.TrimRight(lineRest, " \t")
+		if lineIndent < 4 && len(closing) >= len(fence) && strings.Trim(closing, fence[:1]) == "" {
+			i++
+			break
+		}
+		text = append(text, stripIndent(lines[i], indent))
+	}
+	b.text = strings.Join(text, "\n")
+	return b, i, true
+}
+
+// parseQuote collects the lines of a blockquote. Lines without a marker
+// continue it when they continue a paragraph.
+func (s *parseState) parseQuote(lines []string, i int) (*block, int) {
+	var inner []string
+	for ; i < len(lines); i++ {
+		indent, rest := splitIndent(lines[i])
+		if indent < 4 && strings.HasPrefix(rest, ">") {
+			inner = append(inner, stripIndent(rest[1:], 1))
+			continue
+		}
+		if isBlank(lines[i]) || len(inner) == 0 || isBlank(inner[len(inner)-1]) || interrupts(lines, i) {
+			break
+		}
+		inner = append(inner, lines[i])
+	}
+	return &block{kind: quoteBlock, children: s.parseBlocks(inner)}, i
+}
+
+type listMarker struct {
+	ordered bool
+	// bullet is the bullet character or the delimiter after the number
+	bullet byte
+	number int
+	// offset is the column the content of the item starts at
+	offset int
+	empty  bool
+	// content is the first line of the item after the marker
+	content string
+}
+
+func parseListMarker(line string) (listMarker, bool) {
+	indent, rest := splitIndent(line)
+	if indent >= 4 || thematicBreak.MatchString(rest) {
+		return listMarker{}, false
+	}
+	m := listMarkerExpr.FindStringSubmatch(rest)
+	if m == nil {
+		return listMarker{}, false
+	}
+	marker := listMarker{bullet: m[1][0]}
+	if m[2] != "" {
+		marker.ordered = true
+		marker.bullet = m[3][0]
+		marker.number, _ = strconv.Atoi(m[2])
+	}
+	after := rest[len(m[1]):]
+	width := columns(m[4], indent+len(m[1]))
+	spaces := width
+	marker.empty = isBlank(after)
+	if marker.empty || spaces > 4 {
+		// Content indented further is an indented code block
+		spaces = 1
+	}
+	marker.offset = indent + len(m[1]) + spaces
+	if !marker.empty {
+		marker.content = strings.Repeat(" ", width-spaces) + after[len(m[4]):]
+	}
+	return marker, true
+}
+
+// parseList collects consecutive items with the same kind of marker. The
+// lines of an item are those indented past its marker, and lines continuing
+// its last paragraph.
+func (s *parseState) parseList(lines []string, i int) (*block, int) {
+	first, _ := parseListMarker(lines[i])
+	list := &block{kind: listBlock, ordered: first.ordered, start: first.number, task: !first.ordered}
+	for i < len(lines) {
+		marker, ok := parseListMarker(lines[i])
+		if !ok || marker.ordered != first.ordered || marker.bullet != first.bullet {
+			break
+		}
+		content := []string{marker.content}
+		for i++; i < len(lines); i++ {
+			line := lines[i]
+			if isBlank(line) {
+				content = append(content, "")
+				continue
+			}
+			if indent, _ := splitIndent(line); indent >= marker.offset {
+				content = append(content, stripIndent(line, marker.offset))
+				continue
+			}
+			if isBlank(content[len(content)-1]) || interrupts(lines, i) {
+				break
+			}
+			if _, ok := parseListMarker(line); ok {
+				break
+			}
+			content = append(content, strings.TrimLeft(line, " \t"))
+		}
+
+		var item listItem
+		if m := taskMarker.FindStringSubmatch(content[0]); m != nil && !first.ordered {
+			item.checked = m[1] != " "
+			content[0] = content[0][len(m[0]):]
+		} else {
+			list.task = false
+		}
+		item.blocks = s.parseBlocks(content)
+		list.items = append(list.items, item)
+	}
+	return list, i
+}
+
+// htmlBlockStart returns the kind of HTML block a line starts, following the
+// seven start conditions of CommonMark, or 0
+func htmlBlockStart(rest string) int {
+	if !strings.HasPrefix(rest, "<") {
+		return 0
+	}
+	for kind, start := range htmlBlockStarts {
+		if start.MatchString(rest) {
+			return kind + 1
+		}
+	}
+	return 0
+}
+
+var htmlBlockStarts = []*regexp.Regexp{
+	regexp.MustCompile(`(?i)^<(?:script|pre|style|textarea)(?:\s|>|$)`),
+	regexp.MustCompile(`^<!--`),
+	regexp.MustCompile(`^<\?`),
+	regexp.MustCompile(`^<![A-Za-z]`),
+	regexp.MustCompile(`^<!\[CDATA\[`),
+	regexp.MustCompile(`(?i)^</?(?:address|article|aside|base|basefont|blockquote|body|caption|center|col|colgroup|dd|details|dialog|dir|div|dl|dt|fieldset|figcaption|figure|footer|form|frame|frameset|h[1-6]|head|header|hr|html|iframe|legend|li|link|main|menu|menuitem|nav|noframes|ol|optgroup|option|p|param|search|section|summary|table|tbody|td|tfoot|th|thead|title|tr|track|ul)(?:\s|/?>|$)`),
+	regexp.MustCompile(`^(?:<[A-Za-z][A-Za-z0-9-]*(?:\s+[A-Za-z_:][A-Za-z0-9_.:-]*(?:\s*=\s*(?:[^\s"'=<>` + "`" + `]+|'[^']*'|"[^"]*"))?)*\s*/?>|</[A-Za-z][A-Za-z0-9-]*\s*>)\s*$`),
+}
+
+// htmlBlockEnds are the end conditions of the first five kinds, the others
+// end at a blank line
+var htmlBlockEnds = []*regexp.Regexp{
+	regexp.MustCompile(`(?i)</(?:script|pre|style|textarea)>`),
+	regexp.MustCompile(`-->`),
+	regexp.MustCompile(`\?>`),
+	regexp.MustCompile(`>`),
+	regexp.MustCompile(`\]\]>`),
+}
+
+func parseHTMLBlock(lines []string, i, kind int) (*block, int) {
+	var source []string
+	for ; i < len(lines); i++ {
+		if kind > len(htmlBlockEnds) && isBlank(lines[i]) {
+			break
+		}
+		source = append(source, lines[i])
+		if kind <= len(htmlBlockEnds) && htmlBlockEnds[kind-1].MatchString(lines[i]) {
+			i++
+			break
+		}
+	}
+	return &block{kind: htmlBlock, text: strings.Join(source, "\n")}, i
+}
+
+// isTableStart reports whether lines[i] is the header row of a table: it
+// is followed by a delimiter row with as many cells
+func isTableStart(lines []string, i int) bool {
+	if i+1 >= len(lines) || !strings.Contains(lines[i], "|") || !tableDelimiter.MatchString(strings.TrimSpace(lines[i+1])) {
+		return false
+	}
+	if indent, _ := splitIndent(lines[i]); indent >= 4 {
+		return false
+	}
+	return len(splitRow(lines[i])) == len(splitRow(lines[i+1]))
+}
+
+// parseTable reads a GFM table: the header row, the delimiter row and the
+// rows up to a blank line or another block
+func parseTable(lines []string, i int) (*block, int, bool) {
+	if !isTableStart(lines, i) {
+		return nil, i, false
+	}
+	header := splitRow(lines[i])
+	delimiters := splitRow(lines[i+1])
+
+	b := &block{kind: tableBlock, rows: [][]string{header}}
+	for _, delimiter := range delimiters {
+		delimiter = strings.TrimSpace(delimiter)
+		left, right := strings.HasPrefix(delimiter, ":"), strings.HasSuffix(delimiter, ":")
+		switch {
+		case left && right:
+			b.align = append(b.align, "center")
+		case right:
+			b.align = append(b.align, "right")
+		case left:
+			b.align = append(b.align, "left")
+		default:
+			b.align = append(b.align, "")
+		}
+	}
+	for i += 2; i < len(lines) && !isBlank(lines[i]); i++ {
+		if _, rest := splitIndent(lines[i]); strings.HasPrefix(rest, ">") || atxHeading.MatchString(rest) || thematicBreak.MatchString(rest) {
+			break
+		}
+		if isFence(strings.TrimLeft(lines[i], " ")) {
+			break
+		}
+		row := splitRow(lines[i])
+		for len(row) < len(header) {
+			row = append(row, "")
+		}
+		b.rows = append(b.rows, row[:len(header)])
+	}
+	return b, i, true
+}
+
+// splitRow splits a table row into cells on the pipes that are not escaped
+func splitRow(line string) []string {
+	line = strings.TrimSpace(line)
+	line = strings.TrimPrefix(line, "|")
+	if strings.HasSuffix(line, "|") && !strings.HasSuffix(line, `\|`) {
+		line = line[:len(line)-1]
+	}
+	var cells []string
+	var cell strings.Builder
+	for i := 0; i < len(line); i++ {
+		switch {
+		case line[i] == '\\' && i+1 < len(line) && line[i+1] == '|':
+			cell.WriteByte('|')
+			i++
+		case line[i] == '|':
+			cells = append(cells, strings.TrimSpace(cell.String()))
+			cell.Reset()
+		default:
+			cell.WriteByte(line[i])
+		}
+	}
+	return append(cells, strings.TrimSpace(cell.String()))
+}
+
+// convert turns the blocks of the first pass into nodes, parsing their inline
+// content
+func (s *parseState) convert(blocks []*block) []*prosemirror.Node {
+	var nodes []*prosemirror.Node
+	for _, b := range blocks {
+		switch b.kind {
+		case paragraphBlock:
+			nodes = append(nodes, s.textblock(&prosemirror.Node{Type: "paragraph"}, b.text)...)
+		case headingBlock:
+			heading := &prosemirror.Node{Type: "heading", Attrs: map[string]any{"level": float64(b.level)}}
+			nodes = append(nodes, s.textblock(heading, b.text)...)
+		case codeBlock:
+			code := &prosemirror.Node{Type: "codeBlock"}
+			if b.language != "" {
+				code.Attrs = map[string]any{"language": b.language}
+			}
+			if b.text != "" {
+				code.Content = []*prosemirror.Node{{Type: "text", Text: b.text}}
+			}
+			nodes = append(nodes, code)
+		case quoteBlock:
+			nodes = append(nodes, &prosemirror.Node{Type: "blockquote", Content: ensureParagraph(s.convert(b.children))})
+		case ruleBlock:
+			nodes = append(nodes, &prosemirror.Node{Type: "horizontalRule"})
+		case listBlock:
+			nodes = append(nodes, s.convertList(b))
+		case tableBlock:
+			nodes = append(nodes, s.convertTable(b))
+		case htmlBlock:
+			html := &prosemirror.HTMLParser{Schema: s.parser.schema}
+			nodes = append(nodes, html.ParseFragment(b.text)...)
+			s.warnings.Merge(html.Warnings)
+		}
+	}
+	return nodes
+}
+
+func (s *parseState) convertList(b *block) *prosemirror.Node {
+	list := &prosemirror.Node{Type: "bulletList"}
+	itemType := "listItem"
+	switch {
+	case b.task:
+		list.Type, itemType = "taskList", "taskItem"
+	case b.ordered:
+		list.Type = "orderedList"
+		if b.start != 1 {
+			list.Attrs = map[string]any{"start": float64(b.start)}
+		}
+	}
+	for _, item := range b.items {
+		content := s.convert(item.blocks)
+		if len(content) == 0 || content[0].Type != "paragraph" {
+			content = append([]*prosemirror.Node{{Type: "paragraph"}}, content...)
+		}
+		node := &prosemirror.Node{Type: itemType, Content: content}
+		if b.task {
+			node.Attrs = map[string]any{"checked": item.checked}
+		}
+		list.Content = append(list.Content, node)
+	}
+	return list
+}
+
+func (s *parseState) convertTable(b *block) *prosemirror.Node {
+	table := &prosemirror.Node{Type: "table"}
+	for r, cells := range b.rows {
+		row := &prosemirror.Node{Type: "tableRow"}
+		cellType := "tableCell"
+		if r == 0 {
+			cellType = "tableHeader"
+		}
+		for c, text := range cells {
+			paragraph := &prosemirror.Node{Type: "paragraph"}
+			if b.align[c] != "" {
+				paragraph.Attrs = map[string]any{"textAlign": b.align[c]}
+			}
+			row.Content = append(row.Content, &prosemirror.Node{
+				Type:    cellType,
+				Content: ensureParagraph(s.textblock(paragraph, text)),
+			})
+		}
+		table.Content = append(table.Content, row)
+	}
+	return table
+}
+
+// textblock parses inline content into a copy of node. Images are blocks in
+// the schema: paragraphs are split around them, other textblocks are
+// followed by them.
+func (s *parseState) textblock(node *prosemirror.Node, text string) []*prosemirror.Node {
+	var blocks, images []*prosemirror.Node
+	var content []*prosemirror.Node
+	flush := func(force bool) {
+		content = trimText(prosemirror.JoinText(content))
+		if len(content) > 0 || force {
+			blocks = append(blocks, &prosemirror.Node{Type: node.Type, Attrs: node.Attrs, Content: content})
+		}
+		content = nil
+	}
+	for _, inline := range s.parseInline(text) {
+		switch {
+		case inline.Type != "image":
+			content = append(content, inline)
+		case node.Type == "paragraph":
+			flush(false)
+			blocks = append(blocks, inline)
+		default:
+			images = append(images, inline)
+		}
+	}
+	flush(node.Type != "paragraph")
+	return append(blocks, images...)
+}
+
+// trimText removes the spaces left at both ends of a textblock where images
+// were split off. The nodes are fresh from the inline parser, so they are
+// changed in place.
+func trimText(content []*prosemirror.Node) []*prosemirror.Node {
+	if len(content) > 0 && content[0].IsText() {
+		content[0].Text = strings.TrimLeft(content[0].Text, " ")
+	}
+	if n := len(content); n > 0 && content[n-1].IsText() {
+		content[n-1].Text = strings.TrimRight(content[n-1].Text, " ")
+	}
+	return prosemirror.JoinText(content)
+}
+
+func ensureParagraph(content []*prosemirror.Node) []*prosemirror.Node {
+	if len(content) == 0 {
+		return []*prosemirror.Node{{Type: "paragraph"}}
+	}
+	return content
+}
+
+// normalizeLabel makes link labels match case insensitively and regardless
+// of whitespace
+func normalizeLabel(label string) string {
+	return strings.ToLower(strings.ToUpper(strings.Join(strings.Fields(label), " ")))
+}
@@ -0,0 +1,407 @@
+// Package markdown converts ProseMirror documents to and from CommonMark with
+// the GFM table, strikethrough and task list extensions. It follows the design
+// of prosemirror-markdown: every node and mark type has a renderer registered
+// on a Serializer, and a SerializerState tracks the block structure. Parser
+// reads Markdown back into documents.
+package markdown
+
+import (
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/emaforlin/ce-document-service/pkg/prosemirror"
+)
+
+// NodeRenderer writes a node. parent is nil for the top node, index is the
+// position of the node inside parent.
+type NodeRenderer func(state *SerializerState, node, parent *prosemirror.Node, index int)
+
+// MarkRenderer writes the delimiters around marked inline content. Open is
+// given the first node inside the mark and Close the last one.
+type MarkRenderer struct {
+	Open  func(state *SerializerState, mark *prosemirror.Mark, node *prosemirror.Node) string
+	Close func(state *SerializerState, mark *prosemirror.Mark, node *prosemirror.Node) string
+	// Raw marks (code) take their text verbatim, without escaping, and
+	// cannot be shared by adjacent nodes
+	Raw bool
+}
+
+// Serializer holds the renderers used to turn a document into Markdown
+type Serializer struct {
+	schema *prosemirror.Schema
+	nodes  map[string]NodeRenderer
+	marks  map[string]MarkRenderer
+	// Fallback renders nodes without a registered renderer, the default
+	// keeps their content and drops the markup
+	Fallback NodeRenderer
+}
+
+// NewSerializer creates a serializer with renderers for the node and mark
+// types of the default schema. schema tells inline from block nodes of
+// unknown types, the default schema is used when nil.
+func NewSerializer(schema *prosemirror.Schema) *Serializer {
+	if schema == nil {
+		schema = prosemirror.DefaultSchema()
+	}
+	s := &Serializer{
+		schema:   schema,
+		nodes:    make(map[string]NodeRenderer),
+		marks:    make(map[string]MarkRenderer),
+		Fallback: renderUnknown,
+	}
+	for name, renderer := range defaultNodes {
+		s.nodes[name] = renderer
+	}
+	for name, renderer := range defaultMarks {
+		s.marks[name] = renderer
+	}
+	return s
+}
+
+// RegisterNode sets the renderer of a node type, replacing any previous one
+func (s *Serializer) RegisterNode(nodeType string, renderer NodeRenderer) {
+	s.nodes[nodeType] = renderer
+}
+
+// RegisterMark sets the renderer of a mark type, replacing any previous one
+func (s *Serializer) RegisterMark(markType string, renderer MarkRenderer) {
+	s.marks[markType] = renderer
+}
+
+// Serialize renders a document as Markdown
+func (s *Serializer) Serialize(doc *prosemirror.Node) string {
+	state := &SerializerState{serializer: s}
+	state.RenderContent(doc)
+	return strings.TrimRight(state.out.String(), "\n") + "\n"
+}
+
+// SerializerState is the output being built, with the block context needed
+// to prefix lines and separate blocks
+type SerializerState struct {
+	serializer *Serializer
+	out        strings.Builder
+	// delim is written at the start of every line, like "> " in quotes
+	delim string
+	// closed is the last block that was closed, a blank line is written
+	// before the next one
+	closed      *prosemirror.Node
+	inTightList bool
+	// inTable is set while rendering table cells, where pipes must be escaped
+	inTable bool
+}
+
+// Write flushes pending block separators, adds the line prefix at the start
+// of a line and writes content verbatim
+func (s *SerializerState) Write(content string) {
+	s.flushClose(2)
+	if s.delim != "" && s.atBlank() {
+		s.out.WriteString(s.delim)
+	}
+	s.out.WriteString(content)
+}
+
+// Text writes text, escaped when escape is set. Lines after the first get the
+// line prefix.
+func (s *SerializerState) Text(text string, escape bool) {
+	lines := strings.Split(text, "\n")
+	for i, line := range lines {
+		s.Write("")
+		if escape {
+			line = s.Esc(line, s.atBlank())
+		}
+		s.out.WriteString(line)
+		if i != len(lines)-1 {
+			s.out.WriteString("\n")
+		}
+	}
+}
+
+// EnsureNewLine starts a new line unless already at the start of one
+func (s *SerializerState) EnsureNewLine() {
+	if !s.atBlank() {
+		s.out.WriteString("\n")
+	}
+}
+
+// CloseBlock marks the end of a block, the separator is written lazily so
+// that lists can keep their items together
+func (s *SerializerState) CloseBlock(node *prosemirror.Node) {
+	s.closed = node
+}
+
+// WrapBlock renders a block with delim prefixed to its lines, and firstDelim
+// to its first line when not empty
+func (s *SerializerState) WrapBlock(delim, firstDelim string, node *prosemirror.Node, render func()) {
+	old := s.delim
+	if firstDelim == "" {
+		firstDelim = delim
+	}
+	s.Write(firstDelim)
+	s.delim += delim
+	render()
+	s.delim = old
+	s.CloseBlock(node)
+}
+
+// Render writes a node with its registered renderer
+func (s *SerializerState) Render(node, parent *prosemirror.Node, index int) {
+	renderer, ok := s.serializer.nodes[node.Type]
+	if !ok {
+		renderer = s.serializer.Fallback
+	}
+	renderer(s, node, parent, index)
+}
+
+// RenderContent writes the children of a block node
+func (s *SerializerState) RenderContent(parent *prosemirror.Node) {
+	for i, child := range parent.Content {
+		s.Render(child, parent, i)
+	}
+}
+
+// RenderInline writes the inline content of a textblock, opening and closing
+// marks around runs of nodes. Whitespace is moved out of marks since CommonMark
+// does not recognize delimiters next to it.
+func (s *SerializerState) RenderInline(parent *prosemirror.Node) {
+	var active []*prosemirror.Mark
+	// pending is whitespace cut from the end of the previous node, written
+	// once it is known which marks close before it
+	pending := ""
+
+	for i, node := range parent.Content {
+		marks := s.orderMarks(node.Marks)
+		raw := len(marks) > 0 && s.serializer.marks[marks[len(marks)-1].Type].Raw
+		leading, body, trailing := "", node.Text, ""
+		if node.IsText() && !raw {
+			leading, body, trailing = splitSpace(node.Text)
+			if body == "" {
+				// Whitespace only nodes do not open marks of their own
+				leading, trailing = "", node.Text
+				marks = commonPrefix(active, marks)
+			}
+		}
+
+		keep := 0
+		for keep < len(active) && keep < len(marks) && prosemirror.MarkEqual(active[keep], marks[keep]) &&
+			!s.serializer.marks[marks[keep].Type].Raw {
+			keep++
+		}
+		for j := len(active) - 1; j >= keep; j-- {
+			s.closeMark(active[j], parent.Content[i-1])
+		}
+		active = active[:keep]
+		s.writeSpace(pending + leading)
+		for _, mark := range marks[keep:] {
+			s.openMark(mark, node)
+			active = append(active, mark)
+		}
+
+		switch {
+		case !node.IsText():
+			s.Render(node, parent, i)
+		case raw:
+			s.Write(body)
+		default:
+			s.Text(body, true)
+		}
+		pending = trailing
+	}
+	for j := len(active) - 1; j >= 0; j-- {
+		s.closeMark(active[j], parent.Content[len(parent.Content)-1])
+	}
+	s.writeSpace(pending)
+}
+
+// RenderList writes the items of a list, firstDelim returns the marker of
+// the item at index
+func (s *SerializerState) RenderList(node *prosemirror.Node, delim string, firstDelim func(index int) string) {
+	if s.closed != nil && s.closed.Type == node.Type {
+		// Two lists of the same kind in a row would merge into one
+		s.flushClose(3)
+	} else if s.inTightList {
+		s.flushClose(1)
+	}
+
+	prevTight := s.inTightList
+	s.inTightList = s.isTight(node)
+	for i, child := range node.Content {
+		if i > 0 && s.inTightList {
+			s.flushClose(1)
+		}
+		s.WrapBlock(delim, firstDelim(i), node, func() {
+			s.Render(child, node, i)
+		})
+	}
+	s.inTightList = prevTight
+}
+
+var (
+	escapeChars      = regexp.MustCompile("[`*\\\\~\\[\\]_<]")
+	escapeLineStart  = regexp.MustCompile(`^(\+[ ]|[\-*>=])`)
+	escapeHeading    = regexp.MustCompile(`^(\s*)(#{1,6})(\s|$)`)
+	escapeOrderedNum = regexp.MustCompile(`^(\s*\d+)([.)])(\s|$)`)
+)
+
+// Esc escapes Markdown syntax in text. startOfLine also escapes what would
+// start a block at the beginning of a line.
+func (s *SerializerState) Esc(text string, startOfLine bool) string {
+	text = escapeChars.ReplaceAllString(text, `\$0`)
+	if s.inTable {
+		text = strings.ReplaceAll(text, "|", `\|`)
+	}
+	if startOfLine {
+		text = escapeLineStart.ReplaceAllString(text, `\$0`)
+		text = escapeHeading.ReplaceAllString(text, `$1\$2$3`)
+		text = escapeOrderedNum.ReplaceAllString(text, `$1\$2$3`)
+	}
+	return text
+}
+
+// flushClose writes the separator owed to the last closed block: size-1
+// lines, the first of them ending the current line
+func (s *SerializerState) flushClose(size int) {
+	if s.closed == nil {
+		return
+	}
+	if !s.atBlank() {
+		s.out.WriteString("\n")
+	}
+	if size > 1 {
+		delimMin := strings.TrimRight(s.delim, " ")
+		for i := 1; i < size; i++ {
+			s.out.WriteString(delimMin + "\n")
+		}
+	}
+	s.closed = nil
+}
+
+func (s *SerializerState) writeSpace(space string) {
+	if space != "" {
+		s.Text(space, false)
+	}
+}
+
+// atBlank reports whether the output is at the start of a line
+func (s *SerializerState) atBlank() bool {
+	out := s.out.String()
+	return out == "" || strings.HasSuffix(out, "\n")
+}
+
+func (s *SerializerState) openMark(mark *prosemirror.Mark, node *prosemirror.Node) {
+	if renderer, ok := s.serializer.marks[mark.Type]; ok && renderer.Open != nil {
+		s.Write(renderer.Open(s, mark, node))
+	}
+}
+
+func (s *SerializerState) closeMark(mark *prosemirror.Mark, node *prosemirror.Node) {
+	if renderer, ok := s.serializer.marks[mark.Type]; ok && renderer.Close != nil {
+		s.Write(renderer.Close(s, mark, node))
+	}
+}
+
+// orderMarks drops marks without a renderer and moves raw marks last, so
+// that they are the innermost ones
+func (s *SerializerState) orderMarks(marks []*prosemirror.Mark) []*prosemirror.Mark {
+	var ordered, raw []*prosemirror.Mark
+	for _, mark := range marks {
+		renderer, ok := s.serializer.marks[mark.Type]
+		switch {
+		case !ok:
+		case renderer.Raw:
+			raw = append(raw, mark)
+		default:
+			ordered = append(ordered, mark)
+		}
+	}
+	return append(ordered, raw...)
+}
+
+// renderUnknown keeps the content of nodes without a renderer
+func renderUnknown(state *SerializerState, node, parent *prosemirror.Node, index int) {
+	switch {
+	case node.IsText():
+		state.Text(node.Text, true)
+	case len(node.Content) == 0:
+		if parent != nil && state.serializer.schema.IsInline(node) {
+			state.Text(node.TextContent(), true)
+		}
+	case state.isInline(node.Content[0]):
+		if state.isInline(node) {
+			state.RenderInline(node)
+			return
+		}
+		state.RenderInline(node)
+		state.CloseBlock(node)
+	default:
+		state.RenderContent(node)
+	}
+}
+
+func (s *SerializerState) isInline(node *prosemirror.Node) bool {
+	if node.IsText() {
+		return true
+	}
+	if _, ok := s.serializer.schema.Nodes[node.Type]; ok {
+		return s.serializer.schema.IsInline(node)
+	}
+	return len(node.Marks) > 0
+}
+
+// splitSpace splits the whitespace at both ends of text off its body
+func splitSpace(text string) (string, string, string) {
+	body := strings.TrimLeft(text, " \t")
+	leading := text[:len(text)-len(body)]
+	trimmed := strings.TrimRight(body, " \t")
+	return leading, trimmed, body[len(trimmed):]
+}
+
+func commonPrefix(a, b []*prosemirror.Mark) []*prosemirror.Mark {
+	n := 0
+	for n < len(a) && n < len(b) && prosemirror.MarkEqual(a[n], b[n]) {
+		n++
+	}
+	return b[:n]
+}
+
+func (s *SerializerState) isTight(node *prosemirror.Node) bool {
+	if tight, ok := node.Attr("tight").(bool); ok {
+		return tight
+	}
+	// Items with a single paragraph, possibly followed by nested lists, read
+	// best without blank lines between them
+	for _, item := range node.Content {
+		blocks := 0
+		for _, child := range item.Content {
+			if nodeType, ok := s.serializer.schema.Nodes[child.Type]; !ok || !slices.Contains(nodeType.Groups, "list") {
+				blocks++
+			}
+		}
+		if blocks > 1 {
+			return false
+		}
+	}
+	return true
+}
+
+func attrString(v any) string {
+	s, _ := v.(string)
+	return s
+}
+
+func attrInt(v any, def int) int {
+	switch n := v.(type) {
+	case float64:
+		return int(n)
+	case int:
+		return n
+	case int64:
+		return int(n)
+	}
+	return def
+}
+
+func itoa(n int) string {
+	return strconv.Itoa(n)
+}
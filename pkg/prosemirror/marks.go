@@ -0,0 +1,63 @@
+package prosemirror
+
+import "sort"
+
+// AddMarkToSet returns a mark set with mark added, replacing any mark of the
+// same type. Marks are kept ordered by type name so that sets built on the
+// server are stable.
+func AddMarkToSet(set []*Mark, mark *Mark) []*Mark {
+	if markInSet(mark, set) {
+		return set
+	}
+	result := make([]*Mark, 0, len(set)+1)
+	for _, other := range set {
+		if other.Type != mark.Type {
+			result = append(result, other)
+		}
+	}
+	result = append(result, mark)
+	sort.SliceStable(result, func(i, j int) bool {
+		return result[i].Type < result[j].Type
+	})
+	return result
+}
+
+// RemoveMarkFromSet returns a mark set without mark
+func RemoveMarkFromSet(set []*Mark, mark *Mark) []*Mark {
+	if !markInSet(mark, set) {
+		return set
+	}
+	result := make([]*Mark, 0, len(set))
+	for _, other := range set {
+		if !MarkEqual(other, mark) {
+			result = append(result, other)
+		}
+	}
+	return result
+}
+
+// WithMarks returns a shallow copy of the node carrying a different mark set
+func WithMarks(n *Node, marks []*Mark) *Node {
+	return withMarks(n, marks)
+}
+
+// WithAttrs returns a shallow copy of the node with different attributes
+func WithAttrs(n *Node, attrs map[string]any) *Node {
+	return &Node{Type: n.Type, Attrs: attrs, Marks: n.Marks, Content: n.Content, Text: n.Text}
+}
+
+// MapInline returns a copy of content with every inline node below it passed
+// through fn together with its parent, like mapFragment in prosemirror-transform
+func (s *Schema) MapInline(content []*Node, parent *Node, fn func(node, parent *Node) *Node) []*Node {
+	mapped := make([]*Node, 0, len(content))
+	for _, child := range content {
+		if len(child.Content) > 0 {
+			child = withContent(child, s.MapInline(child.Content, child, fn))
+		}
+		if s.IsInline(child) {
+			child = fn(child, parent)
+		}
+		mapped = append(mapped, child)
+	}
+	return normalizeFragment(mapped)
+}
@@ -0,0 +1,203 @@
+// Package prosemirror implements the ProseMirror/TipTap document model used
+// to store and validate document content.
+package prosemirror
+
+import (
+	"reflect"
+	"unicode/utf16"
+)
+
+// Node is a ProseMirror node as found in the JSON representation of a document
+type Node struct {
+	Type    string         `json:"type"`
+	Attrs   map[string]any `json:"attrs,omitempty"`
+	Content []*Node        `json:"content,omitempty"`
+	Marks   []*Mark        `json:"marks,omitempty"`
+	Text    string         `json:"text,omitempty"`
+}
+
+// Mark is an inline mark (bold, link, ...) applied to a node
+type Mark struct {
+	Type  string         `json:"type"`
+	Attrs map[string]any `json:"attrs,omitempty"`
+}
+
+// IsText reports whether the node is a text node
+func (n *Node) IsText() bool {
+	return n.Type == "text"
+}
+
+// TextLength returns the length of the node text in UTF-16 code units, which
+// is the unit ProseMirror positions are expressed in.
+func (n *Node) TextLength() int {
+	return utf16Len(n.Text)
+}
+
+// TextContent concatenates the text of all descendant text nodes
+func (n *Node) TextContent() string {
+	if n.IsText() {
+		return n.Text
+	}
+	text := ""
+	for _, child := range n.Content {
+		text += child.TextContent()
+	}
+	return text
+}
+
+// Attr returns the attribute value for the given key or nil if not set
+func (n *Node) Attr(key string) any {
+	if n.Attrs == nil {
+		return nil
+	}
+	return n.Attrs[key]
+}
+
+// Attr returns the attribute value for the given key or nil if not set
+func (m *Mark) Attr(key string) any {
+	if m.Attrs == nil {
+		return nil
+	}
+	return m.Attrs[key]
+}
+
+// HasMark reports whether the node carries a mark of the given type
+func (n *Node) HasMark(markType string) bool {
+	for _, mark := range n.Marks {
+		if mark.Type == markType {
+			return true
+		}
+	}
+	return false
+}
+
+// Equal reports whether two nodes have the same type, attributes, marks and
+// content
+func (n *Node) Equal(other *Node) bool {
+	if n == nil || other == nil {
+		return n == other
+	}
+	if n.Type != other.Type || n.Text != other.Text || !AttrsEqual(n.Attrs, other.Attrs) {
+		return false
+	}
+	if !MarksEqual(n.Marks, other.Marks) || len(n.Content) != len(other.Content) {
+		return false
+	}
+	for i := range n.Content {
+		if !n.Content[i].Equal(other.Content[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// MarkEqual reports whether two marks have the same type and attributes
+func MarkEqual(a, b *Mark) bool {
+	return a.Type == b.Type && AttrsEqual(a.Attrs, b.Attrs)
+}
+
+// MarksEqual reports whether two mark sets hold the same marks. The order of
+// the marks is not significant since editors may serialize them differently.
+func MarksEqual(a, b []*Mark) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for _, mark := range a {
+		if !markInSet(mark, b) {
+			return false
+		}
+	}
+	return true
+}
+
+func markInSet(mark *Mark, set []*Mark) bool {
+	for _, other := range set {
+		if MarkEqual(mark, other) {
+			return true
+		}
+	}
+	return false
+}
+
+// AttrsEqual compares attribute maps, treating nil and empty maps as equal
+func AttrsEqual(a, b map[string]any) bool {
+	if len(a) == 0 && len(b) == 0 {
+		return true
+	}
+	return reflect.DeepEqual(a, b)
+}
+
+// Copy returns a deep copy of the node
+func (n *Node) Copy() *Node {
+	if n == nil {
+		return nil
+	}
+	cp := &Node{
+		Type:  n.Type,
+		Text:  n.Text,
+		Attrs: copyAttrs(n.Attrs),
+	}
+	if n.Content != nil {
+		cp.Content = make([]*Node, len(n.Content))
+		for i, child := range n.Content {
+			cp.Content[i] = child.Copy()
+		}
+	}
+	if n.Marks != nil {
+		cp.Marks = make([]*Mark, len(n.Marks))
+		for i, mark := range n.Marks {
+			cp.Marks[i] = mark.Copy()
+		}
+	}
+	return cp
+}
+
+// Copy returns a deep copy of the mark
+func (m *Mark) Copy() *Mark {
+	return &Mark{Type: m.Type, Attrs: copyAttrs(m.Attrs)}
+}
+
+// Walk calls fn for the node and each of its descendants in document order.
+// Returning false from fn skips the children of that node.
+func (n *Node) Walk(fn func(node *Node) bool) {
+	if !fn(n) {
+		return
+	}
+	for _, child := range n.Content {
+		child.Walk(fn)
+	}
+}
+
+func copyAttrs(attrs map[string]any) map[string]any {
+	if attrs == nil {
+		return nil
+	}
+	cp := make(map[string]any, len(attrs))
+	for k, v := range attrs {
+		cp[k] = copyValue(v)
+	}
+	return cp
+}
+
+func copyValue(v any) any {
+	switch val := v.(type) {
+	case map[string]any:
+		return copyAttrs(val)
+	case []any:
+		cp := make([]any, len(val))
+		for i, item := range val {
+			cp[i] = copyValue(item)
+		}
+		return cp
+	default:
+		return v
+	}
+}
+
+func utf16Len(s string) int {
+	n := 0
+	for _, r := range s {
+		n += utf16.RuneLen(r)
+	}
+	return n
+}
@@ -0,0 +1,400 @@
+package prosemirror
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+)
+
+// Slice is a piece of a document, possibly open at its start and end, as used
+// by replace steps
+type Slice struct {
+	Content   []*Node `json:"content,omitempty"`
+	OpenStart int     `json:"openStart,omitempty"`
+	OpenEnd   int     `json:"openEnd,omitempty"`
+}
+
+// SliceSize is the number of positions the slice adds when inserted
+func (s *Schema) SliceSize(slice Slice) int {
+	return s.ContentSize(slice.Content) - slice.OpenStart - slice.OpenEnd
+}
+
+// ReplaceError is returned when a replace would produce an invalid document
+type ReplaceError struct {
+	Message string
+}
+
+func (e *ReplaceError) Error() string {
+	return e.Message
+}
+
+func replaceError(format string, args ...any) *ReplaceError {
+	return &ReplaceError{Message: fmt.Sprintf(format, args...)}
+}
+
+// SliceFromJSON parses a slice in its ProseMirror JSON form. The content of
+// open nodes is not checked since it may be incomplete.
+func (s *Schema) SliceFromJSON(data []byte) (Slice, error) {
+	raw, err := decodeJSON(data)
+	if err != nil {
+		return Slice{}, err
+	}
+	if raw == nil {
+		return Slice{}, nil
+	}
+	obj, ok := raw.(map[string]any)
+	if !ok {
+		return Slice{}, invalid("$", "expected a slice object, got %s", jsonTypeOf(raw))
+	}
+
+	var slice Slice
+	for key, value := range obj {
+		switch key {
+		case "content":
+			items, ok := value.([]any)
+			if !ok {
+				return Slice{}, invalid("$.content", "expected an array, got %s", jsonTypeOf(value))
+			}
+			for i, item := range items {
+				node, err := s.parseNode(item, fmt.Sprintf("$.content[%d]", i))
+				if err != nil {
+					return Slice{}, err
+				}
+				slice.Content = append(slice.Content, node)
+			}
+		case "openStart", "openEnd":
+			n, ok := normalizeNumbers(value).(float64)
+			if !ok || n < 0 || n != float64(int(n)) {
+				return Slice{}, invalid("$."+key, "expected a non-negative integer")
+			}
+			if key == "openStart" {
+				slice.OpenStart = int(n)
+			} else {
+				slice.OpenEnd = int(n)
+			}
+		default:
+			return Slice{}, invalid("$."+key, "unexpected slice property")
+		}
+	}
+	return slice, nil
+}
+
+// MarkFromJSON parses a single mark in its ProseMirror JSON form
+func (s *Schema) MarkFromJSON(data []byte) (*Mark, error) {
+	raw, err := decodeJSON(data)
+	if err != nil {
+		return nil, err
+	}
+	return s.parseMark(raw, "$")
+}
+
+// Slice cuts the content between from and to out of doc
+func (s *Schema) Slice(doc *Node, from, to int) (Slice, error) {
+	if from == to {
+		return Slice{}, nil
+	}
+	start, err := s.Resolve(doc, from)
+	if err != nil {
+		return Slice{}, err
+	}
+	end, err := s.Resolve(doc, to)
+	if err != nil {
+		return Slice{}, err
+	}
+	depth := start.SharedDepth(to)
+	offset := start.Start(depth)
+	content := s.cutFragment(start.Node(depth).Content, start.Pos-offset, end.Pos-offset)
+	return Slice{Content: content, OpenStart: start.Depth - depth, OpenEnd: end.Depth - depth}, nil
+}
+
+// Replace replaces the range between from and to in doc with the slice. It
+// returns a *ReplaceError when the slice does not fit.
+func (s *Schema) Replace(doc *Node, from, to int, slice Slice) (*Node, error) {
+	if from > to {
+		return nil, replaceError("invalid range %d-%d", from, to)
+	}
+	start, err := s.Resolve(doc, from)
+	if err != nil {
+		return nil, replaceError("%v", err)
+	}
+	end, err := s.Resolve(doc, to)
+	if err != nil {
+		return nil, replaceError("%v", err)
+	}
+	if slice.OpenStart > start.Depth {
+		return nil, replaceError("inserted content deeper than insertion position")
+	}
+	if start.Depth-slice.OpenStart != end.Depth-slice.OpenEnd {
+		return nil, replaceError("inconsistent open depths")
+	}
+	r := replacer{schema: s}
+	return r.replaceOuter(start, end, slice, 0)
+}
+
+// replacer ports the replace algorithm of prosemirror-model
+type replacer struct {
+	schema *Schema
+}
+
+func (r replacer) replaceOuter(from, to *ResolvedPos, slice Slice, depth int) (*Node, error) {
+	index, node := from.Index(depth), from.Node(depth)
+	if index == to.Index(depth) && depth < from.Depth-slice.OpenStart {
+		inner, err := r.replaceOuter(from, to, slice, depth+1)
+		if err != nil {
+			return nil, err
+		}
+		return withContent(node, replaceChild(node.Content, index, inner)), nil
+	}
+	if len(slice.Content) == 0 {
+		content, err := r.replaceTwoWay(from, to, depth)
+		if err != nil {
+			return nil, err
+		}
+		return r.close(node, content)
+	}
+	if slice.OpenStart == 0 && slice.OpenEnd == 0 && from.Depth == depth && to.Depth == depth {
+		parent := from.Parent()
+		size := r.schema.ContentSize(parent.Content)
+		content := appendFragment(
+			appendFragment(r.schema.cutFragment(parent.Content, 0, from.ParentOffset), slice.Content),
+			r.schema.cutFragment(parent.Content, to.ParentOffset, size))
+		return r.close(parent, content)
+	}
+	start, end, err := r.prepareSliceForReplace(slice, from)
+	if err != nil {
+		return nil, err
+	}
+	content, err := r.replaceThreeWay(from, start, end, to, depth)
+	if err != nil {
+		return nil, err
+	}
+	return r.close(node, content)
+}
+
+func (r replacer) checkJoin(main, sub *Node) error {
+	mainType, subType := r.schema.Nodes[main.Type], r.schema.Nodes[sub.Type]
+	if mainType == nil || subType == nil || !compatibleContent(mainType, subType) {
+		return replaceError("cannot join %s onto %s", sub.Type, main.Type)
+	}
+	return nil
+}
+
+func (r replacer) joinable(before, after *ResolvedPos, depth int) (*Node, error) {
+	node := before.Node(depth)
+	if err := r.checkJoin(node, after.Node(depth)); err != nil {
+		return nil, err
+	}
+	return node, nil
+}
+
+func (r replacer) addRange(start, end *ResolvedPos, depth int, target []*Node) []*Node {
+	var node *Node
+	if end != nil {
+		node = end.Node(depth)
+	} else {
+		node = start.Node(depth)
+	}
+	startIndex, endIndex := 0, len(node.Content)
+	if end != nil {
+		endIndex = end.Index(depth)
+	}
+	if start != nil {
+		startIndex = start.Index(depth)
+		if start.Depth > depth {
+			startIndex++
+		} else if start.TextOffset() > 0 {
+			target = appendNode(target, start.NodeAfter())
+			startIndex++
+		}
+	}
+	for i := startIndex; i < endIndex; i++ {
+		target = appendNode(target, node.Content[i])
+	}
+	if end != nil && end.Depth == depth && end.TextOffset() > 0 {
+		target = appendNode(target, end.NodeBefore())
+	}
+	return target
+}
+
+func (r replacer) close(node *Node, content []*Node) (*Node, error) {
+	nodeType, ok := r.schema.Nodes[node.Type]
+	if !ok {
+		return nil, replaceError("unknown node type %q", node.Type)
+	}
+	if !r.schema.validContent(node, content) {
+		return nil, replaceError("invalid content for node %s", nodeType.Name)
+	}
+	return withContent(node, content), nil
+}
+
+func (r replacer) replaceThreeWay(from, start, end, to *ResolvedPos, depth int) ([]*Node, error) {
+	var openStart, openEnd *Node
+	var err error
+	if from.Depth > depth {
+		if openStart, err = r.joinable(from, start, depth+1); err != nil {
+			return nil, err
+		}
+	}
+	if to.Depth > depth {
+		if openEnd, err = r.joinable(end, to, depth+1); err != nil {
+			return nil, err
+		}
+	}
+
+	content := r.addRange(nil, from, depth, nil)
+	if openStart != nil && openEnd != nil && start.Index(depth) == end.Index(depth) {
+		if err := r.checkJoin(openStart, openEnd); err != nil {
+			return nil, err
+		}
+		inner, err := r.replaceThreeWay(from, start, end, to, depth+1)
+		if err != nil {
+			return nil, err
+		}
+		closed, err := r.close(openStart, inner)
+		if err != nil {
+			return nil, err
+		}
+		content = appendNode(content, closed)
+	} else {
+		if openStart != nil {
+			inner, err := r.replaceTwoWay(from, start, depth+1)
+			if err != nil {
+				return nil, err
+			}
+			closed, err := r.close(openStart, inner)
+			if err != nil {
+				return nil, err
+			}
+			content = appendNode(content, closed)
+		}
+		content = r.addRange(start, end, depth, content)
+		if openEnd != nil {
+			inner, err := r.replaceTwoWay(end, to, depth+1)
+			if err != nil {
+				return nil, err
+			}
+			closed, err := r.close(openEnd, inner)
+			if err != nil {
+				return nil, err
+			}
+			content = appendNode(content, closed)
+		}
+	}
+	return r.addRange(to, nil, depth, content), nil
+}
+
+func (r replacer) replaceTwoWay(from, to *ResolvedPos, depth int) ([]*Node, error) {
+	content := r.addRange(nil, from, depth, nil)
+	if from.Depth > depth {
+		node, err := r.joinable(from, to, depth+1)
+		if err != nil {
+			return nil, err
+		}
+		inner, err := r.replaceTwoWay(from, to, depth+1)
+		if err != nil {
+			return nil, err
+		}
+		closed, err := r.close(node, inner)
+		if err != nil {
+			return nil, err
+		}
+		content = appendNode(content, closed)
+	}
+	return r.addRange(to, nil, depth, content), nil
+}
+
+// prepareSliceForReplace wraps the slice in the ancestors of along so that
+// its open sides can be resolved like positions in a document
+func (r replacer) prepareSliceForReplace(slice Slice, along *ResolvedPos) (*ResolvedPos, *ResolvedPos, error) {
+	extra := along.Depth - slice.OpenStart
+	node := withContent(along.Node(extra), slice.Content)
+	for i := extra - 1; i >= 0; i-- {
+		node = withContent(along.Node(i), []*Node{node})
+	}
+	start, err := r.schema.Resolve(node, slice.OpenStart+extra)
+	if err != nil {
+		return nil, nil, replaceError("%v", err)
+	}
+	end, err := r.schema.Resolve(node, r.schema.ContentSize(node.Content)-slice.OpenEnd-extra)
+	if err != nil {
+		return nil, nil, replaceError("%v", err)
+	}
+	return start, end, nil
+}
+
+// InsertIntoSlice inserts content at pos, relative to the start of the slice
+// content, as Slice.insertAt does in prosemirror-model
+func (s *Schema) InsertIntoSlice(slice Slice, pos int, content []*Node) (Slice, bool) {
+	inserted, ok := s.insertInto(slice.Content, pos+slice.OpenStart, content, nil)
+	if !ok {
+		return Slice{}, false
+	}
+	return Slice{Content: inserted, OpenStart: slice.OpenStart, OpenEnd: slice.OpenEnd}, true
+}
+
+func (s *Schema) insertInto(content []*Node, dist int, insert []*Node, parent *Node) ([]*Node, bool) {
+	index, offset := s.findIndex(content, dist, -1)
+	if index < 0 {
+		return nil, false
+	}
+	if offset == dist || index < len(content) && content[index].IsText() {
+		size := s.ContentSize(content)
+		result := appendFragment(appendFragment(s.cutFragment(content, 0, dist), insert), s.cutFragment(content, dist, size))
+		if parent != nil && !s.validContent(parent, result) {
+			return nil, false
+		}
+		return result, true
+	}
+	child := content[index]
+	inner, ok := s.insertInto(child.Content, dist-offset-1, insert, child)
+	if !ok {
+		return nil, false
+	}
+	return replaceChild(content, index, withContent(child, inner)), true
+}
+
+// compatibleContent reports whether nodes of the two types can be joined,
+// which is the case when their content can start with a common node type
+func compatibleContent(a, b *NodeType) bool {
+	if a == b {
+		return true
+	}
+	first := map[string]bool{}
+	for _, name := range a.content.expected(nil) {
+		first[name] = true
+	}
+	for _, name := range b.content.expected(nil) {
+		if first[name] {
+			return true
+		}
+	}
+	return false
+}
+
+// validContent reports whether content matches the content expression of node
+func (s *Schema) validContent(node *Node, content []*Node) bool {
+	nodeType, ok := s.Nodes[node.Type]
+	if !ok {
+		return false
+	}
+	types := make([]string, len(content))
+	for i, child := range content {
+		types[i] = child.Type
+	}
+	return nodeType.content.match(types) == -1
+}
+
+// decodeJSON decodes a single JSON value keeping numbers as json.Number
+func decodeJSON(data []byte) (any, error) {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+
+	var raw any
+	if err := decoder.Decode(&raw); err != nil {
+		return nil, invalid("$", "malformed JSON: %v", err)
+	}
+	if decoder.More() {
+		return nil, invalid("$", "malformed JSON: unexpected data after the value")
+	}
+	return raw, nil
+}
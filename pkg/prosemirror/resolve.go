@@ -0,0 +1,172 @@
+package prosemirror
+
+import "fmt"
+
+// ResolvedPos is a position in a document together with the path of ancestor
+// nodes leading to it, like ResolvedPos in prosemirror-model
+type ResolvedPos struct {
+	Pos int
+	// Depth is the number of ancestors of the position, excluding the top node
+	Depth int
+	// ParentOffset is the offset of the position inside its parent's content
+	ParentOffset int
+
+	schema *Schema
+	path   []resolvedLevel
+}
+
+type resolvedLevel struct {
+	node  *Node
+	index int
+	// offset is the absolute position at which the child at index starts
+	offset int
+}
+
+// Resolve resolves a position inside doc
+func (s *Schema) Resolve(doc *Node, pos int) (*ResolvedPos, error) {
+	if pos < 0 || pos > s.ContentSize(doc.Content) {
+		return nil, fmt.Errorf("position %d out of range", pos)
+	}
+	var path []resolvedLevel
+	start, parentOffset := 0, pos
+	for node := doc; ; {
+		index, offset := s.findIndex(node.Content, parentOffset, -1)
+		rem := parentOffset - offset
+		path = append(path, resolvedLevel{node: node, index: index, offset: start + offset})
+		if rem == 0 {
+			break
+		}
+		node = node.Content[index]
+		if node.IsText() {
+			break
+		}
+		parentOffset = rem - 1
+		start += offset + 1
+	}
+	return &ResolvedPos{
+		Pos:          pos,
+		Depth:        len(path) - 1,
+		ParentOffset: parentOffset,
+		schema:       s,
+		path:         path,
+	}, nil
+}
+
+// Node returns the ancestor node at the given depth
+func (p *ResolvedPos) Node(depth int) *Node {
+	return p.path[depth].node
+}
+
+// Parent is the node directly containing the position
+func (p *ResolvedPos) Parent() *Node {
+	return p.Node(p.Depth)
+}
+
+// Doc is the top node the position was resolved in
+func (p *ResolvedPos) Doc() *Node {
+	return p.Node(0)
+}
+
+// Index returns the index into the ancestor at depth
+func (p *ResolvedPos) Index(depth int) int {
+	return p.path[depth].index
+}
+
+// IndexAfter returns the index pointing after the position in the ancestor
+// at depth
+func (p *ResolvedPos) IndexAfter(depth int) int {
+	if depth == p.Depth && p.TextOffset() == 0 {
+		return p.Index(depth)
+	}
+	return p.Index(depth) + 1
+}
+
+// Start is the absolute position at which the content of the ancestor at
+// depth starts
+func (p *ResolvedPos) Start(depth int) int {
+	if depth == 0 {
+		return 0
+	}
+	return p.path[depth-1].offset + 1
+}
+
+// End is the absolute position at which the content of the ancestor at depth
+// ends
+func (p *ResolvedPos) End(depth int) int {
+	return p.Start(depth) + p.schema.ContentSize(p.Node(depth).Content)
+}
+
+// Before is the absolute position directly before the ancestor at depth
+func (p *ResolvedPos) Before(depth int) int {
+	if depth == p.Depth+1 {
+		return p.Pos
+	}
+	return p.path[depth-1].offset
+}
+
+// After is the absolute position directly after the ancestor at depth
+func (p *ResolvedPos) After(depth int) int {
+	if depth == p.Depth+1 {
+		return p.Pos
+	}
+	return p.path[depth-1].offset + p.schema.NodeSize(p.path[depth].node)
+}
+
+// TextOffset is the offset of the position into a text node, 0 when the
+// position points between nodes
+func (p *ResolvedPos) TextOffset() int {
+	return p.Pos - p.path[len(p.path)-1].offset
+}
+
+// NodeAfter returns the node directly after the position, cut when the
+// position is inside a text node
+func (p *ResolvedPos) NodeAfter() *Node {
+	parent, index := p.Parent(), p.Index(p.Depth)
+	if index == len(parent.Content) {
+		return nil
+	}
+	child := parent.Content[index]
+	if offset := p.TextOffset(); offset > 0 {
+		return p.schema.cutNode(child, offset, child.TextLength())
+	}
+	return child
+}
+
+// NodeBefore returns the node directly before the position, cut when the
+// position is inside a text node
+func (p *ResolvedPos) NodeBefore() *Node {
+	parent, index := p.Parent(), p.Index(p.Depth)
+	if offset := p.TextOffset(); offset > 0 {
+		return p.schema.cutNode(parent.Content[index], 0, offset)
+	}
+	if index == 0 {
+		return nil
+	}
+	return parent.Content[index-1]
+}
+
+// SharedDepth is the depth of the deepest ancestor that also contains pos
+func (p *ResolvedPos) SharedDepth(pos int) int {
+	for depth := p.Depth; depth > 0; depth-- {
+		if p.Start(depth) <= pos && p.End(depth) >= pos {
+			return depth
+		}
+	}
+	return 0
+}
+
+// NodeAt returns the node directly after pos, or nil if there is none
+func (s *Schema) NodeAt(doc *Node, pos int) *Node {
+	for node := doc; ; {
+		index, offset := s.findIndex(node.Content, pos, -1)
+		if index < 0 || index >= len(node.Content) {
+			return nil
+		}
+		child := node.Content[index]
+		if offset == pos || child.IsText() {
+			return child
+		}
+		pos -= offset + 1
+		node = child
+	}
+}
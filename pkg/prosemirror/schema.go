@@ -0,0 +1,253 @@
+package prosemirror
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+)
+
+// SchemaSpec is the declarative (JSON) description of a schema. It follows the
+// shape of the ProseMirror schema spec: nodes and marks keyed by name.
+type SchemaSpec struct {
+	TopNode string              `json:"topNode"`
+	Nodes   map[string]NodeSpec `json:"nodes"`
+	Marks   map[string]MarkSpec `json:"marks"`
+}
+
+// NodeSpec describes a node type
+type NodeSpec struct {
+	// Content is the content expression, empty for leaf nodes
+	Content string `json:"content"`
+	// Marks lists the mark names or groups allowed inside this node. "_"
+	// allows all marks, "" none. When nil, textblocks allow every mark.
+	Marks  *string             `json:"marks"`
+	Group  string              `json:"group"`
+	Inline bool                `json:"inline"`
+	Attrs  map[string]AttrSpec `json:"attrs"`
+}
+
+// MarkSpec describes a mark type
+type MarkSpec struct {
+	Group string              `json:"group"`
+	Attrs map[string]AttrSpec `json:"attrs"`
+}
+
+// AttrSpec describes an attribute. Attributes without a default are required.
+type AttrSpec struct {
+	Default    any
+	HasDefault bool
+	// Validate is a "|" separated list of JSON types the value may have
+	// (string, number, boolean, null, array, object). Empty allows anything.
+	Validate string
+}
+
+func (a *AttrSpec) UnmarshalJSON(data []byte) error {
+	var raw map[string]json.RawMessage
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+	if def, ok := raw["default"]; ok {
+		a.HasDefault = true
+		if err := json.Unmarshal(def, &a.Default); err != nil {
+			return err
+		}
+	}
+	if validate, ok := raw["validate"]; ok {
+		if err := json.Unmarshal(validate, &a.Validate); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (a AttrSpec) MarshalJSON() ([]byte, error) {
+	out := map[string]any{}
+	if a.HasDefault {
+		out["default"] = a.Default
+	}
+	if a.Validate != "" {
+		out["validate"] = a.Validate
+	}
+	return json.Marshal(out)
+}
+
+// NodeType is a compiled node type of a Schema
+type NodeType struct {
+	Name   string
+	Spec   NodeSpec
+	Groups []string
+
+	content       *contentExpr
+	inlineContent bool
+	// allowedMarks is nil when every mark is allowed
+	allowedMarks map[string]bool
+}
+
+// MarkType is a compiled mark type of a Schema
+type MarkType struct {
+	Name   string
+	Spec   MarkSpec
+	Groups []string
+}
+
+// Schema holds the compiled node and mark types content is validated against
+type Schema struct {
+	Spec    SchemaSpec
+	TopNode string
+	Nodes   map[string]*NodeType
+	Marks   map[string]*MarkType
+}
+
+// IsInline reports whether the node type is inline content
+func (t *NodeType) IsInline() bool {
+	return t.Name == "text" || t.Spec.Inline
+}
+
+// IsLeaf reports whether the node type cannot have content
+func (t *NodeType) IsLeaf() bool {
+	return !t.content.allowsContent()
+}
+
+// IsTextblock reports whether the node type holds inline content
+func (t *NodeType) IsTextblock() bool {
+	return !t.IsInline() && t.inlineContent
+}
+
+// AllowsMark reports whether marks of the given type may appear in this node
+func (t *NodeType) AllowsMark(markType *MarkType) bool {
+	return t.allowedMarks == nil || t.allowedMarks[markType.Name]
+}
+
+// NewSchema compiles a schema spec
+func NewSchema(spec SchemaSpec) (*Schema, error) {
+	if spec.TopNode == "" {
+		spec.TopNode = "doc"
+	}
+	if _, ok := spec.Nodes[spec.TopNode]; !ok {
+		return nil, fmt.Errorf("schema is missing the top node type %q", spec.TopNode)
+	}
+	if _, ok := spec.Nodes["text"]; !ok {
+		return nil, fmt.Errorf("schema is missing the text node type")
+	}
+
+	schema := &Schema{
+		Spec:    spec,
+		TopNode: spec.TopNode,
+		Nodes:   make(map[string]*NodeType, len(spec.Nodes)),
+		Marks:   make(map[string]*MarkType, len(spec.Marks)),
+	}
+
+	groups := map[string][]string{}
+	for _, name := range sortedKeys(spec.Nodes) {
+		nodeSpec := spec.Nodes[name]
+		nodeType := &NodeType{Name: name, Spec: nodeSpec, Groups: strings.Fields(nodeSpec.Group)}
+		for _, group := range nodeType.Groups {
+			groups[group] = append(groups[group], name)
+		}
+		schema.Nodes[name] = nodeType
+	}
+
+	markGroups := map[string][]string{}
+	for _, name := range sortedKeys(spec.Marks) {
+		markSpec := spec.Marks[name]
+		markType := &MarkType{Name: name, Spec: markSpec, Groups: strings.Fields(markSpec.Group)}
+		for _, group := range markType.Groups {
+			markGroups[group] = append(markGroups[group], name)
+		}
+		schema.Marks[name] = markType
+	}
+
+	resolve := func(name string) ([]string, error) {
+		if _, ok := schema.Nodes[name]; ok {
+			return []string{name}, nil
+		}
+		if members, ok := groups[name]; ok {
+			return members, nil
+		}
+		return nil, fmt.Errorf("no node type or group %q found", name)
+	}
+
+	for name, nodeType := range schema.Nodes {
+		content, err := compileContentExpr(nodeType.Spec.Content, resolve)
+		if err != nil {
+			return nil, fmt.Errorf("node type %q: %w", name, err)
+		}
+		nodeType.content = content
+	}
+
+	for _, nodeType := range schema.Nodes {
+		nodeType.inlineContent = schema.hasInlineContent(nodeType)
+	}
+
+	for name, nodeType := range schema.Nodes {
+		allowed, err := schema.compileAllowedMarks(nodeType, markGroups)
+		if err != nil {
+			return nil, fmt.Errorf("node type %q: %w", name, err)
+		}
+		nodeType.allowedMarks = allowed
+	}
+
+	return schema, nil
+}
+
+func (s *Schema) compileAllowedMarks(nodeType *NodeType, markGroups map[string][]string) (map[string]bool, error) {
+	if nodeType.Spec.Marks == nil {
+		if nodeType.IsTextblock() {
+			return nil, nil
+		}
+		return map[string]bool{}, nil
+	}
+	if *nodeType.Spec.Marks == "_" {
+		return nil, nil
+	}
+	allowed := map[string]bool{}
+	for _, name := range strings.Fields(*nodeType.Spec.Marks) {
+		if _, ok := s.Marks[name]; ok {
+			allowed[name] = true
+			continue
+		}
+		members, ok := markGroups[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown mark type or group %q", name)
+		}
+		for _, member := range members {
+			allowed[member] = true
+		}
+	}
+	return allowed, nil
+}
+
+func (s *Schema) hasInlineContent(nodeType *NodeType) bool {
+	for _, edges := range nodeType.content.edges {
+		for _, edge := range edges {
+			for name := range edge.types {
+				return s.Nodes[name].IsInline()
+			}
+		}
+	}
+	return false
+}
+
+// LoadSchema reads and compiles a JSON schema spec from disk
+func LoadSchema(path string) (*Schema, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read schema: %w", err)
+	}
+	var spec SchemaSpec
+	if err := json.Unmarshal(data, &spec); err != nil {
+		return nil, fmt.Errorf("failed to parse schema: %w", err)
+	}
+	return NewSchema(spec)
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
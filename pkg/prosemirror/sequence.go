@@ -0,0 +1,108 @@
+package prosemirror
+
+type editKind int
+
+const (
+	editEqual editKind = iota
+	editDelete
+	editInsert
+)
+
+type editOp struct {
+	kind editKind
+	old  int
+	new  int
+}
+
+// maxEditDistance bounds the work done by diffSequences; inputs that differ
+// more than this are reported as a full replacement
+const maxEditDistance = 4000
+
+// diffSequences computes a shortest edit script between two sequences of
+// length n and m using Myers' algorithm. eq compares old[i] with new[j].
+func diffSequences(n, m int, eq func(i, j int) bool) []editOp {
+	max := n + m
+	if max == 0 {
+		return nil
+	}
+	limit := max
+	if limit > maxEditDistance {
+		limit = maxEditDistance
+	}
+
+	// v[k+offset] holds the furthest x reached on diagonal k
+	offset := max + 1
+	v := make([]int, 2*max+3)
+	var trace [][]int
+	for d := 0; d <= limit; d++ {
+		// Only diagonals -d-1..d+1 can be read by this round's backtrack step
+		snapshot := make([]int, 2*d+3)
+		copy(snapshot, v[offset-d-1:offset+d+2])
+		trace = append(trace, snapshot)
+
+		for k := -d; k <= d; k += 2 {
+			var x int
+			if k == -d || (k != d && v[offset+k-1] < v[offset+k+1]) {
+				x = v[offset+k+1]
+			} else {
+				x = v[offset+k-1] + 1
+			}
+			y := x - k
+			for x < n && y < m && eq(x, y) {
+				x++
+				y++
+			}
+			v[offset+k] = x
+			if x >= n && y >= m {
+				return backtrackEdits(trace, n, m)
+			}
+		}
+	}
+
+	ops := make([]editOp, 0, max)
+	for i := 0; i < n; i++ {
+		ops = append(ops, editOp{kind: editDelete, old: i, new: -1})
+	}
+	for j := 0; j < m; j++ {
+		ops = append(ops, editOp{kind: editInsert, old: -1, new: j})
+	}
+	return ops
+}
+
+func backtrackEdits(trace [][]int, n, m int) []editOp {
+	var ops []editOp
+	x, y := n, m
+	for d := len(trace) - 1; d >= 0; d-- {
+		snapshot := trace[d]
+		at := func(k int) int { return snapshot[k+d+1] }
+
+		k := x - y
+		var prevK int
+		if k == -d || (k != d && at(k-1) < at(k+1)) {
+			prevK = k + 1
+		} else {
+			prevK = k - 1
+		}
+		prevX := at(prevK)
+		prevY := prevX - prevK
+
+		for x > prevX && y > prevY && x > 0 && y > 0 {
+			ops = append(ops, editOp{kind: editEqual, old: x - 1, new: y - 1})
+			x--
+			y--
+		}
+		if d > 0 {
+			if x == prevX {
+				ops = append(ops, editOp{kind: editInsert, old: -1, new: prevY})
+			} else {
+				ops = append(ops, editOp{kind: editDelete, old: prevX, new: -1})
+			}
+		}
+		x, y = prevX, prevY
+	}
+
+	for i, j := 0, len(ops)-1; i < j; i, j = i+1, j-1 {
+		ops[i], ops[j] = ops[j], ops[i]
+	}
+	return ops
+}
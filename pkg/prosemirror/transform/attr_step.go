@@ -0,0 +1,86 @@
+package transform
+
+import (
+	"encoding/json"
+	"maps"
+
+	"github.com/emaforlin/ce-document-service/pkg/prosemirror"
+)
+
+// AttrStep sets a single attribute of the node at Pos
+type AttrStep struct {
+	Pos   int
+	Attr  string
+	Value any
+
+	schema *prosemirror.Schema
+}
+
+// Apply implements Step
+func (s *AttrStep) Apply(doc *prosemirror.Node) (*prosemirror.Node, error) {
+	node := s.schema.NodeAt(doc, s.Pos)
+	if node == nil || node.IsText() {
+		return nil, fail("no node at attribute step's position")
+	}
+	return replaceNode(s.schema, doc, s.Pos, node, prosemirror.WithAttrs(node, setAttr(node.Attrs, s.Attr, s.Value)))
+}
+
+// GetMap implements Step
+func (s *AttrStep) GetMap() *StepMap {
+	return EmptyStepMap
+}
+
+// Map implements Step
+func (s *AttrStep) Map(mapping Mappable) Step {
+	pos := mapping.MapResult(s.Pos, 1)
+	if pos.DeletedAfter() {
+		return nil
+	}
+	return &AttrStep{schema: s.schema, Pos: pos.Pos, Attr: s.Attr, Value: s.Value}
+}
+
+// MarshalJSON implements json.Marshaler
+func (s *AttrStep) MarshalJSON() ([]byte, error) {
+	attr, value, err := marshalAttr(s.Attr, s.Value)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(stepJSON{StepType: "attr", Pos: intPtr(s.Pos), Attr: attr, Value: value})
+}
+
+// DocAttrStep sets a single attribute of the top node
+type DocAttrStep struct {
+	Attr  string
+	Value any
+}
+
+// Apply implements Step
+func (s *DocAttrStep) Apply(doc *prosemirror.Node) (*prosemirror.Node, error) {
+	return prosemirror.WithAttrs(doc, setAttr(doc.Attrs, s.Attr, s.Value)), nil
+}
+
+// GetMap implements Step
+func (s *DocAttrStep) GetMap() *StepMap {
+	return EmptyStepMap
+}
+
+// Map implements Step
+func (s *DocAttrStep) Map(Mappable) Step {
+	return s
+}
+
+// MarshalJSON implements json.Marshaler
+func (s *DocAttrStep) MarshalJSON() ([]byte, error) {
+	attr, value, err := marshalAttr(s.Attr, s.Value)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(stepJSON{StepType: "docAttr", Attr: attr, Value: value})
+}
+
+func setAttr(attrs map[string]any, name string, value any) map[string]any {
+	updated := make(map[string]any, len(attrs)+1)
+	maps.Copy(updated, attrs)
+	updated[name] = value
+	return updated
+}
@@ -0,0 +1,134 @@
+package transform
+
+const (
+	delBefore = 1 << iota
+	delAfter
+	delAcross
+	delSide
+)
+
+// MapResult is the outcome of mapping a position through a step map
+type MapResult struct {
+	Pos int
+
+	delInfo int
+}
+
+// Deleted tells whether the content on the side of the position given by
+// the assoc argument was deleted
+func (r MapResult) Deleted() bool { return r.delInfo&delSide > 0 }
+
+// DeletedBefore tells whether the token before the position was deleted
+func (r MapResult) DeletedBefore() bool { return r.delInfo&(delBefore|delAcross) > 0 }
+
+// DeletedAfter tells whether the token after the position was deleted
+func (r MapResult) DeletedAfter() bool { return r.delInfo&(delAfter|delAcross) > 0 }
+
+// DeletedAcross tells whether a deletion spanned across the position
+func (r MapResult) DeletedAcross() bool { return r.delInfo&delAcross > 0 }
+
+// Mappable is anything positions can be mapped through
+type Mappable interface {
+	// Map maps a position. assoc tells which side the position sticks to when
+	// content is inserted at it: negative for the left, positive for the right.
+	Map(pos, assoc int) int
+	MapResult(pos, assoc int) MapResult
+}
+
+// StepMap describes the ranges replaced by a single step as triples of
+// start, old size and new size
+type StepMap struct {
+	ranges []int
+}
+
+// NewStepMap creates a step map from start, old size, new size triples
+func NewStepMap(ranges ...int) *StepMap {
+	return &StepMap{ranges: ranges}
+}
+
+// EmptyStepMap is the map of steps that do not change positions
+var EmptyStepMap = &StepMap{}
+
+// Map implements Mappable
+func (m *StepMap) Map(pos, assoc int) int {
+	return m.MapResult(pos, assoc).Pos
+}
+
+// MapResult implements Mappable
+func (m *StepMap) MapResult(pos, assoc int) MapResult {
+	diff := 0
+	for i := 0; i+2 < len(m.ranges); i += 3 {
+		start := m.ranges[i]
+		if start > pos {
+			break
+		}
+		oldSize, newSize := m.ranges[i+1], m.ranges[i+2]
+		end := start + oldSize
+		if pos <= end {
+			side := assoc
+			if oldSize > 0 {
+				if pos == start {
+					side = -1
+				} else if pos == end {
+					side = 1
+				}
+			}
+			result := start + diff
+			if side >= 0 {
+				result += newSize
+			}
+			del := delAcross
+			if pos == start {
+				del = delAfter
+			} else if pos == end {
+				del = delBefore
+			}
+			if assoc < 0 && pos != start || assoc >= 0 && pos != end {
+				del |= delSide
+			}
+			return MapResult{Pos: result, delInfo: del}
+		}
+		diff += newSize - oldSize
+	}
+	return MapResult{Pos: pos + diff}
+}
+
+// ForEach calls fn for every changed range with its bounds before and after
+// the step
+func (m *StepMap) ForEach(fn func(oldStart, oldEnd, newStart, newEnd int)) {
+	diff := 0
+	for i := 0; i+2 < len(m.ranges); i += 3 {
+		start, oldSize, newSize := m.ranges[i], m.ranges[i+1], m.ranges[i+2]
+		fn(start, start+oldSize, start+diff, start+diff+newSize)
+		diff += newSize - oldSize
+	}
+}
+
+// Mapping is a pipeline of step maps
+type Mapping struct {
+	Maps []*StepMap
+}
+
+// AppendMap adds a step map to the end of the mapping
+func (m *Mapping) AppendMap(stepMap *StepMap) {
+	m.Maps = append(m.Maps, stepMap)
+}
+
+// Map implements Mappable
+func (m *Mapping) Map(pos, assoc int) int {
+	for _, stepMap := range m.Maps {
+		pos = stepMap.Map(pos, assoc)
+	}
+	return pos
+}
+
+// MapResult implements Mappable
+func (m *Mapping) MapResult(pos, assoc int) MapResult {
+	delInfo := 0
+	for _, stepMap := range m.Maps {
+		result := stepMap.MapResult(pos, assoc)
+		pos = result.Pos
+		delInfo |= result.delInfo
+	}
+	return MapResult{Pos: pos, delInfo: delInfo}
+}
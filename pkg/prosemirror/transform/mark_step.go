@@ -0,0 +1,193 @@
+package transform
+
+import (
+	"encoding/json"
+
+	"github.com/emaforlin/ce-document-service/pkg/prosemirror"
+)
+
+// AddMarkStep adds a mark to the inline content between From and To
+type AddMarkStep struct {
+	From int
+	To   int
+	Mark *prosemirror.Mark
+
+	schema *prosemirror.Schema
+}
+
+// Apply implements Step
+func (s *AddMarkStep) Apply(doc *prosemirror.Node) (*prosemirror.Node, error) {
+	old, err := s.schema.Slice(doc, s.From, s.To)
+	if err != nil {
+		return nil, fail("%v", err)
+	}
+	from, err := s.schema.Resolve(doc, s.From)
+	if err != nil {
+		return nil, fail("%v", err)
+	}
+	markType := s.schema.Marks[s.Mark.Type]
+	parent := from.Node(from.SharedDepth(s.To))
+	content := s.schema.MapInline(old.Content, parent, func(node, parent *prosemirror.Node) *prosemirror.Node {
+		parentType, ok := s.schema.Nodes[parent.Type]
+		if !s.schema.IsLeaf(node) || !ok || !parentType.AllowsMark(markType) {
+			return node
+		}
+		return prosemirror.WithMarks(node, prosemirror.AddMarkToSet(node.Marks, s.Mark))
+	})
+	return fromReplace(s.schema, doc, s.From, s.To, prosemirror.Slice{Content: content, OpenStart: old.OpenStart, OpenEnd: old.OpenEnd})
+}
+
+// GetMap implements Step
+func (s *AddMarkStep) GetMap() *StepMap {
+	return EmptyStepMap
+}
+
+// Map implements Step
+func (s *AddMarkStep) Map(mapping Mappable) Step {
+	from, to := mapping.MapResult(s.From, 1), mapping.MapResult(s.To, -1)
+	if from.Deleted() && to.Deleted() || from.Pos >= to.Pos {
+		return nil
+	}
+	return &AddMarkStep{schema: s.schema, From: from.Pos, To: to.Pos, Mark: s.Mark}
+}
+
+// MarshalJSON implements json.Marshaler
+func (s *AddMarkStep) MarshalJSON() ([]byte, error) {
+	mark, err := marshalMark(s.Mark)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(stepJSON{StepType: "addMark", From: intPtr(s.From), To: intPtr(s.To), Mark: mark})
+}
+
+// RemoveMarkStep removes a mark from the inline content between From and To
+type RemoveMarkStep struct {
+	From int
+	To   int
+	Mark *prosemirror.Mark
+
+	schema *prosemirror.Schema
+}
+
+// Apply implements Step
+func (s *RemoveMarkStep) Apply(doc *prosemirror.Node) (*prosemirror.Node, error) {
+	old, err := s.schema.Slice(doc, s.From, s.To)
+	if err != nil {
+		return nil, fail("%v", err)
+	}
+	content := s.schema.MapInline(old.Content, doc, func(node, _ *prosemirror.Node) *prosemirror.Node {
+		return prosemirror.WithMarks(node, prosemirror.RemoveMarkFromSet(node.Marks, s.Mark))
+	})
+	return fromReplace(s.schema, doc, s.From, s.To, prosemirror.Slice{Content: content, OpenStart: old.OpenStart, OpenEnd: old.OpenEnd})
+}
+
+// GetMap implements Step
+func (s *RemoveMarkStep) GetMap() *StepMap {
+	return EmptyStepMap
+}
+
+// Map implements Step
+func (s *RemoveMarkStep) Map(mapping Mappable) Step {
+	from, to := mapping.MapResult(s.From, 1), mapping.MapResult(s.To, -1)
+	if from.Deleted() && to.Deleted() || from.Pos >= to.Pos {
+		return nil
+	}
+	return &RemoveMarkStep{schema: s.schema, From: from.Pos, To: to.Pos, Mark: s.Mark}
+}
+
+// MarshalJSON implements json.Marshaler
+func (s *RemoveMarkStep) MarshalJSON() ([]byte, error) {
+	mark, err := marshalMark(s.Mark)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(stepJSON{StepType: "removeMark", From: intPtr(s.From), To: intPtr(s.To), Mark: mark})
+}
+
+// AddNodeMarkStep adds a mark to the node at Pos
+type AddNodeMarkStep struct {
+	Pos  int
+	Mark *prosemirror.Mark
+
+	schema *prosemirror.Schema
+}
+
+// Apply implements Step
+func (s *AddNodeMarkStep) Apply(doc *prosemirror.Node) (*prosemirror.Node, error) {
+	node := s.schema.NodeAt(doc, s.Pos)
+	if node == nil || node.IsText() {
+		return nil, fail("no node at mark step's position")
+	}
+	updated := prosemirror.WithMarks(node, prosemirror.AddMarkToSet(node.Marks, s.Mark))
+	return replaceNode(s.schema, doc, s.Pos, node, updated)
+}
+
+// GetMap implements Step
+func (s *AddNodeMarkStep) GetMap() *StepMap {
+	return EmptyStepMap
+}
+
+// Map implements Step
+func (s *AddNodeMarkStep) Map(mapping Mappable) Step {
+	pos := mapping.MapResult(s.Pos, 1)
+	if pos.DeletedAfter() {
+		return nil
+	}
+	return &AddNodeMarkStep{schema: s.schema, Pos: pos.Pos, Mark: s.Mark}
+}
+
+// MarshalJSON implements json.Marshaler
+func (s *AddNodeMarkStep) MarshalJSON() ([]byte, error) {
+	mark, err := marshalMark(s.Mark)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(stepJSON{StepType: "addNodeMark", Pos: intPtr(s.Pos), Mark: mark})
+}
+
+// RemoveNodeMarkStep removes a mark from the node at Pos
+type RemoveNodeMarkStep struct {
+	Pos  int
+	Mark *prosemirror.Mark
+
+	schema *prosemirror.Schema
+}
+
+// Apply implements Step
+func (s *RemoveNodeMarkStep) Apply(doc *prosemirror.Node) (*prosemirror.Node, error) {
+	node := s.schema.NodeAt(doc, s.Pos)
+	if node == nil || node.IsText() {
+		return nil, fail("no node at mark step's position")
+	}
+	updated := prosemirror.WithMarks(node, prosemirror.RemoveMarkFromSet(node.Marks, s.Mark))
+	return replaceNode(s.schema, doc, s.Pos, node, updated)
+}
+
+// GetMap implements Step
+func (s *RemoveNodeMarkStep) GetMap() *StepMap {
+	return EmptyStepMap
+}
+
+// Map implements Step
+func (s *RemoveNodeMarkStep) Map(mapping Mappable) Step {
+	pos := mapping.MapResult(s.Pos, 1)
+	if pos.DeletedAfter() {
+		return nil
+	}
+	return &RemoveNodeMarkStep{schema: s.schema, Pos: pos.Pos, Mark: s.Mark}
+}
+
+// MarshalJSON implements json.Marshaler
+func (s *RemoveNodeMarkStep) MarshalJSON() ([]byte, error) {
+	mark, err := marshalMark(s.Mark)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(stepJSON{StepType: "removeNodeMark", Pos: intPtr(s.Pos), Mark: mark})
+}
+
+// replaceNode swaps the node starting at pos for an updated copy with the
+// same content
+func replaceNode(schema *prosemirror.Schema, doc *prosemirror.Node, pos int, old, updated *prosemirror.Node) (*prosemirror.Node, error) {
+	return fromReplace(schema, doc, pos, pos+schema.NodeSize(old), prosemirror.Slice{Content: []*prosemirror.Node{updated}})
+}
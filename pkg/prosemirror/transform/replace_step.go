@@ -0,0 +1,166 @@
+package transform
+
+import (
+	"encoding/json"
+
+	"github.com/emaforlin/ce-document-service/pkg/prosemirror"
+)
+
+// ReplaceStep replaces the range between From and To with a slice. When
+// Structure is set the step only moves structure and fails if it would
+// overwrite content.
+type ReplaceStep struct {
+	From      int
+	To        int
+	Slice     prosemirror.Slice
+	Structure bool
+
+	schema *prosemirror.Schema
+}
+
+// NewReplaceStep creates a replace step for documents of the schema
+func NewReplaceStep(schema *prosemirror.Schema, from, to int, slice prosemirror.Slice, structure bool) *ReplaceStep {
+	return &ReplaceStep{schema: schema, From: from, To: to, Slice: slice, Structure: structure}
+}
+
+// Apply implements Step
+func (s *ReplaceStep) Apply(doc *prosemirror.Node) (*prosemirror.Node, error) {
+	if s.Structure && contentBetween(s.schema, doc, s.From, s.To) {
+		return nil, fail("structure replace would overwrite content")
+	}
+	return fromReplace(s.schema, doc, s.From, s.To, s.Slice)
+}
+
+// GetMap implements Step
+func (s *ReplaceStep) GetMap() *StepMap {
+	return NewStepMap(s.From, s.To-s.From, s.schema.SliceSize(s.Slice))
+}
+
+// Map implements Step
+func (s *ReplaceStep) Map(mapping Mappable) Step {
+	from, to := mapping.MapResult(s.From, 1), mapping.MapResult(s.To, -1)
+	if from.DeletedAcross() && to.DeletedAcross() {
+		return nil
+	}
+	return NewReplaceStep(s.schema, from.Pos, max(from.Pos, to.Pos), s.Slice, s.Structure)
+}
+
+// MarshalJSON implements json.Marshaler
+func (s *ReplaceStep) MarshalJSON() ([]byte, error) {
+	slice, err := marshalSlice(s.Slice)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(stepJSON{
+		StepType: "replace", From: intPtr(s.From), To: intPtr(s.To), Slice: slice, Structure: s.Structure,
+	})
+}
+
+// ReplaceAroundStep replaces the range between From and To with a slice
+// while keeping the content between GapFrom and GapTo, which is inserted into
+// the slice at Insert. It is used to wrap and unwrap content.
+type ReplaceAroundStep struct {
+	From      int
+	To        int
+	GapFrom   int
+	GapTo     int
+	Slice     prosemirror.Slice
+	Insert    int
+	Structure bool
+
+	schema *prosemirror.Schema
+}
+
+// Apply implements Step
+func (s *ReplaceAroundStep) Apply(doc *prosemirror.Node) (*prosemirror.Node, error) {
+	if s.Structure && (contentBetween(s.schema, doc, s.From, s.GapFrom) || contentBetween(s.schema, doc, s.GapTo, s.To)) {
+		return nil, fail("structure gap-replace would overwrite content")
+	}
+	if s.From > s.GapFrom || s.GapFrom > s.GapTo || s.GapTo > s.To {
+		return nil, fail("gap is not inside the replaced range")
+	}
+	gap, err := s.schema.Slice(doc, s.GapFrom, s.GapTo)
+	if err != nil {
+		return nil, fail("%v", err)
+	}
+	if gap.OpenStart > 0 || gap.OpenEnd > 0 {
+		return nil, fail("gap is not a flat range")
+	}
+	inserted, ok := s.schema.InsertIntoSlice(s.Slice, s.Insert, gap.Content)
+	if !ok {
+		return nil, fail("content does not fit in gap")
+	}
+	return fromReplace(s.schema, doc, s.From, s.To, inserted)
+}
+
+// GetMap implements Step
+func (s *ReplaceAroundStep) GetMap() *StepMap {
+	return NewStepMap(
+		s.From, s.GapFrom-s.From, s.Insert,
+		s.GapTo, s.To-s.GapTo, s.schema.SliceSize(s.Slice)-s.Insert,
+	)
+}
+
+// Map implements Step
+func (s *ReplaceAroundStep) Map(mapping Mappable) Step {
+	from, to := mapping.MapResult(s.From, 1), mapping.MapResult(s.To, -1)
+	gapFrom, gapTo := mapping.Map(s.GapFrom, -1), mapping.Map(s.GapTo, 1)
+	if s.From == s.GapFrom {
+		gapFrom = from.Pos
+	}
+	if s.To == s.GapTo {
+		gapTo = to.Pos
+	}
+	if from.DeletedAcross() && to.DeletedAcross() || gapFrom < from.Pos || gapTo > to.Pos {
+		return nil
+	}
+	return &ReplaceAroundStep{
+		schema: s.schema, From: from.Pos, To: to.Pos, GapFrom: gapFrom, GapTo: gapTo,
+		Slice: s.Slice, Insert: s.Insert, Structure: s.Structure,
+	}
+}
+
+// MarshalJSON implements json.Marshaler
+func (s *ReplaceAroundStep) MarshalJSON() ([]byte, error) {
+	slice, err := marshalSlice(s.Slice)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(stepJSON{
+		StepType: "replaceAround", From: intPtr(s.From), To: intPtr(s.To),
+		GapFrom: intPtr(s.GapFrom), GapTo: intPtr(s.GapTo), Insert: intPtr(s.Insert),
+		Slice: slice, Structure: s.Structure,
+	})
+}
+
+// contentBetween reports whether there is content other than closing and
+// opening tokens between from and to
+func contentBetween(schema *prosemirror.Schema, doc *prosemirror.Node, from, to int) bool {
+	pos, err := schema.Resolve(doc, from)
+	if err != nil {
+		return true
+	}
+	dist, depth := to-from, pos.Depth
+	for dist > 0 && depth > 0 && pos.IndexAfter(depth) == len(pos.Node(depth).Content) {
+		depth--
+		dist--
+	}
+	if dist > 0 {
+		var next *prosemirror.Node
+		if parent, index := pos.Node(depth), pos.IndexAfter(depth); index < len(parent.Content) {
+			next = parent.Content[index]
+		}
+		for dist > 0 {
+			if next == nil || schema.IsLeaf(next) {
+				return true
+			}
+			if len(next.Content) > 0 {
+				next = next.Content[0]
+			} else {
+				next = nil
+			}
+			dist--
+		}
+	}
+	return false
+}
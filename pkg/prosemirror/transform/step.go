@@ -0,0 +1,224 @@
+// Package transform implements ProseMirror steps, the atomic document changes
+// collaborative editors exchange, and the mapping of positions through them.
+// It follows prosemirror-transform so that steps produced by the editor apply
+// identically on the server.
+package transform
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/emaforlin/ce-document-service/pkg/prosemirror"
+)
+
+// Step is an atomic change to a document
+type Step interface {
+	// Apply applies the step to doc and returns the new document
+	Apply(doc *prosemirror.Node) (*prosemirror.Node, error)
+	// GetMap returns the map describing how positions move through the step
+	GetMap() *StepMap
+	// Map returns the step rebased over mapping, or nil when the content it
+	// applied to was deleted
+	Map(mapping Mappable) Step
+	json.Marshaler
+}
+
+// StepError is returned when a step cannot be applied to a document
+type StepError struct {
+	Message string
+}
+
+func (e *StepError) Error() string {
+	return e.Message
+}
+
+func fail(format string, args ...any) *StepError {
+	return &StepError{Message: fmt.Sprintf(format, args...)}
+}
+
+// fromReplace applies a replace and converts replace failures into step errors
+func fromReplace(schema *prosemirror.Schema, doc *prosemirror.Node, from, to int, slice prosemirror.Slice) (*prosemirror.Node, error) {
+	result, err := schema.Replace(doc, from, to, slice)
+	if err != nil {
+		var replaceErr *prosemirror.ReplaceError
+		if errors.As(err, &replaceErr) {
+			return nil, fail("%s", replaceErr.Message)
+		}
+		return nil, err
+	}
+	return result, nil
+}
+
+type stepJSON struct {
+	StepType  string          `json:"stepType"`
+	From      *int            `json:"from,omitempty"`
+	To        *int            `json:"to,omitempty"`
+	GapFrom   *int            `json:"gapFrom,omitempty"`
+	GapTo     *int            `json:"gapTo,omitempty"`
+	Insert    *int            `json:"insert,omitempty"`
+	Pos       *int            `json:"pos,omitempty"`
+	Slice     json.RawMessage `json:"slice,omitempty"`
+	Mark      json.RawMessage `json:"mark,omitempty"`
+	Structure bool            `json:"structure,omitempty"`
+	Attr      *string         `json:"attr,omitempty"`
+	Value     json.RawMessage `json:"value,omitempty"`
+}
+
+// StepFromJSON parses a step in the JSON form produced by Step.toJSON in
+// prosemirror-transform
+func StepFromJSON(schema *prosemirror.Schema, data []byte) (Step, error) {
+	var raw stepJSON
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return nil, fmt.Errorf("malformed step: %w", err)
+	}
+
+	required := func(name string, value *int) (int, error) {
+		if value == nil {
+			return 0, fmt.Errorf("%s step is missing %q", raw.StepType, name)
+		}
+		if *value < 0 {
+			return 0, fmt.Errorf("%s step has a negative %q", raw.StepType, name)
+		}
+		return *value, nil
+	}
+	positions := func(names []string, values ...*int) ([]int, error) {
+		result := make([]int, len(values))
+		for i, value := range values {
+			n, err := required(names[i], value)
+			if err != nil {
+				return nil, err
+			}
+			result[i] = n
+		}
+		return result, nil
+	}
+	slice := func() (prosemirror.Slice, error) {
+		if len(raw.Slice) == 0 {
+			return prosemirror.Slice{}, nil
+		}
+		parsed, err := schema.SliceFromJSON(raw.Slice)
+		if err != nil {
+			return prosemirror.Slice{}, fmt.Errorf("invalid slice: %w", err)
+		}
+		return parsed, nil
+	}
+	mark := func() (*prosemirror.Mark, error) {
+		if len(raw.Mark) == 0 {
+			return nil, fmt.Errorf("%s step is missing \"mark\"", raw.StepType)
+		}
+		parsed, err := schema.MarkFromJSON(raw.Mark)
+		if err != nil {
+			return nil, fmt.Errorf("invalid mark: %w", err)
+		}
+		return parsed, nil
+	}
+	attr := func() (string, any, error) {
+		if raw.Attr == nil {
+			return "", nil, fmt.Errorf("%s step is missing \"attr\"", raw.StepType)
+		}
+		var value any
+		if len(raw.Value) > 0 {
+			if err := json.Unmarshal(raw.Value, &value); err != nil {
+				return "", nil, fmt.Errorf("invalid attribute value: %w", err)
+			}
+		}
+		return *raw.Attr, value, nil
+	}
+
+	switch raw.StepType {
+	case "replace":
+		pos, err := positions([]string{"from", "to"}, raw.From, raw.To)
+		if err != nil {
+			return nil, err
+		}
+		content, err := slice()
+		if err != nil {
+			return nil, err
+		}
+		return &ReplaceStep{schema: schema, From: pos[0], To: pos[1], Slice: content, Structure: raw.Structure}, nil
+	case "replaceAround":
+		pos, err := positions([]string{"from", "to", "gapFrom", "gapTo", "insert"},
+			raw.From, raw.To, raw.GapFrom, raw.GapTo, raw.Insert)
+		if err != nil {
+			return nil, err
+		}
+		content, err := slice()
+		if err != nil {
+			return nil, err
+		}
+		return &ReplaceAroundStep{
+			schema: schema, From: pos[0], To: pos[1], GapFrom: pos[2], GapTo: pos[3],
+			Insert: pos[4], Slice: content, Structure: raw.Structure,
+		}, nil
+	case "addMark", "removeMark":
+		pos, err := positions([]string{"from", "to"}, raw.From, raw.To)
+		if err != nil {
+			return nil, err
+		}
+		m, err := mark()
+		if err != nil {
+			return nil, err
+		}
+		if raw.StepType == "addMark" {
+			return &AddMarkStep{schema: schema, From: pos[0], To: pos[1], Mark: m}, nil
+		}
+		return &RemoveMarkStep{schema: schema, From: pos[0], To: pos[1], Mark: m}, nil
+	case "addNodeMark", "removeNodeMark":
+		pos, err := required("pos", raw.Pos)
+		if err != nil {
+			return nil, err
+		}
+		m, err := mark()
+		if err != nil {
+			return nil, err
+		}
+		if raw.StepType == "addNodeMark" {
+			return &AddNodeMarkStep{schema: schema, Pos: pos, Mark: m}, nil
+		}
+		return &RemoveNodeMarkStep{schema: schema, Pos: pos, Mark: m}, nil
+	case "attr":
+		pos, err := required("pos", raw.Pos)
+		if err != nil {
+			return nil, err
+		}
+		name, value, err := attr()
+		if err != nil {
+			return nil, err
+		}
+		return &AttrStep{schema: schema, Pos: pos, Attr: name, Value: value}, nil
+	case "docAttr":
+		name, value, err := attr()
+		if err != nil {
+			return nil, err
+		}
+		return &DocAttrStep{Attr: name, Value: value}, nil
+	case "":
+		return nil, errors.New("step is missing \"stepType\"")
+	default:
+		return nil, fmt.Errorf("unknown step type %q", raw.StepType)
+	}
+}
+
+func intPtr(n int) *int {
+	return &n
+}
+
+func marshalSlice(slice prosemirror.Slice) (json.RawMessage, error) {
+	if len(slice.Content) == 0 {
+		return nil, nil
+	}
+	return json.Marshal(slice)
+}
+
+func marshalMark(mark *prosemirror.Mark) (json.RawMessage, error) {
+	return json.Marshal(mark)
+}
+
+func marshalAttr(name string, value any) (*string, json.RawMessage, error) {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return nil, nil, err
+	}
+	return &name, data, nil
+}
@@ -0,0 +1,44 @@
+package transform
+
+import (
+	"fmt"
+
+	"github.com/emaforlin/ce-document-service/pkg/prosemirror"
+)
+
+// Transform accumulates steps applied to a document
+type Transform struct {
+	Doc     *prosemirror.Node
+	Steps   []Step
+	Mapping Mapping
+}
+
+// New starts a transform of doc
+func New(doc *prosemirror.Node) *Transform {
+	return &Transform{Doc: doc}
+}
+
+// Step applies a step to the current document. The transform is left
+// unchanged when the step fails.
+func (t *Transform) Step(step Step) error {
+	doc, err := step.Apply(t.Doc)
+	if err != nil {
+		return err
+	}
+	t.Doc = doc
+	t.Steps = append(t.Steps, step)
+	t.Mapping.AppendMap(step.GetMap())
+	return nil
+}
+
+// ApplySteps applies steps in order and returns the resulting document. It
+// fails on the first step that cannot be applied, reporting its index.
+func ApplySteps(doc *prosemirror.Node, steps []Step) (*Transform, error) {
+	tr := New(doc)
+	for i, step := range steps {
+		if err := tr.Step(step); err != nil {
+			return nil, fmt.Errorf("step %d: %w", i, err)
+		}
+	}
+	return tr, nil
+}
@@ -0,0 +1,368 @@
+package transform
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/emaforlin/ce-document-service/pkg/prosemirror"
+)
+
+var schema = prosemirror.DefaultSchema()
+
+const (
+	// plainDoc is "hello world" in a paragraph: the text spans 1 to 12
+	plainDoc = `{"type":"doc","content":[{"type":"paragraph","content":[{"type":"text","text":"hello world"}]}]}`
+	// boldDoc has "world", 7 to 12, in bold
+	boldDoc = `{"type":"doc","content":[{"type":"paragraph","content":[{"type":"text","text":"hello "},{"type":"text","text":"world","marks":[{"type":"bold"}]}]}]}`
+	// headingDoc is a level 1 heading followed by a paragraph starting at 7
+	headingDoc = `{"type":"doc","content":[{"type":"heading","attrs":{"level":1},"content":[{"type":"text","text":"Title"}]},{"type":"paragraph","content":[{"type":"text","text":"body"}]}]}`
+)
+
+func parseDoc(t *testing.T, data string) *prosemirror.Node {
+	t.Helper()
+	doc, err := schema.NodeFromJSON([]byte(data))
+	if err != nil {
+		t.Fatalf("invalid test document: %v", err)
+	}
+	return doc
+}
+
+func parseStep(t *testing.T, data string) Step {
+	t.Helper()
+	step, err := StepFromJSON(schema, []byte(data))
+	if err != nil {
+		t.Fatalf("invalid test step %s: %v", data, err)
+	}
+	return step
+}
+
+func toJSON(t *testing.T, v any) string {
+	t.Helper()
+	data, err := json.Marshal(v)
+	if err != nil {
+		t.Fatalf("marshal: %v", err)
+	}
+	return string(data)
+}
+
+// invert builds the step undoing step on doc, the way prosemirror-transform
+// inverts steps
+func invert(t *testing.T, step Step, doc *prosemirror.Node) Step {
+	t.Helper()
+	switch s := step.(type) {
+	case *ReplaceStep:
+		old, err := schema.Slice(doc, s.From, s.To)
+		if err != nil {
+			t.Fatalf("slice: %v", err)
+		}
+		return NewReplaceStep(schema, s.From, s.From+schema.SliceSize(s.Slice), old, false)
+	case *AddMarkStep:
+		return &RemoveMarkStep{schema: schema, From: s.From, To: s.To, Mark: s.Mark}
+	case *RemoveMarkStep:
+		return &AddMarkStep{schema: schema, From: s.From, To: s.To, Mark: s.Mark}
+	case *AttrStep:
+		return &AttrStep{schema: schema, Pos: s.Pos, Attr: s.Attr, Value: schema.NodeAt(doc, s.Pos).Attr(s.Attr)}
+	case *DocAttrStep:
+		return &DocAttrStep{Attr: s.Attr, Value: doc.Attr(s.Attr)}
+	}
+	t.Fatalf("cannot invert %T", step)
+	return nil
+}
+
+func TestStepApplyAndInvert(t *testing.T) {
+	tests := []struct {
+		name string
+		doc  string
+		step string
+		want string
+	}{
+		{
+			name: "insert text",
+			doc:  plainDoc,
+			step: `{"stepType":"replace","from":6,"to":6,"slice":{"content":[{"type":"text","text":","}]}}`,
+			want: "hello, world",
+		},
+		{
+			name: "delete text",
+			doc:  plainDoc,
+			step: `{"stepType":"replace","from":6,"to":12}`,
+			want: "hello",
+		},
+		{
+			name: "replace text",
+			doc:  plainDoc,
+			step: `{"stepType":"replace","from":7,"to":12,"slice":{"content":[{"type":"text","text":"there"}]}}`,
+			want: "hello there",
+		},
+		{
+			name: "split paragraph",
+			doc:  plainDoc,
+			step: `{"stepType":"replace","from":6,"to":6,"slice":{"content":[{"type":"paragraph"},{"type":"paragraph"}],"openStart":1,"openEnd":1}}`,
+			want: "hello world",
+		},
+		{
+			name: "delete across blocks",
+			doc:  headingDoc,
+			step: `{"stepType":"replace","from":3,"to":9,"slice":{"content":[{"type":"heading","attrs":{"level":1}},{"type":"paragraph"}],"openStart":1,"openEnd":1}}`,
+			want: "Tiody",
+		},
+		{
+			name: "add mark",
+			doc:  plainDoc,
+			step: `{"stepType":"addMark","from":1,"to":6,"mark":{"type":"italic"}}`,
+			want: "hello world",
+		},
+		{
+			name: "remove mark",
+			doc:  boldDoc,
+			step: `{"stepType":"removeMark","from":7,"to":12,"mark":{"type":"bold"}}`,
+			want: "hello world",
+		},
+		{
+			name: "set node attribute",
+			doc:  headingDoc,
+			step: `{"stepType":"attr","pos":0,"attr":"level","value":2}`,
+			want: "Titlebody",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			doc := parseDoc(t, tt.doc)
+			step := parseStep(t, tt.step)
+
+			applied, err := step.Apply(doc)
+			if err != nil {
+				t.Fatalf("apply: %v", err)
+			}
+			if got := applied.TextContent(); got != tt.want {
+				t.Errorf("text = %q, want %q", got, tt.want)
+			}
+			if err := schema.Check(applied); err != nil {
+				t.Errorf("result is not a valid document: %v", err)
+			}
+
+			restored, err := invert(t, step, doc).Apply(applied)
+			if err != nil {
+				t.Fatalf("apply inverted step: %v", err)
+			}
+			if got, want := toJSON(t, restored), toJSON(t, doc); got != want {
+				t.Errorf("inverted step gave\n%s\nwant\n%s", got, want)
+			}
+		})
+	}
+}
+
+func TestStepMarksAndAttrs(t *testing.T) {
+	doc := parseDoc(t, plainDoc)
+
+	tr, err := ApplySteps(doc, []Step{
+		parseStep(t, `{"stepType":"addMark","from":1,"to":6,"mark":{"type":"bold"}}`),
+		parseStep(t, `{"stepType":"attr","pos":0,"attr":"textAlign","value":"center"}`),
+	})
+	if err != nil {
+		t.Fatalf("apply: %v", err)
+	}
+	paragraph := tr.Doc.Content[0]
+	if len(paragraph.Content) != 2 {
+		t.Fatalf("got %d text nodes, want 2", len(paragraph.Content))
+	}
+	if first := paragraph.Content[0]; first.Text != "hello" || len(first.Marks) != 1 || first.Marks[0].Type != "bold" {
+		t.Errorf("first text node = %s, want bold \"hello\"", toJSON(t, first))
+	}
+	if second := paragraph.Content[1]; second.Text != " world" || len(second.Marks) != 0 {
+		t.Errorf("second text node = %s, want plain \" world\"", toJSON(t, second))
+	}
+	if align := paragraph.Attr("textAlign"); align != "center" {
+		t.Errorf("textAlign = %v, want center", align)
+	}
+	if len(tr.Steps) != 2 || len(tr.Mapping.Maps) != 2 {
+		t.Errorf("transform recorded %d steps and %d maps, want 2 and 2", len(tr.Steps), len(tr.Mapping.Maps))
+	}
+}
+
+func TestStepApplyErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		doc  string
+		step string
+	}{
+		{"position past the end", plainDoc, `{"stepType":"replace","from":20,"to":20,"slice":{"content":[{"type":"text","text":"x"}]}}`},
+		{"block inside text", plainDoc, `{"stepType":"replace","from":3,"to":3,"slice":{"content":[{"type":"horizontalRule"}]}}`},
+		{"text at the top level", plainDoc, `{"stepType":"replace","from":0,"to":0,"slice":{"content":[{"type":"text","text":"x"}]}}`},
+		{"structure replace over content", plainDoc, `{"stepType":"replace","from":1,"to":6,"structure":true}`},
+		{"attribute of a text node", plainDoc, `{"stepType":"attr","pos":1,"attr":"level","value":2}`},
+		{"node mark past the end", plainDoc, `{"stepType":"addNodeMark","pos":40,"mark":{"type":"bold"}}`},
+		{"gap outside the range", plainDoc, `{"stepType":"replaceAround","from":4,"to":6,"gapFrom":2,"gapTo":8,"insert":0}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			doc := parseDoc(t, tt.doc)
+			before := toJSON(t, doc)
+
+			tr := New(doc)
+			err := tr.Step(parseStep(t, tt.step))
+			if err == nil {
+				t.Fatal("step applied, want an error")
+			}
+			var stepErr *StepError
+			if !errors.As(err, &stepErr) {
+				t.Errorf("error %v is a %T, want a *StepError", err, err)
+			}
+			if len(tr.Steps) != 0 || tr.Doc != doc {
+				t.Error("failed step changed the transform")
+			}
+			if toJSON(t, doc) != before {
+				t.Error("failed step changed the document")
+			}
+		})
+	}
+}
+
+func TestStepFromJSON(t *testing.T) {
+	tests := []struct {
+		name    string
+		data    string
+		wantErr bool
+	}{
+		{name: "replace", data: `{"stepType":"replace","from":1,"to":2,"slice":{"content":[{"type":"text","text":"a"}]}}`},
+		{name: "structure replace", data: `{"stepType":"replace","from":1,"to":2,"structure":true}`},
+		{name: "replace around", data: `{"stepType":"replaceAround","from":0,"to":13,"gapFrom":0,"gapTo":13,"insert":1,"slice":{"content":[{"type":"blockquote"}]},"structure":true}`},
+		{name: "add mark", data: `{"stepType":"addMark","from":1,"to":2,"mark":{"type":"link","attrs":{"href":"https://example.com","target":null,"rel":null,"class":null}}}`},
+		{name: "remove node mark", data: `{"stepType":"removeNodeMark","pos":0,"mark":{"type":"bold"}}`},
+		{name: "attr", data: `{"stepType":"attr","pos":0,"attr":"level","value":3}`},
+		{name: "doc attr", data: `{"stepType":"docAttr","attr":"lang","value":"en"}`},
+		{name: "malformed", data: `{"stepType":`, wantErr: true},
+		{name: "missing type", data: `{"from":1,"to":2}`, wantErr: true},
+		{name: "unknown type", data: `{"stepType":"teleport","from":1}`, wantErr: true},
+		{name: "missing position", data: `{"stepType":"replace","from":1}`, wantErr: true},
+		{name: "negative position", data: `{"stepType":"replace","from":-1,"to":2}`, wantErr: true},
+		{name: "missing mark", data: `{"stepType":"addMark","from":1,"to":2}`, wantErr: true},
+		{name: "unknown mark", data: `{"stepType":"addMark","from":1,"to":2,"mark":{"type":"blink"}}`, wantErr: true},
+		{name: "unknown node in slice", data: `{"stepType":"replace","from":1,"to":2,"slice":{"content":[{"type":"marquee"}]}}`, wantErr: true},
+		{name: "missing attr", data: `{"stepType":"attr","pos":0,"value":1}`, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			step, err := StepFromJSON(schema, []byte(tt.data))
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("parsed %s, want an error", tt.data)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("parse: %v", err)
+			}
+			// The JSON form survives a round trip through the step
+			var got, want any
+			json.Unmarshal([]byte(toJSON(t, step)), &got)
+			json.Unmarshal([]byte(tt.data), &want)
+			if toJSON(t, got) != toJSON(t, want) {
+				t.Errorf("round trip gave %s, want %s", toJSON(t, got), toJSON(t, want))
+			}
+		})
+	}
+}
+
+func TestStepMapMapResult(t *testing.T) {
+	// 2..5 deleted, then 3 inserted at 8 in the original document
+	deletion := NewStepMap(2, 3, 0)
+	insertion := NewStepMap(8, 0, 3)
+
+	tests := []struct {
+		name        string
+		m           Mappable
+		pos, assoc  int
+		want        int
+		deleted     bool
+		deletedAcro bool
+	}{
+		{name: "before a deletion", m: deletion, pos: 1, assoc: 1, want: 1},
+		{name: "at the start of a deletion", m: deletion, pos: 2, assoc: 1, want: 2, deleted: true},
+		{name: "inside a deletion", m: deletion, pos: 3, assoc: 1, want: 2, deleted: true, deletedAcro: true},
+		{name: "at the end of a deletion", m: deletion, pos: 5, assoc: -1, want: 2, deleted: true},
+		{name: "after a deletion", m: deletion, pos: 7, assoc: 1, want: 4},
+		{name: "insertion point sticking left", m: insertion, pos: 8, assoc: -1, want: 8},
+		{name: "insertion point sticking right", m: insertion, pos: 8, assoc: 1, want: 11},
+		{name: "after an insertion", m: insertion, pos: 9, assoc: 1, want: 12},
+		{name: "mapping through both", m: &Mapping{Maps: []*StepMap{deletion, NewStepMap(5, 0, 3)}}, pos: 8, assoc: 1, want: 8},
+		{name: "empty map", m: EmptyStepMap, pos: 4, assoc: -1, want: 4},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result := tt.m.MapResult(tt.pos, tt.assoc)
+			if result.Pos != tt.want {
+				t.Errorf("MapResult(%d, %d).Pos = %d, want %d", tt.pos, tt.assoc, result.Pos, tt.want)
+			}
+			if result.Deleted() != tt.deleted {
+				t.Errorf("Deleted() = %v, want %v", result.Deleted(), tt.deleted)
+			}
+			if result.DeletedAcross() != tt.deletedAcro {
+				t.Errorf("DeletedAcross() = %v, want %v", result.DeletedAcross(), tt.deletedAcro)
+			}
+			if got := tt.m.Map(tt.pos, tt.assoc); got != tt.want {
+				t.Errorf("Map(%d, %d) = %d, want %d", tt.pos, tt.assoc, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestStepMapThroughConcurrentChange(t *testing.T) {
+	// Two clients edit "hello world" at the same time: one inserts ", dear"
+	// after "hello", the other bolds "world"
+	doc := parseDoc(t, plainDoc)
+	insert := parseStep(t, `{"stepType":"replace","from":6,"to":6,"slice":{"content":[{"type":"text","text":", dear"}]}}`)
+	bold := parseStep(t, `{"stepType":"addMark","from":7,"to":12,"mark":{"type":"bold"}}`)
+	deleteWorld := parseStep(t, `{"stepType":"replace","from":7,"to":12}`)
+
+	tr, err := ApplySteps(doc, []Step{insert})
+	if err != nil {
+		t.Fatalf("apply: %v", err)
+	}
+
+	mapped := bold.Map(&tr.Mapping)
+	if mapped == nil {
+		t.Fatal("mark step was dropped")
+	}
+	if got := mapped.(*AddMarkStep); got.From != 13 || got.To != 18 {
+		t.Errorf("mark step mapped to %d-%d, want 13-18", got.From, got.To)
+	}
+	if err := tr.Step(mapped); err != nil {
+		t.Fatalf("apply mapped step: %v", err)
+	}
+	last := tr.Doc.Content[0].Content[len(tr.Doc.Content[0].Content)-1]
+	if last.Text != "world" || len(last.Marks) != 1 {
+		t.Errorf("last text node = %s, want bold \"world\"", toJSON(t, last))
+	}
+
+	// A mark over content deleted in the meantime has nothing left to apply to
+	deleted, err := ApplySteps(doc, []Step{deleteWorld})
+	if err != nil {
+		t.Fatalf("apply: %v", err)
+	}
+	if step := bold.Map(&deleted.Mapping); step != nil {
+		t.Errorf("mark over deleted content mapped to %s, want nil", toJSON(t, step))
+	}
+}
+
+func TestReplaceAroundStepWraps(t *testing.T) {
+	doc := parseDoc(t, plainDoc)
+	wrap := parseStep(t, `{"stepType":"replaceAround","from":0,"to":13,"gapFrom":0,"gapTo":13,"insert":1,"slice":{"content":[{"type":"blockquote"}]},"structure":true}`)
+
+	tr, err := ApplySteps(doc, []Step{wrap})
+	if err != nil {
+		t.Fatalf("apply: %v", err)
+	}
+	quote := tr.Doc.Content[0]
+	if quote.Type != "blockquote" || len(quote.Content) != 1 || quote.Content[0].Type != "paragraph" {
+		t.Fatalf("got %s, want the paragraph inside a blockquote", toJSON(t, tr.Doc))
+	}
+	// Positions inside the wrapped paragraph move by the opening token
+	if got := tr.Mapping.Map(3, 1); got != 4 {
+		t.Errorf("Map(3) = %d, want 4", got)
+	}
+	if got := tr.Mapping.Map(13, 1); got != 15 {
+		t.Errorf("Map(13) = %d, want 15", got)
+	}
+}
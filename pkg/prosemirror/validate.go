@@ -0,0 +1,324 @@
+package prosemirror
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// ValidationError reports why a document does not conform to the schema.
+// Path is a JSONPath expression pointing at the offending value.
+type ValidationError struct {
+	Path    string
+	Message string
+}
+
+func (e *ValidationError) Error() string {
+	return fmt.Sprintf("%s: %s", e.Path, e.Message)
+}
+
+func invalid(path, format string, args ...any) *ValidationError {
+	return &ValidationError{Path: path, Message: fmt.Sprintf(format, args...)}
+}
+
+// NodeFromJSON parses and validates a JSON document against the schema. The
+// returned node has the attribute defaults of the schema filled in.
+func (s *Schema) NodeFromJSON(data []byte) (*Node, error) {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+
+	var raw any
+	if err := decoder.Decode(&raw); err != nil {
+		return nil, invalid("$", "malformed JSON: %v", err)
+	}
+	if decoder.More() {
+		return nil, invalid("$", "malformed JSON: unexpected data after the document")
+	}
+
+	node, err := s.parseNode(raw, "$")
+	if err != nil {
+		return nil, err
+	}
+	if node.Type != s.TopNode {
+		return nil, invalid("$.type", "expected top node %q, got %q", s.TopNode, node.Type)
+	}
+	if err := s.check(node, "$"); err != nil {
+		return nil, err
+	}
+	return node, nil
+}
+
+// Check validates an already decoded document against the schema
+func (s *Schema) Check(doc *Node) error {
+	if doc == nil {
+		return invalid("$", "document is empty")
+	}
+	if doc.Type != s.TopNode {
+		return invalid("$.type", "expected top node %q, got %q", s.TopNode, doc.Type)
+	}
+	return s.check(doc, "$")
+}
+
+func (s *Schema) parseNode(raw any, path string) (*Node, error) {
+	obj, ok := raw.(map[string]any)
+	if !ok {
+		return nil, invalid(path, "expected a node object, got %s", jsonTypeOf(raw))
+	}
+
+	for key := range obj {
+		switch key {
+		case "type", "attrs", "content", "marks", "text":
+		default:
+			return nil, invalid(path+"."+key, "unexpected node property")
+		}
+	}
+
+	typeName, ok := obj["type"].(string)
+	if !ok {
+		return nil, invalid(path+".type", "expected a string node type, got %s", jsonTypeOf(obj["type"]))
+	}
+	nodeType, ok := s.Nodes[typeName]
+	if !ok {
+		return nil, invalid(path+".type", "unknown node type %q", typeName)
+	}
+
+	node := &Node{Type: typeName}
+
+	attrs, err := s.parseAttrs(obj["attrs"], nodeType.Spec.Attrs, path+".attrs")
+	if err != nil {
+		return nil, err
+	}
+	node.Attrs = attrs
+
+	if rawText, present := obj["text"]; present {
+		text, ok := rawText.(string)
+		if !ok {
+			return nil, invalid(path+".text", "expected a string, got %s", jsonTypeOf(rawText))
+		}
+		if !node.IsText() {
+			return nil, invalid(path+".text", "only text nodes can have text")
+		}
+		node.Text = text
+	}
+	if node.IsText() && node.Text == "" {
+		return nil, invalid(path+".text", "text nodes must have non-empty text")
+	}
+
+	if rawContent, present := obj["content"]; present {
+		items, ok := rawContent.([]any)
+		if !ok {
+			return nil, invalid(path+".content", "expected an array, got %s", jsonTypeOf(rawContent))
+		}
+		if node.IsText() && len(items) > 0 {
+			return nil, invalid(path+".content", "text nodes cannot have content")
+		}
+		for i, item := range items {
+			child, err := s.parseNode(item, fmt.Sprintf("%s.content[%d]", path, i))
+			if err != nil {
+				return nil, err
+			}
+			node.Content = append(node.Content, child)
+		}
+	}
+
+	if rawMarks, present := obj["marks"]; present {
+		items, ok := rawMarks.([]any)
+		if !ok {
+			return nil, invalid(path+".marks", "expected an array, got %s", jsonTypeOf(rawMarks))
+		}
+		for i, item := range items {
+			mark, err := s.parseMark(item, fmt.Sprintf("%s.marks[%d]", path, i))
+			if err != nil {
+				return nil, err
+			}
+			node.Marks = append(node.Marks, mark)
+		}
+	}
+
+	return node, nil
+}
+
+func (s *Schema) parseMark(raw any, path string) (*Mark, error) {
+	obj, ok := raw.(map[string]any)
+	if !ok {
+		return nil, invalid(path, "expected a mark object, got %s", jsonTypeOf(raw))
+	}
+	for key := range obj {
+		if key != "type" && key != "attrs" {
+			return nil, invalid(path+"."+key, "unexpected mark property")
+		}
+	}
+	typeName, ok := obj["type"].(string)
+	if !ok {
+		return nil, invalid(path+".type", "expected a string mark type, got %s", jsonTypeOf(obj["type"]))
+	}
+	markType, ok := s.Marks[typeName]
+	if !ok {
+		return nil, invalid(path+".type", "unknown mark type %q", typeName)
+	}
+	attrs, err := s.parseAttrs(obj["attrs"], markType.Spec.Attrs, path+".attrs")
+	if err != nil {
+		return nil, err
+	}
+	return &Mark{Type: typeName, Attrs: attrs}, nil
+}
+
+func (s *Schema) parseAttrs(raw any, specs map[string]AttrSpec, path string) (map[string]any, error) {
+	given := map[string]any{}
+	if raw != nil {
+		obj, ok := raw.(map[string]any)
+		if !ok {
+			return nil, invalid(path, "expected an object, got %s", jsonTypeOf(raw))
+		}
+		given = obj
+	}
+	return computeAttrs(given, specs, path)
+}
+
+// computeAttrs checks the given attributes against the specs and fills in the
+// defaults of missing optional attributes
+func computeAttrs(given map[string]any, specs map[string]AttrSpec, path string) (map[string]any, error) {
+	for key := range given {
+		if _, ok := specs[key]; !ok {
+			return nil, invalid(path+"."+key, "unknown attribute")
+		}
+	}
+	if len(specs) == 0 {
+		return nil, nil
+	}
+
+	attrs := make(map[string]any, len(specs))
+	for _, key := range sortedKeys(specs) {
+		spec := specs[key]
+		value, present := given[key]
+		if !present {
+			if !spec.HasDefault {
+				return nil, invalid(path+"."+key, "missing required attribute")
+			}
+			attrs[key] = copyValue(spec.Default)
+			continue
+		}
+		value = normalizeNumbers(value)
+		if spec.Validate != "" && !matchesJSONType(value, spec.Validate) {
+			return nil, invalid(path+"."+key, "expected %s, got %s", spec.Validate, jsonTypeOf(value))
+		}
+		attrs[key] = value
+	}
+	return attrs, nil
+}
+
+// check verifies content expressions and mark placement of an already parsed tree
+func (s *Schema) check(node *Node, path string) error {
+	nodeType, ok := s.Nodes[node.Type]
+	if !ok {
+		return invalid(path+".type", "unknown node type %q", node.Type)
+	}
+	if node.IsText() && node.Text == "" {
+		return invalid(path+".text", "text nodes must have non-empty text")
+	}
+	if !node.IsText() && node.Text != "" {
+		return invalid(path+".text", "only text nodes can have text")
+	}
+	if _, err := computeAttrs(node.Attrs, nodeType.Spec.Attrs, path+".attrs"); err != nil {
+		return err
+	}
+	for i, mark := range node.Marks {
+		if _, ok := s.Marks[mark.Type]; !ok {
+			return invalid(fmt.Sprintf("%s.marks[%d].type", path, i), "unknown mark type %q", mark.Type)
+		}
+	}
+
+	types := make([]string, len(node.Content))
+	for i, child := range node.Content {
+		types[i] = child.Type
+	}
+	if failed := nodeType.content.match(types); failed != -1 {
+		if failed == len(types) {
+			return invalid(path+".content", "incomplete content for %q, expected one of [%s]",
+				node.Type, strings.Join(nodeType.content.expected(types), ", "))
+		}
+		if nodeType.IsLeaf() {
+			return invalid(path+".content", "%q nodes cannot have content", node.Type)
+		}
+		return invalid(fmt.Sprintf("%s.content[%d]", path, failed), "node %q is not allowed here in %q (content: %q)",
+			types[failed], node.Type, nodeType.content.source)
+	}
+
+	for i, child := range node.Content {
+		childPath := fmt.Sprintf("%s.content[%d]", path, i)
+		seen := map[string]bool{}
+		for j, mark := range child.Marks {
+			markPath := fmt.Sprintf("%s.marks[%d]", childPath, j)
+			markType := s.Marks[mark.Type]
+			if !nodeType.AllowsMark(markType) {
+				return invalid(markPath, "mark %q is not allowed in %q", mark.Type, node.Type)
+			}
+			if seen[mark.Type] {
+				return invalid(markPath, "duplicate mark %q", mark.Type)
+			}
+			seen[mark.Type] = true
+			if _, err := computeAttrs(mark.Attrs, markType.Spec.Attrs, markPath+".attrs"); err != nil {
+				return err
+			}
+		}
+		if err := s.check(child, childPath); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// normalizeNumbers converts json.Number values into float64 so attributes
+// compare and encode the same way regardless of how they were decoded
+func normalizeNumbers(v any) any {
+	switch val := v.(type) {
+	case json.Number:
+		f, err := val.Float64()
+		if err != nil {
+			return val.String()
+		}
+		return f
+	case map[string]any:
+		for k, item := range val {
+			val[k] = normalizeNumbers(item)
+		}
+		return val
+	case []any:
+		for i, item := range val {
+			val[i] = normalizeNumbers(item)
+		}
+		return val
+	default:
+		return v
+	}
+}
+
+func matchesJSONType(value any, validate string) bool {
+	actual := jsonTypeOf(value)
+	for _, allowed := range strings.Split(validate, "|") {
+		if strings.TrimSpace(allowed) == actual {
+			return true
+		}
+	}
+	return false
+}
+
+func jsonTypeOf(v any) string {
+	switch v.(type) {
+	case nil:
+		return "null"
+	case string:
+		return "string"
+	case bool:
+		return "boolean"
+	case float64, float32, int, int64, json.Number:
+		return "number"
+	case []any:
+		return "array"
+	case map[string]any:
+		return "object"
+	default:
+		return fmt.Sprintf("%T", v)
+	}
+}
@@ -0,0 +1,37 @@
+package prosemirror
+
+// Warning reports input a parser dropped or simplified because the schema
+// cannot represent it
+type Warning struct {
+	// Construct names what was met, like "<iframe>" or "footnote"
+	Construct string `json:"construct"`
+	Message   string `json:"message"`
+	// Count is the number of times the construct was met
+	Count int `json:"count"`
+}
+
+// Warnings collects parser warnings, listing every construct once in the
+// order it was first met
+type Warnings []Warning
+
+// Add records an occurrence of construct
+func (w *Warnings) Add(construct, message string) {
+	w.add(Warning{Construct: construct, Message: message, Count: 1})
+}
+
+// Merge adds the warnings of another parser
+func (w *Warnings) Merge(other Warnings) {
+	for _, warning := range other {
+		w.add(warning)
+	}
+}
+
+func (w *Warnings) add(warning Warning) {
+	for i := range *w {
+		if (*w)[i].Construct == warning.Construct {
+			(*w)[i].Count += warning.Count
+			return
+		}
+	}
+	*w = append(*w, warning)
+}
@@ -0,0 +1,239 @@
+package yjs
+
+import (
+	"encoding/binary"
+	"errors"
+	"math"
+	"unicode/utf8"
+)
+
+// ErrUnexpectedEOF is returned when an update ends in the middle of a value
+var ErrUnexpectedEOF = errors.New("yjs: unexpected end of update")
+
+// decoder reads the lib0 encoding used by Yjs
+type decoder struct {
+	buf []byte
+	pos int
+}
+
+func (d *decoder) hasContent() bool {
+	return d.pos < len(d.buf)
+}
+
+func (d *decoder) readUint8() (byte, error) {
+	if d.pos >= len(d.buf) {
+		return 0, ErrUnexpectedEOF
+	}
+	b := d.buf[d.pos]
+	d.pos++
+	return b, nil
+}
+
+func (d *decoder) readBytes(n uint64) ([]byte, error) {
+	if n > uint64(len(d.buf)-d.pos) {
+		return nil, ErrUnexpectedEOF
+	}
+	b := d.buf[d.pos : d.pos+int(n)]
+	d.pos += int(n)
+	return b, nil
+}
+
+// readVarUint reads an unsigned integer stored 7 bits per byte, least
+// significant group first
+func (d *decoder) readVarUint() (uint64, error) {
+	var num uint64
+	var shift uint
+	for {
+		b, err := d.readUint8()
+		if err != nil {
+			return 0, err
+		}
+		num |= uint64(b&0x7f) << shift
+		if b < 0x80 {
+			return num, nil
+		}
+		shift += 7
+		if shift > 63 {
+			return 0, errors.New("yjs: varuint overflow")
+		}
+	}
+}
+
+// readVarInt reads a signed integer whose first byte holds a sign bit and
+// six value bits
+func (d *decoder) readVarInt() (int64, error) {
+	b, err := d.readUint8()
+	if err != nil {
+		return 0, err
+	}
+	num := int64(b & 0x3f)
+	negative := b&0x40 > 0
+	shift := uint(6)
+	for b&0x80 > 0 {
+		if b, err = d.readUint8(); err != nil {
+			return 0, err
+		}
+		num |= int64(b&0x7f) << shift
+		shift += 7
+		if shift > 63 {
+			return 0, errors.New("yjs: varint overflow")
+		}
+	}
+	if negative {
+		num = -num
+	}
+	return num, nil
+}
+
+func (d *decoder) readVarString() (string, error) {
+	n, err := d.readVarUint()
+	if err != nil {
+		return "", err
+	}
+	b, err := d.readBytes(n)
+	if err != nil {
+		return "", err
+	}
+	if !utf8.Valid(b) {
+		return "", errors.New("yjs: invalid utf-8 string")
+	}
+	return string(b), nil
+}
+
+func (d *decoder) readVarUint8Array() ([]byte, error) {
+	n, err := d.readVarUint()
+	if err != nil {
+		return nil, err
+	}
+	return d.readBytes(n)
+}
+
+// Tags of the lib0 "any" encoding
+const (
+	anyUndefined = 127 - iota
+	anyNull
+	anyInteger
+	anyFloat32
+	anyFloat64
+	anyBigInt
+	anyFalse
+	anyTrue
+	anyString
+	anyObject
+	anyArray
+	anyBytes
+)
+
+// readAny decodes a value of the lib0 "any" encoding. Objects decode to
+// map[string]any, arrays to []any and numbers to int64 or float64.
+func (d *decoder) readAny() (any, error) {
+	tag, err := d.readUint8()
+	if err != nil {
+		return nil, err
+	}
+	switch tag {
+	case anyUndefined, anyNull:
+		return nil, nil
+	case anyInteger:
+		return d.readVarInt()
+	case anyFloat32:
+		b, err := d.readBytes(4)
+		if err != nil {
+			return nil, err
+		}
+		return float64(math.Float32frombits(binary.BigEndian.Uint32(b))), nil
+	case anyFloat64:
+		b, err := d.readBytes(8)
+		if err != nil {
+			return nil, err
+		}
+		return math.Float64frombits(binary.BigEndian.Uint64(b)), nil
+	case anyBigInt:
+		b, err := d.readBytes(8)
+		if err != nil {
+			return nil, err
+		}
+		return int64(binary.BigEndian.Uint64(b)), nil
+	case anyFalse:
+		return false, nil
+	case anyTrue:
+		return true, nil
+	case anyString:
+		return d.readVarString()
+	case anyObject:
+		n, err := d.readVarUint()
+		if err != nil {
+			return nil, err
+		}
+		obj := make(map[string]any)
+		for i := uint64(0); i < n; i++ {
+			key, err := d.readVarString()
+			if err != nil {
+				return nil, err
+			}
+			if obj[key], err = d.readAny(); err != nil {
+				return nil, err
+			}
+		}
+		return obj, nil
+	case anyArray:
+		n, err := d.readVarUint()
+		if err != nil {
+			return nil, err
+		}
+		arr := make([]any, 0, min(n, 1024))
+		for i := uint64(0); i < n; i++ {
+			value, err := d.readAny()
+			if err != nil {
+				return nil, err
+			}
+			arr = append(arr, value)
+		}
+		return arr, nil
+	case anyBytes:
+		return d.readVarUint8Array()
+	default:
+		return nil, errors.New("yjs: unknown value type")
+	}
+}
+
+// skipAny advances past a value of the lib0 "any" encoding and returns its
+// raw bytes
+func (d *decoder) skipAny() ([]byte, error) {
+	start := d.pos
+	if _, err := d.readAny(); err != nil {
+		return nil, err
+	}
+	return d.buf[start:d.pos], nil
+}
+
+// encoder writes the lib0 encoding used by Yjs
+type encoder struct {
+	buf []byte
+}
+
+func (e *encoder) writeUint8(b byte) {
+	e.buf = append(e.buf, b)
+}
+
+func (e *encoder) writeVarUint(num uint64) {
+	for num > 0x7f {
+		e.buf = append(e.buf, byte(num&0x7f)|0x80)
+		num >>= 7
+	}
+	e.buf = append(e.buf, byte(num))
+}
+
+func (e *encoder) writeVarString(s string) {
+	e.writeVarUint(uint64(len(s)))
+	e.buf = append(e.buf, s...)
+}
+
+func (e *encoder) writeVarUint8Array(b []byte) {
+	e.writeVarUint(uint64(len(b)))
+	e.buf = append(e.buf, b...)
+}
+
+func (e *encoder) writeRaw(b []byte) {
+	e.buf = append(e.buf, b...)
+}
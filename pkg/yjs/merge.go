@@ -0,0 +1,182 @@
+package yjs
+
+import (
+	"errors"
+	"sort"
+)
+
+// StateVector maps each client to the next clock expected from it
+type StateVector map[uint64]uint64
+
+// DecodeStateVector decodes a state vector as encoded by Y.encodeStateVector
+func DecodeStateVector(data []byte) (StateVector, error) {
+	d := &decoder{buf: data}
+	sv := StateVector{}
+	n, err := d.readVarUint()
+	if err != nil {
+		return nil, err
+	}
+	for i := uint64(0); i < n; i++ {
+		client, err := d.readVarUint()
+		if err != nil {
+			return nil, err
+		}
+		clock, err := d.readVarUint()
+		if err != nil {
+			return nil, err
+		}
+		sv[client] = clock
+	}
+	if d.hasContent() {
+		return nil, errors.New("yjs: unexpected data after the state vector")
+	}
+	return sv, nil
+}
+
+// Encode writes the state vector in the encoding of Y.encodeStateVector
+func (sv StateVector) Encode() []byte {
+	clients := make([]uint64, 0, len(sv))
+	for client := range sv {
+		clients = append(clients, client)
+	}
+	sort.Slice(clients, func(i, j int) bool { return clients[i] > clients[j] })
+
+	e := &encoder{}
+	e.writeVarUint(uint64(len(clients)))
+	for _, client := range clients {
+		e.writeVarUint(client)
+		e.writeVarUint(sv[client])
+	}
+	return e.buf
+}
+
+// StateVector computes the state vector of the update. Only ranges starting
+// at clock zero without holes count, like Y.encodeStateVectorFromUpdate.
+func (u *Update) StateVector() StateVector {
+	sv := StateVector{}
+	for client, structs := range u.Structs {
+		var clock uint64
+		for _, s := range structs {
+			if s.IsSkip() || s.ID.Clock > clock {
+				break
+			}
+			clock = max(clock, s.End())
+		}
+		if clock > 0 {
+			sv[client] = clock
+		}
+	}
+	return sv
+}
+
+// MergeUpdates combines updates into a single update, like Y.mergeUpdates.
+// Overlapping structs are deduplicated and missing ranges are marked with
+// skip structs.
+func MergeUpdates(updates ...*Update) *Update {
+	merged := &Update{Structs: map[uint64][]*Struct{}, Deletes: DeleteSet{}}
+
+	all := map[uint64][]*Struct{}
+	for _, update := range updates {
+		for client, structs := range update.Structs {
+			for _, s := range structs {
+				// Skips carry no information, gaps are recomputed below
+				if !s.IsSkip() {
+					all[client] = append(all[client], s)
+				}
+			}
+		}
+		for client, ranges := range update.Deletes {
+			merged.Deletes[client] = append(merged.Deletes[client], ranges...)
+		}
+	}
+
+	for client, structs := range all {
+		sortStructs(structs)
+		var result []*Struct
+		var end uint64
+		for _, s := range structs {
+			switch {
+			case len(result) == 0:
+			case s.End() <= end:
+				continue
+			case s.ID.Clock < end:
+				s = s.slice(end - s.ID.Clock)
+			case s.ID.Clock > end:
+				result = append(result, &Struct{
+					ID:     ID{Client: client, Clock: end},
+					Length: s.ID.Clock - end,
+					Info:   refSkip,
+				})
+			}
+			result = append(result, s)
+			end = s.End()
+		}
+		merged.Structs[client] = result
+	}
+
+	for client, ranges := range merged.Deletes {
+		merged.Deletes[client] = mergeRanges(ranges)
+	}
+	return merged
+}
+
+// Diff returns the part of the update a peer at state vector sv is missing,
+// like Y.diffUpdate. The delete set is always included in full.
+func (u *Update) Diff(sv StateVector) *Update {
+	diff := &Update{Structs: map[uint64][]*Struct{}, Deletes: u.Deletes}
+	for client, structs := range u.Structs {
+		known := sv[client]
+		var result []*Struct
+		for _, s := range structs {
+			if s.End() <= known {
+				continue
+			}
+			if s.ID.Clock < known {
+				s = s.slice(known - s.ID.Clock)
+			}
+			result = append(result, s)
+		}
+		// Updates never start with a skip
+		for len(result) > 0 && result[0].IsSkip() {
+			result = result[1:]
+		}
+		if len(result) > 0 {
+			diff.Structs[client] = result
+		}
+	}
+	return diff
+}
+
+// mergeRanges sorts delete ranges and joins overlapping and adjacent ones
+func mergeRanges(ranges []DeleteRange) []DeleteRange {
+	sort.Slice(ranges, func(i, j int) bool { return ranges[i].Clock < ranges[j].Clock })
+	var result []DeleteRange
+	for _, r := range ranges {
+		if r.Length == 0 {
+			continue
+		}
+		if n := len(result); n > 0 && r.Clock <= result[n-1].Clock+result[n-1].Length {
+			last := &result[n-1]
+			last.Length = max(last.Length, r.Clock+r.Length-last.Clock)
+			continue
+		}
+		result = append(result, r)
+	}
+	return result
+}
+
+// Merge decodes, merges and re-encodes updates
+func Merge(updates ...[]byte) ([]byte, error) {
+	decoded := make([]*Update, 0, len(updates))
+	for _, data := range updates {
+		update, err := DecodeUpdate(data)
+		if err != nil {
+			return nil, err
+		}
+		decoded = append(decoded, update)
+	}
+	return MergeUpdates(decoded...).Encode(), nil
+}
+
+// EmptyUpdate is the encoding of an update without changes
+var EmptyUpdate = []byte{0, 0}
@@ -0,0 +1,476 @@
+// Package yjs reads and writes Yjs document updates (the v1 update encoding)
+// so that updates can be stored, merged and diffed against state vectors
+// without integrating them into a document.
+package yjs
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"unicode/utf16"
+)
+
+// ID identifies a struct by the client that created it and a logical clock
+type ID struct {
+	Client uint64
+	Clock  uint64
+}
+
+// Info byte flags of an item
+const (
+	flagParentSub   = 0x20
+	flagRightOrigin = 0x40
+	flagOrigin      = 0x80
+	contentRefMask  = 0x1f
+)
+
+// Content references of the v1 encoding
+const (
+	refGC      = 0
+	refDeleted = 1
+	refJSON    = 2
+	refBinary  = 3
+	refString  = 4
+	refEmbed   = 5
+	refFormat  = 6
+	refType    = 7
+	refAny     = 8
+	refDoc     = 9
+	refSkip    = 10
+)
+
+// Struct is a run of Length clock values of a client: an item carrying
+// content, a garbage collected range or a skipped range
+type Struct struct {
+	ID     ID
+	Length uint64
+	// Info holds the content reference and the item flags as encoded
+	Info byte
+
+	Origin      *ID
+	RightOrigin *ID
+	// ParentKey names the root type holding the item, ParentID points to the
+	// item of the type holding it. They are only present when the item has
+	// neither origin.
+	ParentKey *string
+	ParentID  *ID
+	ParentSub *string
+
+	content itemContent
+}
+
+// itemContent keeps item content in its encoded form, split into elements
+// for the content types that can be sliced
+type itemContent struct {
+	// str is the content of string items
+	str string
+	// elements are the encoded elements of JSON and any items
+	elements [][]byte
+	// raw is the encoded content of items that cannot be sliced
+	raw []byte
+}
+
+// IsSkip reports whether the struct stands for a missing range
+func (s *Struct) IsSkip() bool { return s.Info&contentRefMask == refSkip }
+
+// IsGC reports whether the struct is a garbage collected range
+func (s *Struct) IsGC() bool { return s.Info&contentRefMask == refGC }
+
+// IsItem reports whether the struct is an item
+func (s *Struct) IsItem() bool { return !s.IsSkip() && !s.IsGC() }
+
+// ContentRef is the type of the item content
+func (s *Struct) ContentRef() byte { return s.Info & contentRefMask }
+
+// End is the clock following the struct
+func (s *Struct) End() uint64 { return s.ID.Clock + s.Length }
+
+// DeleteSet lists deleted clock ranges per client
+type DeleteSet map[uint64][]DeleteRange
+
+// DeleteRange is a deleted range of clocks of a client
+type DeleteRange struct {
+	Clock  uint64
+	Length uint64
+}
+
+// Update is a decoded Yjs update
+type Update struct {
+	// Structs are grouped by client and sorted by clock
+	Structs map[uint64][]*Struct
+	Deletes DeleteSet
+}
+
+// DecodeUpdate decodes a Yjs update in the v1 encoding
+func DecodeUpdate(data []byte) (*Update, error) {
+	d := &decoder{buf: data}
+	update := &Update{Structs: map[uint64][]*Struct{}, Deletes: DeleteSet{}}
+
+	numClients, err := d.readVarUint()
+	if err != nil {
+		return nil, err
+	}
+	for i := uint64(0); i < numClients; i++ {
+		numStructs, err := d.readVarUint()
+		if err != nil {
+			return nil, err
+		}
+		client, err := d.readVarUint()
+		if err != nil {
+			return nil, err
+		}
+		clock, err := d.readVarUint()
+		if err != nil {
+			return nil, err
+		}
+		for j := uint64(0); j < numStructs; j++ {
+			s, err := readStruct(d, ID{Client: client, Clock: clock})
+			if err != nil {
+				return nil, err
+			}
+			update.Structs[client] = append(update.Structs[client], s)
+			clock += s.Length
+		}
+	}
+
+	if update.Deletes, err = readDeleteSet(d); err != nil {
+		return nil, err
+	}
+	if d.hasContent() {
+		return nil, errors.New("yjs: unexpected data after the update")
+	}
+	for client := range update.Structs {
+		sortStructs(update.Structs[client])
+	}
+	return update, nil
+}
+
+func readID(d *decoder) (*ID, error) {
+	client, err := d.readVarUint()
+	if err != nil {
+		return nil, err
+	}
+	clock, err := d.readVarUint()
+	if err != nil {
+		return nil, err
+	}
+	return &ID{Client: client, Clock: clock}, nil
+}
+
+func readStruct(d *decoder, id ID) (*Struct, error) {
+	info, err := d.readUint8()
+	if err != nil {
+		return nil, err
+	}
+	s := &Struct{ID: id, Info: info}
+
+	switch info & contentRefMask {
+	case refGC, refSkip:
+		if s.Length, err = d.readVarUint(); err != nil {
+			return nil, err
+		}
+		if s.Length == 0 {
+			return nil, errors.New("yjs: empty struct")
+		}
+		return s, nil
+	}
+
+	if info&flagOrigin > 0 {
+		if s.Origin, err = readID(d); err != nil {
+			return nil, err
+		}
+	}
+	if info&flagRightOrigin > 0 {
+		if s.RightOrigin, err = readID(d); err != nil {
+			return nil, err
+		}
+	}
+	if info&(flagOrigin|flagRightOrigin) == 0 {
+		isKey, err := d.readVarUint()
+		if err != nil {
+			return nil, err
+		}
+		if isKey == 1 {
+			key, err := d.readVarString()
+			if err != nil {
+				return nil, err
+			}
+			s.ParentKey = &key
+		} else if s.ParentID, err = readID(d); err != nil {
+			return nil, err
+		}
+		if info&flagParentSub > 0 {
+			sub, err := d.readVarString()
+			if err != nil {
+				return nil, err
+			}
+			s.ParentSub = &sub
+		}
+	}
+
+	if err := readContent(d, s); err != nil {
+		return nil, err
+	}
+	if s.Length == 0 {
+		return nil, errors.New("yjs: empty struct")
+	}
+	return s, nil
+}
+
+func readContent(d *decoder, s *Struct) error {
+	start := d.pos
+	switch s.ContentRef() {
+	case refDeleted:
+		n, err := d.readVarUint()
+		if err != nil {
+			return err
+		}
+		s.Length = n
+		return nil
+	case refJSON:
+		n, err := d.readVarUint()
+		if err != nil {
+			return err
+		}
+		for i := uint64(0); i < n; i++ {
+			elementStart := d.pos
+			if _, err := d.readVarString(); err != nil {
+				return err
+			}
+			s.content.elements = append(s.content.elements, d.buf[elementStart:d.pos])
+		}
+		s.Length = n
+		return nil
+	case refAny:
+		n, err := d.readVarUint()
+		if err != nil {
+			return err
+		}
+		for i := uint64(0); i < n; i++ {
+			element, err := d.skipAny()
+			if err != nil {
+				return err
+			}
+			s.content.elements = append(s.content.elements, element)
+		}
+		s.Length = n
+		return nil
+	case refString:
+		str, err := d.readVarString()
+		if err != nil {
+			return err
+		}
+		s.content.str = str
+		s.Length = uint64(len(utf16.Encode([]rune(str))))
+		return nil
+	case refBinary:
+		if _, err := d.readVarUint8Array(); err != nil {
+			return err
+		}
+	case refEmbed:
+		if _, err := d.readVarString(); err != nil {
+			return err
+		}
+	case refFormat:
+		if _, err := d.readVarString(); err != nil {
+			return err
+		}
+		if _, err := d.readVarString(); err != nil {
+			return err
+		}
+	case refType:
+		typeRef, err := d.readVarUint()
+		if err != nil {
+			return err
+		}
+		// XmlElement and XmlHook carry a name
+		if typeRef == 3 || typeRef == 5 {
+			if _, err := d.readVarString(); err != nil {
+				return err
+			}
+		}
+	case refDoc:
+		if _, err := d.readVarString(); err != nil {
+			return err
+		}
+		if _, err := d.readAny(); err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("yjs: unknown content type %d", s.ContentRef())
+	}
+	s.content.raw = d.buf[start:d.pos]
+	s.Length = 1
+	return nil
+}
+
+func readDeleteSet(d *decoder) (DeleteSet, error) {
+	ds := DeleteSet{}
+	numClients, err := d.readVarUint()
+	if err != nil {
+		return nil, err
+	}
+	for i := uint64(0); i < numClients; i++ {
+		client, err := d.readVarUint()
+		if err != nil {
+			return nil, err
+		}
+		numRanges, err := d.readVarUint()
+		if err != nil {
+			return nil, err
+		}
+		for j := uint64(0); j < numRanges; j++ {
+			clock, err := d.readVarUint()
+			if err != nil {
+				return nil, err
+			}
+			length, err := d.readVarUint()
+			if err != nil {
+				return nil, err
+			}
+			ds[client] = append(ds[client], DeleteRange{Clock: clock, Length: length})
+		}
+	}
+	return ds, nil
+}
+
+// Encode writes the update in the v1 encoding
+func (u *Update) Encode() []byte {
+	e := &encoder{}
+
+	clients := make([]uint64, 0, len(u.Structs))
+	for client, structs := range u.Structs {
+		if len(structs) > 0 {
+			clients = append(clients, client)
+		}
+	}
+	// Yjs writes higher client ids first
+	sort.Slice(clients, func(i, j int) bool { return clients[i] > clients[j] })
+
+	e.writeVarUint(uint64(len(clients)))
+	for _, client := range clients {
+		structs := u.Structs[client]
+		e.writeVarUint(uint64(len(structs)))
+		e.writeVarUint(client)
+		e.writeVarUint(structs[0].ID.Clock)
+		for _, s := range structs {
+			writeStruct(e, s)
+		}
+	}
+
+	writeDeleteSet(e, u.Deletes)
+	return e.buf
+}
+
+func writeStruct(e *encoder, s *Struct) {
+	e.writeUint8(s.Info)
+	if !s.IsItem() {
+		e.writeVarUint(s.Length)
+		return
+	}
+	if s.Origin != nil {
+		e.writeVarUint(s.Origin.Client)
+		e.writeVarUint(s.Origin.Clock)
+	}
+	if s.RightOrigin != nil {
+		e.writeVarUint(s.RightOrigin.Client)
+		e.writeVarUint(s.RightOrigin.Clock)
+	}
+	if s.Origin == nil && s.RightOrigin == nil {
+		if s.ParentKey != nil {
+			e.writeVarUint(1)
+			e.writeVarString(*s.ParentKey)
+		} else {
+			e.writeVarUint(0)
+			e.writeVarUint(s.ParentID.Client)
+			e.writeVarUint(s.ParentID.Clock)
+		}
+		if s.ParentSub != nil {
+			e.writeVarString(*s.ParentSub)
+		}
+	}
+
+	switch s.ContentRef() {
+	case refDeleted:
+		e.writeVarUint(s.Length)
+	case refJSON, refAny:
+		e.writeVarUint(uint64(len(s.content.elements)))
+		for _, element := range s.content.elements {
+			e.writeRaw(element)
+		}
+	case refString:
+		e.writeVarString(s.content.str)
+	default:
+		e.writeRaw(s.content.raw)
+	}
+}
+
+func writeDeleteSet(e *encoder, ds DeleteSet) {
+	clients := make([]uint64, 0, len(ds))
+	for client, ranges := range ds {
+		if len(ranges) > 0 {
+			clients = append(clients, client)
+		}
+	}
+	sort.Slice(clients, func(i, j int) bool { return clients[i] > clients[j] })
+
+	e.writeVarUint(uint64(len(clients)))
+	for _, client := range clients {
+		ranges := ds[client]
+		e.writeVarUint(client)
+		e.writeVarUint(uint64(len(ranges)))
+		for _, r := range ranges {
+			e.writeVarUint(r.Clock)
+			e.writeVarUint(r.Length)
+		}
+	}
+}
+
+// slice returns the part of the struct starting diff clocks into it. Items
+// sliced this way get the clock before the cut as their origin, as
+// sliceStruct does in Yjs.
+func (s *Struct) slice(diff uint64) *Struct {
+	if diff == 0 {
+		return s
+	}
+	id := ID{Client: s.ID.Client, Clock: s.ID.Clock + diff}
+	if !s.IsItem() {
+		return &Struct{ID: id, Length: s.Length - diff, Info: s.Info}
+	}
+
+	sliced := &Struct{
+		ID:          id,
+		Length:      s.Length - diff,
+		Info:        s.Info | flagOrigin,
+		Origin:      &ID{Client: s.ID.Client, Clock: s.ID.Clock + diff - 1},
+		RightOrigin: s.RightOrigin,
+		ParentKey:   s.ParentKey,
+		ParentID:    s.ParentID,
+		ParentSub:   s.ParentSub,
+	}
+	switch s.ContentRef() {
+	case refJSON, refAny:
+		sliced.content.elements = s.content.elements[diff:]
+	case refString:
+		sliced.content.str = sliceString(s.content.str, diff)
+	}
+	return sliced
+}
+
+// sliceString drops the first n UTF-16 code units of str. A surrogate pair
+// cut in half is replaced by U+FFFD, which is how the JavaScript encoder
+// writes lone surrogates.
+func sliceString(str string, n uint64) string {
+	units := utf16.Encode([]rune(str))
+	return string(utf16.Decode(units[n:]))
+}
+
+// sortStructs orders structs by clock, longer structs first on ties
+func sortStructs(structs []*Struct) {
+	sort.SliceStable(structs, func(i, j int) bool {
+		if structs[i].ID.Clock != structs[j].ID.Clock {
+			return structs[i].ID.Clock < structs[j].ID.Clock
+		}
+		return structs[i].Length > structs[j].Length
+	})
+}
@@ -0,0 +1,331 @@
+package yjs
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+// Updates as encoded by Yjs. Client 1 types "abc" into the root text type
+// "text", then appends "de" and "f", and deletes "b".
+var (
+	insertABC = []byte{1, 1, 1, 0, 4, 1, 4, 't', 'e', 'x', 't', 3, 'a', 'b', 'c', 0}
+	insertDE  = []byte{1, 1, 1, 3, 0x84, 1, 2, 2, 'd', 'e', 0}
+	insertF   = []byte{1, 1, 1, 5, 0x84, 1, 4, 1, 'f', 0}
+	deleteB   = []byte{0, 1, 1, 1, 1, 1}
+	// insertABCDE inserts "abcde" in a single item
+	insertABCDE = []byte{1, 1, 1, 0, 4, 1, 4, 't', 'e', 'x', 't', 5, 'a', 'b', 'c', 'd', 'e', 0}
+	// setMapKey is client 2 setting "k" to 1 in the root map "map"
+	setMapKey = []byte{1, 1, 2, 0, 0x28, 1, 3, 'm', 'a', 'p', 1, 'k', 1, 0x7d, 1, 0}
+	// bigClient is client 300, whose id takes two bytes, inserting "x"
+	bigClient = []byte{1, 1, 0xac, 2, 0, 4, 1, 4, 't', 'e', 'x', 't', 1, 'x', 0}
+	// emoji is a surrogate pair, two clocks long
+	emoji = []byte{1, 1, 1, 0, 4, 1, 4, 't', 'e', 'x', 't', 4, 0xf0, 0x9f, 0x98, 0x80, 0}
+)
+
+// concat joins byte slices without aliasing the vectors above
+func concat(parts ...[]byte) []byte {
+	var out []byte
+	for _, part := range parts {
+		out = append(out, part...)
+	}
+	return out
+}
+
+func TestDecodeEncodeRoundTrip(t *testing.T) {
+	tests := []struct {
+		name    string
+		data    []byte
+		clients map[uint64]int
+		sv      StateVector
+	}{
+		{"empty update", EmptyUpdate, map[uint64]int{}, StateVector{}},
+		{"root text insert", insertABC, map[uint64]int{1: 1}, StateVector{1: 3}},
+		{"insert after origin", insertDE, map[uint64]int{1: 1}, StateVector{}},
+		{"delete set only", deleteB, map[uint64]int{}, StateVector{}},
+		{"map key with any content", setMapKey, map[uint64]int{2: 1}, StateVector{2: 1}},
+		{"multi-byte client id", bigClient, map[uint64]int{300: 1}, StateVector{300: 1}},
+		{"surrogate pair", emoji, map[uint64]int{1: 1}, StateVector{1: 2}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			update, err := DecodeUpdate(tt.data)
+			if err != nil {
+				t.Fatalf("DecodeUpdate: %v", err)
+			}
+			clients := map[uint64]int{}
+			for client, structs := range update.Structs {
+				clients[client] = len(structs)
+			}
+			if !reflect.DeepEqual(clients, tt.clients) {
+				t.Errorf("structs per client = %v, want %v", clients, tt.clients)
+			}
+			if sv := update.StateVector(); !reflect.DeepEqual(sv, tt.sv) {
+				t.Errorf("StateVector() = %v, want %v", sv, tt.sv)
+			}
+			if got := update.Encode(); !bytes.Equal(got, tt.data) {
+				t.Errorf("Encode() = %v, want %v", got, tt.data)
+			}
+		})
+	}
+}
+
+func TestDecodeUpdateFields(t *testing.T) {
+	update, err := DecodeUpdate(concat([]byte{2, 1, 2, 0, 0x28, 1, 3, 'm', 'a', 'p', 1, 'k', 1, 0x7d, 1}, insertABC[1:15], deleteB[1:]))
+	if err != nil {
+		t.Fatalf("DecodeUpdate: %v", err)
+	}
+
+	text := update.Structs[1][0]
+	if text.ID != (ID{Client: 1, Clock: 0}) || text.Length != 3 || text.ParentKey == nil || *text.ParentKey != "text" {
+		t.Errorf("text item = %+v", text)
+	}
+	entry := update.Structs[2][0]
+	if entry.ParentKey == nil || *entry.ParentKey != "map" || entry.ParentSub == nil || *entry.ParentSub != "k" || entry.Length != 1 {
+		t.Errorf("map item = %+v", entry)
+	}
+	if want := (DeleteSet{1: {{Clock: 1, Length: 1}}}); !reflect.DeepEqual(update.Deletes, want) {
+		t.Errorf("Deletes = %v, want %v", update.Deletes, want)
+	}
+
+	appended, err := DecodeUpdate(insertDE)
+	if err != nil {
+		t.Fatalf("DecodeUpdate: %v", err)
+	}
+	item := appended.Structs[1][0]
+	if item.ID != (ID{Client: 1, Clock: 3}) || item.Origin == nil || *item.Origin != (ID{Client: 1, Clock: 2}) || item.ParentKey != nil {
+		t.Errorf("appended item = %+v", item)
+	}
+}
+
+func TestDecodeUpdateErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{"no data", nil},
+		{"missing delete set", insertABC[:len(insertABC)-1]},
+		{"truncated string", insertABC[:13]},
+		{"truncated origin", insertDE[:6]},
+		{"trailing data", concat(insertABC, []byte{0})},
+		{"unknown content type", []byte{1, 1, 1, 0, 0x0b, 1, 4, 't', 'e', 'x', 't', 0}},
+		{"empty string item", []byte{1, 1, 1, 0, 4, 1, 4, 't', 'e', 'x', 't', 0, 0}},
+		{"empty skip", []byte{1, 1, 1, 0, 10, 0, 0}},
+		{"more structs than encoded", []byte{1, 2, 1, 0, 4, 1, 4, 't', 'e', 'x', 't', 1, 'a', 0}},
+		{"truncated delete set", deleteB[:4]},
+		{"unterminated varuint", []byte{0x80}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if update, err := DecodeUpdate(tt.data); err == nil {
+				t.Errorf("DecodeUpdate(%v) = %+v, want an error", tt.data, update)
+			}
+		})
+	}
+}
+
+func TestMerge(t *testing.T) {
+	// "abc" and "de" in one update, the delete set follows
+	abcde := []byte{1, 2, 1, 0, 4, 1, 4, 't', 'e', 'x', 't', 3, 'a', 'b', 'c', 0x84, 1, 2, 2, 'd', 'e'}
+
+	tests := []struct {
+		name    string
+		updates [][]byte
+		want    []byte
+	}{
+		{
+			name:    "single update",
+			updates: [][]byte{insertABC},
+			want:    insertABC,
+		},
+		{
+			name:    "sequential updates",
+			updates: [][]byte{insertABC, insertDE},
+			want:    concat(abcde, []byte{0}),
+		},
+		{
+			name:    "out of order",
+			updates: [][]byte{insertDE, insertABC},
+			want:    concat(abcde, []byte{0}),
+		},
+		{
+			name:    "duplicates",
+			updates: [][]byte{insertABC, insertDE, insertABC, insertDE},
+			want:    concat(abcde, []byte{0}),
+		},
+		{
+			name:    "with deletes",
+			updates: [][]byte{insertABC, deleteB, insertDE},
+			want:    concat(abcde, deleteB[1:]),
+		},
+		{
+			name:    "overlapping delete ranges",
+			updates: [][]byte{deleteB, {0, 1, 1, 2, 0, 1, 2, 1}},
+			want:    []byte{0, 1, 1, 1, 0, 3},
+		},
+		{
+			name:    "gap becomes a skip",
+			updates: [][]byte{insertABC, insertF},
+			want:    []byte{1, 3, 1, 0, 4, 1, 4, 't', 'e', 'x', 't', 3, 'a', 'b', 'c', 10, 2, 0x84, 1, 4, 1, 'f', 0},
+		},
+		{
+			name:    "overlap is sliced",
+			updates: [][]byte{insertABC, insertABCDE},
+			want:    insertABCDE,
+		},
+		{
+			name:    "clients in descending order",
+			updates: [][]byte{insertABC, setMapKey},
+			want:    []byte{2, 1, 2, 0, 0x28, 1, 3, 'm', 'a', 'p', 1, 'k', 1, 0x7d, 1, 1, 1, 0, 4, 1, 4, 't', 'e', 'x', 't', 3, 'a', 'b', 'c', 0},
+		},
+		{
+			name:    "empty updates",
+			updates: [][]byte{EmptyUpdate, EmptyUpdate},
+			want:    EmptyUpdate,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Merge(tt.updates...)
+			if err != nil {
+				t.Fatalf("Merge: %v", err)
+			}
+			if !bytes.Equal(got, tt.want) {
+				t.Errorf("Merge() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMergeSlicesOverlappingItems(t *testing.T) {
+	// "cde" starting at clock 2, as a peer holding "ab" would receive it
+	cde := []byte{1, 1, 1, 2, 0x84, 1, 1, 3, 'c', 'd', 'e', 0}
+	got, err := Merge(insertABC, cde)
+	if err != nil {
+		t.Fatalf("Merge: %v", err)
+	}
+	want := []byte{1, 2, 1, 0, 4, 1, 4, 't', 'e', 'x', 't', 3, 'a', 'b', 'c', 0x84, 1, 2, 2, 'd', 'e', 0}
+	if !bytes.Equal(got, want) {
+		t.Errorf("Merge() = %v, want %v", got, want)
+	}
+}
+
+func TestMergeInvalidUpdate(t *testing.T) {
+	if _, err := Merge(insertABC, insertABC[:5]); err == nil {
+		t.Error("Merge with a truncated update succeeded")
+	}
+}
+
+func TestDiff(t *testing.T) {
+	merged, err := Merge(insertABC, insertDE, deleteB)
+	if err != nil {
+		t.Fatalf("Merge: %v", err)
+	}
+	update, err := DecodeUpdate(merged)
+	if err != nil {
+		t.Fatalf("DecodeUpdate: %v", err)
+	}
+
+	tests := []struct {
+		name string
+		sv   StateVector
+		want []byte
+	}{
+		{"empty state", StateVector{}, merged},
+		{"other client only", StateVector{2: 7}, merged},
+		{"up to an item boundary", StateVector{1: 3}, concat(insertDE[:len(insertDE)-1], deleteB[1:])},
+		{"inside an item", StateVector{1: 4}, concat([]byte{1, 1, 1, 4, 0x84, 1, 3, 1, 'e'}, deleteB[1:])},
+		{"up to date", StateVector{1: 5}, concat([]byte{0}, deleteB[1:])},
+		{"ahead", StateVector{1: 9}, concat([]byte{0}, deleteB[1:])},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := update.Diff(tt.sv).Encode(); !bytes.Equal(got, tt.want) {
+				t.Errorf("Diff(%v) = %v, want %v", tt.sv, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDiffSurrogatePair(t *testing.T) {
+	update, err := DecodeUpdate(emoji)
+	if err != nil {
+		t.Fatalf("DecodeUpdate: %v", err)
+	}
+	// Cutting the pair leaves a lone surrogate, written as U+FFFD
+	want := []byte{1, 1, 1, 1, 0x84, 1, 0, 3, 0xef, 0xbf, 0xbd, 0}
+	if got := update.Diff(StateVector{1: 1}).Encode(); !bytes.Equal(got, want) {
+		t.Errorf("Diff() = %v, want %v", got, want)
+	}
+}
+
+func TestStateVectorRoundTrip(t *testing.T) {
+	tests := []struct {
+		name string
+		data []byte
+		sv   StateVector
+	}{
+		{"empty", []byte{0}, StateVector{}},
+		{"one client", []byte{1, 1, 5}, StateVector{1: 5}},
+		{"clients in descending order", []byte{2, 2, 1, 1, 5}, StateVector{1: 5, 2: 1}},
+		{"multi-byte values", []byte{1, 0xac, 2, 0x80, 1}, StateVector{300: 128}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sv, err := DecodeStateVector(tt.data)
+			if err != nil {
+				t.Fatalf("DecodeStateVector: %v", err)
+			}
+			if !reflect.DeepEqual(sv, tt.sv) {
+				t.Errorf("DecodeStateVector() = %v, want %v", sv, tt.sv)
+			}
+			if got := sv.Encode(); !bytes.Equal(got, tt.data) {
+				t.Errorf("Encode() = %v, want %v", got, tt.data)
+			}
+		})
+	}
+}
+
+func TestDecodeStateVectorErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{"no data", nil},
+		{"missing clock", []byte{1, 1}},
+		{"missing client", []byte{2, 1, 5}},
+		{"trailing data", []byte{1, 1, 5, 0}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if sv, err := DecodeStateVector(tt.data); err == nil {
+				t.Errorf("DecodeStateVector(%v) = %v, want an error", tt.data, sv)
+			}
+		})
+	}
+}
+
+func TestStateVectorWithGap(t *testing.T) {
+	merged, err := Merge(insertABC, insertF)
+	if err != nil {
+		t.Fatalf("Merge: %v", err)
+	}
+	update, err := DecodeUpdate(merged)
+	if err != nil {
+		t.Fatalf("DecodeUpdate: %v", err)
+	}
+	// The skip over "de" stops the state vector at "abc"
+	if sv := update.StateVector(); !reflect.DeepEqual(sv, StateVector{1: 3}) {
+		t.Errorf("StateVector() = %v, want %v", sv, StateVector{1: 3})
+	}
+	// A peer holding "abc" receives only "f", the skip is dropped
+	if got, want := update.Diff(StateVector{1: 3}).Encode(), insertF; !bytes.Equal(got, want) {
+		t.Errorf("Diff() = %v, want %v", got, want)
+	}
+}